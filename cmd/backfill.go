@@ -19,6 +19,7 @@ import (
 	"os"
 	"os/signal"
 	s "sync"
+	"syscall"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -49,8 +50,12 @@ func backfillCmdCommand() {
 
 	wg := new(s.WaitGroup)
 	logWithCommand.Debug("loading backfill configuration variables")
-	bConfig := historical.NewConfig()
+	bConfig, err := historical.NewConfig()
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
 	logWithCommand.Infof("backfill config: %+v", bConfig)
+	serveHealth(bConfig.DB, bConfig.Source)
 	logWithCommand.Debug("initializing new backfill service")
 	bService, err := historical.NewBackfillService(bConfig)
 	if err != nil {
@@ -59,9 +64,10 @@ func backfillCmdCommand() {
 	logWithCommand.Info("starting up backfill process")
 	bService.Sync(wg)
 
-	shutdown := make(chan os.Signal)
-	signal.Notify(shutdown, os.Interrupt)
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 	<-shutdown
+	logWithCommand.Info("shutting down, finishing the batches in progress")
 	bService.Stop()
 	wg.Wait()
 }
@@ -72,16 +78,28 @@ func init() {
 	// flags
 	backfillCmd.PersistentFlags().Int("backfill-frequency", 15, "how often to search for new gaps (in seconds; default 15)")
 	backfillCmd.PersistentFlags().Int("backfill-batch-size", 2, "batch size for http requests")
+	backfillCmd.PersistentFlags().Int("backfill-commit-size", 0, "number of blocks to publish in each db transaction (default and maximum is the batch size)")
 	backfillCmd.PersistentFlags().Int("backfill-workers", 4, "number of worker goroutines to concurrently make and process http requests")
 	backfillCmd.PersistentFlags().Int("backfill-timeout", 15, "timeout used for backfill http requests")
 	backfillCmd.PersistentFlags().Int("backfill-validation-level", 1, "data validated less than this amount will be backfilled")
+	backfillCmd.PersistentFlags().Int("backfill-lease", 0, "seconds a worker's claim on a batch lasts without a heartbeat before another worker can take it over (default 60)")
+	backfillCmd.PersistentFlags().Int("backfill-drain-timeout", 30, "seconds given to finish the batches in progress on shutdown")
+	backfillCmd.PersistentFlags().Bool("backfill-bulk-load", false, "if true, publish each batch using COPY instead of row-by-row inserts")
 	backfillCmd.PersistentFlags().String("btc-http-path", "", "http url for bitcoin node")
+	backfillCmd.PersistentFlags().StringSlice("btc-http-paths", nil, "additional http urls for bitcoin nodes to load-balance and fail over between")
+	backfillCmd.PersistentFlags().Bool("btc-cross-validate", false, "if true, fetch each block from two sources and compare their hashes before publishing")
 
 	// and their .toml config bindings
 	viper.BindPFlag("backfill.frequency", backfillCmd.PersistentFlags().Lookup("backfill-frequency"))
 	viper.BindPFlag("backfill.batchSize", backfillCmd.PersistentFlags().Lookup("backfill-batch-size"))
+	viper.BindPFlag("backfill.commitSize", backfillCmd.PersistentFlags().Lookup("backfill-commit-size"))
 	viper.BindPFlag("backfill.workers", backfillCmd.PersistentFlags().Lookup("backfill-workers"))
 	viper.BindPFlag("backfill.timeout", backfillCmd.PersistentFlags().Lookup("backfill-timeout"))
 	viper.BindPFlag("backfill.validationLevel", backfillCmd.PersistentFlags().Lookup("backfill-validation-level"))
+	viper.BindPFlag("backfill.lease", backfillCmd.PersistentFlags().Lookup("backfill-lease"))
+	viper.BindPFlag("backfill.drainTimeout", backfillCmd.PersistentFlags().Lookup("backfill-drain-timeout"))
+	viper.BindPFlag("backfill.bulkLoad", backfillCmd.PersistentFlags().Lookup("backfill-bulk-load"))
 	viper.BindPFlag("bitcoin.httpPath", backfillCmd.PersistentFlags().Lookup("btc-http-path"))
+	viper.BindPFlag("bitcoin.httpPaths", backfillCmd.PersistentFlags().Lookup("btc-http-paths"))
+	viper.BindPFlag("bitcoin.crossValidate", backfillCmd.PersistentFlags().Lookup("btc-cross-validate"))
 }
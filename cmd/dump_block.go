@@ -0,0 +1,130 @@
+// Copyright © 2020 Vulcanize, Inc
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+	"github.com/vulcanize/ipld-btc-indexer/utils"
+	v "github.com/vulcanize/ipld-btc-indexer/version"
+)
+
+// dumpBlockCmd represents the dump-block command
+var dumpBlockCmd = &cobra.Command{
+	Use:   "dump-block",
+	Short: "Write a block reassembled from its IPLDs",
+	Long: `Use this command to reassemble a block from the IPLDs stored in public.blocks, without a btc node
+The header IPLD is read, the tx trie it links to is walked down to the transaction IPLDs, and the serialized block
+is checked against the header's hash and merkle root before it is written
+
+The block is the one with --dump-block-hash, whether or not it is canonical, or else the canonical block at
+--dump-block-height, or else the canonical tip. It is written raw (--dump-block-format bin) or hex encoded
+(--dump-block-format hex) to --dump-block-output, which defaults to {hash}.bin or {hash}.hex; "-" writes to stdout`,
+	Run: func(cmd *cobra.Command, args []string) {
+		subCommand = cmd.CalledAs()
+		logWithCommand = *log.WithField("SubCommand", subCommand)
+		dumpBlock()
+	},
+}
+
+func dumpBlock() {
+	logWithCommand.Infof("running ipld-btc-indexer version: %s", v.VersionWithMeta)
+	viper.BindEnv("dumpBlock.hash", "DUMP_BLOCK_HASH")
+	viper.BindEnv("dumpBlock.height", "DUMP_BLOCK_HEIGHT")
+	viper.BindEnv("dumpBlock.format", "DUMP_BLOCK_FORMAT")
+	viper.BindEnv("dumpBlock.output", "DUMP_BLOCK_OUTPUT")
+	hash := viper.GetString("dumpBlock.hash")
+	height := viper.GetInt64("dumpBlock.height")
+	format := viper.GetString("dumpBlock.format")
+	output := viper.GetString("dumpBlock.output")
+	if format != "bin" && format != "hex" {
+		logWithCommand.Fatalf("unknown block format %q, expected bin or hex", format)
+	}
+	viper.BindEnv("bitcoin.httpPath", shared.BTC_HTTP_PATH)
+	nodeInfo, _ := shared.GetBtcNodeAndClient(viper.GetString("bitcoin.httpPath"))
+	var dbConfig postgres.Config
+	dbConfig.Init()
+	db := utils.LoadPostgres(dbConfig, nodeInfo)
+
+	reader := btc.NewDBChainReader(&db)
+	var header *btc.IndexedHeader
+	var err error
+	switch {
+	case hash != "":
+		header, err = reader.HeaderByHash(hash)
+	case height >= 0:
+		header, err = reader.HeaderAt(height)
+	default:
+		header, err = reader.Tip()
+	}
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	block, err := reader.BlockFromIPLDs(header)
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	if err := block.Serialize(buf); err != nil {
+		logWithCommand.Fatal(err)
+	}
+	data := buf.Bytes()
+	if format == "hex" {
+		data = []byte(hex.EncodeToString(data) + "\n")
+	}
+	if output == "" {
+		output = fmt.Sprintf("%s.%s", header.BlockHash, format)
+	}
+	if output == "-" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = ioutil.WriteFile(output, data, 0644)
+	}
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	logWithCommand.Infof("wrote block %s at height %d (%d transactions, %d bytes) to %s", header.BlockHash, header.BlockNumber, len(block.Transactions), buf.Len(), output)
+	if err := db.Close(); err != nil {
+		logWithCommand.Error(err)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(dumpBlockCmd)
+
+	// flags
+	dumpBlockCmd.PersistentFlags().String("dump-block-hash", "", "hash of the block to write")
+	dumpBlockCmd.PersistentFlags().Int64("dump-block-height", -1, "height of the canonical block to write, if no hash is given (default the tip)")
+	dumpBlockCmd.PersistentFlags().String("dump-block-format", "bin", "format to write the block in: bin or hex")
+	dumpBlockCmd.PersistentFlags().String("dump-block-output", "", "file to write the block to, or - for stdout (default {hash}.{format})")
+
+	// and their .toml config bindings
+	viper.BindPFlag("dumpBlock.hash", dumpBlockCmd.PersistentFlags().Lookup("dump-block-hash"))
+	viper.BindPFlag("dumpBlock.height", dumpBlockCmd.PersistentFlags().Lookup("dump-block-height"))
+	viper.BindPFlag("dumpBlock.format", dumpBlockCmd.PersistentFlags().Lookup("dump-block-format"))
+	viper.BindPFlag("dumpBlock.output", dumpBlockCmd.PersistentFlags().Lookup("dump-block-output"))
+}
@@ -0,0 +1,99 @@
+// Copyright © 2020 Vulcanize, Inc
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/electrum"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+	"github.com/vulcanize/ipld-btc-indexer/utils"
+	v "github.com/vulcanize/ipld-btc-indexer/version"
+)
+
+// electrumCmd represents the electrum command
+var electrumCmd = &cobra.Command{
+	Use:   "electrum",
+	Short: "Serve the Electrum protocol from the index",
+	Long: `This command serves the Electrum protocol (newline delimited JSON-RPC over TCP) from the index, so that
+Electrum wallets can follow the chain and look up the history, balance and unspent outputs of their scripts
+
+Scripts are looked up by the sha256 hash of their pk_script, stored with each output; there is no mempool, so only
+confirmed transactions of the canonical chain are served. Clients subscribed to headers or scripts are notified
+when the indexed tip changes, which is checked every poll interval
+
+The protocol can also be served from the serve command with --electrum`,
+	Run: func(cmd *cobra.Command, args []string) {
+		subCommand = cmd.CalledAs()
+		logWithCommand = *log.WithField("SubCommand", subCommand)
+		electrumCmdCommand()
+	},
+}
+
+func electrumCmdCommand() {
+	logWithCommand.Infof("running ipld-btc-indexer version: %s", v.VersionWithMeta)
+	logWithCommand.Debug("loading electrum configuration variables")
+	electrumConfig := electrum.NewConfig()
+	viper.BindEnv("bitcoin.httpPath", shared.BTC_HTTP_PATH)
+	nodeInfo, _ := shared.GetBtcNodeAndClient(viper.GetString("bitcoin.httpPath"))
+	var dbConfig postgres.Config
+	dbConfig.Init()
+	db := utils.LoadPostgres(dbConfig, nodeInfo)
+
+	service := electrum.NewService(electrumConfig.Address(), electrumServer(&db, electrumConfig))
+	if err := service.Start(nil); err != nil {
+		logWithCommand.Fatal(err)
+	}
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	<-shutdown
+	if err := service.Stop(); err != nil {
+		logWithCommand.Error(err)
+	}
+	if err := db.Close(); err != nil {
+		logWithCommand.Error(err)
+	}
+}
+
+func electrumServer(db *postgres.DB, c electrum.Config) *electrum.Server {
+	return electrum.NewServer(btc.NewDBChainReader(db), &chaincfg.MainNetParams, c.PollInterval, c.WriteTimeout, c.MaxConnections, c.MaxSubscriptions) /// TODO make this configurable
+}
+
+func init() {
+	rootCmd.AddCommand(electrumCmd)
+
+	// flags
+	electrumCmd.PersistentFlags().String("electrum-tcp-addr", "127.0.0.1", "address to serve the electrum protocol on")
+	electrumCmd.PersistentFlags().Int("electrum-tcp-port", 50001, "port to serve the electrum protocol on")
+	electrumCmd.PersistentFlags().Int("electrum-poll-interval", 5, "seconds between checks of the indexed tip for subscription notifications")
+	electrumCmd.PersistentFlags().Int("electrum-write-timeout", 10, "seconds a client has to read a message before it is disconnected")
+
+	// and their .toml config bindings
+	viper.BindPFlag("electrum.tcpAddr", electrumCmd.PersistentFlags().Lookup("electrum-tcp-addr"))
+	viper.BindPFlag("electrum.tcpPort", electrumCmd.PersistentFlags().Lookup("electrum-tcp-port"))
+	viper.BindPFlag("electrum.pollInterval", electrumCmd.PersistentFlags().Lookup("electrum-poll-interval"))
+	viper.BindPFlag("electrum.writeTimeout", electrumCmd.PersistentFlags().Lookup("electrum-write-timeout"))
+}
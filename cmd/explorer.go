@@ -0,0 +1,89 @@
+// Copyright © 2020 Vulcanize, Inc
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/explorer"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+	"github.com/vulcanize/ipld-btc-indexer/utils"
+	v "github.com/vulcanize/ipld-btc-indexer/version"
+)
+
+// explorerCmd represents the explorer command
+var explorerCmd = &cobra.Command{
+	Use:   "explorer",
+	Short: "Serve a block explorer JSON API from the index",
+	Long: `This command serves a read-only JSON API modeled on common block explorer endpoints, assembled from the
+indexed headers, transactions, inputs and outputs and the decoded transaction IPLDs
+
+Endpoints: /blocks/tip, /block/{hash|height}, /block/{hash|height}/txs, /tx/{txid}, /tx/{txid}/outspends,
+/address/{address}/txs and /address/{address}/utxo
+
+The API can also be served from the serve command with --explorer`,
+	Run: func(cmd *cobra.Command, args []string) {
+		subCommand = cmd.CalledAs()
+		logWithCommand = *log.WithField("SubCommand", subCommand)
+		explorerCmdCommand()
+	},
+}
+
+func explorerCmdCommand() {
+	logWithCommand.Infof("running ipld-btc-indexer version: %s", v.VersionWithMeta)
+	logWithCommand.Debug("loading explorer configuration variables")
+	explorerConfig := explorer.NewConfig()
+	viper.BindEnv("bitcoin.httpPath", shared.BTC_HTTP_PATH)
+	nodeInfo, _ := shared.GetBtcNodeAndClient(viper.GetString("bitcoin.httpPath"))
+	var dbConfig postgres.Config
+	dbConfig.Init()
+	db := utils.LoadPostgres(dbConfig, nodeInfo)
+
+	service := explorer.NewService(explorerConfig.Address(), explorer.NewServer(btc.NewDBChainReader(&db)))
+	if err := service.Start(nil); err != nil {
+		logWithCommand.Fatal(err)
+	}
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	<-shutdown
+	if err := service.Stop(); err != nil {
+		logWithCommand.Error(err)
+	}
+	if err := db.Close(); err != nil {
+		logWithCommand.Error(err)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(explorerCmd)
+
+	// flags
+	explorerCmd.PersistentFlags().String("explorer-http-addr", "127.0.0.1", "address to serve the block explorer API on")
+	explorerCmd.PersistentFlags().Int("explorer-http-port", 8084, "port to serve the block explorer API on")
+
+	// and their .toml config bindings
+	viper.BindPFlag("explorer.httpAddr", explorerCmd.PersistentFlags().Lookup("explorer-http-addr"))
+	viper.BindPFlag("explorer.httpPort", explorerCmd.PersistentFlags().Lookup("explorer-http-port"))
+}
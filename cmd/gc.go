@@ -0,0 +1,72 @@
+// Copyright © 2020 Vulcanize, Inc
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/gc"
+	v "github.com/vulcanize/ipld-btc-indexer/version"
+)
+
+// gcCmd represents the gc command
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Delete IPLDs that are unreachable from any indexed header",
+	Long: `Use this command to garbage collect the bitcoin IPLDs in public.blocks that can no longer be reached
+by walking from an indexed header to its tx trie and txs, e.g. those left behind by reorgs or by resync clearing old data
+The size of the unreachable IPLDs is reported, and with --gc-dry-run nothing is deleted
+
+sync, backfill and resync can keep running: before each batch is deleted, gc waits for the publishes in progress
+to commit, marks the headers they indexed, and holds new publishes off until the batch is deleted`,
+	Run: func(cmd *cobra.Command, args []string) {
+		subCommand = cmd.CalledAs()
+		logWithCommand = *log.WithField("SubCommand", subCommand)
+		gcCmdCommand()
+	},
+}
+
+func gcCmdCommand() {
+	logWithCommand.Infof("running ipld-btc-indexer version: %s", v.VersionWithMeta)
+	logWithCommand.Debug("loading gc configuration variables")
+	gcConfig := gc.NewConfig()
+	logWithCommand.Infof("gc config: %+v", gcConfig)
+	collector := btc.NewDBGarbageCollector(gcConfig.DB)
+	report, err := collector.Collect(gcConfig.BatchSize, gcConfig.DryRun)
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	if gcConfig.DryRun {
+		logWithCommand.Infof("found %d unreachable IPLDs totalling %d bytes (dry run, nothing deleted)", report.Unreachable, report.Bytes)
+		return
+	}
+	logWithCommand.Infof("deleted %d of %d unreachable IPLDs totalling %d bytes", report.Deleted, report.Unreachable, report.Bytes)
+}
+
+func init() {
+	rootCmd.AddCommand(gcCmd)
+
+	// flags
+	gcCmd.PersistentFlags().Int("gc-batch-size", 0, "number of block heights to mark, and IPLDs to sweep, at a time")
+	gcCmd.PersistentFlags().Bool("gc-dry-run", false, "if true, report the unreachable IPLDs without deleting them")
+
+	// and their .toml config bindings
+	viper.BindPFlag("gc.batchSize", gcCmd.PersistentFlags().Lookup("gc-batch-size"))
+	viper.BindPFlag("gc.dryRun", gcCmd.PersistentFlags().Lookup("gc-dry-run"))
+}
@@ -0,0 +1,99 @@
+// Copyright © 2020 Vulcanize, Inc
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/graphql"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+	"github.com/vulcanize/ipld-btc-indexer/utils"
+	v "github.com/vulcanize/ipld-btc-indexer/version"
+)
+
+// graphqlCmd represents the graphql command
+var graphqlCmd = &cobra.Command{
+	Use:   "graphql",
+	Short: "Serve a GraphQL API over the indexed bitcoin data",
+	Long: `This command serves a read-only GraphQL API over the btc schema, exposing blocks, transactions, inputs,
+outputs and addresses along with the relationships between them (block -> transactions -> inputs and outputs ->
+the inputs spending them), with cursor pagination and height range filters
+
+The API is served at /graphql, and can also be served from the serve command with --graphql`,
+	Run: func(cmd *cobra.Command, args []string) {
+		subCommand = cmd.CalledAs()
+		logWithCommand = *log.WithField("SubCommand", subCommand)
+		graphqlCmdCommand()
+	},
+}
+
+func graphqlCmdCommand() {
+	logWithCommand.Infof("running ipld-btc-indexer version: %s", v.VersionWithMeta)
+	logWithCommand.Debug("loading graphql configuration variables")
+	graphqlConfig := graphql.NewConfig()
+	viper.BindEnv("bitcoin.httpPath", shared.BTC_HTTP_PATH)
+	nodeInfo, _ := shared.GetBtcNodeAndClient(viper.GetString("bitcoin.httpPath"))
+	var dbConfig postgres.Config
+	dbConfig.Init()
+	db := utils.LoadPostgres(dbConfig, nodeInfo)
+
+	service, err := graphqlService(&db, graphqlConfig)
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	if err := service.Start(nil); err != nil {
+		logWithCommand.Fatal(err)
+	}
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	<-shutdown
+	if err := service.Stop(); err != nil {
+		logWithCommand.Error(err)
+	}
+	if err := db.Close(); err != nil {
+		logWithCommand.Error(err)
+	}
+}
+
+// graphqlService creates the GraphQL service reading from the db
+func graphqlService(db *postgres.DB, c graphql.Config) (*shared.HTTPService, error) {
+	schema, err := graphql.NewSchema(btc.NewDBChainReader(db))
+	if err != nil {
+		return nil, err
+	}
+	return graphql.NewService(c.Address(), schema), nil
+}
+
+func init() {
+	rootCmd.AddCommand(graphqlCmd)
+
+	// flags
+	graphqlCmd.PersistentFlags().String("graphql-http-addr", "127.0.0.1", "address to serve the graphql API on")
+	graphqlCmd.PersistentFlags().Int("graphql-http-port", 8083, "port to serve the graphql API on")
+
+	// and their .toml config bindings
+	viper.BindPFlag("graphql.httpAddr", graphqlCmd.PersistentFlags().Lookup("graphql-http-addr"))
+	viper.BindPFlag("graphql.httpPort", graphqlCmd.PersistentFlags().Lookup("graphql-http-port"))
+}
@@ -16,6 +16,11 @@
 package cmd
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -30,8 +35,9 @@ var resyncCmd = &cobra.Command{
 	Short: "Resync historical data",
 	Long: `Use this command to define historical block ranges to sync data within
 This does not find gaps or under-validated data, it resyncs all the data in the provided range
-This can be ran in parallel on non-overlapping regions to scale historical data syncing or
-used to force resyncing of data from a new source
+The range is worked through as a queue of batches recorded in Postgres, so this can be ran by any
+number of processes against the same range to scale historical data syncing, and an interrupted
+resync picks up where it left off when rerun; it can also be used to force resyncing of data from a new source
 
 NOTE: Requires a full btc node`,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -54,8 +60,16 @@ func rsyncCmdCommand() {
 	if err != nil {
 		logWithCommand.Fatal(err)
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-shutdown
+		logWithCommand.Info("shutting down, finishing the batches in progress")
+		cancel()
+	}()
 	logWithCommand.Info("starting up resync process")
-	if err := rService.Sync(); err != nil {
+	if err := rService.Sync(ctx); err != nil {
 		logWithCommand.Fatal(err)
 	}
 	logWithCommand.Infof("bitcoin %s resync finished", rConfig.ResyncType.String())
@@ -68,20 +82,40 @@ func init() {
 	resyncCmd.PersistentFlags().String("resync-type", "", "which type of data to resync")
 	resyncCmd.PersistentFlags().Int("resync-start", 0, "block height to start resync")
 	resyncCmd.PersistentFlags().Int("resync-stop", 0, "block height to stop resync")
+	resyncCmd.PersistentFlags().StringSlice("ranges", nil, "block ranges or range expressions to resync, e.g. 0-1000,5000-6000 or \"last 1000\" or \"times_validated < 2\"; overrides resync-start and resync-stop")
+	resyncCmd.PersistentFlags().String("ranges-file", "", "file of block ranges or range expressions to resync, one per line")
 	resyncCmd.PersistentFlags().Int("resync-batch-size", 0, "batch size for http requests")
+	resyncCmd.PersistentFlags().Int("resync-commit-size", 0, "number of blocks to publish in each db transaction (default and maximum is the batch size)")
 	resyncCmd.PersistentFlags().Int("resync-workers", 0, "number of worker goroutines to concurrently make and process http requests")
 	resyncCmd.PersistentFlags().Bool("resync-clear-old-cache", false, "if true, clear out old data of the provided type within the resync range before resyncing (warning: clearing out data will delete any rows that FK reference it")
 	resyncCmd.PersistentFlags().Bool("resync-reset-validation", false, "if true, reset times_validated of headers in this range to 0")
+	resyncCmd.PersistentFlags().Bool("resync-retry-failed", false, "if true, give the batches that failed in an earlier run of the same job a fresh set of attempts")
+	resyncCmd.PersistentFlags().Int("resync-max-attempts", 0, "number of times to attempt each batch before reporting its heights as failed (default 3)")
+	resyncCmd.PersistentFlags().Int("resync-lease", 0, "seconds a worker's claim on a batch lasts without a heartbeat before another worker can take it over (default 60)")
+	resyncCmd.PersistentFlags().Int("resync-drain-timeout", 30, "seconds given to finish the batches in progress when interrupted")
+	resyncCmd.PersistentFlags().Bool("resync-bulk-load", false, "if true, publish each batch using COPY instead of row-by-row inserts")
 	resyncCmd.PersistentFlags().String("btc-http-path", "", "http url for bitcoin node")
+	resyncCmd.PersistentFlags().StringSlice("btc-http-paths", nil, "additional http urls for bitcoin nodes to load-balance and fail over between")
+	resyncCmd.PersistentFlags().Bool("btc-cross-validate", false, "if true, fetch each block from two sources and compare their hashes before publishing")
 
 	// and their .toml config bindings
 	viper.BindPFlag("resync.type", resyncCmd.PersistentFlags().Lookup("resync-type"))
 	viper.BindPFlag("resync.start", resyncCmd.PersistentFlags().Lookup("resync-start"))
 	viper.BindPFlag("resync.stop", resyncCmd.PersistentFlags().Lookup("resync-stop"))
+	viper.BindPFlag("resync.ranges", resyncCmd.PersistentFlags().Lookup("ranges"))
+	viper.BindPFlag("resync.rangesFile", resyncCmd.PersistentFlags().Lookup("ranges-file"))
 	viper.BindPFlag("resync.batchSize", resyncCmd.PersistentFlags().Lookup("resync-batch-size"))
+	viper.BindPFlag("resync.commitSize", resyncCmd.PersistentFlags().Lookup("resync-commit-size"))
 	viper.BindPFlag("resync.workers", resyncCmd.PersistentFlags().Lookup("resync-workers"))
 	viper.BindPFlag("resync.clearOldCache", resyncCmd.PersistentFlags().Lookup("resync-clear-old-cache"))
 	viper.BindPFlag("resync.resetValidation", resyncCmd.PersistentFlags().Lookup("resync-reset-validation"))
+	viper.BindPFlag("resync.retryFailed", resyncCmd.PersistentFlags().Lookup("resync-retry-failed"))
+	viper.BindPFlag("resync.maxAttempts", resyncCmd.PersistentFlags().Lookup("resync-max-attempts"))
+	viper.BindPFlag("resync.lease", resyncCmd.PersistentFlags().Lookup("resync-lease"))
+	viper.BindPFlag("resync.drainTimeout", resyncCmd.PersistentFlags().Lookup("resync-drain-timeout"))
+	viper.BindPFlag("resync.bulkLoad", resyncCmd.PersistentFlags().Lookup("resync-bulk-load"))
 	viper.BindPFlag("resync.timeout", resyncCmd.PersistentFlags().Lookup("resync-timeout"))
 	viper.BindPFlag("bitcoin.httpPath", resyncCmd.PersistentFlags().Lookup("btc-http-path"))
+	viper.BindPFlag("bitcoin.httpPaths", resyncCmd.PersistentFlags().Lookup("btc-http-paths"))
+	viper.BindPFlag("bitcoin.crossValidate", resyncCmd.PersistentFlags().Lookup("btc-cross-validate"))
 }
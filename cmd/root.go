@@ -24,6 +24,11 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/health"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/prom"
 )
 
 var (
@@ -62,6 +67,39 @@ func initFuncs(cmd *cobra.Command, args []string) {
 	if err := logLevel(); err != nil {
 		log.Fatal("Could not set log level: ", err)
 	}
+	metrics()
+}
+
+// metrics turns on metric collection and serves the metrics over http, if enabled
+func metrics() {
+	c := prom.NewConfig()
+	if !c.Enabled {
+		return
+	}
+	prom.Init()
+	prom.Serve(c.Address())
+}
+
+// serveHealth serves the health and readiness checks for a long-running command, if enabled
+func serveHealth(db *postgres.DB, source btc.SourceConfig) {
+	if c, checker := healthChecker(db, source); checker != nil {
+		if _, err := health.Serve(c.Address(), checker); err != nil {
+			logWithCommand.Fatal(err)
+		}
+	}
+}
+
+// healthChecker returns the health config and, if the checks are enabled, a checker for the database and source
+func healthChecker(db *postgres.DB, source btc.SourceConfig) (health.Config, *health.Checker) {
+	c := health.NewConfig()
+	if !c.Enabled {
+		return c, nil
+	}
+	client, err := btc.NewBlockClient(source)
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	return c, health.NewChecker(db, client, c.MaxHeadLag, c.MaxGapAge)
 }
 
 func logLevel() error {
@@ -95,11 +133,24 @@ func init() {
 	rootCmd.PersistentFlags().String("log-level", log.InfoLevel.String(), "Log level (trace, debug, info, warn, error, fatal, panic")
 	rootCmd.PersistentFlags().String("logfile", "", "file path for logging")
 
+	rootCmd.PersistentFlags().Bool("metrics", false, "collect prometheus metrics and serve them over http")
+	rootCmd.PersistentFlags().String("metrics-http-addr", "127.0.0.1", "address to serve prometheus metrics on")
+	rootCmd.PersistentFlags().Int("metrics-http-port", 8090, "port to serve prometheus metrics on")
+
+	rootCmd.PersistentFlags().Bool("health", true, "serve health and readiness checks over http from the sync, backfill and serve commands")
+	rootCmd.PersistentFlags().String("health-http-addr", "127.0.0.1", "address to serve health and readiness checks on (0.0.0.0 to expose them to probes from other hosts)")
+	rootCmd.PersistentFlags().Int("health-http-port", 8082, "port to serve health and readiness checks on")
+	rootCmd.PersistentFlags().Int("health-max-head-lag", 6, "number of blocks the indexed head can be behind the node's tip while still ready")
+	rootCmd.PersistentFlags().Int("health-max-gap-age", 60, "minutes a gap can go unfilled while still ready (0 to ignore gaps)")
+
 	rootCmd.PersistentFlags().String("btc-node-id", "", "btc node id")
 	rootCmd.PersistentFlags().String("btc-client-name", "", "btc client name")
 	rootCmd.PersistentFlags().String("btc-genesis-block", "", "btc genesis block hash")
 	rootCmd.PersistentFlags().String("btc-network-id", "", "btc network id")
 	rootCmd.PersistentFlags().String("btc-chain-id", "", "btc chain id")
+	rootCmd.PersistentFlags().String("btc-source", "rpc", "btc data source type (rpc or esplora)")
+	rootCmd.PersistentFlags().String("btc-esplora-path", "", "url for the esplora api, required when btc-source is esplora")
+	rootCmd.PersistentFlags().StringSlice("btc-esplora-paths", nil, "additional esplora api urls to load-balance and fail over between")
 
 	// and their .toml config bindings
 	viper.BindPFlag("database.name", rootCmd.PersistentFlags().Lookup("database-name"))
@@ -111,11 +162,24 @@ func init() {
 	viper.BindPFlag("logfile", rootCmd.PersistentFlags().Lookup("logfile"))
 	viper.BindPFlag("log.level", rootCmd.PersistentFlags().Lookup("log-level"))
 
+	viper.BindPFlag("metrics.enabled", rootCmd.PersistentFlags().Lookup("metrics"))
+	viper.BindPFlag("metrics.httpAddr", rootCmd.PersistentFlags().Lookup("metrics-http-addr"))
+	viper.BindPFlag("metrics.httpPort", rootCmd.PersistentFlags().Lookup("metrics-http-port"))
+
+	viper.BindPFlag("health.enabled", rootCmd.PersistentFlags().Lookup("health"))
+	viper.BindPFlag("health.httpAddr", rootCmd.PersistentFlags().Lookup("health-http-addr"))
+	viper.BindPFlag("health.httpPort", rootCmd.PersistentFlags().Lookup("health-http-port"))
+	viper.BindPFlag("health.maxHeadLag", rootCmd.PersistentFlags().Lookup("health-max-head-lag"))
+	viper.BindPFlag("health.maxGapAge", rootCmd.PersistentFlags().Lookup("health-max-gap-age"))
+
 	viper.BindPFlag("bitcoin.nodeID", rootCmd.PersistentFlags().Lookup("btc-node-id"))
 	viper.BindPFlag("bitcoin.clientName", rootCmd.PersistentFlags().Lookup("btc-client-name"))
 	viper.BindPFlag("bitcoin.genesisBlock", rootCmd.PersistentFlags().Lookup("btc-genesis-block"))
 	viper.BindPFlag("bitcoin.networkID", rootCmd.PersistentFlags().Lookup("btc-network-id"))
 	viper.BindPFlag("bitcoin.chainID", rootCmd.PersistentFlags().Lookup("btc-chain-id"))
+	viper.BindPFlag("bitcoin.source", rootCmd.PersistentFlags().Lookup("btc-source"))
+	viper.BindPFlag("bitcoin.esploraPath", rootCmd.PersistentFlags().Lookup("btc-esplora-path"))
+	viper.BindPFlag("bitcoin.esploraPaths", rootCmd.PersistentFlags().Lookup("btc-esplora-paths"))
 }
 
 func initConfig() {
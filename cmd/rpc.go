@@ -0,0 +1,103 @@
+// Copyright © 2020 Vulcanize, Inc
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btcrpc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+	"github.com/vulcanize/ipld-btc-indexer/utils"
+	v "github.com/vulcanize/ipld-btc-indexer/version"
+)
+
+// rpcCmd represents the rpc command
+var rpcCmd = &cobra.Command{
+	Use:   "rpc",
+	Short: "Serve a bitcoind compatible JSON-RPC API from the index",
+	Long: `This command serves a read-only subset of the bitcoind JSON-RPC API, answered entirely from the
+indexed headers and transactions and their IPLDs in Postgres, so that tools speaking bitcoind JSON-RPC can
+query historical data without an archive node
+
+Supported methods: getblockcount, getbestblockhash, getblockhash, getblockheader, getblock (verbosity 0, 1 and 2),
+getrawtransaction (with txindex semantics), gettxout, and gettxoutproof and verifytxoutproof for SPV merkle proofs
+
+The API can also be served from the serve command with --rpc`,
+	Run: func(cmd *cobra.Command, args []string) {
+		subCommand = cmd.CalledAs()
+		logWithCommand = *log.WithField("SubCommand", subCommand)
+		rpcCmdCommand()
+	},
+}
+
+func rpcCmdCommand() {
+	logWithCommand.Infof("running ipld-btc-indexer version: %s", v.VersionWithMeta)
+	logWithCommand.Debug("loading rpc configuration variables")
+	rpcConfig := btcrpc.NewConfig()
+	viper.BindEnv("bitcoin.httpPath", shared.BTC_HTTP_PATH)
+	nodeInfo, _ := shared.GetBtcNodeAndClient(viper.GetString("bitcoin.httpPath"))
+	var dbConfig postgres.Config
+	dbConfig.Init()
+	db := utils.LoadPostgres(dbConfig, nodeInfo)
+
+	service := btcrpc.NewService(rpcConfig.Address(), rpcServer(&db, rpcConfig))
+	if err := service.Start(nil); err != nil {
+		logWithCommand.Fatal(err)
+	}
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	<-shutdown
+	if err := service.Stop(); err != nil {
+		logWithCommand.Error(err)
+	}
+	if err := db.Close(); err != nil {
+		logWithCommand.Error(err)
+	}
+}
+
+// rpcServer creates the bitcoind compatible JSON-RPC server reading from the db
+func rpcServer(db *postgres.DB, c btcrpc.Config) *btcrpc.Server {
+	if c.User == "" {
+		logWithCommand.Warn("rpc.user is not set, the bitcoin json-rpc API is served without authentication")
+	}
+	return btcrpc.NewServer(btc.NewDBChainReader(db), &chaincfg.MainNetParams, c.User, c.Password) /// TODO make this configurable
+}
+
+func init() {
+	rootCmd.AddCommand(rpcCmd)
+
+	// flags
+	rpcCmd.PersistentFlags().String("rpc-http-addr", "127.0.0.1", "address to serve the bitcoin json-rpc API on")
+	rpcCmd.PersistentFlags().Int("rpc-http-port", 8336, "port to serve the bitcoin json-rpc API on")
+	rpcCmd.PersistentFlags().String("rpc-user", "", "username clients must authenticate with, if set")
+	rpcCmd.PersistentFlags().String("rpc-password", "", "password clients must authenticate with")
+
+	// and their .toml config bindings
+	viper.BindPFlag("rpc.httpAddr", rpcCmd.PersistentFlags().Lookup("rpc-http-addr"))
+	viper.BindPFlag("rpc.httpPort", rpcCmd.PersistentFlags().Lookup("rpc-http-port"))
+	viper.BindPFlag("rpc.user", rpcCmd.PersistentFlags().Lookup("rpc-user"))
+	viper.BindPFlag("rpc.password", rpcCmd.PersistentFlags().Lookup("rpc-password"))
+}
@@ -0,0 +1,172 @@
+// Copyright © 2020 Vulcanize, Inc
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	ethnode "github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btcrpc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/electrum"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/explorer"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/graphql"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/health"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/historical"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	w "github.com/vulcanize/ipld-btc-indexer/pkg/sync"
+	"github.com/vulcanize/ipld-btc-indexer/utils"
+	v "github.com/vulcanize/ipld-btc-indexer/version"
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "sync and backfill bitcoin chain data in one process",
+	Long: `This command runs the sync and backfill processes, along with the health checks, in a single process
+They share one Postgres connection pool and are started and shut down together
+With --rpc the bitcoind compatible JSON-RPC API (see the rpc command) is served from the same process,
+with --graphql the GraphQL API (see the graphql command), with --explorer the block explorer API (see the
+explorer command) and with --electrum the Electrum protocol (see the electrum command)
+
+The sync and backfill settings are read from the [sync] and [backfill] sections of the config file (or their
+environment variables), and the pool is sized by the [database] settings
+
+NOTE: Requires a btc full node`,
+	Run: func(cmd *cobra.Command, args []string) {
+		subCommand = cmd.CalledAs()
+		logWithCommand = *log.WithField("SubCommand", subCommand)
+		serve()
+	},
+}
+
+func serve() {
+	logWithCommand.Infof("running ipld-btc-indexer version: %s", v.VersionWithMeta)
+
+	logWithCommand.Debug("loading serve configuration variables")
+	syncerConfig := new(w.Config)
+	if err := syncerConfig.Init(); err != nil {
+		logWithCommand.Fatal(err)
+	}
+	backfillConfig := new(historical.Config)
+	if err := backfillConfig.Init(); err != nil {
+		logWithCommand.Fatal(err)
+	}
+	var dbConfig postgres.Config
+	dbConfig.Init()
+	db := utils.LoadPostgres(dbConfig, syncerConfig.NodeInfo)
+	syncerConfig.DB, syncerConfig.DBConfig = &db, dbConfig
+	backfillConfig.DB, backfillConfig.DBConfig = &db, dbConfig
+	logWithCommand.Infof("sync config: %+v", syncerConfig)
+	logWithCommand.Infof("backfill config: %+v", backfillConfig)
+
+	stack, err := ethnode.New(&ethnode.Config{
+		Name:  "ipld-btc-indexer",
+		NoUSB: true,
+		P2P: p2p.Config{
+			NoDiscovery: true,
+			MaxPeers:    0,
+		},
+	})
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	logWithCommand.Debug("registering sync, backfill and health services")
+	if err := stack.Register(func(*ethnode.ServiceContext) (ethnode.Service, error) {
+		return w.NewIndexerService(syncerConfig)
+	}); err != nil {
+		logWithCommand.Fatal(err)
+	}
+	if err := stack.Register(func(*ethnode.ServiceContext) (ethnode.Service, error) {
+		return historical.NewBackfillService(backfillConfig)
+	}); err != nil {
+		logWithCommand.Fatal(err)
+	}
+	if rpcConfig := btcrpc.NewConfig(); rpcConfig.Enabled {
+		if err := stack.Register(func(*ethnode.ServiceContext) (ethnode.Service, error) {
+			return btcrpc.NewService(rpcConfig.Address(), rpcServer(&db, rpcConfig)), nil
+		}); err != nil {
+			logWithCommand.Fatal(err)
+		}
+	}
+	if graphqlConfig := graphql.NewConfig(); graphqlConfig.Enabled {
+		if err := stack.Register(func(*ethnode.ServiceContext) (ethnode.Service, error) {
+			return graphqlService(&db, graphqlConfig)
+		}); err != nil {
+			logWithCommand.Fatal(err)
+		}
+	}
+	if explorerConfig := explorer.NewConfig(); explorerConfig.Enabled {
+		if err := stack.Register(func(*ethnode.ServiceContext) (ethnode.Service, error) {
+			return explorer.NewService(explorerConfig.Address(), explorer.NewServer(btc.NewDBChainReader(&db))), nil
+		}); err != nil {
+			logWithCommand.Fatal(err)
+		}
+	}
+	if electrumConfig := electrum.NewConfig(); electrumConfig.Enabled {
+		if err := stack.Register(func(*ethnode.ServiceContext) (ethnode.Service, error) {
+			return electrum.NewService(electrumConfig.Address(), electrumServer(&db, electrumConfig)), nil
+		}); err != nil {
+			logWithCommand.Fatal(err)
+		}
+	}
+	if c, checker := healthChecker(&db, backfillConfig.Source); checker != nil {
+		if err := stack.Register(func(*ethnode.ServiceContext) (ethnode.Service, error) {
+			return health.NewService(c.Address(), checker), nil
+		}); err != nil {
+			logWithCommand.Fatal(err)
+		}
+	}
+
+	logWithCommand.Info("starting up sync and backfill processes")
+	if err := stack.Start(); err != nil {
+		logWithCommand.Fatal(err)
+	}
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	<-shutdown
+	logWithCommand.Info("shutting down sync and backfill processes")
+	if err := stack.Stop(); err != nil {
+		logWithCommand.Error(err)
+	}
+	if err := db.Close(); err != nil {
+		logWithCommand.Error(err)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	// flags
+	serveCmd.PersistentFlags().Bool("rpc", false, "also serve the bitcoind compatible json-rpc API")
+	serveCmd.PersistentFlags().Bool("graphql", false, "also serve the graphql API")
+	serveCmd.PersistentFlags().Bool("explorer", false, "also serve the block explorer API")
+	serveCmd.PersistentFlags().Bool("electrum", false, "also serve the electrum protocol")
+
+	// and their .toml config bindings
+	viper.BindPFlag("rpc.enabled", serveCmd.PersistentFlags().Lookup("rpc"))
+	viper.BindPFlag("graphql.enabled", serveCmd.PersistentFlags().Lookup("graphql"))
+	viper.BindPFlag("explorer.enabled", serveCmd.PersistentFlags().Lookup("explorer"))
+	viper.BindPFlag("electrum.enabled", serveCmd.PersistentFlags().Lookup("electrum"))
+}
@@ -19,6 +19,7 @@ import (
 	"os"
 	"os/signal"
 	s "sync"
+	"syscall"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -50,8 +51,12 @@ func sync() {
 
 	wg := new(s.WaitGroup)
 	logWithCommand.Debug("loading sync configuration variables")
-	syncerConfig := w.NewConfig()
+	syncerConfig, err := w.NewConfig()
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
 	logWithCommand.Infof("config: %+v", syncerConfig)
+	serveHealth(syncerConfig.DB, syncerConfig.Source)
 	logWithCommand.Debug("initializing new sync service")
 	syncer, err := w.NewIndexerService(syncerConfig)
 	if err != nil {
@@ -63,9 +68,10 @@ func sync() {
 		logWithCommand.Fatal(err)
 	}
 
-	shutdown := make(chan os.Signal)
-	signal.Notify(shutdown, os.Interrupt)
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 	<-shutdown
+	logWithCommand.Info("shutting down, draining the blocks already streamed")
 	syncer.Stop()
 	wg.Wait()
 }
@@ -75,9 +81,13 @@ func init() {
 
 	// flags
 	syncCmd.PersistentFlags().Int("sync-workers", 0, "how many worker goroutines to publish and index data")
+	syncCmd.PersistentFlags().Bool("sync-ordered", false, "commit blocks strictly in height order, verifying each against its parent")
+	syncCmd.PersistentFlags().Int("sync-drain-timeout", 30, "seconds given to publish the blocks already streamed on shutdown")
 	syncCmd.PersistentFlags().String("btc-ws-path", "", "ws url for bitcoin node")
 
 	// and their .toml config bindings
 	viper.BindPFlag("sync.workers", syncCmd.PersistentFlags().Lookup("sync-workers"))
+	viper.BindPFlag("sync.ordered", syncCmd.PersistentFlags().Lookup("sync-ordered"))
+	viper.BindPFlag("sync.drainTimeout", syncCmd.PersistentFlags().Lookup("sync-drain-timeout"))
 	viper.BindPFlag("bitcoin.wsPath", syncCmd.PersistentFlags().Lookup("btc-ws-path"))
 }
@@ -0,0 +1,78 @@
+// Copyright © 2020 Vulcanize, Inc
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/verify"
+	v "github.com/vulcanize/ipld-btc-indexer/version"
+)
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the integrity of the data indexed in a block range",
+	Long: `Use this command to check the data indexed within a block range against itself
+Every IPLD is rehashed against its multihash key, headers and transactions are decoded and compared to their
+indexed hashes, the transactions are checked against the header's merkle root, and parent hashes are checked
+to chain to the headers indexed at the height below
+
+With --verify-repair, the heights that fail are cleared and resynced
+
+NOTE: Repairing requires a full btc node`,
+	Run: func(cmd *cobra.Command, args []string) {
+		subCommand = cmd.CalledAs()
+		logWithCommand = *log.WithField("SubCommand", subCommand)
+		verifyCmdCommand()
+	},
+}
+
+func verifyCmdCommand() {
+	logWithCommand.Infof("running ipld-btc-indexer version: %s", v.VersionWithMeta)
+	logWithCommand.Debug("loading verify configuration variables")
+	vConfig, err := verify.NewConfig()
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	logWithCommand.Infof("verify config: %+v", vConfig)
+	failures, err := verify.NewVerifyService(vConfig).Verify()
+	if err != nil {
+		logWithCommand.Fatal(err)
+	}
+	if len(failures) > 0 && !vConfig.Repair {
+		logWithCommand.Fatalf("%d bitcoin blocks failed verification between heights %d and %d", len(failures), vConfig.Start, vConfig.Stop)
+	}
+	logWithCommand.Infof("bitcoin verification of heights %d to %d finished, %d blocks failed", vConfig.Start, vConfig.Stop, len(failures))
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+
+	// flags
+	verifyCmd.PersistentFlags().Int("verify-start", 0, "block height to start verifying at")
+	verifyCmd.PersistentFlags().Int("verify-stop", 0, "block height to stop verifying at")
+	verifyCmd.PersistentFlags().Int("verify-batch-size", 0, "number of block heights to verify at a time")
+	verifyCmd.PersistentFlags().Bool("verify-repair", false, "if true, clear and resync the heights that fail verification")
+
+	// and their .toml config bindings
+	viper.BindPFlag("verify.start", verifyCmd.PersistentFlags().Lookup("verify-start"))
+	viper.BindPFlag("verify.stop", verifyCmd.PersistentFlags().Lookup("verify-stop"))
+	viper.BindPFlag("verify.batchSize", verifyCmd.PersistentFlags().Lookup("verify-batch-size"))
+	viper.BindPFlag("verify.repair", verifyCmd.PersistentFlags().Lookup("verify-repair"))
+}
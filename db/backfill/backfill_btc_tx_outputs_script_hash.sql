@@ -0,0 +1,37 @@
+-- Backfills btc.tx_outputs.script_hash, the sha256 of the output script, for the outputs indexed before migration
+-- 00020 added the column; the outputs published since carry it already
+--
+-- It is not a goose migration: it must be applied with psql after migration 00020 and before migration 00021, which
+-- sets the column NOT NULL:
+--
+--   psql -v ON_ERROR_STOP=1 -v batch_size=100 -d vulcanize_public -f db/backfill/backfill_btc_tx_outputs_script_hash.sql
+--
+-- The outputs are updated batch_size block heights at a time, each batch in its own transaction, so that the indexer
+-- can keep publishing while it runs; an interrupted run can be started again, it skips the outputs already backfilled
+
+\if :{?batch_size}
+\else
+  \set batch_size 100
+\endif
+
+-- psql variables are not interpolated into the DO block's body, so the batch size is passed in a setting
+SET btc_backfill.batch_size = :batch_size;
+
+DO $$
+DECLARE
+  batch_size CONSTANT BIGINT := current_setting('btc_backfill.batch_size')::BIGINT;
+  from_block BIGINT;
+  last_block BIGINT;
+  updated    BIGINT;
+BEGIN
+  SELECT MIN(block_number), MAX(block_number) INTO from_block, last_block FROM btc.tx_outputs;
+  WHILE from_block <= last_block LOOP
+    UPDATE btc.tx_outputs SET script_hash = sha256(pk_script)
+    WHERE block_number >= from_block AND block_number < from_block + batch_size AND script_hash IS NULL;
+    GET DIAGNOSTICS updated = ROW_COUNT;
+    COMMIT;
+    RAISE NOTICE 'backfilled % script hashes at heights % to %', updated, from_block, from_block + batch_size - 1;
+    from_block := from_block + batch_size;
+  END LOOP;
+END
+$$;
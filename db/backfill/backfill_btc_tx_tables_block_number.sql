@@ -0,0 +1,50 @@
+-- Backfills the block_number of btc.transaction_cids, btc.tx_inputs and btc.tx_outputs for the rows indexed before
+-- migration 00011 added the columns; the rows published since carry it already
+--
+-- It is not a goose migration: it must be applied with psql after migration 00011 and before migration 00012, which
+-- sets the columns NOT NULL:
+--
+--   psql -v ON_ERROR_STOP=1 -v batch_size=10000 -d vulcanize_public -f db/backfill/backfill_btc_tx_tables_block_number.sql
+--
+-- The rows are updated batch_size transaction ids at a time, each batch in its own transaction, so that the indexer
+-- can keep publishing while it runs; an interrupted run can be started again, it skips the rows already backfilled
+
+\if :{?batch_size}
+\else
+  \set batch_size 10000
+\endif
+
+-- psql variables are not interpolated into the DO block's body, so the batch size is passed in a setting
+SET btc_backfill.batch_size = :batch_size;
+
+DO $$
+DECLARE
+  batch_size CONSTANT BIGINT := current_setting('btc_backfill.batch_size')::BIGINT;
+  from_id    BIGINT;
+  last_id    BIGINT;
+  updated    BIGINT;
+BEGIN
+  SELECT MIN(id), MAX(id) INTO from_id, last_id FROM btc.transaction_cids;
+  WHILE from_id <= last_id LOOP
+    UPDATE btc.transaction_cids SET block_number = header_cids.block_number
+    FROM btc.header_cids
+    WHERE transaction_cids.header_id = header_cids.id
+    AND transaction_cids.id >= from_id AND transaction_cids.id < from_id + batch_size
+    AND transaction_cids.block_number IS NULL;
+    GET DIAGNOSTICS updated = ROW_COUNT;
+    UPDATE btc.tx_inputs SET block_number = transaction_cids.block_number
+    FROM btc.transaction_cids
+    WHERE tx_inputs.tx_id = transaction_cids.id
+    AND tx_inputs.tx_id >= from_id AND tx_inputs.tx_id < from_id + batch_size
+    AND tx_inputs.block_number IS NULL;
+    UPDATE btc.tx_outputs SET block_number = transaction_cids.block_number
+    FROM btc.transaction_cids
+    WHERE tx_outputs.tx_id = transaction_cids.id
+    AND tx_outputs.tx_id >= from_id AND tx_outputs.tx_id < from_id + batch_size
+    AND tx_outputs.block_number IS NULL;
+    COMMIT;
+    RAISE NOTICE 'backfilled the block numbers of % transactions with ids % to %', updated, from_id, from_id + batch_size - 1;
+    from_id := from_id + batch_size;
+  END LOOP;
+END
+$$;
@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE btc.sync_checkpoints (
+  node_id      INTEGER PRIMARY KEY REFERENCES nodes (id) ON DELETE CASCADE,
+  block_number BIGINT NOT NULL,
+  block_hash   VARCHAR(66) NOT NULL,
+  updated_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE btc.sync_checkpoints;
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE btc.failed_blocks (
+  id           SERIAL PRIMARY KEY,
+  node_id      INTEGER NOT NULL REFERENCES nodes (id) ON DELETE CASCADE,
+  block_number BIGINT NOT NULL,
+  block_hash   VARCHAR(66) NOT NULL,
+  attempts     INTEGER NOT NULL,
+  error        TEXT NOT NULL,
+  failed_at    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  UNIQUE (node_id, block_number, block_hash)
+);
+
+-- +goose Down
+DROP TABLE btc.failed_blocks;
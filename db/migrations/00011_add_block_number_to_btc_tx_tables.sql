@@ -0,0 +1,11 @@
+-- +goose Up
+-- nullable, so that adding them does not rewrite or scan the tables; the rows indexed before them are backfilled by
+-- db/backfill/backfill_btc_tx_tables_block_number.sql, and 00012 then sets them NOT NULL and keys the tables on them
+ALTER TABLE btc.transaction_cids ADD COLUMN block_number BIGINT;
+ALTER TABLE btc.tx_inputs ADD COLUMN block_number BIGINT;
+ALTER TABLE btc.tx_outputs ADD COLUMN block_number BIGINT;
+
+-- +goose Down
+ALTER TABLE btc.tx_outputs DROP COLUMN block_number;
+ALTER TABLE btc.tx_inputs DROP COLUMN block_number;
+ALTER TABLE btc.transaction_cids DROP COLUMN block_number;
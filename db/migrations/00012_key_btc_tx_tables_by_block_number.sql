@@ -0,0 +1,54 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+-- the block numbers of the rows indexed before 00011 must have been backfilled with
+-- db/backfill/backfill_btc_tx_tables_block_number.sql
+-- +goose StatementBegin
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM btc.transaction_cids WHERE block_number IS NULL)
+    OR EXISTS (SELECT 1 FROM btc.tx_inputs WHERE block_number IS NULL)
+    OR EXISTS (SELECT 1 FROM btc.tx_outputs WHERE block_number IS NULL) THEN
+    RAISE EXCEPTION 'btc tx tables have rows without a block_number, run make backfill_block_numbers before this migration';
+  END IF;
+END
+$$;
+-- +goose StatementEnd
+
+-- the NOT VALID checks are validated without blocking writes, and let Postgres 12+ set NOT NULL without scanning the
+-- tables; the unique indexes are built concurrently and then swapped in for the constraints they replace
+ALTER TABLE btc.transaction_cids ADD CONSTRAINT transaction_cids_block_number_not_null CHECK (block_number IS NOT NULL) NOT VALID;
+ALTER TABLE btc.transaction_cids VALIDATE CONSTRAINT transaction_cids_block_number_not_null;
+ALTER TABLE btc.transaction_cids ALTER COLUMN block_number SET NOT NULL;
+ALTER TABLE btc.transaction_cids DROP CONSTRAINT transaction_cids_block_number_not_null;
+CREATE UNIQUE INDEX CONCURRENTLY transaction_cids_block_number_header_id_tx_hash_key ON btc.transaction_cids USING btree (block_number, header_id, tx_hash);
+ALTER TABLE btc.transaction_cids DROP CONSTRAINT transaction_cids_header_id_tx_hash_key,
+  ADD CONSTRAINT transaction_cids_block_number_header_id_tx_hash_key UNIQUE USING INDEX transaction_cids_block_number_header_id_tx_hash_key;
+
+ALTER TABLE btc.tx_inputs ADD CONSTRAINT tx_inputs_block_number_not_null CHECK (block_number IS NOT NULL) NOT VALID;
+ALTER TABLE btc.tx_inputs VALIDATE CONSTRAINT tx_inputs_block_number_not_null;
+ALTER TABLE btc.tx_inputs ALTER COLUMN block_number SET NOT NULL;
+ALTER TABLE btc.tx_inputs DROP CONSTRAINT tx_inputs_block_number_not_null;
+CREATE UNIQUE INDEX CONCURRENTLY tx_inputs_block_number_tx_id_index_key ON btc.tx_inputs USING btree (block_number, tx_id, index);
+ALTER TABLE btc.tx_inputs DROP CONSTRAINT tx_inputs_tx_id_index_key,
+  ADD CONSTRAINT tx_inputs_block_number_tx_id_index_key UNIQUE USING INDEX tx_inputs_block_number_tx_id_index_key;
+
+ALTER TABLE btc.tx_outputs ADD CONSTRAINT tx_outputs_block_number_not_null CHECK (block_number IS NOT NULL) NOT VALID;
+ALTER TABLE btc.tx_outputs VALIDATE CONSTRAINT tx_outputs_block_number_not_null;
+ALTER TABLE btc.tx_outputs ALTER COLUMN block_number SET NOT NULL;
+ALTER TABLE btc.tx_outputs DROP CONSTRAINT tx_outputs_block_number_not_null;
+CREATE UNIQUE INDEX CONCURRENTLY tx_outputs_block_number_tx_id_index_key ON btc.tx_outputs USING btree (block_number, tx_id, index);
+ALTER TABLE btc.tx_outputs DROP CONSTRAINT tx_outputs_tx_id_index_key,
+  ADD CONSTRAINT tx_outputs_block_number_tx_id_index_key UNIQUE USING INDEX tx_outputs_block_number_tx_id_index_key;
+
+-- +goose Down
+ALTER TABLE btc.tx_outputs DROP CONSTRAINT tx_outputs_block_number_tx_id_index_key;
+ALTER TABLE btc.tx_outputs ADD CONSTRAINT tx_outputs_tx_id_index_key UNIQUE (tx_id, index);
+ALTER TABLE btc.tx_outputs ALTER COLUMN block_number DROP NOT NULL;
+
+ALTER TABLE btc.tx_inputs DROP CONSTRAINT tx_inputs_block_number_tx_id_index_key;
+ALTER TABLE btc.tx_inputs ADD CONSTRAINT tx_inputs_tx_id_index_key UNIQUE (tx_id, index);
+ALTER TABLE btc.tx_inputs ALTER COLUMN block_number DROP NOT NULL;
+
+ALTER TABLE btc.transaction_cids DROP CONSTRAINT transaction_cids_block_number_header_id_tx_hash_key;
+ALTER TABLE btc.transaction_cids ADD CONSTRAINT transaction_cids_header_id_tx_hash_key UNIQUE (header_id, tx_hash);
+ALTER TABLE btc.transaction_cids ALTER COLUMN block_number DROP NOT NULL;
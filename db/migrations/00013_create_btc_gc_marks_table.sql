@@ -0,0 +1,7 @@
+-- +goose Up
+CREATE UNLOGGED TABLE btc.gc_marks (
+  key TEXT PRIMARY KEY
+);
+
+-- +goose Down
+DROP TABLE btc.gc_marks;
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE btc.validation_failures (
+  id           SERIAL PRIMARY KEY,
+  node_id      INTEGER NOT NULL REFERENCES nodes (id) ON DELETE CASCADE,
+  block_number BIGINT NOT NULL,
+  indexed_hash VARCHAR(66),
+  node_hash    VARCHAR(66) NOT NULL,
+  reason       TEXT NOT NULL,
+  failed_at    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE btc.validation_failures;
@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE btc.resync_jobs (
+  id          SERIAL PRIMARY KEY,
+  data_type   TEXT NOT NULL,
+  start_block BIGINT NOT NULL,
+  stop_block  BIGINT NOT NULL,
+  created_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE btc.resync_batches (
+  job_id      INTEGER NOT NULL REFERENCES btc.resync_jobs (id) ON DELETE CASCADE,
+  start_block BIGINT NOT NULL,
+  stop_block  BIGINT NOT NULL,
+  status      TEXT NOT NULL DEFAULT 'pending',
+  attempts    INTEGER NOT NULL DEFAULT 0,
+  last_error  TEXT,
+  updated_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (job_id, start_block)
+);
+
+-- +goose Down
+DROP TABLE btc.resync_batches;
+DROP TABLE btc.resync_jobs;
@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE btc.resync_batches
+ADD COLUMN leased_by TEXT,
+ADD COLUMN lease_expires_at TIMESTAMP WITH TIME ZONE;
+
+-- +goose Down
+ALTER TABLE btc.resync_batches
+DROP COLUMN lease_expires_at,
+DROP COLUMN leased_by;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE btc.resync_jobs
+ADD COLUMN kind TEXT NOT NULL DEFAULT 'resync';
+
+-- +goose Down
+ALTER TABLE btc.resync_jobs
+DROP COLUMN kind;
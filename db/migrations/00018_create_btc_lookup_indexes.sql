@@ -0,0 +1,11 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+-- built concurrently so that the indexer can keep publishing while they are
+CREATE INDEX CONCURRENTLY header_cids_block_hash_index ON btc.header_cids USING btree (block_hash);
+CREATE INDEX CONCURRENTLY transaction_cids_tx_hash_index ON btc.transaction_cids USING btree (tx_hash);
+CREATE INDEX CONCURRENTLY tx_inputs_outpoint_index ON btc.tx_inputs USING btree (outpoint_tx_hash, outpoint_index);
+
+-- +goose Down
+DROP INDEX CONCURRENTLY btc.tx_inputs_outpoint_index;
+DROP INDEX CONCURRENTLY btc.transaction_cids_tx_hash_index;
+DROP INDEX CONCURRENTLY btc.header_cids_block_hash_index;
@@ -0,0 +1,7 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+-- built concurrently so that the indexer can keep publishing while it is
+CREATE INDEX CONCURRENTLY tx_outputs_addresses_index ON btc.tx_outputs USING gin (addresses);
+
+-- +goose Down
+DROP INDEX CONCURRENTLY btc.tx_outputs_addresses_index;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- nullable, so that adding it does not rewrite or scan the table; the outputs indexed before it are backfilled by
+-- db/backfill/backfill_btc_tx_outputs_script_hash.sql, and 00021 then sets it NOT NULL and indexes it
+ALTER TABLE btc.tx_outputs ADD COLUMN script_hash BYTEA;
+
+-- +goose Down
+ALTER TABLE btc.tx_outputs DROP COLUMN script_hash;
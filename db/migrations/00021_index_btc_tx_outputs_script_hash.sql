@@ -0,0 +1,24 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+-- the script hashes of the outputs indexed before 00020 must have been backfilled with
+-- db/backfill/backfill_btc_tx_outputs_script_hash.sql
+-- +goose StatementBegin
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM btc.tx_outputs WHERE script_hash IS NULL) THEN
+    RAISE EXCEPTION 'btc.tx_outputs has rows without a script_hash, run make backfill_script_hashes before this migration';
+  END IF;
+END
+$$;
+-- +goose StatementEnd
+
+-- the NOT VALID check is validated without blocking writes, and lets Postgres 12+ set NOT NULL without scanning the table
+ALTER TABLE btc.tx_outputs ADD CONSTRAINT tx_outputs_script_hash_not_null CHECK (script_hash IS NOT NULL) NOT VALID;
+ALTER TABLE btc.tx_outputs VALIDATE CONSTRAINT tx_outputs_script_hash_not_null;
+ALTER TABLE btc.tx_outputs ALTER COLUMN script_hash SET NOT NULL;
+ALTER TABLE btc.tx_outputs DROP CONSTRAINT tx_outputs_script_hash_not_null;
+CREATE INDEX CONCURRENTLY tx_outputs_script_hash_index ON btc.tx_outputs USING btree (script_hash);
+
+-- +goose Down
+DROP INDEX CONCURRENTLY btc.tx_outputs_script_hash_index;
+ALTER TABLE btc.tx_outputs ALTER COLUMN script_hash DROP NOT NULL;
@@ -0,0 +1,171 @@
+-- Converts btc.header_cids, btc.transaction_cids, btc.tx_inputs and btc.tx_outputs into tables that are
+-- range partitioned on block_number, moving any existing rows into the new partitions
+--
+-- This is an opt-in, one-way conversion; it is not a goose migration and must be applied with psql after
+-- all of the migrations in db/migrations have been run:
+--
+--   psql -v ON_ERROR_STOP=1 -v partition_size=100000 -d vulcanize_public -f db/partitioning/partition_btc_tables_by_block_number.sql
+--
+-- Postgres 11 cannot reference a partitioned table from a foreign key, so the partitioned tables drop their
+-- foreign keys to one another and to public.blocks; the indexer and the cleaner remove rows by block range
+-- instead of relying on cascading deletes
+-- public.blocks is left unpartitioned: IPLDs are keyed by their multihash and can be shared across heights
+--
+-- Partitions covering new heights are created by the publishers, using btc.create_block_partitions, before
+-- they write to them
+
+\if :{?partition_size}
+\else
+  \set partition_size 100000
+\endif
+
+BEGIN;
+
+CREATE TABLE btc.partitioning (
+  partition_size BIGINT NOT NULL CHECK (partition_size > 0)
+);
+INSERT INTO btc.partitioning (partition_size) VALUES (:partition_size);
+
+CREATE TABLE btc.block_partitions (
+  from_block BIGINT PRIMARY KEY,
+  to_block   BIGINT NOT NULL
+);
+
+-- create_block_partitions creates the partitions of every btc table covering the given height, if they do not exist
+-- partitions are named <table>_p<first height>, and cover [from_block, to_block)
+CREATE FUNCTION btc.create_block_partitions(height BIGINT) RETURNS VOID AS $$
+DECLARE
+  size  BIGINT;
+  lower BIGINT;
+  tbl   TEXT;
+BEGIN
+  SELECT partition_size INTO size FROM btc.partitioning;
+  lower := height - height % size;
+  PERFORM pg_advisory_xact_lock(hashtext('btc.create_block_partitions'));
+  IF EXISTS (SELECT 1 FROM btc.block_partitions WHERE from_block = lower) THEN
+    RETURN;
+  END IF;
+  FOREACH tbl IN ARRAY ARRAY['header_cids', 'transaction_cids', 'tx_inputs', 'tx_outputs'] LOOP
+    EXECUTE format('CREATE TABLE IF NOT EXISTS btc.%I PARTITION OF btc.%I FOR VALUES FROM (%s) TO (%s)',
+                   tbl || '_p' || lower, tbl, lower, lower + size);
+  END LOOP;
+  INSERT INTO btc.block_partitions (from_block, to_block) VALUES (lower, lower + size);
+END;
+$$ LANGUAGE plpgsql;
+
+-- move the existing tables, and their indexes, out of the way; their id sequences are kept and reused
+CREATE SCHEMA btc_unpartitioned;
+ALTER TABLE btc.header_cids SET SCHEMA btc_unpartitioned;
+ALTER TABLE btc.transaction_cids SET SCHEMA btc_unpartitioned;
+ALTER TABLE btc.tx_inputs SET SCHEMA btc_unpartitioned;
+ALTER TABLE btc.tx_outputs SET SCHEMA btc_unpartitioned;
+ALTER SEQUENCE btc_unpartitioned.header_cids_id_seq OWNED BY NONE;
+ALTER SEQUENCE btc_unpartitioned.transaction_cids_id_seq OWNED BY NONE;
+ALTER SEQUENCE btc_unpartitioned.tx_inputs_id_seq OWNED BY NONE;
+ALTER SEQUENCE btc_unpartitioned.tx_outputs_id_seq OWNED BY NONE;
+ALTER SEQUENCE btc_unpartitioned.header_cids_id_seq SET SCHEMA btc;
+ALTER SEQUENCE btc_unpartitioned.transaction_cids_id_seq SET SCHEMA btc;
+ALTER SEQUENCE btc_unpartitioned.tx_inputs_id_seq SET SCHEMA btc;
+ALTER SEQUENCE btc_unpartitioned.tx_outputs_id_seq SET SCHEMA btc;
+
+CREATE TABLE btc.header_cids (
+  id              INTEGER NOT NULL DEFAULT nextval('btc.header_cids_id_seq'),
+  block_number    BIGINT NOT NULL,
+  block_hash      VARCHAR(66) NOT NULL,
+  parent_hash     VARCHAR(66) NOT NULL,
+  cid             TEXT NOT NULL,
+  mh_key          TEXT NOT NULL,
+  timestamp       NUMERIC NOT NULL,
+  bits            BIGINT NOT NULL,
+  node_id         INTEGER NOT NULL REFERENCES nodes (id) ON DELETE CASCADE,
+  times_validated INTEGER NOT NULL DEFAULT 1,
+  PRIMARY KEY (block_number, id),
+  UNIQUE (block_number, block_hash)
+) PARTITION BY RANGE (block_number);
+
+CREATE TABLE btc.transaction_cids (
+  id           INTEGER NOT NULL DEFAULT nextval('btc.transaction_cids_id_seq'),
+  block_number BIGINT NOT NULL,
+  header_id    INTEGER NOT NULL,
+  index        INTEGER NOT NULL,
+  tx_hash      VARCHAR(66) NOT NULL,
+  cid          TEXT NOT NULL,
+  mh_key       TEXT NOT NULL,
+  segwit       BOOL NOT NULL,
+  witness_hash VARCHAR(66),
+  PRIMARY KEY (block_number, id),
+  UNIQUE (block_number, header_id, tx_hash)
+) PARTITION BY RANGE (block_number);
+
+CREATE TABLE btc.tx_inputs (
+  id               INTEGER NOT NULL DEFAULT nextval('btc.tx_inputs_id_seq'),
+  block_number     BIGINT NOT NULL,
+  tx_id            INTEGER NOT NULL,
+  index            INTEGER NOT NULL,
+  witness          VARCHAR[],
+  sig_script       BYTEA NOT NULL,
+  outpoint_tx_hash VARCHAR(66) NOT NULL,
+  outpoint_index   NUMERIC NOT NULL,
+  PRIMARY KEY (block_number, id),
+  UNIQUE (block_number, tx_id, index)
+) PARTITION BY RANGE (block_number);
+
+CREATE TABLE btc.tx_outputs (
+  id            INTEGER NOT NULL DEFAULT nextval('btc.tx_outputs_id_seq'),
+  block_number  BIGINT NOT NULL,
+  tx_id         INTEGER NOT NULL,
+  index         INTEGER NOT NULL,
+  value         BIGINT NOT NULL,
+  pk_script     BYTEA NOT NULL,
+  script_class  INTEGER NOT NULL,
+  addresses     VARCHAR(66)[],
+  required_sigs INTEGER NOT NULL,
+  script_hash   BYTEA NOT NULL,
+  PRIMARY KEY (block_number, id),
+  UNIQUE (block_number, tx_id, index)
+) PARTITION BY RANGE (block_number);
+
+ALTER SEQUENCE btc.header_cids_id_seq OWNED BY btc.header_cids.id;
+ALTER SEQUENCE btc.transaction_cids_id_seq OWNED BY btc.transaction_cids.id;
+ALTER SEQUENCE btc.tx_inputs_id_seq OWNED BY btc.tx_inputs.id;
+ALTER SEQUENCE btc.tx_outputs_id_seq OWNED BY btc.tx_outputs.id;
+
+COMMENT ON TABLE btc.header_cids IS E'@name BtcHeaderCids';
+COMMENT ON TABLE btc.transaction_cids IS E'@name BtcTransactionCids';
+COMMENT ON COLUMN btc.header_cids.node_id IS E'@name BtcNodeID';
+
+-- the lookup indexes added by migrations 00018, 00019 and 00020; each partition gets its own copy
+CREATE INDEX header_cids_block_hash_index ON btc.header_cids USING btree (block_hash);
+CREATE INDEX transaction_cids_tx_hash_index ON btc.transaction_cids USING btree (tx_hash);
+CREATE INDEX tx_inputs_outpoint_index ON btc.tx_inputs USING btree (outpoint_tx_hash, outpoint_index);
+CREATE INDEX tx_outputs_addresses_index ON btc.tx_outputs USING gin (addresses);
+CREATE INDEX tx_outputs_script_hash_index ON btc.tx_outputs USING btree (script_hash);
+
+-- create the partitions covering the existing rows, then copy them over
+SELECT btc.create_block_partitions(height)
+FROM generate_series(
+  (SELECT MIN(block_number) FROM btc_unpartitioned.header_cids),
+  (SELECT MAX(block_number) FROM btc_unpartitioned.header_cids),
+  :partition_size
+) AS height;
+SELECT btc.create_block_partitions(MAX(block_number)) FROM btc_unpartitioned.header_cids HAVING COUNT(*) > 0;
+
+INSERT INTO btc.header_cids (id, block_number, block_hash, parent_hash, cid, mh_key, timestamp, bits, node_id, times_validated)
+SELECT id, block_number, block_hash, parent_hash, cid, mh_key, timestamp, bits, node_id, times_validated
+FROM btc_unpartitioned.header_cids;
+
+INSERT INTO btc.transaction_cids (id, block_number, header_id, index, tx_hash, cid, mh_key, segwit, witness_hash)
+SELECT id, block_number, header_id, index, tx_hash, cid, mh_key, segwit, witness_hash
+FROM btc_unpartitioned.transaction_cids;
+
+INSERT INTO btc.tx_inputs (id, block_number, tx_id, index, witness, sig_script, outpoint_tx_hash, outpoint_index)
+SELECT id, block_number, tx_id, index, witness, sig_script, outpoint_tx_hash, outpoint_index
+FROM btc_unpartitioned.tx_inputs;
+
+INSERT INTO btc.tx_outputs (id, block_number, tx_id, index, value, pk_script, script_class, addresses, required_sigs, script_hash)
+SELECT id, block_number, tx_id, index, value, pk_script, script_class, addresses, required_sigs, script_hash
+FROM btc_unpartitioned.tx_outputs;
+
+DROP SCHEMA btc_unpartitioned CASCADE;
+
+COMMIT;
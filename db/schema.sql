@@ -27,6 +27,50 @@ SET default_tablespace = '';
 
 SET default_table_access_method = heap;
 
+--
+-- Name: failed_blocks; Type: TABLE; Schema: btc; Owner: -
+--
+
+CREATE TABLE btc.failed_blocks (
+    id integer NOT NULL,
+    node_id integer NOT NULL,
+    block_number bigint NOT NULL,
+    block_hash character varying(66) NOT NULL,
+    attempts integer NOT NULL,
+    error text NOT NULL,
+    failed_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: failed_blocks_id_seq; Type: SEQUENCE; Schema: btc; Owner: -
+--
+
+CREATE SEQUENCE btc.failed_blocks_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: failed_blocks_id_seq; Type: SEQUENCE OWNED BY; Schema: btc; Owner: -
+--
+
+ALTER SEQUENCE btc.failed_blocks_id_seq OWNED BY btc.failed_blocks.id;
+
+
+--
+-- Name: gc_marks; Type: TABLE; Schema: btc; Owner: -
+--
+
+CREATE UNLOGGED TABLE btc.gc_marks (
+    key text NOT NULL
+);
+
+
 --
 -- Name: header_cids; Type: TABLE; Schema: btc; Owner: -
 --
@@ -79,6 +123,70 @@ CREATE SEQUENCE btc.header_cids_id_seq
 ALTER SEQUENCE btc.header_cids_id_seq OWNED BY btc.header_cids.id;
 
 
+--
+-- Name: resync_batches; Type: TABLE; Schema: btc; Owner: -
+--
+
+CREATE TABLE btc.resync_batches (
+    job_id integer NOT NULL,
+    start_block bigint NOT NULL,
+    stop_block bigint NOT NULL,
+    status text DEFAULT 'pending'::text NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    last_error text,
+    updated_at timestamp with time zone DEFAULT now() NOT NULL,
+    leased_by text,
+    lease_expires_at timestamp with time zone
+);
+
+
+--
+-- Name: resync_jobs; Type: TABLE; Schema: btc; Owner: -
+--
+
+CREATE TABLE btc.resync_jobs (
+    id integer NOT NULL,
+    data_type text NOT NULL,
+    start_block bigint NOT NULL,
+    stop_block bigint NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    finished_at timestamp with time zone,
+    kind text DEFAULT 'resync'::text NOT NULL
+);
+
+
+--
+-- Name: resync_jobs_id_seq; Type: SEQUENCE; Schema: btc; Owner: -
+--
+
+CREATE SEQUENCE btc.resync_jobs_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: resync_jobs_id_seq; Type: SEQUENCE OWNED BY; Schema: btc; Owner: -
+--
+
+ALTER SEQUENCE btc.resync_jobs_id_seq OWNED BY btc.resync_jobs.id;
+
+
+--
+-- Name: sync_checkpoints; Type: TABLE; Schema: btc; Owner: -
+--
+
+CREATE TABLE btc.sync_checkpoints (
+    node_id integer NOT NULL,
+    block_number bigint NOT NULL,
+    block_hash character varying(66) NOT NULL,
+    updated_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
 --
 -- Name: transaction_cids; Type: TABLE; Schema: btc; Owner: -
 --
@@ -91,7 +199,8 @@ CREATE TABLE btc.transaction_cids (
     cid text NOT NULL,
     mh_key text NOT NULL,
     segwit boolean NOT NULL,
-    witness_hash character varying(66)
+    witness_hash character varying(66),
+    block_number bigint NOT NULL
 );
 
 
@@ -133,7 +242,8 @@ CREATE TABLE btc.tx_inputs (
     witness character varying[],
     sig_script bytea NOT NULL,
     outpoint_tx_hash character varying(66) NOT NULL,
-    outpoint_index numeric NOT NULL
+    outpoint_index numeric NOT NULL,
+    block_number bigint NOT NULL
 );
 
 
@@ -169,7 +279,9 @@ CREATE TABLE btc.tx_outputs (
     pk_script bytea NOT NULL,
     script_class integer NOT NULL,
     addresses character varying(66)[],
-    required_sigs integer NOT NULL
+    required_sigs integer NOT NULL,
+    block_number bigint NOT NULL,
+    script_hash bytea NOT NULL
 );
 
 
@@ -193,6 +305,41 @@ CREATE SEQUENCE btc.tx_outputs_id_seq
 ALTER SEQUENCE btc.tx_outputs_id_seq OWNED BY btc.tx_outputs.id;
 
 
+--
+-- Name: validation_failures; Type: TABLE; Schema: btc; Owner: -
+--
+
+CREATE TABLE btc.validation_failures (
+    id integer NOT NULL,
+    node_id integer NOT NULL,
+    block_number bigint NOT NULL,
+    indexed_hash character varying(66),
+    node_hash character varying(66) NOT NULL,
+    reason text NOT NULL,
+    failed_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Name: validation_failures_id_seq; Type: SEQUENCE; Schema: btc; Owner: -
+--
+
+CREATE SEQUENCE btc.validation_failures_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+
+--
+-- Name: validation_failures_id_seq; Type: SEQUENCE OWNED BY; Schema: btc; Owner: -
+--
+
+ALTER SEQUENCE btc.validation_failures_id_seq OWNED BY btc.validation_failures.id;
+
+
 --
 -- Name: blocks; Type: TABLE; Schema: public; Owner: -
 --
@@ -282,6 +429,13 @@ CREATE SEQUENCE public.nodes_id_seq
 ALTER SEQUENCE public.nodes_id_seq OWNED BY public.nodes.id;
 
 
+--
+-- Name: failed_blocks id; Type: DEFAULT; Schema: btc; Owner: -
+--
+
+ALTER TABLE ONLY btc.failed_blocks ALTER COLUMN id SET DEFAULT nextval('btc.failed_blocks_id_seq'::regclass);
+
+
 --
 -- Name: header_cids id; Type: DEFAULT; Schema: btc; Owner: -
 --
@@ -289,6 +443,13 @@ ALTER SEQUENCE public.nodes_id_seq OWNED BY public.nodes.id;
 ALTER TABLE ONLY btc.header_cids ALTER COLUMN id SET DEFAULT nextval('btc.header_cids_id_seq'::regclass);
 
 
+--
+-- Name: resync_jobs id; Type: DEFAULT; Schema: btc; Owner: -
+--
+
+ALTER TABLE ONLY btc.resync_jobs ALTER COLUMN id SET DEFAULT nextval('btc.resync_jobs_id_seq'::regclass);
+
+
 --
 -- Name: transaction_cids id; Type: DEFAULT; Schema: btc; Owner: -
 --
@@ -310,6 +471,13 @@ ALTER TABLE ONLY btc.tx_inputs ALTER COLUMN id SET DEFAULT nextval('btc.tx_input
 ALTER TABLE ONLY btc.tx_outputs ALTER COLUMN id SET DEFAULT nextval('btc.tx_outputs_id_seq'::regclass);
 
 
+--
+-- Name: validation_failures id; Type: DEFAULT; Schema: btc; Owner: -
+--
+
+ALTER TABLE ONLY btc.validation_failures ALTER COLUMN id SET DEFAULT nextval('btc.validation_failures_id_seq'::regclass);
+
+
 --
 -- Name: goose_db_version id; Type: DEFAULT; Schema: public; Owner: -
 --
@@ -324,6 +492,30 @@ ALTER TABLE ONLY public.goose_db_version ALTER COLUMN id SET DEFAULT nextval('pu
 ALTER TABLE ONLY public.nodes ALTER COLUMN id SET DEFAULT nextval('public.nodes_id_seq'::regclass);
 
 
+--
+-- Name: failed_blocks failed_blocks_node_id_block_number_block_hash_key; Type: CONSTRAINT; Schema: btc; Owner: -
+--
+
+ALTER TABLE ONLY btc.failed_blocks
+    ADD CONSTRAINT failed_blocks_node_id_block_number_block_hash_key UNIQUE (node_id, block_number, block_hash);
+
+
+--
+-- Name: failed_blocks failed_blocks_pkey; Type: CONSTRAINT; Schema: btc; Owner: -
+--
+
+ALTER TABLE ONLY btc.failed_blocks
+    ADD CONSTRAINT failed_blocks_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: gc_marks gc_marks_pkey; Type: CONSTRAINT; Schema: btc; Owner: -
+--
+
+ALTER TABLE ONLY btc.gc_marks
+    ADD CONSTRAINT gc_marks_pkey PRIMARY KEY (key);
+
+
 --
 -- Name: header_cids header_cids_block_number_block_hash_key; Type: CONSTRAINT; Schema: btc; Owner: -
 --
@@ -341,11 +533,35 @@ ALTER TABLE ONLY btc.header_cids
 
 
 --
--- Name: transaction_cids transaction_cids_header_id_tx_hash_key; Type: CONSTRAINT; Schema: btc; Owner: -
+-- Name: resync_batches resync_batches_pkey; Type: CONSTRAINT; Schema: btc; Owner: -
+--
+
+ALTER TABLE ONLY btc.resync_batches
+    ADD CONSTRAINT resync_batches_pkey PRIMARY KEY (job_id, start_block);
+
+
+--
+-- Name: resync_jobs resync_jobs_pkey; Type: CONSTRAINT; Schema: btc; Owner: -
+--
+
+ALTER TABLE ONLY btc.resync_jobs
+    ADD CONSTRAINT resync_jobs_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: sync_checkpoints sync_checkpoints_pkey; Type: CONSTRAINT; Schema: btc; Owner: -
+--
+
+ALTER TABLE ONLY btc.sync_checkpoints
+    ADD CONSTRAINT sync_checkpoints_pkey PRIMARY KEY (node_id);
+
+
+--
+-- Name: transaction_cids transaction_cids_block_number_header_id_tx_hash_key; Type: CONSTRAINT; Schema: btc; Owner: -
 --
 
 ALTER TABLE ONLY btc.transaction_cids
-    ADD CONSTRAINT transaction_cids_header_id_tx_hash_key UNIQUE (header_id, tx_hash);
+    ADD CONSTRAINT transaction_cids_block_number_header_id_tx_hash_key UNIQUE (block_number, header_id, tx_hash);
 
 
 --
@@ -356,6 +572,14 @@ ALTER TABLE ONLY btc.transaction_cids
     ADD CONSTRAINT transaction_cids_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: tx_inputs tx_inputs_block_number_tx_id_index_key; Type: CONSTRAINT; Schema: btc; Owner: -
+--
+
+ALTER TABLE ONLY btc.tx_inputs
+    ADD CONSTRAINT tx_inputs_block_number_tx_id_index_key UNIQUE (block_number, tx_id, index);
+
+
 --
 -- Name: tx_inputs tx_inputs_pkey; Type: CONSTRAINT; Schema: btc; Owner: -
 --
@@ -365,11 +589,11 @@ ALTER TABLE ONLY btc.tx_inputs
 
 
 --
--- Name: tx_inputs tx_inputs_tx_id_index_key; Type: CONSTRAINT; Schema: btc; Owner: -
+-- Name: tx_outputs tx_outputs_block_number_tx_id_index_key; Type: CONSTRAINT; Schema: btc; Owner: -
 --
 
-ALTER TABLE ONLY btc.tx_inputs
-    ADD CONSTRAINT tx_inputs_tx_id_index_key UNIQUE (tx_id, index);
+ALTER TABLE ONLY btc.tx_outputs
+    ADD CONSTRAINT tx_outputs_block_number_tx_id_index_key UNIQUE (block_number, tx_id, index);
 
 
 --
@@ -381,11 +605,11 @@ ALTER TABLE ONLY btc.tx_outputs
 
 
 --
--- Name: tx_outputs tx_outputs_tx_id_index_key; Type: CONSTRAINT; Schema: btc; Owner: -
+-- Name: validation_failures validation_failures_pkey; Type: CONSTRAINT; Schema: btc; Owner: -
 --
 
-ALTER TABLE ONLY btc.tx_outputs
-    ADD CONSTRAINT tx_outputs_tx_id_index_key UNIQUE (tx_id, index);
+ALTER TABLE ONLY btc.validation_failures
+    ADD CONSTRAINT validation_failures_pkey PRIMARY KEY (id);
 
 
 --
@@ -420,6 +644,14 @@ ALTER TABLE ONLY public.nodes
     ADD CONSTRAINT nodes_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: failed_blocks failed_blocks_node_id_fkey; Type: FK CONSTRAINT; Schema: btc; Owner: -
+--
+
+ALTER TABLE ONLY btc.failed_blocks
+    ADD CONSTRAINT failed_blocks_node_id_fkey FOREIGN KEY (node_id) REFERENCES public.nodes(id) ON DELETE CASCADE;
+
+
 --
 -- Name: header_cids header_cids_mh_key_fkey; Type: FK CONSTRAINT; Schema: btc; Owner: -
 --
@@ -436,6 +668,22 @@ ALTER TABLE ONLY btc.header_cids
     ADD CONSTRAINT header_cids_node_id_fkey FOREIGN KEY (node_id) REFERENCES public.nodes(id) ON DELETE CASCADE;
 
 
+--
+-- Name: resync_batches resync_batches_job_id_fkey; Type: FK CONSTRAINT; Schema: btc; Owner: -
+--
+
+ALTER TABLE ONLY btc.resync_batches
+    ADD CONSTRAINT resync_batches_job_id_fkey FOREIGN KEY (job_id) REFERENCES btc.resync_jobs(id) ON DELETE CASCADE;
+
+
+--
+-- Name: sync_checkpoints sync_checkpoints_node_id_fkey; Type: FK CONSTRAINT; Schema: btc; Owner: -
+--
+
+ALTER TABLE ONLY btc.sync_checkpoints
+    ADD CONSTRAINT sync_checkpoints_node_id_fkey FOREIGN KEY (node_id) REFERENCES public.nodes(id) ON DELETE CASCADE;
+
+
 --
 -- Name: transaction_cids transaction_cids_header_id_fkey; Type: FK CONSTRAINT; Schema: btc; Owner: -
 --
@@ -468,6 +716,14 @@ ALTER TABLE ONLY btc.tx_outputs
     ADD CONSTRAINT tx_outputs_tx_id_fkey FOREIGN KEY (tx_id) REFERENCES btc.transaction_cids(id) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED;
 
 
+--
+-- Name: validation_failures validation_failures_node_id_fkey; Type: FK CONSTRAINT; Schema: btc; Owner: -
+--
+
+ALTER TABLE ONLY btc.validation_failures
+    ADD CONSTRAINT validation_failures_node_id_fkey FOREIGN KEY (node_id) REFERENCES public.nodes(id) ON DELETE CASCADE;
+
+
 --
 -- PostgreSQL database dump complete
 --
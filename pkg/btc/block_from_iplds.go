@@ -0,0 +1,201 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/ipfs/go-cid"
+	"github.com/lib/pq"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/ipfs/ipld"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+)
+
+// BlockFromIPLDs reassembles the header's block from its IPLDs in public.blocks: the header, the tx trie it links to
+// and the transactions at the trie's leaves. The header IPLD must hash to the header's hash, every node and transaction
+// must hash to the link it was reached by, and the transactions must produce the header's merkle root
+// Only the number of transactions is taken from the index. The trie's leaves are the CIDs of the transactions serialized
+// with their witnesses, so blocks with witness transactions have no trie under their merkle root; their trie is walked
+// from the root rebuilt from the indexed transaction CIDs instead
+func (r *DBChainReader) BlockFromIPLDs(header *IndexedHeader) (*wire.MsgBlock, error) {
+	wireHeader, err := header.WireHeader()
+	if err != nil {
+		return nil, err
+	}
+	if hash := wireHeader.BlockHash().String(); hash != header.BlockHash {
+		return nil, fmt.Errorf("header IPLD hashes to %s, not %s", hash, header.BlockHash)
+	}
+	headerNode, err := ipld.NewBtcHeader(wireHeader)
+	if err != nil {
+		return nil, err
+	}
+	var root chainhash.Hash
+	for _, link := range headerNode.Links() {
+		if link.Name == "tx" {
+			if root, err = cidHash(link.Cid); err != nil {
+				return nil, err
+			}
+		}
+	}
+	txs, err := r.Txs(header)
+	if err != nil {
+		return nil, err
+	}
+	if len(txs) == 0 {
+		return nil, fmt.Errorf("block %s has no transactions indexed", header.BlockHash)
+	}
+	if _, err := r.txIPLD(root); errors.Is(err, ErrNotIndexed) {
+		if root, err = indexedTrieRoot(txs); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	leaves, err := r.trieLeaves(root, uint32(len(txs)))
+	if err != nil {
+		return nil, fmt.Errorf("block %s: %w", header.BlockHash, err)
+	}
+	data, err := r.txIPLDs(leaves)
+	if err != nil {
+		return nil, err
+	}
+	msgBlock := wire.NewMsgBlock(wireHeader)
+	for i, leaf := range leaves {
+		raw, ok := data[leaf]
+		if !ok {
+			return nil, fmt.Errorf("transaction %d of block %s: IPLD %s is %w", i, header.BlockHash, leaf.String(), ErrNotIndexed)
+		}
+		if chainhash.DoubleHashH(raw) != leaf {
+			return nil, fmt.Errorf("transaction %d of block %s does not hash to its tx trie leaf %s", i, header.BlockHash, leaf.String())
+		}
+		msgTx := new(wire.MsgTx)
+		if err := msgTx.Deserialize(bytes.NewReader(raw)); err != nil {
+			return nil, fmt.Errorf("transaction %d of block %s does not decode: %v", i, header.BlockHash, err)
+		}
+		if err := msgBlock.AddTransaction(msgTx); err != nil {
+			return nil, err
+		}
+	}
+	merkles := blockchain.BuildMerkleTreeStore(btcutil.NewBlock(msgBlock).Transactions(), false)
+	if root := merkles[len(merkles)-1]; !root.IsEqual(&wireHeader.MerkleRoot) {
+		return nil, fmt.Errorf("transactions of block %s produce merkle root %s, header has %s", header.BlockHash, root.String(), wireHeader.MerkleRoot.String())
+	}
+	return msgBlock, nil
+}
+
+// trieLeaves walks the tx trie down from the root, a layer at a time, to the hashes of its numTxs leaves in block order
+func (r *DBChainReader) trieLeaves(root chainhash.Hash, numTxs uint32) ([]chainhash.Hash, error) {
+	layer := []chainhash.Hash{root}
+	for height := treeHeight(numTxs); height > 0; height-- {
+		data, err := r.txIPLDs(layer)
+		if err != nil {
+			return nil, err
+		}
+		width := treeWidth(numTxs, height-1)
+		next := make([]chainhash.Hash, 0, width)
+		for _, node := range layer {
+			raw, ok := data[node]
+			if !ok {
+				return nil, fmt.Errorf("tx trie node %s is %w", node.String(), ErrNotIndexed)
+			}
+			if len(raw) != 2*chainhash.HashSize || chainhash.DoubleHashH(raw) != node {
+				return nil, fmt.Errorf("tx trie node %s does not match its stored data", node.String())
+			}
+			var left, right chainhash.Hash
+			copy(left[:], raw[:chainhash.HashSize])
+			copy(right[:], raw[chainhash.HashSize:])
+			next = append(next, left)
+			if uint32(len(next)) < width {
+				next = append(next, right)
+			} else if right != left {
+				return nil, fmt.Errorf("tx trie node %s does not duplicate the last node of its layer", node.String())
+			}
+		}
+		layer = next
+	}
+	return layer, nil
+}
+
+// txIPLDs returns the stored data of the transaction and tx trie node IPLDs with the hashes, by hash
+// hashes that are not stored are missing from the result
+func (r *DBChainReader) txIPLDs(hashes []chainhash.Hash) (map[chainhash.Hash][]byte, error) {
+	keys := make([]string, len(hashes))
+	byKey := make(map[string]chainhash.Hash, len(hashes))
+	for i, hash := range hashes {
+		mh, err := multihash.Encode(hash[:], multihash.DBL_SHA2_256)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = shared.MultihashKeyFromCID(cid.NewCidV1(ipld.MBitcoinTx, mh))
+		byKey[keys[i]] = hash
+	}
+	var stored []struct {
+		Key  string `db:"key"`
+		Data []byte `db:"data"`
+	}
+	if err := r.db.Select(&stored, `SELECT key, data FROM public.blocks WHERE key = ANY($1)`, pq.Array(keys)); err != nil {
+		return nil, err
+	}
+	data := make(map[chainhash.Hash][]byte, len(stored))
+	for _, block := range stored {
+		data[byKey[block.Key]] = block.Data
+	}
+	return data, nil
+}
+
+// indexedTrieRoot returns the hash of the root of the tx trie built from the CIDs of the indexed transactions
+func indexedTrieRoot(txs []IndexedTx) (chainhash.Hash, error) {
+	txCIDs := make([]cid.Cid, len(txs))
+	for i, tx := range txs {
+		if tx.Index != int64(i) {
+			return chainhash.Hash{}, fmt.Errorf("transaction %d is not indexed", i)
+		}
+		c, err := cid.Decode(tx.CID)
+		if err != nil {
+			return chainhash.Hash{}, err
+		}
+		txCIDs[i] = c
+	}
+	trie := ipld.TxTrieFromCIDs(txCIDs)
+	if len(trie) == 0 {
+		return cidHash(txCIDs[0])
+	}
+	return cidHash(trie[len(trie)-1].Cid())
+}
+
+// cidHash returns the double sha256 digest of a bitcoin CID as a hash
+func cidHash(c cid.Cid) (chainhash.Hash, error) {
+	decoded, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+	if decoded.Code != multihash.DBL_SHA2_256 || len(decoded.Digest) != chainhash.HashSize {
+		return chainhash.Hash{}, fmt.Errorf("CID %s is not a double sha256 hash", c.String())
+	}
+	var hash chainhash.Hash
+	copy(hash[:], decoded.Digest)
+	return hash, nil
+}
@@ -0,0 +1,225 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/prom"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+)
+
+// BulkPublisher satisfies the Publisher interface for bitcoin
+// Instead of inserting rows one at a time, it COPYs a whole batch of blocks into temporary staging tables
+// and then moves them into the real tables with one set-based upsert per table, all in a single sqlx.Tx
+type BulkPublisher struct {
+	db         *postgres.DB
+	partitions *partitioner
+}
+
+// NewBulkPublisher creates a pointer to a new BulkPublisher
+func NewBulkPublisher(db *postgres.DB) *BulkPublisher {
+	return &BulkPublisher{
+		db:         db,
+		partitions: newPartitioner(db),
+	}
+}
+
+// the staging tables identify rows by their natural keys since the serial ids don't exist until the upserts
+var stagingTables = []string{
+	`CREATE TEMP TABLE tmp_blocks (key TEXT, data BYTEA) ON COMMIT DROP`,
+	`CREATE TEMP TABLE tmp_header_cids (block_number BIGINT, block_hash VARCHAR(66), parent_hash VARCHAR(66), cid TEXT,
+		mh_key TEXT, timestamp NUMERIC, bits BIGINT, node_id INTEGER, times_validated INTEGER) ON COMMIT DROP`,
+	`CREATE TEMP TABLE tmp_transaction_cids (block_number BIGINT, block_hash VARCHAR(66), tx_hash VARCHAR(66), index INTEGER,
+		cid TEXT, mh_key TEXT, segwit BOOL, witness_hash VARCHAR(66)) ON COMMIT DROP`,
+	`CREATE TEMP TABLE tmp_tx_inputs (block_number BIGINT, block_hash VARCHAR(66), tx_hash VARCHAR(66), index INTEGER,
+		witness VARCHAR[], sig_script BYTEA, outpoint_tx_hash VARCHAR(66), outpoint_index NUMERIC) ON COMMIT DROP`,
+	`CREATE TEMP TABLE tmp_tx_outputs (block_number BIGINT, block_hash VARCHAR(66), tx_hash VARCHAR(66), index INTEGER,
+		value BIGINT, pk_script BYTEA, script_class INTEGER, addresses VARCHAR(66)[], required_sigs INTEGER, script_hash BYTEA) ON COMMIT DROP`,
+}
+
+// the upserts are run in this order so that each can join against the rows inserted by the one before it
+var stagingUpserts = []struct {
+	table string
+	pgStr string
+}{
+	{"public.blocks", `INSERT INTO public.blocks (key, data)
+		SELECT key, data FROM tmp_blocks
+		ON CONFLICT (key) DO NOTHING`},
+	{"btc.header_cids", `INSERT INTO btc.header_cids (block_number, block_hash, parent_hash, cid, timestamp, bits, node_id, mh_key, times_validated)
+		SELECT DISTINCT ON (block_number, block_hash) block_number, block_hash, parent_hash, cid, timestamp, bits, node_id, mh_key, times_validated
+		FROM tmp_header_cids
+		ON CONFLICT (block_number, block_hash) DO UPDATE SET (parent_hash, cid, timestamp, bits, node_id, mh_key) =
+		(EXCLUDED.parent_hash, EXCLUDED.cid, EXCLUDED.timestamp, EXCLUDED.bits, EXCLUDED.node_id, EXCLUDED.mh_key)`},
+	{"btc.transaction_cids", `INSERT INTO btc.transaction_cids (header_id, tx_hash, index, cid, segwit, witness_hash, mh_key, block_number)
+		SELECT DISTINCT ON (header_cids.id, tmp.tx_hash) header_cids.id, tmp.tx_hash, tmp.index, tmp.cid, tmp.segwit, tmp.witness_hash, tmp.mh_key, tmp.block_number
+		FROM tmp_transaction_cids AS tmp
+		INNER JOIN btc.header_cids ON (header_cids.block_number = tmp.block_number AND header_cids.block_hash = tmp.block_hash)
+		ON CONFLICT (block_number, header_id, tx_hash) DO UPDATE SET (index, cid, segwit, witness_hash, mh_key) =
+		(EXCLUDED.index, EXCLUDED.cid, EXCLUDED.segwit, EXCLUDED.witness_hash, EXCLUDED.mh_key)`},
+	{"btc.tx_inputs", `INSERT INTO btc.tx_inputs (tx_id, index, witness, sig_script, outpoint_tx_hash, outpoint_index, block_number)
+		SELECT DISTINCT ON (transaction_cids.id, tmp.index) transaction_cids.id, tmp.index, tmp.witness, tmp.sig_script, tmp.outpoint_tx_hash, tmp.outpoint_index, tmp.block_number
+		FROM tmp_tx_inputs AS tmp
+		INNER JOIN btc.header_cids ON (header_cids.block_number = tmp.block_number AND header_cids.block_hash = tmp.block_hash)
+		INNER JOIN btc.transaction_cids ON (transaction_cids.block_number = tmp.block_number AND transaction_cids.header_id = header_cids.id AND transaction_cids.tx_hash = tmp.tx_hash)
+		ON CONFLICT (block_number, tx_id, index) DO UPDATE SET (witness, sig_script, outpoint_tx_hash, outpoint_index) =
+		(EXCLUDED.witness, EXCLUDED.sig_script, EXCLUDED.outpoint_tx_hash, EXCLUDED.outpoint_index)`},
+	{"btc.tx_outputs", `INSERT INTO btc.tx_outputs (tx_id, index, value, pk_script, script_class, addresses, required_sigs, block_number, script_hash)
+		SELECT DISTINCT ON (transaction_cids.id, tmp.index) transaction_cids.id, tmp.index, tmp.value, tmp.pk_script, tmp.script_class, tmp.addresses, tmp.required_sigs, tmp.block_number, tmp.script_hash
+		FROM tmp_tx_outputs AS tmp
+		INNER JOIN btc.header_cids ON (header_cids.block_number = tmp.block_number AND header_cids.block_hash = tmp.block_hash)
+		INNER JOIN btc.transaction_cids ON (transaction_cids.block_number = tmp.block_number AND transaction_cids.header_id = header_cids.id AND transaction_cids.tx_hash = tmp.tx_hash)
+		ON CONFLICT (block_number, tx_id, index) DO UPDATE SET (value, pk_script, script_class, addresses, required_sigs, script_hash) =
+		(EXCLUDED.value, EXCLUDED.pk_script, EXCLUDED.script_class, EXCLUDED.addresses, EXCLUDED.required_sigs, EXCLUDED.script_hash)`},
+}
+
+// Publish publishes and indexes a single payload
+func (pub *BulkPublisher) Publish(ctx context.Context, payload ConvertedPayload) error {
+	return pub.PublishBatch(ctx, []ConvertedPayload{payload})
+}
+
+// PublishBatch publishes and indexes the payloads together in a single tx
+func (pub *BulkPublisher) PublishBatch(ctx context.Context, payloads []ConvertedPayload) (err error) {
+	prepared := make([]*PreparedPayload, len(payloads))
+	for i, payload := range payloads {
+		if prepared[i], err = prepare(payload); err != nil {
+			return err
+		}
+	}
+	if err = pub.partitions.ensure(heights(prepared)...); err != nil {
+		return err
+	}
+
+	// Begin new db tx
+	tx, err := pub.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			shared.Rollback(tx)
+			panic(p)
+		} else if err != nil {
+			shared.Rollback(tx)
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	if err = shareGCLock(tx); err != nil {
+		return err
+	}
+
+	for _, pgStr := range stagingTables {
+		if _, err = tx.Exec(pgStr); err != nil {
+			return err
+		}
+	}
+	if err = stage(tx, pub.db.NodeID, prepared); err != nil {
+		return err
+	}
+	for _, upsert := range stagingUpserts {
+		start := time.Now()
+		if _, err = tx.Exec(upsert.pgStr); err != nil {
+			return err
+		}
+		prom.PublishDuration(upsert.table, start)
+	}
+	return checkBatchLease(ctx, tx)
+}
+
+// stage COPYs the rows for the payloads into the staging tables
+func stage(tx *sqlx.Tx, nodeID int64, payloads []*PreparedPayload) error {
+	blocks := newCopier(tx, "tmp_blocks", "key", "data")
+	headers := newCopier(tx, "tmp_header_cids", "block_number", "block_hash", "parent_hash", "cid", "mh_key", "timestamp", "bits", "node_id", "times_validated")
+	txs := newCopier(tx, "tmp_transaction_cids", "block_number", "block_hash", "tx_hash", "index", "cid", "mh_key", "segwit", "witness_hash")
+	inputs := newCopier(tx, "tmp_tx_inputs", "block_number", "block_hash", "tx_hash", "index", "witness", "sig_script", "outpoint_tx_hash", "outpoint_index")
+	outputs := newCopier(tx, "tmp_tx_outputs", "block_number", "block_hash", "tx_hash", "index", "value", "pk_script", "script_class", "addresses", "required_sigs", "script_hash")
+	for _, payload := range payloads {
+		for _, node := range payload.txTrieNodes {
+			blocks.add(shared.MultihashKeyFromCID(node.Cid()), node.RawData())
+		}
+		blocks.add(shared.MultihashKeyFromCID(payload.headerNode.Cid()), payload.headerNode.RawData())
+		header := payload.headerModel()
+		headers.add(payload.BlockHeight, header.BlockHash, header.ParentHash, header.CID, header.MhKey, header.Timestamp, header.Bits, nodeID, payload.validations())
+		for i, txNode := range payload.txNodes {
+			blocks.add(shared.MultihashKeyFromCID(txNode.Cid()), txNode.RawData())
+			txModel := payload.TxMetaData[i]
+			txs.add(payload.BlockHeight, header.BlockHash, txModel.TxHash, txModel.Index, txNode.Cid().String(),
+				shared.MultihashKeyFromCID(txNode.Cid()), txModel.SegWit, txModel.WitnessHash)
+			for _, input := range txModel.TxInputs {
+				inputs.add(payload.BlockHeight, header.BlockHash, txModel.TxHash, input.Index, input.TxWitness,
+					input.SignatureScript, input.PreviousOutPointHash, input.PreviousOutPointIndex)
+			}
+			for _, output := range txModel.TxOutputs {
+				outputs.add(payload.BlockHeight, header.BlockHash, txModel.TxHash, output.Index, output.Value,
+					output.PkScript, output.ScriptClass, output.Addresses, output.RequiredSigs, output.ScriptHash)
+			}
+		}
+	}
+	for _, c := range []*copier{blocks, headers, txs, inputs, outputs} {
+		if err := c.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copier buffers the rows for a COPY into a single table
+// the rows for every table are collected first and then copied one table after the other,
+// since a connection can only have one COPY in progress at a time
+type copier struct {
+	tx      *sqlx.Tx
+	table   string
+	columns []string
+	rows    [][]interface{}
+}
+
+func newCopier(tx *sqlx.Tx, table string, columns ...string) *copier {
+	return &copier{
+		tx:      tx,
+		table:   table,
+		columns: columns,
+	}
+}
+
+func (c *copier) add(values ...interface{}) {
+	c.rows = append(c.rows, values)
+}
+
+func (c *copier) flush() error {
+	stmt, err := c.tx.Prepare(pq.CopyIn(c.table, c.columns...))
+	if err != nil {
+		return err
+	}
+	for _, row := range c.rows {
+		if _, err := stmt.Exec(row...); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+	return stmt.Close()
+}
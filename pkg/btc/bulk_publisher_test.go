@@ -0,0 +1,150 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+)
+
+// payloadsAt returns copies of the mock payload at count consecutive heights beginning at start
+func payloadsAt(start int64, count int) []btc.ConvertedPayload {
+	payloads := make([]btc.ConvertedPayload, count)
+	for i := range payloads {
+		payloads[i] = mocks.MockConvertedPayload
+		payloads[i].BlockHeight = start + int64(i)
+	}
+	return payloads
+}
+
+var _ = Describe("BulkPublisher", func() {
+	var (
+		db   *postgres.DB
+		err  error
+		bulk *btc.BulkPublisher
+		repo *btc.IPLDPublisher
+	)
+	BeforeEach(func() {
+		db, err = shared.SetupDB()
+		Expect(err).ToNot(HaveOccurred())
+		bulk = btc.NewBulkPublisher(db)
+		repo = btc.NewIPLDPublisher(db)
+	})
+	AfterEach(func() {
+		btc.TearDownDB(db)
+	})
+
+	Describe("PublishBatch", func() {
+		It("Indexes the same rows as the IPLDPublisher", func() {
+			err = repo.Publish(context.Background(), mocks.MockConvertedPayload)
+			Expect(err).ToNot(HaveOccurred())
+			var expectedHeaders []btc.HeaderModel
+			err = db.Select(&expectedHeaders, `SELECT block_number, block_hash, parent_hash, cid, mh_key, timestamp, bits, times_validated FROM btc.header_cids`)
+			Expect(err).ToNot(HaveOccurred())
+			var expectedTxs []btc.TxModel
+			err = db.Select(&expectedTxs, `SELECT index, tx_hash, cid, mh_key, segwit, witness_hash FROM btc.transaction_cids ORDER BY index`)
+			Expect(err).ToNot(HaveOccurred())
+			var expectedCounts [3]int
+			err = db.QueryRow(`SELECT (SELECT COUNT(*) FROM public.blocks), (SELECT COUNT(*) FROM btc.tx_inputs), (SELECT COUNT(*) FROM btc.tx_outputs)`).
+				Scan(&expectedCounts[0], &expectedCounts[1], &expectedCounts[2])
+			Expect(err).ToNot(HaveOccurred())
+			btc.TearDownDB(db)
+
+			err = bulk.PublishBatch(context.Background(), []btc.ConvertedPayload{mocks.MockConvertedPayload})
+			Expect(err).ToNot(HaveOccurred())
+			var headers []btc.HeaderModel
+			err = db.Select(&headers, `SELECT block_number, block_hash, parent_hash, cid, mh_key, timestamp, bits, times_validated FROM btc.header_cids`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(headers).To(Equal(expectedHeaders))
+			var txs []btc.TxModel
+			err = db.Select(&txs, `SELECT index, tx_hash, cid, mh_key, segwit, witness_hash FROM btc.transaction_cids ORDER BY index`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(txs).To(Equal(expectedTxs))
+			var counts [3]int
+			err = db.QueryRow(`SELECT (SELECT COUNT(*) FROM public.blocks), (SELECT COUNT(*) FROM btc.tx_inputs), (SELECT COUNT(*) FROM btc.tx_outputs)`).
+				Scan(&counts[0], &counts[1], &counts[2])
+			Expect(err).ToNot(HaveOccurred())
+			Expect(counts).To(Equal(expectedCounts))
+		})
+
+		It("Publishes several blocks in one batch", func() {
+			err = bulk.PublishBatch(context.Background(), payloadsAt(mocks.MockBlockHeight, 3))
+			Expect(err).ToNot(HaveOccurred())
+			var count int
+			err = db.Get(&count, `SELECT COUNT(*) FROM btc.header_cids`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(3))
+			err = db.Get(&count, `SELECT COUNT(*) FROM btc.transaction_cids`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(3 * len(mocks.MockTransactions)))
+		})
+
+		It("Leaves times_validated alone when a block is republished", func() {
+			err = bulk.PublishBatch(context.Background(), payloadsAt(mocks.MockBlockHeight, 1))
+			Expect(err).ToNot(HaveOccurred())
+			err = bulk.PublishBatch(context.Background(), payloadsAt(mocks.MockBlockHeight, 1))
+			Expect(err).ToNot(HaveOccurred())
+			var timesValidated int
+			err = db.Get(&timesValidated, `SELECT times_validated FROM btc.header_cids`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(timesValidated).To(Equal(1))
+		})
+	})
+})
+
+const benchmarkBatchSize = 10
+
+func benchmarkPublisher(b *testing.B, publish func(db *postgres.DB, payloads []btc.ConvertedPayload) error) {
+	db, err := shared.SetupDB()
+	if err != nil {
+		b.Skipf("benchmark requires the testing database: %v", err)
+	}
+	RegisterTestingT(b)
+	defer btc.TearDownDB(db)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := publish(db, payloadsAt(int64(i*benchmarkBatchSize), benchmarkBatchSize)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkIPLDPublisher(b *testing.B) {
+	benchmarkPublisher(b, func(db *postgres.DB, payloads []btc.ConvertedPayload) error {
+		pub := btc.NewIPLDPublisher(db)
+		for _, payload := range payloads {
+			if err := pub.Publish(context.Background(), payload); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func BenchmarkBulkPublisher(b *testing.B) {
+	benchmarkPublisher(b, func(db *postgres.DB, payloads []btc.ConvertedPayload) error {
+		return btc.NewBulkPublisher(db).PublishBatch(context.Background(), payloads)
+	})
+}
@@ -0,0 +1,532 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lib/pq"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+)
+
+// ErrNotIndexed is returned by the ChainReader when the requested header, transaction or output is not in the canonical chain
+var ErrNotIndexed = errors.New("not indexed in the canonical chain")
+
+// ChainReader interface for substituting mocks in tests
+type ChainReader interface {
+	Tip() (*IndexedHeader, error)
+	HeaderAt(height int64) (*IndexedHeader, error)
+	HeaderByHash(hash string) (*IndexedHeader, error)
+	IsCanonical(header *IndexedHeader) (bool, error)
+	Txs(header *IndexedHeader) ([]IndexedTx, error)
+	TxCount(header *IndexedHeader) (int64, error)
+	BlockTxs(header *IndexedHeader, afterIndex int64, limit int) ([]IndexedTx, error)
+	Tx(txHash string) (*IndexedTx, *IndexedHeader, error)
+	Spender(txHash string, index uint32) (*IndexedTx, error)
+	Headers(from, to int64, limit int) ([]IndexedHeader, error)
+	Inputs(tx *IndexedTx) ([]TxInput, error)
+	Outputs(tx *IndexedTx) ([]TxOutput, error)
+	AddressOutputs(address string, from, to, afterID int64, limit int) ([]AddressOutput, error)
+	AddressUnspentOutputs(address string, from, to, afterID int64, limit int) ([]AddressOutput, error)
+	AddressTxs(address string, from, to, afterIndex int64, limit int) ([]IndexedTx, error)
+	ScriptHashOutputs(scriptHash []byte, from, to, afterID int64, limit int) ([]AddressOutput, error)
+	ScriptHashUnspentOutputs(scriptHash []byte, from, to, afterID int64, limit int) ([]AddressOutput, error)
+	ScriptHashTxs(scriptHash []byte, from, to, afterIndex int64, limit int) ([]IndexedTx, error)
+	ScriptHashesTouched(scriptHashes [][]byte, from, to int64) ([][]byte, error)
+	TxOutProof(header *IndexedHeader, txHashes []string) (*wire.MsgMerkleBlock, error)
+	BlockFromIPLDs(header *IndexedHeader) (*wire.MsgBlock, error)
+}
+
+// IndexedHeader is a header indexed in btc.header_cids along with its IPLD data from public.blocks
+type IndexedHeader struct {
+	ID             int64  `db:"id"`
+	BlockNumber    int64  `db:"block_number"`
+	BlockHash      string `db:"block_hash"`
+	ParentHash     string `db:"parent_hash"`
+	CID            string `db:"cid"`
+	TimesValidated int64  `db:"times_validated"`
+	Data           []byte `db:"data"`
+}
+
+// WireHeader decodes the header's IPLD data
+func (h *IndexedHeader) WireHeader() (*wire.BlockHeader, error) {
+	if h.Data == nil {
+		return nil, fmt.Errorf("header %s IPLD is missing", h.BlockHash)
+	}
+	header := new(wire.BlockHeader)
+	if err := header.Deserialize(bytes.NewReader(h.Data)); err != nil {
+		return nil, fmt.Errorf("header %s IPLD does not decode: %v", h.BlockHash, err)
+	}
+	return header, nil
+}
+
+// IndexedTx is a transaction indexed in btc.transaction_cids along with its IPLD data from public.blocks
+type IndexedTx struct {
+	ID          int64  `db:"id"`
+	HeaderID    int64  `db:"header_id"`
+	BlockNumber int64  `db:"block_number"`
+	Index       int64  `db:"index"`
+	TxHash      string `db:"tx_hash"`
+	CID         string `db:"cid"`
+	Data        []byte `db:"data"`
+}
+
+// MsgTx decodes the transaction's IPLD data
+func (tx *IndexedTx) MsgTx() (*wire.MsgTx, error) {
+	if tx.Data == nil {
+		return nil, fmt.Errorf("transaction %s IPLD is missing", tx.TxHash)
+	}
+	msgTx := new(wire.MsgTx)
+	if err := msgTx.Deserialize(bytes.NewReader(tx.Data)); err != nil {
+		return nil, fmt.Errorf("transaction %s IPLD does not decode: %v", tx.TxHash, err)
+	}
+	return msgTx, nil
+}
+
+// AddressOutput is an output paying to an address or script, along with the hash and header of the transaction creating it
+type AddressOutput struct {
+	TxOutput
+	TxHash   string `db:"tx_hash"`
+	HeaderID int64  `db:"header_id"`
+}
+
+const (
+	headerColumns = `header_cids.id, header_cids.block_number, block_hash, parent_hash, cid, times_validated, blocks.data
+			FROM btc.header_cids
+			LEFT JOIN public.blocks ON (blocks.key = header_cids.mh_key)`
+	txColumns = `transaction_cids.id, header_id, transaction_cids.block_number, transaction_cids.index, tx_hash,
+			transaction_cids.cid, blocks.data
+			FROM btc.transaction_cids
+			LEFT JOIN public.blocks ON (blocks.key = transaction_cids.mh_key)`
+	inputColumns = `id, tx_id, index, witness, sig_script, outpoint_tx_hash, outpoint_index, block_number
+			FROM btc.tx_inputs`
+	outputColumns = `tx_outputs.id, tx_id, tx_outputs.index, value, pk_script, script_class, required_sigs, addresses,
+			script_hash, tx_outputs.block_number
+			FROM btc.tx_outputs`
+	// conditions on btc.tx_outputs matching the outputs paying to an address or script
+	matchAddress    = `tx_outputs.addresses @> ARRAY[$1]::VARCHAR[]`
+	matchScriptHash = `tx_outputs.script_hash = $1`
+	// when several headers are indexed at the tip height, the one validated most often, and then the first indexed, is chosen
+	forkChoice = `ORDER BY times_validated DESC, header_cids.id ASC`
+)
+
+// DBChainReader satisfies the ChainReader interface for bitcoin
+// It reads the canonical chain out of the index: the chain leading to the highest indexed header, where a height at
+// which several headers are indexed (after a reorg) resolves to the one that the canonical header above it builds on
+type DBChainReader struct {
+	db *postgres.DB
+}
+
+// NewDBChainReader returns a new DBChainReader struct
+func NewDBChainReader(db *postgres.DB) *DBChainReader {
+	return &DBChainReader{
+		db: db,
+	}
+}
+
+// Tip returns the canonical header at the highest indexed height
+func (r *DBChainReader) Tip() (*IndexedHeader, error) {
+	header := new(IndexedHeader)
+	pgStr := `SELECT ` + headerColumns + `
+			WHERE header_cids.block_number = (SELECT MAX(block_number) FROM btc.header_cids) ` + forkChoice + ` LIMIT 1`
+	if err := r.db.Get(header, pgStr); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("no headers are %w", ErrNotIndexed)
+		}
+		return nil, err
+	}
+	return header, nil
+}
+
+// HeaderAt returns the canonical header at the height
+func (r *DBChainReader) HeaderAt(height int64) (*IndexedHeader, error) {
+	var headers []IndexedHeader
+	pgStr := `SELECT ` + headerColumns + ` WHERE header_cids.block_number = $1 ` + forkChoice
+	if err := r.db.Select(&headers, pgStr, height); err != nil {
+		return nil, err
+	}
+	switch len(headers) {
+	case 0:
+		return nil, fmt.Errorf("height %d is %w", height, ErrNotIndexed)
+	case 1:
+		return &headers[0], nil
+	}
+	above, err := r.HeaderAt(height + 1)
+	if errors.Is(err, ErrNotIndexed) {
+		return &headers[0], nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range headers {
+		if headers[i].BlockHash == above.ParentHash {
+			return &headers[i], nil
+		}
+	}
+	return &headers[0], nil
+}
+
+// HeaderByHash returns the header with the hash, whether or not it is canonical
+func (r *DBChainReader) HeaderByHash(hash string) (*IndexedHeader, error) {
+	header := new(IndexedHeader)
+	pgStr := `SELECT ` + headerColumns + ` WHERE block_hash = $1 ` + forkChoice + ` LIMIT 1`
+	if err := r.db.Get(header, pgStr, hash); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("block %s is %w", hash, ErrNotIndexed)
+		}
+		return nil, err
+	}
+	return header, nil
+}
+
+// IsCanonical returns whether the header is the canonical one at its height
+func (r *DBChainReader) IsCanonical(header *IndexedHeader) (bool, error) {
+	canonical, err := r.HeaderAt(header.BlockNumber)
+	if err != nil {
+		return false, err
+	}
+	return canonical.ID == header.ID, nil
+}
+
+// Txs returns the header's transactions, in block order
+func (r *DBChainReader) Txs(header *IndexedHeader) ([]IndexedTx, error) {
+	var txs []IndexedTx
+	pgStr := `SELECT ` + txColumns + `
+			WHERE transaction_cids.block_number = $1 AND header_id = $2
+			ORDER BY transaction_cids.index`
+	if err := r.db.Select(&txs, pgStr, header.BlockNumber, header.ID); err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+// TxCount returns the number of the header's transactions, without reading their IPLDs
+func (r *DBChainReader) TxCount(header *IndexedHeader) (int64, error) {
+	var count int64
+	pgStr := `SELECT COUNT(*) FROM btc.transaction_cids WHERE block_number = $1 AND header_id = $2`
+	if err := r.db.Get(&count, pgStr, header.BlockNumber, header.ID); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// BlockTxs returns up to limit of the header's transactions with an index above afterIndex, in block order
+func (r *DBChainReader) BlockTxs(header *IndexedHeader, afterIndex int64, limit int) ([]IndexedTx, error) {
+	var txs []IndexedTx
+	pgStr := `SELECT ` + txColumns + `
+			WHERE transaction_cids.block_number = $1 AND header_id = $2 AND transaction_cids.index > $3
+			ORDER BY transaction_cids.index
+			LIMIT $4`
+	if err := r.db.Select(&txs, pgStr, header.BlockNumber, header.ID, afterIndex, limit); err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+// Tx returns the transaction with the hash, and the header including it, from the canonical chain
+func (r *DBChainReader) Tx(txHash string) (*IndexedTx, *IndexedHeader, error) {
+	var txs []IndexedTx
+	pgStr := `SELECT ` + txColumns + ` WHERE tx_hash = $1 ORDER BY transaction_cids.block_number`
+	if err := r.db.Select(&txs, pgStr, txHash); err != nil {
+		return nil, nil, err
+	}
+	for i := range txs {
+		header, err := r.canonicalHeader(txs[i].BlockNumber, txs[i].HeaderID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if header != nil {
+			return &txs[i], header, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("transaction %s is %w", txHash, ErrNotIndexed)
+}
+
+// Spender returns the canonical transaction spending the output, if there is one
+func (r *DBChainReader) Spender(txHash string, index uint32) (*IndexedTx, error) {
+	var txs []IndexedTx
+	pgStr := `SELECT ` + txColumns + `
+			INNER JOIN btc.tx_inputs ON (tx_inputs.tx_id = transaction_cids.id)
+			WHERE outpoint_tx_hash = $1 AND outpoint_index = $2
+			ORDER BY transaction_cids.block_number`
+	if err := r.db.Select(&txs, pgStr, txHash, index); err != nil {
+		return nil, err
+	}
+	for i := range txs {
+		header, err := r.canonicalHeader(txs[i].BlockNumber, txs[i].HeaderID)
+		if err != nil {
+			return nil, err
+		}
+		if header != nil {
+			return &txs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("spender of output %d of %s is %w", index, txHash, ErrNotIndexed)
+}
+
+// Headers returns the canonical headers at the first limit indexed heights between from and to (inclusive), in height order
+func (r *DBChainReader) Headers(from, to int64, limit int) ([]IndexedHeader, error) {
+	var headers []IndexedHeader
+	pgStr := `SELECT ` + headerColumns + `
+			WHERE header_cids.block_number IN (
+				SELECT DISTINCT block_number FROM btc.header_cids
+				WHERE block_number BETWEEN $1 AND $2
+				ORDER BY block_number LIMIT $3
+			)
+			ORDER BY header_cids.block_number DESC, times_validated DESC, header_cids.id ASC`
+	if err := r.db.Select(&headers, pgStr, from, to, limit); err != nil {
+		return nil, err
+	}
+	// walk down from the highest height, following parent hashes through the heights indexed with more than one header
+	canonical := make([]IndexedHeader, 0, limit)
+	for i := 0; i < len(headers); {
+		height := headers[i].BlockNumber
+		end := i
+		for end < len(headers) && headers[end].BlockNumber == height {
+			end++
+		}
+		chosen := &headers[i]
+		if len(canonical) == 0 && end-i > 1 {
+			top, err := r.HeaderAt(height)
+			if err != nil {
+				return nil, err
+			}
+			chosen = top
+		} else if len(canonical) > 0 && canonical[len(canonical)-1].BlockNumber == height+1 {
+			for j := i; j < end; j++ {
+				if headers[j].BlockHash == canonical[len(canonical)-1].ParentHash {
+					chosen = &headers[j]
+					break
+				}
+			}
+		}
+		canonical = append(canonical, *chosen)
+		i = end
+	}
+	for i, j := 0, len(canonical)-1; i < j; i, j = i+1, j-1 {
+		canonical[i], canonical[j] = canonical[j], canonical[i]
+	}
+	return canonical, nil
+}
+
+// Inputs returns the transaction's inputs, in transaction order
+func (r *DBChainReader) Inputs(tx *IndexedTx) ([]TxInput, error) {
+	var inputs []TxInput
+	pgStr := `SELECT ` + inputColumns + ` WHERE block_number = $1 AND tx_id = $2 ORDER BY index`
+	if err := r.db.Select(&inputs, pgStr, tx.BlockNumber, tx.ID); err != nil {
+		return nil, err
+	}
+	return inputs, nil
+}
+
+// Outputs returns the transaction's outputs, in transaction order
+func (r *DBChainReader) Outputs(tx *IndexedTx) ([]TxOutput, error) {
+	var outputs []TxOutput
+	pgStr := `SELECT ` + outputColumns + ` WHERE block_number = $1 AND tx_id = $2 ORDER BY index`
+	if err := r.db.Select(&outputs, pgStr, tx.BlockNumber, tx.ID); err != nil {
+		return nil, err
+	}
+	return outputs, nil
+}
+
+// AddressOutputs returns up to limit outputs paying to the address in the canonical chain between the heights from and
+// to (inclusive), ordered by height and then id; at the height from only the outputs with an id above afterID are returned
+func (r *DBChainReader) AddressOutputs(address string, from, to, afterID int64, limit int) ([]AddressOutput, error) {
+	return r.outputs(matchAddress, address, from, to, afterID, limit, false)
+}
+
+// AddressUnspentOutputs is AddressOutputs for the outputs that no canonical input spends
+// Spent outputs are filtered out in the query, by their inputs at heights indexed with a single header; only the outputs
+// whose spending inputs are all at forked heights are looked up one by one, to see whether a spender is canonical
+func (r *DBChainReader) AddressUnspentOutputs(address string, from, to, afterID int64, limit int) ([]AddressOutput, error) {
+	return r.outputs(matchAddress, address, from, to, afterID, limit, true)
+}
+
+// ScriptHashOutputs is AddressOutputs for the outputs whose script has the hash (see ScriptHash)
+func (r *DBChainReader) ScriptHashOutputs(scriptHash []byte, from, to, afterID int64, limit int) ([]AddressOutput, error) {
+	return r.outputs(matchScriptHash, scriptHash, from, to, afterID, limit, false)
+}
+
+// ScriptHashUnspentOutputs is ScriptHashOutputs for the outputs that no canonical input spends (see AddressUnspentOutputs)
+func (r *DBChainReader) ScriptHashUnspentOutputs(scriptHash []byte, from, to, afterID int64, limit int) ([]AddressOutput, error) {
+	return r.outputs(matchScriptHash, scriptHash, from, to, afterID, limit, true)
+}
+
+// spentOutput matches the outputs spent by an input, of tx_inputs aliased as spent
+const spentOutput = `spent.outpoint_tx_hash = transaction_cids.tx_hash AND spent.outpoint_index = tx_outputs.index`
+
+// matchedOutput is an output read by outputs, flagged if an input at a forked height spends it
+type matchedOutput struct {
+	AddressOutput
+	SpentOnFork bool `db:"spent_on_fork"`
+}
+
+func (r *DBChainReader) outputs(match string, arg interface{}, from, to, afterID int64, limit int, unspent bool) ([]AddressOutput, error) {
+	spentOnFork, unspentOnly := `FALSE`, ``
+	if unspent {
+		// an input at a height indexed with a single header is canonical; the others are checked with Spender
+		spentOnFork = `EXISTS (SELECT 1 FROM btc.tx_inputs AS spent WHERE ` + spentOutput + `)`
+		unspentOnly = `AND NOT EXISTS (
+				SELECT 1 FROM btc.tx_inputs AS spent
+				WHERE ` + spentOutput + `
+				AND (SELECT COUNT(*) FROM btc.header_cids WHERE header_cids.block_number = spent.block_number) = 1
+			)`
+	}
+	pgStr := `SELECT ` + outputColumns + `, tx_hash, header_id, ` + spentOnFork + ` AS spent_on_fork
+			INNER JOIN btc.transaction_cids ON (transaction_cids.block_number = tx_outputs.block_number AND transaction_cids.id = tx_id)
+			WHERE ` + match + `
+			AND (tx_outputs.block_number, tx_outputs.id) > ($2, $3) AND tx_outputs.block_number <= $4
+			` + unspentOnly + `
+			ORDER BY tx_outputs.block_number, tx_outputs.id
+			LIMIT $5`
+	outputs := make([]AddressOutput, 0, limit)
+	canonical := make(map[int64]int64)
+	for len(outputs) < limit {
+		var batch []matchedOutput
+		want := limit - len(outputs)
+		if err := r.db.Select(&batch, pgStr, arg, from, afterID, to, want); err != nil {
+			return nil, err
+		}
+		for _, output := range batch {
+			headerID, err := r.canonicalID(canonical, output.BlockNumber)
+			if err != nil {
+				return nil, err
+			}
+			if output.HeaderID != headerID {
+				continue
+			}
+			if output.SpentOnFork {
+				_, err := r.Spender(output.TxHash, uint32(output.Index))
+				if err == nil {
+					continue
+				}
+				if !errors.Is(err, ErrNotIndexed) {
+					return nil, err
+				}
+			}
+			outputs = append(outputs, output.AddressOutput)
+		}
+		if len(batch) < want {
+			break
+		}
+		last := batch[len(batch)-1]
+		from, afterID = last.BlockNumber, last.ID
+	}
+	return outputs, nil
+}
+
+// AddressTxs returns up to limit transactions in the canonical chain between the heights from and to (inclusive) that
+// pay to the address or spend an output paying to it, in chain order; at the height from only the transactions with
+// an index above afterIndex are returned
+func (r *DBChainReader) AddressTxs(address string, from, to, afterIndex int64, limit int) ([]IndexedTx, error) {
+	return r.txs(matchAddress, address, from, to, afterIndex, limit)
+}
+
+// ScriptHashTxs is AddressTxs for the transactions paying to or spending from the script with the hash (see ScriptHash)
+func (r *DBChainReader) ScriptHashTxs(scriptHash []byte, from, to, afterIndex int64, limit int) ([]IndexedTx, error) {
+	return r.txs(matchScriptHash, scriptHash, from, to, afterIndex, limit)
+}
+
+// ScriptHashesTouched returns, in one query, those of the script hashes that transactions between the heights from and
+// to (inclusive) pay to or spend from; the transactions of forked blocks at those heights count too
+func (r *DBChainReader) ScriptHashesTouched(scriptHashes [][]byte, from, to int64) ([][]byte, error) {
+	pgStr := `SELECT script_hash FROM btc.tx_outputs
+			WHERE script_hash = ANY($1) AND block_number BETWEEN $2 AND $3
+			UNION
+			SELECT tx_outputs.script_hash FROM btc.tx_inputs
+			INNER JOIN btc.transaction_cids AS funding ON (funding.tx_hash = tx_inputs.outpoint_tx_hash)
+			INNER JOIN btc.tx_outputs ON (tx_outputs.block_number = funding.block_number AND tx_outputs.tx_id = funding.id AND tx_outputs.index = tx_inputs.outpoint_index)
+			WHERE tx_outputs.script_hash = ANY($1) AND tx_inputs.block_number BETWEEN $2 AND $3`
+	var touched [][]byte
+	if err := r.db.Select(&touched, pgStr, pq.Array(scriptHashes), from, to); err != nil {
+		return nil, err
+	}
+	return touched, nil
+}
+
+func (r *DBChainReader) txs(match string, arg interface{}, from, to, afterIndex int64, limit int) ([]IndexedTx, error) {
+	pgStr := `SELECT ` + txColumns + `
+			WHERE transaction_cids.id IN (
+				SELECT tx_id FROM btc.tx_outputs WHERE ` + match + `
+				UNION
+				SELECT tx_inputs.tx_id FROM btc.tx_outputs
+				INNER JOIN btc.transaction_cids AS funding ON (funding.block_number = tx_outputs.block_number AND funding.id = tx_outputs.tx_id)
+				INNER JOIN btc.tx_inputs ON (tx_inputs.outpoint_tx_hash = funding.tx_hash AND tx_inputs.outpoint_index = tx_outputs.index)
+				WHERE ` + match + `
+			)
+			AND (transaction_cids.block_number, transaction_cids.index, transaction_cids.id) > ($2, $3, $4)
+			AND transaction_cids.block_number <= $5
+			ORDER BY transaction_cids.block_number, transaction_cids.index, transaction_cids.id
+			LIMIT $6`
+	txs := make([]IndexedTx, 0, limit)
+	canonical := make(map[int64]int64)
+	// ids break the ties between transactions at the same index of the headers at a forked height
+	afterID := int64(math.MaxInt64)
+	for len(txs) < limit {
+		var batch []IndexedTx
+		want := limit - len(txs)
+		if err := r.db.Select(&batch, pgStr, arg, from, afterIndex, afterID, to, want); err != nil {
+			return nil, err
+		}
+		for _, tx := range batch {
+			headerID, err := r.canonicalID(canonical, tx.BlockNumber)
+			if err != nil {
+				return nil, err
+			}
+			if tx.HeaderID == headerID {
+				txs = append(txs, tx)
+			}
+		}
+		if len(batch) < want {
+			break
+		}
+		last := batch[len(batch)-1]
+		from, afterIndex, afterID = last.BlockNumber, last.Index, last.ID
+	}
+	return txs, nil
+}
+
+// canonicalID returns the id of the canonical header at the height, caching it in ids
+func (r *DBChainReader) canonicalID(ids map[int64]int64, height int64) (int64, error) {
+	if id, ok := ids[height]; ok {
+		return id, nil
+	}
+	header, err := r.HeaderAt(height)
+	if err != nil {
+		return 0, err
+	}
+	ids[height] = header.ID
+	return header.ID, nil
+}
+
+// canonicalHeader returns the canonical header at the height if its id matches, and nil otherwise
+func (r *DBChainReader) canonicalHeader(height, headerID int64) (*IndexedHeader, error) {
+	header, err := r.HeaderAt(height)
+	if err != nil {
+		return nil, err
+	}
+	if header.ID != headerID {
+		return nil, nil
+	}
+	return header, nil
+}
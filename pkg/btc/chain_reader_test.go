@@ -0,0 +1,361 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/ipfs/go-cid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/ipfs/ipld"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+)
+
+// forkedPayload returns the consistent mock payload at the height with its header altered by the nonce
+// and, if parent isn't nil, building on parent
+func forkedPayload(height int64, nonce uint32, parent *btc.ConvertedPayload) btc.ConvertedPayload {
+	payload := consistentPayload(height)
+	header := *payload.Header
+	header.Nonce = nonce
+	if parent != nil {
+		header.PrevBlock = parent.Header.BlockHash()
+	}
+	payload.Header = &header
+	return payload
+}
+
+var _ = Describe("DBChainReader", func() {
+	var (
+		db        *postgres.DB
+		err       error
+		reader    *btc.DBChainReader
+		publisher *btc.IPLDPublisher
+		payload   btc.ConvertedPayload
+	)
+	BeforeEach(func() {
+		db, err = shared.SetupDB()
+		Expect(err).ToNot(HaveOccurred())
+		reader = btc.NewDBChainReader(db)
+		publisher = btc.NewIPLDPublisher(db)
+		payload = consistentPayload(mocks.MockBlockHeight)
+		err = publisher.Publish(context.Background(), payload)
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		btc.TearDownDB(db)
+	})
+
+	It("Reads the tip and the header at a height or hash", func() {
+		tip, err := reader.Tip()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tip.BlockNumber).To(Equal(mocks.MockBlockHeight))
+		Expect(tip.BlockHash).To(Equal(payload.Header.BlockHash().String()))
+		header, err := tip.WireHeader()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*header).To(Equal(*payload.Header))
+
+		atHeight, err := reader.HeaderAt(mocks.MockBlockHeight)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(atHeight.ID).To(Equal(tip.ID))
+		byHash, err := reader.HeaderByHash(tip.BlockHash)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(byHash.ID).To(Equal(tip.ID))
+
+		_, err = reader.HeaderAt(mocks.MockBlockHeight + 1)
+		Expect(errors.Is(err, btc.ErrNotIndexed)).To(BeTrue())
+		_, err = reader.HeaderByHash("unknown")
+		Expect(errors.Is(err, btc.ErrNotIndexed)).To(BeTrue())
+	})
+
+	It("Reads a header's transactions in block order", func() {
+		tip, err := reader.Tip()
+		Expect(err).ToNot(HaveOccurred())
+		txs, err := reader.Txs(tip)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(txs).To(HaveLen(len(payload.Txs)))
+		for i, tx := range txs {
+			Expect(tx.Index).To(Equal(int64(i)))
+			msgTx, err := tx.MsgTx()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(msgTx.TxHash()).To(Equal(*payload.Txs[i].Hash()))
+		}
+	})
+
+	It("Counts and pages through a header's transactions", func() {
+		tip, err := reader.Tip()
+		Expect(err).ToNot(HaveOccurred())
+		count, err := reader.TxCount(tip)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(BeEquivalentTo(len(payload.Txs)))
+
+		page, err := reader.BlockTxs(tip, -1, 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(page).To(HaveLen(2))
+		Expect(page[0].Index).To(BeEquivalentTo(0))
+		Expect(page[1].Index).To(BeEquivalentTo(1))
+		page, err = reader.BlockTxs(tip, 1, 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(page).To(HaveLen(len(payload.Txs) - 2))
+		Expect(page[0].TxHash).To(Equal(payload.Txs[2].Hash().String()))
+	})
+
+	It("Finds a transaction and the canonical transaction spending an output", func() {
+		spending := payload.Txs[1].MsgTx()
+		tx, header, err := reader.Tx(spending.TxHash().String())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tx.Index).To(Equal(int64(1)))
+		Expect(header.BlockNumber).To(Equal(mocks.MockBlockHeight))
+
+		outpoint := spending.TxIn[0].PreviousOutPoint
+		spender, err := reader.Spender(outpoint.Hash.String(), outpoint.Index)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(spender.TxHash).To(Equal(spending.TxHash().String()))
+
+		_, err = reader.Spender(spending.TxHash().String(), 0)
+		Expect(errors.Is(err, btc.ErrNotIndexed)).To(BeTrue())
+		_, _, err = reader.Tx("unknown")
+		Expect(errors.Is(err, btc.ErrNotIndexed)).To(BeTrue())
+	})
+
+	It("Resolves a forked height to the header that the canonical chain builds on", func() {
+		fork := forkedPayload(mocks.MockBlockHeight, payload.Header.Nonce+1, nil)
+		err = publisher.Publish(context.Background(), fork)
+		Expect(err).ToNot(HaveOccurred())
+		child := forkedPayload(mocks.MockBlockHeight+1, 0, &fork)
+		err = publisher.Publish(context.Background(), child)
+		Expect(err).ToNot(HaveOccurred())
+
+		header, err := reader.HeaderAt(mocks.MockBlockHeight)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(header.BlockHash).To(Equal(fork.Header.BlockHash().String()))
+		stale, err := reader.HeaderByHash(payload.Header.BlockHash().String())
+		Expect(err).ToNot(HaveOccurred())
+		canonical, err := reader.IsCanonical(stale)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(canonical).To(BeFalse())
+
+		tx, header, err := reader.Tx(payload.Txs[0].Hash().String())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(header.BlockHash).To(Equal(fork.Header.BlockHash().String()))
+		Expect(tx.HeaderID).To(Equal(header.ID))
+	})
+
+	It("Reads a transaction's inputs and outputs", func() {
+		tx, _, err := reader.Tx(payload.Txs[1].Hash().String())
+		Expect(err).ToNot(HaveOccurred())
+		inputs, err := reader.Inputs(tx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(inputs).To(HaveLen(len(payload.TxMetaData[1].TxInputs)))
+		for i, input := range inputs {
+			expected := payload.TxMetaData[1].TxInputs[i]
+			Expect(input.Index).To(Equal(int64(i)))
+			Expect(input.PreviousOutPointHash).To(Equal(expected.PreviousOutPointHash))
+			Expect(input.PreviousOutPointIndex).To(Equal(expected.PreviousOutPointIndex))
+			Expect(input.SignatureScript).To(Equal(expected.SignatureScript))
+		}
+		outputs, err := reader.Outputs(tx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(outputs).To(HaveLen(len(payload.TxMetaData[1].TxOutputs)))
+		for i, output := range outputs {
+			expected := payload.TxMetaData[1].TxOutputs[i]
+			Expect(output.Index).To(Equal(int64(i)))
+			Expect(output.Value).To(Equal(expected.Value))
+			Expect(output.PkScript).To(Equal(expected.PkScript))
+			Expect(output.Addresses).To(Equal(expected.Addresses))
+		}
+	})
+
+	It("Pages through the canonical headers in a height range", func() {
+		fork := forkedPayload(mocks.MockBlockHeight, payload.Header.Nonce+1, nil)
+		err = publisher.Publish(context.Background(), fork)
+		Expect(err).ToNot(HaveOccurred())
+		child := forkedPayload(mocks.MockBlockHeight+1, 0, &fork)
+		err = publisher.Publish(context.Background(), child)
+		Expect(err).ToNot(HaveOccurred())
+		above := forkedPayload(mocks.MockBlockHeight+3, 0, nil)
+		err = publisher.Publish(context.Background(), above)
+		Expect(err).ToNot(HaveOccurred())
+
+		headers, err := reader.Headers(mocks.MockBlockHeight, mocks.MockBlockHeight+3, 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(headers).To(HaveLen(2))
+		Expect(headers[0].BlockHash).To(Equal(fork.Header.BlockHash().String()))
+		Expect(headers[1].BlockHash).To(Equal(child.Header.BlockHash().String()))
+
+		headers, err = reader.Headers(mocks.MockBlockHeight+2, mocks.MockBlockHeight+3, 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(headers).To(HaveLen(1))
+		Expect(headers[0].BlockNumber).To(Equal(mocks.MockBlockHeight + 3))
+	})
+
+	It("Pages through the canonical outputs paying to an address", func() {
+		address := payload.TxMetaData[0].TxOutputs[0].Addresses[0]
+		outputs, err := reader.AddressOutputs(address, mocks.MockBlockHeight, mocks.MockBlockHeight, 0, 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(outputs).ToNot(BeEmpty())
+		for _, output := range outputs {
+			Expect(output.Addresses).To(ContainElement(address))
+			Expect(output.BlockNumber).To(Equal(mocks.MockBlockHeight))
+		}
+		Expect(outputs[0].TxHash).To(Equal(payload.Txs[0].Hash().String()))
+
+		rest, err := reader.AddressOutputs(address, mocks.MockBlockHeight, mocks.MockBlockHeight, outputs[0].ID, 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rest).To(HaveLen(len(outputs) - 1))
+
+		fork := forkedPayload(mocks.MockBlockHeight, payload.Header.Nonce+1, nil)
+		err = publisher.Publish(context.Background(), fork)
+		Expect(err).ToNot(HaveOccurred())
+		child := forkedPayload(mocks.MockBlockHeight+1, 0, &fork)
+		err = publisher.Publish(context.Background(), child)
+		Expect(err).ToNot(HaveOccurred())
+		forked, err := reader.AddressOutputs(address, mocks.MockBlockHeight, mocks.MockBlockHeight, 0, 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(forked).To(HaveLen(len(outputs)))
+		header, err := reader.HeaderAt(mocks.MockBlockHeight)
+		Expect(err).ToNot(HaveOccurred())
+		for _, output := range forked {
+			Expect(output.HeaderID).To(Equal(header.ID))
+		}
+	})
+
+	It("Pages through the canonical outputs paying to an address that no canonical input spends", func() {
+		address := payload.TxMetaData[0].TxOutputs[0].Addresses[0]
+		outputs, err := reader.AddressOutputs(address, mocks.MockBlockHeight, mocks.MockBlockHeight, 0, 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(outputs).ToNot(BeEmpty())
+		unspent, err := reader.AddressUnspentOutputs(address, mocks.MockBlockHeight, mocks.MockBlockHeight, 0, 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unspent).To(Equal(outputs))
+
+		_, err = db.Exec(`INSERT INTO btc.tx_inputs (tx_id, index, sig_script, outpoint_tx_hash, outpoint_index, block_number)
+			SELECT id, 99, '\x', $1, $2, block_number FROM btc.transaction_cids WHERE index = 1`,
+			outputs[0].TxHash, outputs[0].Index)
+		Expect(err).ToNot(HaveOccurred())
+		unspent, err = reader.AddressUnspentOutputs(address, mocks.MockBlockHeight, mocks.MockBlockHeight, 0, 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unspent).To(Equal(outputs[1:]))
+	})
+
+	It("Pages through the canonical transactions paying to an address", func() {
+		address := payload.TxMetaData[1].TxOutputs[0].Addresses[0]
+		txs, err := reader.AddressTxs(address, mocks.MockBlockHeight, mocks.MockBlockHeight, -1, 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(txs).To(HaveLen(1))
+		Expect(txs[0].TxHash).To(Equal(payload.Txs[1].Hash().String()))
+
+		txs, err = reader.AddressTxs(address, mocks.MockBlockHeight, mocks.MockBlockHeight, 1, 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(txs).To(BeEmpty())
+	})
+
+	It("Looks up the outputs and transactions paying to a script by its hash", func() {
+		output := payload.TxMetaData[1].TxOutputs[1]
+		outputs, err := reader.ScriptHashOutputs(btc.ScriptHash(output.PkScript), 0, mocks.MockBlockHeight, 0, 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(outputs).To(HaveLen(1))
+		Expect(outputs[0].TxHash).To(Equal(payload.Txs[1].Hash().String()))
+		Expect(outputs[0].Index).To(Equal(int64(1)))
+		Expect(outputs[0].ScriptHash).To(Equal(output.ScriptHash))
+
+		txs, err := reader.ScriptHashTxs(btc.ScriptHash(output.PkScript), 0, mocks.MockBlockHeight, -1, 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(txs).To(HaveLen(1))
+		Expect(txs[0].TxHash).To(Equal(payload.Txs[1].Hash().String()))
+	})
+
+	It("Filters the spent outputs paying to a script out in the query", func() {
+		output := payload.TxMetaData[1].TxOutputs[1]
+		scriptHash := btc.ScriptHash(output.PkScript)
+		unspent, err := reader.ScriptHashUnspentOutputs(scriptHash, 0, mocks.MockBlockHeight, 0, 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unspent).To(HaveLen(1))
+
+		_, err = db.Exec(`INSERT INTO btc.tx_inputs (tx_id, index, sig_script, outpoint_tx_hash, outpoint_index, block_number)
+			SELECT id, 99, '\x', $1, 1, block_number FROM btc.transaction_cids WHERE index = 2`,
+			payload.Txs[1].Hash().String())
+		Expect(err).ToNot(HaveOccurred())
+		unspent, err = reader.ScriptHashUnspentOutputs(scriptHash, 0, mocks.MockBlockHeight, 0, 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unspent).To(BeEmpty())
+	})
+
+	It("Finds the script hashes touched by the transactions in a range of heights", func() {
+		touchedHash := btc.ScriptHash(payload.TxMetaData[1].TxOutputs[1].PkScript)
+		untouchedHash := btc.ScriptHash([]byte{0x51})
+		touched, err := reader.ScriptHashesTouched([][]byte{touchedHash, untouchedHash}, mocks.MockBlockHeight, mocks.MockBlockHeight)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(touched).To(Equal([][]byte{touchedHash}))
+
+		touched, err = reader.ScriptHashesTouched([][]byte{touchedHash, untouchedHash}, mocks.MockBlockHeight+1, mocks.MockBlockHeight+10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(touched).To(BeEmpty())
+	})
+
+	It("Proves that transactions are in a block, from the stored tx trie or from the indexed transactions", func() {
+		tip, err := reader.Tip()
+		Expect(err).ToNot(HaveOccurred())
+		txHash := payload.Txs[1].Hash().String()
+		proof, err := reader.TxOutProof(tip, []string{txHash})
+		Expect(err).ToNot(HaveOccurred())
+		proved, err := btc.VerifyTxOutProof(proof)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(proved).To(Equal([]chainhash.Hash{*payload.Txs[1].Hash()}))
+
+		txCIDs := make([]cid.Cid, len(payload.Txs))
+		for i, tx := range payload.Txs {
+			node, err := ipld.NewBtcTx(tx.MsgTx())
+			Expect(err).ToNot(HaveOccurred())
+			txCIDs[i] = node.Cid()
+		}
+		trie := ipld.TxTrieFromCIDs(txCIDs)
+		_, err = db.Exec(`DELETE FROM public.blocks WHERE key = $1`, shared.MultihashKeyFromCID(trie[len(trie)-1].Cid()))
+		Expect(err).ToNot(HaveOccurred())
+		fromTxs, err := reader.TxOutProof(tip, []string{txHash})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fromTxs).To(Equal(proof))
+
+		_, err = reader.TxOutProof(tip, []string{"unknown"})
+		Expect(errors.Is(err, btc.ErrNotIndexed)).To(BeTrue())
+	})
+
+	It("Reassembles a block from its IPLDs", func() {
+		tip, err := reader.Tip()
+		Expect(err).ToNot(HaveOccurred())
+		block, err := reader.BlockFromIPLDs(tip)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(block.Header).To(Equal(*payload.Header))
+		Expect(block.Transactions).To(HaveLen(len(payload.Txs)))
+		for i, tx := range block.Transactions {
+			Expect(tx.TxHash()).To(Equal(*payload.Txs[i].Hash()))
+		}
+
+		node, err := ipld.NewBtcTx(payload.Txs[2].MsgTx())
+		Expect(err).ToNot(HaveOccurred())
+		_, err = db.Exec(`DELETE FROM public.blocks WHERE key = $1`, shared.MultihashKeyFromCID(node.Cid()))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = reader.BlockFromIPLDs(tip)
+		Expect(errors.Is(err, btc.ErrNotIndexed)).To(BeTrue())
+	})
+})
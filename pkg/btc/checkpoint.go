@@ -0,0 +1,72 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc
+
+import (
+	"database/sql"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+)
+
+// Checkpoint is the last block published by the sync process
+type Checkpoint struct {
+	NodeID      int64  `db:"node_id"`
+	BlockNumber int64  `db:"block_number"`
+	BlockHash   string `db:"block_hash"`
+}
+
+// Checkpointer interface for substituting mocks in tests
+type Checkpointer interface {
+	// Load returns the stored checkpoint, or nil if there is none
+	Load() (*Checkpoint, error)
+	Save(height int64, hash string) error
+}
+
+// DBCheckpointer satisfies the Checkpointer interface by persisting the checkpoint in Postgres
+type DBCheckpointer struct {
+	db *postgres.DB
+}
+
+// NewDBCheckpointer returns a new DBCheckpointer struct
+func NewDBCheckpointer(db *postgres.DB) *DBCheckpointer {
+	return &DBCheckpointer{
+		db: db,
+	}
+}
+
+// Load returns the checkpoint for the node this process is connected to
+func (c *DBCheckpointer) Load() (*Checkpoint, error) {
+	checkpoint := new(Checkpoint)
+	pgStr := `SELECT node_id, block_number, block_hash FROM btc.sync_checkpoints WHERE node_id = $1`
+	if err := c.db.Get(checkpoint, pgStr, c.db.NodeID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// Save records the given block as the checkpoint
+// the checkpoint only moves forward; saving a block below the current checkpoint is a no-op
+func (c *DBCheckpointer) Save(height int64, hash string) error {
+	pgStr := `INSERT INTO btc.sync_checkpoints (node_id, block_number, block_hash) VALUES ($1, $2, $3)
+			ON CONFLICT (node_id) DO UPDATE SET (block_number, block_hash, updated_at) = ($2, $3, NOW())
+			WHERE btc.sync_checkpoints.block_number <= EXCLUDED.block_number`
+	_, err := c.db.Exec(pgStr, c.db.NodeID, height, hash)
+	return err
+}
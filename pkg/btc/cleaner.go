@@ -24,12 +24,15 @@ import (
 
 	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+	"github.com/vulcanize/ipld-btc-indexer/utils"
 )
 
 // Cleaner interface for substituting mocks in tests
 type Cleaner interface {
 	ResetValidation(rngs [][2]uint64) error
 	Clean(rngs [][2]uint64, t shared.DataType) error
+	Prune(rngs [][2]uint64, t shared.DataType) error
+	Vacuum(t shared.DataType) error
 }
 
 // DBCleaner satisfies the Cleaner interface fo bitcoin
@@ -64,23 +67,60 @@ func (c *DBCleaner) ResetValidation(rngs [][2]uint64) error {
 }
 
 // Clean removes the specified data from the db within the provided block range
+// If the btc tables are partitioned by block number, partitions that the range fully covers are truncated
+// rather than deleted from row by row
 func (c *DBCleaner) Clean(rngs [][2]uint64, t shared.DataType) error {
-	tx, err := c.db.Beginx()
+	deletedRows, err := c.prune(rngs, t)
 	if err != nil {
 		return err
 	}
+	if !deletedRows {
+		logrus.Infof("btc db cleaner vacuum analyzing public.blocks to free up space from deleted rows")
+		return c.vacuumIPLDs()
+	}
+	logrus.Infof("btc db cleaner vacuum analyzing cleaned tables to free up space from deleted rows")
+	return c.vacuumAnalyze(t)
+}
+
+// Prune removes the specified data from the db within the provided block range, like Clean, but without vacuuming
+// It is used to clean many small ranges, followed by a single Vacuum
+func (c *DBCleaner) Prune(rngs [][2]uint64, t shared.DataType) error {
+	_, err := c.prune(rngs, t)
+	return err
+}
+
+// Vacuum vacuum analyzes the tables holding the specified data
+func (c *DBCleaner) Vacuum(t shared.DataType) error {
+	logrus.Infof("btc db cleaner vacuum analyzing cleaned tables to free up space from deleted rows")
+	return c.vacuumAnalyze(t)
+}
+
+// prune removes the data within the ranges, reporting whether any rows were deleted rather than truncated
+func (c *DBCleaner) prune(rngs [][2]uint64, t shared.DataType) (bool, error) {
+	size, err := partitionSize(c.db)
+	if err != nil {
+		return false, err
+	}
+	tx, err := c.db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	deletedRows := size == 0
 	for _, rng := range rngs {
 		logrus.Infof("btc db cleaner cleaning up block range %d to %d", rng[0], rng[1])
-		if err := c.clean(tx, rng, t); err != nil {
+		if size == 0 {
+			err = c.clean(tx, rng, t)
+		} else {
+			var deleted bool
+			deleted, err = c.cleanPartitioned(tx, rng, t, uint64(size))
+			deletedRows = deletedRows || deleted
+		}
+		if err != nil {
 			shared.Rollback(tx)
-			return err
+			return false, err
 		}
 	}
-	if err := tx.Commit(); err != nil {
-		return err
-	}
-	logrus.Infof("btc db cleaner vacuum analyzing cleaned tables to free up space from deleted rows")
-	return c.vacuumAnalyze(t)
+	return deletedRows, tx.Commit()
 }
 
 func (c *DBCleaner) clean(tx *sqlx.Tx, rng [2]uint64, t shared.DataType) error {
@@ -97,6 +137,55 @@ func (c *DBCleaner) clean(tx *sqlx.Tx, rng [2]uint64, t shared.DataType) error {
 	}
 }
 
+// cleanPartitioned cleans a range from partitioned btc tables, which have no foreign keys to cascade deletes along
+// it truncates the partitions the range fully covers and deletes the rows at the edges of the range,
+// returning whether any rows had to be deleted
+func (c *DBCleaner) cleanPartitioned(tx *sqlx.Tx, rng [2]uint64, t shared.DataType, size uint64) (bool, error) {
+	var tables []string
+	switch t {
+	case shared.Full, shared.Headers:
+		if err := c.cleanTransactionIPLDs(tx, rng); err != nil {
+			return false, err
+		}
+		if err := c.cleanHeaderIPLDs(tx, rng); err != nil {
+			return false, err
+		}
+		tables = []string{"header_cids", "transaction_cids", "tx_inputs", "tx_outputs"}
+	case shared.Transactions:
+		if err := c.cleanTransactionIPLDs(tx, rng); err != nil {
+			return false, err
+		}
+		tables = []string{"transaction_cids", "tx_inputs", "tx_outputs"}
+	default:
+		return false, fmt.Errorf("btc cleaner unrecognized type: %s", t.String())
+	}
+	aligned, edges := utils.SplitRangeByPartition(rng[0], rng[1], size)
+	for _, partition := range aligned {
+		var exists bool
+		if err := tx.Get(&exists, `SELECT EXISTS (SELECT 1 FROM btc.block_partitions WHERE from_block = $1)`, partition[0]); err != nil {
+			return false, err
+		}
+		if !exists {
+			continue
+		}
+		logrus.Infof("btc db cleaner truncating partitions for block range %d to %d", partition[0], partition[1])
+		for _, table := range tables {
+			if _, err := tx.Exec(fmt.Sprintf(`TRUNCATE btc.%s_p%d`, table, partition[0])); err != nil {
+				return false, err
+			}
+		}
+	}
+	for _, edge := range edges {
+		for _, table := range tables {
+			pgStr := fmt.Sprintf(`DELETE FROM btc.%s WHERE block_number BETWEEN $1 AND $2`, table)
+			if _, err := tx.Exec(pgStr, edge[0], edge[1]); err != nil {
+				return false, err
+			}
+		}
+	}
+	return len(edges) > 0, nil
+}
+
 func (c *DBCleaner) vacuumAnalyze(t shared.DataType) error {
 	switch t {
 	case shared.Full, shared.Headers:
@@ -17,6 +17,7 @@
 package btc
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
 
 	"github.com/btcsuite/btcd/chaincfg"
@@ -81,6 +82,7 @@ func (pc *PayloadConverter) Convert(payload BlockPayload) (*ConvertedPayload, er
 				RequiredSigs: int64(numberOfSigs),
 				ScriptClass:  uint8(scriptClass),
 				Addresses:    stringAddrs,
+				ScriptHash:   ScriptHash(out.PkScript),
 			}
 		}
 		txMeta[i] = txModel
@@ -91,6 +93,13 @@ func (pc *PayloadConverter) Convert(payload BlockPayload) (*ConvertedPayload, er
 	}, nil
 }
 
+// ScriptHash returns the sha256 digest of an output script, which outputs are looked up by in btc.tx_outputs
+// Electrum clients send it byte reversed and hex encoded, as they do block and transaction hashes
+func ScriptHash(pkScript []byte) []byte {
+	hash := sha256.Sum256(pkScript)
+	return hash[:]
+}
+
 func convertBytesToHexArray(bytea [][]byte) []string {
 	var strs []string
 	for _, b := range bytea {
@@ -0,0 +1,142 @@
+// VulcanizeDB
+// Copyright © 2020 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// BlockClient is the subset of the bitcoin rpc client methods used to fetch blocks
+// It is satisfied by both the btcd rpcclient.Client and the EsploraClient
+type BlockClient interface {
+	GetBlockCount() (int64, error)
+	GetBlockHash(blockHeight int64) (*chainhash.Hash, error)
+	GetBlock(blockHash *chainhash.Hash) (*wire.MsgBlock, error)
+}
+
+// ContextBlockClient is a BlockClient whose block requests can be cancelled through a context
+// The EsploraClient satisfies it; the btcd rpcclient.Client does not, since its requests cannot be cancelled once sent
+type ContextBlockClient interface {
+	BlockClient
+	GetBlockHashContext(ctx context.Context, blockHeight int64) (*chainhash.Hash, error)
+	GetBlockContext(ctx context.Context, blockHash *chainhash.Hash) (*wire.MsgBlock, error)
+}
+
+// EsploraClient is a minimal client for the Esplora/Electrs REST API
+type EsploraClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewEsploraClient returns a pointer to a new EsploraClient for the api at the provided url
+func NewEsploraClient(baseURL string, timeout time.Duration) *EsploraClient {
+	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
+		baseURL = "http://" + baseURL
+	}
+	return &EsploraClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// GetBlockCount returns the height of the current chain tip
+func (ec *EsploraClient) GetBlockCount() (int64, error) {
+	body, err := ec.get("/blocks/tip/height")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64)
+}
+
+// GetBestBlockHash returns the hash of the current chain tip
+func (ec *EsploraClient) GetBestBlockHash() (*chainhash.Hash, error) {
+	body, err := ec.get("/blocks/tip/hash")
+	if err != nil {
+		return nil, err
+	}
+	return chainhash.NewHashFromStr(strings.TrimSpace(string(body)))
+}
+
+// GetBlockHash returns the hash of the block in the best chain at the given height
+func (ec *EsploraClient) GetBlockHash(blockHeight int64) (*chainhash.Hash, error) {
+	return ec.GetBlockHashContext(context.Background(), blockHeight)
+}
+
+// GetBlockHashContext returns the hash of the block in the best chain at the given height, abandoning the request
+// once the context is done
+func (ec *EsploraClient) GetBlockHashContext(ctx context.Context, blockHeight int64) (*chainhash.Hash, error) {
+	body, err := ec.getContext(ctx, fmt.Sprintf("/block-height/%d", blockHeight))
+	if err != nil {
+		return nil, err
+	}
+	return chainhash.NewHashFromStr(strings.TrimSpace(string(body)))
+}
+
+// GetBlock returns the deserialized block with the given hash
+func (ec *EsploraClient) GetBlock(blockHash *chainhash.Hash) (*wire.MsgBlock, error) {
+	return ec.GetBlockContext(context.Background(), blockHash)
+}
+
+// GetBlockContext returns the deserialized block with the given hash, abandoning the request once the context is done
+func (ec *EsploraClient) GetBlockContext(ctx context.Context, blockHash *chainhash.Hash) (*wire.MsgBlock, error) {
+	body, err := ec.getContext(ctx, fmt.Sprintf("/block/%s/raw", blockHash.String()))
+	if err != nil {
+		return nil, err
+	}
+	block := new(wire.MsgBlock)
+	if err := block.Deserialize(bytes.NewReader(body)); err != nil {
+		return nil, err
+	}
+	if block.BlockHash() != *blockHash {
+		return nil, fmt.Errorf("esplora returned block %s when %s was requested", block.BlockHash().String(), blockHash.String())
+	}
+	return block, nil
+}
+
+func (ec *EsploraClient) get(path string) ([]byte, error) {
+	return ec.getContext(context.Background(), path)
+}
+
+func (ec *EsploraClient) getContext(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ec.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := ec.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("esplora GET %s returned status %d: %s", path, res.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
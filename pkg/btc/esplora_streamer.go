@@ -0,0 +1,50 @@
+// VulcanizeDB
+// Copyright © 2020 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EsploraPayloadStreamer satisfies the Streamer interface by polling the tip of an Esplora REST api
+type EsploraPayloadStreamer struct {
+	client       *EsploraClient
+	pollInterval time.Duration
+}
+
+// NewEsploraPayloadStreamer creates a pointer to a new EsploraPayloadStreamer
+func NewEsploraPayloadStreamer(path string, timeout, pollInterval time.Duration) *EsploraPayloadStreamer {
+	return &EsploraPayloadStreamer{
+		client:       NewEsploraClient(path, timeout),
+		pollInterval: pollInterval,
+	}
+}
+
+// Stream polls the esplora api and sends each new block to the payloadChan
+func (ps *EsploraPayloadStreamer) Stream(ctx context.Context, payloadChan chan BlockPayload, checkpoint *Checkpoint) (Subscription, error) {
+	logrus.Debug("streaming block payloads from esplora")
+	poller, err := newBlockPoller(instrument(ps.client), checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	sub := newPollingSubscription(ctx)
+	go sub.run(poller, payloadChan, ps.pollInterval)
+	return sub, nil
+}
@@ -0,0 +1,233 @@
+// VulcanizeDB
+// Copyright © 2020 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+)
+
+// newEsploraStandIn returns a test server serving the mock block at the mock height as the esplora tip
+func newEsploraStandIn() *httptest.Server {
+	buf := new(bytes.Buffer)
+	Expect(mocks.MockBlock.Serialize(buf)).To(Succeed())
+	rawBlock := buf.Bytes()
+	blockHash := mocks.MockBlock.BlockHash().String()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/blocks/tip/height", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%d", mocks.MockBlockHeight)
+	})
+	mux.HandleFunc("/blocks/tip/hash", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, blockHash)
+	})
+	mux.HandleFunc(fmt.Sprintf("/block-height/%d", mocks.MockBlockHeight), func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, blockHash)
+	})
+	mux.HandleFunc(fmt.Sprintf("/block/%s/raw", blockHash), func(w http.ResponseWriter, r *http.Request) {
+		w.Write(rawBlock)
+	})
+	return httptest.NewServer(mux)
+}
+
+// chainStandIn is an esplora test server backed by a mutable chain of empty blocks
+type chainStandIn struct {
+	sync.Mutex
+	base   int64
+	blocks []*wire.MsgBlock
+}
+
+// extend appends n blocks to the chain, using nonce to distinguish forks
+func (c *chainStandIn) extend(n int, nonce uint32) {
+	c.Lock()
+	defer c.Unlock()
+	for i := 0; i < n; i++ {
+		header := wire.BlockHeader{Nonce: nonce, Timestamp: time.Unix(int64(len(c.blocks)), 0)}
+		if len(c.blocks) > 0 {
+			header.PrevBlock = c.blocks[len(c.blocks)-1].BlockHash()
+		}
+		c.blocks = append(c.blocks, wire.NewMsgBlock(&header))
+	}
+}
+
+// truncate drops blocks from the tip of the chain so that the given height becomes the tip
+func (c *chainStandIn) truncate(height int64) {
+	c.Lock()
+	defer c.Unlock()
+	c.blocks = c.blocks[:height-c.base+1]
+}
+
+func (c *chainStandIn) at(height int64) *wire.MsgBlock {
+	c.Lock()
+	defer c.Unlock()
+	return c.blocks[height-c.base]
+}
+
+func (c *chainStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	defer c.Unlock()
+	tip := c.base + int64(len(c.blocks)) - 1
+	switch {
+	case r.URL.Path == "/blocks/tip/height":
+		fmt.Fprintf(w, "%d", tip)
+		return
+	case r.URL.Path == "/blocks/tip/hash":
+		fmt.Fprint(w, c.blocks[len(c.blocks)-1].BlockHash().String())
+		return
+	}
+	var height int64
+	if _, err := fmt.Sscanf(r.URL.Path, "/block-height/%d", &height); err == nil && height >= c.base && height <= tip {
+		fmt.Fprint(w, c.blocks[height-c.base].BlockHash().String())
+		return
+	}
+	for _, block := range c.blocks {
+		if r.URL.Path == fmt.Sprintf("/block/%s/raw", block.BlockHash().String()) {
+			block.Serialize(w)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+var _ = Describe("Esplora", func() {
+	var server *httptest.Server
+	BeforeEach(func() {
+		server = newEsploraStandIn()
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("FetchAt", func() {
+		It("Fetches block payloads at the provided heights", func() {
+			fetcher := btc.NewEsploraFetcher(server.URL, time.Second)
+			payloads, err := fetcher.FetchAt(context.Background(), []uint64{uint64(mocks.MockBlockHeight)})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(payloads)).To(Equal(1))
+			Expect(payloads[0].BlockHeight).To(Equal(mocks.MockBlockHeight))
+			Expect(payloads[0].Header).To(Equal(&mocks.MockBlock.Header))
+			Expect(len(payloads[0].Txs)).To(Equal(len(mocks.MockTransactions)))
+			for i, tx := range payloads[0].Txs {
+				Expect(tx.Hash()).To(Equal(mocks.MockTransactions[i].Hash()))
+				Expect(tx.Index()).To(Equal(i))
+			}
+		})
+
+		It("Returns an error for heights the api does not know", func() {
+			fetcher := btc.NewEsploraFetcher(server.URL, time.Second)
+			_, err := fetcher.FetchAt(context.Background(), []uint64{uint64(mocks.MockBlockHeight + 1)})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("404"))
+		})
+
+		It("Cancels an in-flight request once the context is done", func() {
+			cancelled := make(chan struct{})
+			slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+				close(cancelled)
+			}))
+			defer slow.Close()
+			fetcher := btc.NewEsploraFetcher(slow.URL, time.Minute)
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err := fetcher.FetchAt(ctx, []uint64{uint64(mocks.MockBlockHeight)})
+			Expect(err).To(HaveOccurred())
+			Eventually(cancelled).Should(BeClosed())
+		})
+	})
+
+	Describe("Stream", func() {
+		It("Streams the tip block once", func() {
+			streamer := btc.NewEsploraPayloadStreamer(server.URL, time.Second, 10*time.Millisecond)
+			payloadChan := make(chan btc.BlockPayload, 2)
+			sub, err := streamer.Stream(context.Background(), payloadChan, nil)
+			Expect(err).ToNot(HaveOccurred())
+			defer sub.Unsubscribe()
+			var payload btc.BlockPayload
+			Eventually(payloadChan).Should(Receive(&payload))
+			Expect(payload.BlockHeight).To(Equal(mocks.MockBlockHeight))
+			Expect(payload.Header.BlockHash()).To(Equal(mocks.MockBlock.BlockHash()))
+			Consistently(payloadChan, 100*time.Millisecond).ShouldNot(Receive())
+		})
+
+		Context("From a checkpoint", func() {
+			var (
+				chain       *chainStandIn
+				chainServer *httptest.Server
+			)
+			BeforeEach(func() {
+				chain = &chainStandIn{base: 100}
+				chain.extend(5, 0)
+				chainServer = httptest.NewServer(chain)
+			})
+			AfterEach(func() {
+				chainServer.Close()
+			})
+
+			receiveHeights := func(payloadChan chan btc.BlockPayload, n int) []int64 {
+				heights := make([]int64, 0, n)
+				for i := 0; i < n; i++ {
+					var payload btc.BlockPayload
+					Eventually(payloadChan).Should(Receive(&payload))
+					Expect(payload.Header.BlockHash()).To(Equal(chain.at(payload.BlockHeight).BlockHash()))
+					heights = append(heights, payload.BlockHeight)
+				}
+				return heights
+			}
+
+			It("Catches up from the checkpoint to the tip in order", func() {
+				streamer := btc.NewEsploraPayloadStreamer(chainServer.URL, time.Second, 10*time.Millisecond)
+				payloadChan := make(chan btc.BlockPayload)
+				checkpoint := &btc.Checkpoint{BlockNumber: 101, BlockHash: chain.at(101).BlockHash().String()}
+				sub, err := streamer.Stream(context.Background(), payloadChan, checkpoint)
+				Expect(err).ToNot(HaveOccurred())
+				defer sub.Unsubscribe()
+				Expect(receiveHeights(payloadChan, 3)).To(Equal([]int64{102, 103, 104}))
+				Consistently(payloadChan, 100*time.Millisecond).ShouldNot(Receive())
+
+				chain.extend(1, 0)
+				Expect(receiveHeights(payloadChan, 1)).To(Equal([]int64{105}))
+			})
+
+			It("Resends the new branch after a reorg", func() {
+				streamer := btc.NewEsploraPayloadStreamer(chainServer.URL, time.Second, 10*time.Millisecond)
+				payloadChan := make(chan btc.BlockPayload)
+				checkpoint := &btc.Checkpoint{BlockNumber: 103, BlockHash: chain.at(103).BlockHash().String()}
+				sub, err := streamer.Stream(context.Background(), payloadChan, checkpoint)
+				Expect(err).ToNot(HaveOccurred())
+				defer sub.Unsubscribe()
+				Expect(receiveHeights(payloadChan, 1)).To(Equal([]int64{104}))
+
+				chain.truncate(102)
+				chain.extend(3, 1)
+				Expect(receiveHeights(payloadChan, 3)).To(Equal([]int64{103, 104, 105}))
+			})
+		})
+	})
+})
@@ -0,0 +1,46 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc
+
+import (
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+)
+
+// FailedBlockRecorder interface for substituting mocks in tests
+type FailedBlockRecorder interface {
+	RecordFailure(height int64, hash string, attempts int, cause error) error
+}
+
+// DBFailedBlockRecorder satisfies the FailedBlockRecorder interface by writing to the btc.failed_blocks table
+type DBFailedBlockRecorder struct {
+	db *postgres.DB
+}
+
+// NewDBFailedBlockRecorder returns a new DBFailedBlockRecorder struct
+func NewDBFailedBlockRecorder(db *postgres.DB) *DBFailedBlockRecorder {
+	return &DBFailedBlockRecorder{
+		db: db,
+	}
+}
+
+// RecordFailure records a block that could not be published after the given number of attempts
+func (r *DBFailedBlockRecorder) RecordFailure(height int64, hash string, attempts int, cause error) error {
+	pgStr := `INSERT INTO btc.failed_blocks (node_id, block_number, block_hash, attempts, error) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (node_id, block_number, block_hash) DO UPDATE SET (attempts, error, failed_at) = (btc.failed_blocks.attempts + $4, $5, NOW())`
+	_, err := r.db.Exec(pgStr, r.db.NodeID, height, hash, attempts, cause.Error())
+	return err
+}
@@ -0,0 +1,365 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/ipfs/go-cid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/multiformats/go-multihash"
+	"github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/ipfs/ipld"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+)
+
+// GCReport summarizes a garbage collection run
+type GCReport struct {
+	Marked      int64 // Number of IPLDs found to be reachable from an indexed header
+	Unreachable int64 // Number of bitcoin IPLDs in public.blocks that are not reachable
+	Bytes       int64 // Total size of the unreachable IPLDs
+	Deleted     int64 // Number of unreachable IPLDs deleted; always 0 on a dry run
+}
+
+// GarbageCollector interface for substituting mocks in tests
+type GarbageCollector interface {
+	Collect(batchSize uint64, dryRun bool) (GCReport, error)
+}
+
+// gcLock names the advisory lock that keeps the garbage collector from deleting IPLDs while headers are being indexed:
+// every tx that indexes headers holds it shared, and the collector holds it exclusively while it re-marks and deletes
+const gcLock = "btc.gc"
+
+// gcRunLock names the advisory lock a garbage collection run holds from start to end, since runs share btc.gc_marks
+const gcRunLock = "btc.gc.run"
+
+// ErrGCRunning is returned by Collect when another garbage collection run holds gcRunLock
+var ErrGCRunning = errors.New("another btc garbage collection is running")
+
+// shareGCLock holds the garbage collector's lock shared until the tx ends; it must be taken before anything is written
+func shareGCLock(tx *sqlx.Tx) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock_shared(hashtext($1))`, gcLock)
+	return err
+}
+
+// DBGarbageCollector satisfies the GarbageCollector interface for bitcoin
+// It marks every IPLD reachable from an indexed header (the header, the tx trie, and the txs) in btc.gc_marks,
+// and then sweeps public.blocks for bitcoin IPLDs that were not marked
+// Headers and transactions can be indexed while it runs: before each batch of swept IPLDs is deleted, it takes gcLock
+// exclusively and marks the blocks of the headers and transactions indexed since it began, so nothing they reach is
+// deleted
+type DBGarbageCollector struct {
+	db *postgres.DB
+	// called between marking and sweeping; set in tests
+	afterMark func()
+}
+
+// NewDBGarbageCollector returns a new DBGarbageCollector struct
+func NewDBGarbageCollector(db *postgres.DB) *DBGarbageCollector {
+	return &DBGarbageCollector{
+		db: db,
+	}
+}
+
+// Collect finds the IPLDs in public.blocks that are unreachable from any indexed header and, unless dryRun is set,
+// deletes them; batchSize is the number of block heights marked, and the number of IPLDs swept, at a time
+// IPLDs using a multihash other than the double sha256 used by bitcoin are left alone, since public.blocks can be
+// shared with other chains
+func (gc *DBGarbageCollector) Collect(batchSize uint64, dryRun bool) (GCReport, error) {
+	var report GCReport
+	if batchSize == 0 {
+		return report, errors.New("btc garbage collector batch size needs to be greater than zero")
+	}
+	unlock, err := gc.lockRun()
+	if err != nil {
+		return report, err
+	}
+	defer unlock()
+	if _, err := gc.db.Exec(`TRUNCATE btc.gc_marks`); err != nil {
+		return report, err
+	}
+	// headers and transactions indexed after the watermark are marked under the lock, before each delete
+	watermark, err := gc.watermark()
+	if err != nil {
+		return report, err
+	}
+	var bounds struct {
+		Min sql.NullInt64 `db:"min"`
+		Max sql.NullInt64 `db:"max"`
+	}
+	if err := gc.db.Get(&bounds, `SELECT MIN(block_number) AS min, MAX(block_number) AS max FROM btc.header_cids WHERE id <= $1`, watermark.Header); err != nil {
+		return report, err
+	}
+	if bounds.Min.Valid {
+		for start := bounds.Min.Int64; start <= bounds.Max.Int64; start += int64(batchSize) {
+			logrus.Debugf("btc garbage collector marking block range %d to %d", start, start+int64(batchSize)-1)
+			marked, err := markRange(gc.db, start, start+int64(batchSize)-1)
+			if err != nil {
+				return report, err
+			}
+			report.Marked += marked
+		}
+	}
+	logrus.Infof("btc garbage collector marked %d reachable IPLDs", report.Marked)
+	if gc.afterMark != nil {
+		gc.afterMark()
+	}
+
+	var lastKey string
+	for {
+		var keys []string
+		var more bool
+		if keys, lastKey, more, err = gc.sweep(lastKey, batchSize); err != nil {
+			return report, err
+		}
+		if len(keys) > 0 {
+			var batch GCReport
+			if batch, watermark, err = gc.deleteUnreachable(keys, watermark, dryRun); err != nil {
+				return report, err
+			}
+			report.Marked += batch.Marked
+			report.Unreachable += batch.Unreachable
+			report.Bytes += batch.Bytes
+			report.Deleted += batch.Deleted
+			if batch.Deleted > 0 {
+				logrus.Infof("btc garbage collector deleted %d unreachable IPLDs", batch.Deleted)
+			}
+		}
+		if !more {
+			break
+		}
+	}
+	_, err = gc.db.Exec(`TRUNCATE btc.gc_marks`)
+	return report, err
+}
+
+// lockRun takes gcRunLock on a connection of its own, failing with ErrGCRunning if another run holds it, and returns
+// the function releasing it
+func (gc *DBGarbageCollector) lockRun() (func(), error) {
+	conn, err := gc.db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	var locked bool
+	if err := conn.QueryRowContext(context.Background(), `SELECT pg_try_advisory_lock(hashtext($1))`, gcRunLock).Scan(&locked); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !locked {
+		conn.Close()
+		return nil, ErrGCRunning
+	}
+	return func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext($1))`, gcRunLock); err != nil {
+			logrus.Errorf("btc garbage collector unlock error: %v", err)
+		}
+		// closing the connection releases the lock in any case
+		conn.Close()
+	}, nil
+}
+
+// gcWatermark holds the ids of the last header and transaction marked
+type gcWatermark struct {
+	Header int64 `db:"header"`
+	Tx     int64 `db:"tx"`
+}
+
+// watermark returns the ids of the last header and transaction indexed, read under the exclusive lock so that none
+// with a lower id can still be uncommitted
+func (gc *DBGarbageCollector) watermark() (watermark gcWatermark, err error) {
+	tx, err := gc.db.Beginx()
+	if err != nil {
+		return watermark, err
+	}
+	defer func() {
+		if err != nil {
+			shared.Rollback(tx)
+		} else {
+			err = tx.Commit()
+		}
+	}()
+	if _, err = tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, gcLock); err != nil {
+		return watermark, err
+	}
+	pgStr := `SELECT (SELECT COALESCE(MAX(id), 0) FROM btc.header_cids) AS header,
+			(SELECT COALESCE(MAX(id), 0) FROM btc.transaction_cids) AS tx`
+	err = tx.Get(&watermark, pgStr)
+	return watermark, err
+}
+
+// deleteUnreachable takes the exclusive lock, marks the headers and transactions indexed since the watermark, and then
+// deletes (or, on a dry run, only counts) the swept IPLDs that are still unmarked; it returns their count and size and
+// the new watermark
+// while it holds the lock nothing can be indexed, so nothing can reach an IPLD between the check and the delete
+func (gc *DBGarbageCollector) deleteUnreachable(keys []string, watermark gcWatermark, dryRun bool) (report GCReport, newWatermark gcWatermark, err error) {
+	tx, err := gc.db.Beginx()
+	if err != nil {
+		return report, watermark, err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			shared.Rollback(tx)
+			panic(p)
+		} else if err != nil {
+			shared.Rollback(tx)
+		} else {
+			err = tx.Commit()
+		}
+	}()
+	if _, err = tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, gcLock); err != nil {
+		return report, watermark, err
+	}
+	if report.Marked, newWatermark, err = markNew(tx, watermark); err != nil {
+		return report, watermark, err
+	}
+	var unreachable []struct {
+		Key  string `db:"key"`
+		Size int64  `db:"size"`
+	}
+	pgStr := `SELECT key, octet_length(data) AS size FROM public.blocks
+			WHERE key = ANY($1)
+			AND NOT EXISTS (SELECT 1 FROM btc.gc_marks WHERE gc_marks.key = blocks.key)`
+	if err = tx.Select(&unreachable, pgStr, pq.Array(keys)); err != nil {
+		return report, watermark, err
+	}
+	if len(unreachable) == 0 {
+		return report, newWatermark, nil
+	}
+	unreachableKeys := make([]string, len(unreachable))
+	for i, block := range unreachable {
+		unreachableKeys[i] = block.Key
+		report.Bytes += block.Size
+	}
+	report.Unreachable = int64(len(unreachable))
+	if dryRun {
+		return report, newWatermark, nil
+	}
+	res, err := tx.Exec(`DELETE FROM public.blocks WHERE key = ANY($1)`, pq.Array(unreachableKeys))
+	if err != nil {
+		return report, watermark, err
+	}
+	report.Deleted, err = res.RowsAffected()
+	return report, newWatermark, err
+}
+
+// markRange marks the IPLDs reachable from the headers indexed within the block range, returning the number marked
+func markRange(q sqlx.Ext, start, stop int64) (int64, error) {
+	var keys []string
+	if err := sqlx.Select(q, &keys, `SELECT mh_key FROM btc.header_cids WHERE block_number BETWEEN $1 AND $2`, start, stop); err != nil {
+		return 0, err
+	}
+	var txs []struct {
+		HeaderID int64  `db:"header_id"`
+		CID      string `db:"cid"`
+		MhKey    string `db:"mh_key"`
+	}
+	pgStr := `SELECT header_id, cid, mh_key FROM btc.transaction_cids
+			WHERE block_number BETWEEN $1 AND $2
+			ORDER BY header_id, index`
+	if err := sqlx.Select(q, &txs, pgStr, start, stop); err != nil {
+		return 0, err
+	}
+	// the tx trie isn't indexed, but it can be rebuilt from the CIDs of each header's txs
+	var txCIDs []cid.Cid
+	for i, tx := range txs {
+		keys = append(keys, tx.MhKey)
+		c, err := cid.Decode(tx.CID)
+		if err != nil {
+			return 0, err
+		}
+		txCIDs = append(txCIDs, c)
+		if i == len(txs)-1 || txs[i+1].HeaderID != tx.HeaderID {
+			for _, node := range ipld.TxTrieFromCIDs(txCIDs) {
+				keys = append(keys, shared.MultihashKeyFromCID(node.Cid()))
+			}
+			txCIDs = nil
+		}
+	}
+	res, err := q.Exec(`INSERT INTO btc.gc_marks (key) SELECT unnest($1::TEXT[]) ON CONFLICT (key) DO NOTHING`, pq.Array(keys))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// markNew marks the IPLDs reachable from the blocks of the headers and transactions indexed since the watermark, which
+// include transactions republished under a header marked before, returning the number marked and the new watermark;
+// it must be called under the exclusive lock, when nothing with a lower id can be uncommitted
+func markNew(q sqlx.Ext, watermark gcWatermark) (int64, gcWatermark, error) {
+	var next gcWatermark
+	pgStr := `SELECT (SELECT GREATEST(MAX(id), $1) FROM btc.header_cids) AS header,
+			(SELECT GREATEST(MAX(id), $2) FROM btc.transaction_cids) AS tx`
+	if err := sqlx.Get(q, &next, pgStr, watermark.Header, watermark.Tx); err != nil {
+		return 0, watermark, err
+	}
+	var heights []int64
+	pgStr = `SELECT block_number FROM btc.header_cids WHERE id > $1 AND id <= $2
+			UNION
+			SELECT block_number FROM btc.transaction_cids WHERE id > $3 AND id <= $4`
+	if err := sqlx.Select(q, &heights, pgStr, watermark.Header, next.Header, watermark.Tx, next.Tx); err != nil {
+		return 0, watermark, err
+	}
+	var marked int64
+	for _, height := range heights {
+		n, err := markRange(q, height, height)
+		if err != nil {
+			return marked, watermark, err
+		}
+		marked += n
+	}
+	return marked, next, nil
+}
+
+// sweep returns the next batch of unmarked bitcoin IPLDs after lastKey, along with the key to resume from and whether
+// there may be more to sweep
+func (gc *DBGarbageCollector) sweep(lastKey string, batchSize uint64) ([]string, string, bool, error) {
+	var swept []string
+	pgStr := `SELECT key FROM public.blocks
+			WHERE key > $1
+			AND NOT EXISTS (SELECT 1 FROM btc.gc_marks WHERE gc_marks.key = blocks.key)
+			ORDER BY key
+			LIMIT $2`
+	if err := gc.db.Select(&swept, pgStr, lastKey, batchSize); err != nil {
+		return nil, lastKey, false, err
+	}
+	if len(swept) == 0 {
+		return nil, lastKey, false, nil
+	}
+	keys := make([]string, 0, len(swept))
+	for _, key := range swept {
+		hash, err := shared.MultihashFromKey(key)
+		if err != nil {
+			logrus.Warnf("btc garbage collector skipping unrecognized key %s: %v", key, err)
+			continue
+		}
+		decoded, err := multihash.Decode(hash)
+		if err != nil {
+			logrus.Warnf("btc garbage collector skipping unrecognized key %s: %v", key, err)
+			continue
+		}
+		if decoded.Code != multihash.DBL_SHA2_256 {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, swept[len(swept)-1], uint64(len(swept)) == batchSize, nil
+}
@@ -0,0 +1,160 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/ipfs/ipld"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+)
+
+var _ = Describe("DBGarbageCollector", func() {
+	var (
+		db         *postgres.DB
+		err        error
+		gc         *btc.DBGarbageCollector
+		orphanCID  cid.Cid
+		orphanKey  string
+		foreignKey string
+		published  int
+	)
+	BeforeEach(func() {
+		db, err = shared.SetupDB()
+		Expect(err).ToNot(HaveOccurred())
+		gc = btc.NewDBGarbageCollector(db)
+		err = btc.NewIPLDPublisher(db).Publish(context.Background(), mocks.MockConvertedPayload)
+		Expect(err).ToNot(HaveOccurred())
+		err = db.Get(&published, `SELECT COUNT(*) FROM public.blocks`)
+		Expect(err).ToNot(HaveOccurred())
+
+		orphanCID, err = ipld.RawdataToCid(ipld.MBitcoinTx, []byte("orphanedTx"), multihash.DBL_SHA2_256)
+		Expect(err).ToNot(HaveOccurred())
+		orphanKey = shared.MultihashKeyFromCID(orphanCID)
+		foreignKey = shared.MultihashKeyFromCID(shared.TestCID([]byte("otherChainIPLD")))
+		Expect(shared.PublishMockIPLD(db, orphanKey, []byte("orphanedTx"))).To(Succeed())
+		Expect(shared.PublishMockIPLD(db, foreignKey, []byte("otherChainIPLD"))).To(Succeed())
+	})
+	AfterEach(func() {
+		btc.TearDownDB(db)
+	})
+
+	It("Reports unreachable IPLDs without deleting them on a dry run", func() {
+		report, err := gc.Collect(2, true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Marked).To(Equal(int64(published)))
+		Expect(report.Unreachable).To(Equal(int64(1)))
+		Expect(report.Bytes).To(Equal(int64(len("orphanedTx"))))
+		Expect(report.Deleted).To(BeZero())
+		var count int
+		err = db.Get(&count, `SELECT COUNT(*) FROM public.blocks`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(published + 2))
+	})
+
+	It("Deletes unreachable bitcoin IPLDs and keeps the tx trie of indexed headers", func() {
+		report, err := gc.Collect(2, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Deleted).To(Equal(int64(1)))
+		var keys []string
+		err = db.Select(&keys, `SELECT key FROM public.blocks`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys).To(HaveLen(published + 1))
+		Expect(keys).ToNot(ContainElement(orphanKey))
+		Expect(keys).To(ContainElement(foreignKey))
+	})
+
+	It("Keeps the IPLDs of a block published between marking and sweeping", func() {
+		childHeader := mocks.MockBlock.Header
+		childHeader.PrevBlock = mocks.MockBlock.Header.BlockHash()
+		child := mocks.MockConvertedPayload
+		child.BlockPayload = btc.BlockPayload{
+			BlockHeight: mocks.MockBlockHeight + 1,
+			Header:      &childHeader,
+			Txs:         mocks.MockTransactions,
+		}
+		gc.SetAfterMark(func() {
+			Expect(btc.NewIPLDPublisher(db).Publish(context.Background(), child)).To(Succeed())
+		})
+		report, err := gc.Collect(1, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Deleted).To(Equal(int64(1)))
+		var childKey string
+		err = db.Get(&childKey, `SELECT mh_key FROM btc.header_cids WHERE block_number = $1`, child.BlockHeight)
+		Expect(err).ToNot(HaveOccurred())
+		var keys []string
+		err = db.Select(&keys, `SELECT key FROM public.blocks`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys).To(HaveLen(published + 2))
+		Expect(keys).To(ContainElement(childKey))
+		Expect(keys).ToNot(ContainElement(orphanKey))
+	})
+
+	It("Keeps the IPLDs of a transaction indexed under a marked header between marking and sweeping", func() {
+		gc.SetAfterMark(func() {
+			_, err := db.Exec(`INSERT INTO btc.transaction_cids (header_id, block_number, index, tx_hash, cid, mh_key, segwit)
+				SELECT id, block_number, 99, 'orphanedTx', $1, $2, false FROM btc.header_cids`,
+				orphanCID.String(), orphanKey)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		report, err := gc.Collect(1, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Deleted).To(BeZero())
+		var keys []string
+		err = db.Select(&keys, `SELECT key FROM public.blocks`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys).To(ContainElement(orphanKey))
+	})
+
+	It("Refuses to run while another run holds its lock", func() {
+		conn, err := db.Conn(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		_, err = conn.ExecContext(context.Background(), `SELECT pg_advisory_lock(hashtext('btc.gc.run'))`)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = gc.Collect(2, false)
+		Expect(errors.Is(err, btc.ErrGCRunning)).To(BeTrue())
+		_, err = conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext('btc.gc.run'))`)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = gc.Collect(2, false)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("Collects the tx trie once its header is no longer indexed", func() {
+		_, err = db.Exec(`DELETE FROM btc.header_cids`)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = db.Exec(`DELETE FROM btc.transaction_cids`)
+		Expect(err).ToNot(HaveOccurred())
+		report, err := gc.Collect(100, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Deleted).To(Equal(int64(published + 1)))
+		var keys []string
+		err = db.Select(&keys, `SELECT key FROM public.blocks`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys).To(Equal([]string{foreignKey}))
+	})
+})
@@ -17,8 +17,7 @@
 package btc
 
 import (
-	"bytes"
-	"time"
+	"context"
 
 	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/sirupsen/logrus"
@@ -26,14 +25,21 @@ import (
 
 // Streamer interface for substituting mocks in tests
 type Streamer interface {
-	Stream(payloadChan chan BlockPayload) (*HTTPClientSubscription, error)
+	// Stream sends blocks at the head of the chain to the payloadChan until the subscription is unsubscribed or the context is done
+	// If a checkpoint is provided, every block after it is sent, in order, before head-tracking begins
+	Stream(ctx context.Context, payloadChan chan BlockPayload, checkpoint *Checkpoint) (Subscription, error)
+}
+
+// Subscription interface for the handles returned by Streamers; mirrors the rpc.Subscription interface
+type Subscription interface {
+	Unsubscribe()
+	Err() <-chan error
 }
 
 // HTTPPayloadStreamer satisfies the PayloadStreamer interface for bitcoin over http endpoints
 // (bitcoin core doesn't support websockets, btcd doesn't support zmq- need to write adapter)
 type HTTPPayloadStreamer struct {
-	Config   *rpcclient.ConnConfig
-	lastHash []byte
+	Config *rpcclient.ConnConfig
 }
 
 // NewHTTPPayloadStreamer creates a pointer to a new PayloadStreamer which satisfies the PayloadStreamer interface for bitcoin
@@ -45,64 +51,30 @@ func NewHTTPPayloadStreamer(clientConfig *rpcclient.ConnConfig) *HTTPPayloadStre
 
 // Stream is the main loop for subscribing to data from the btc block notifications
 // using only the standard http endpoints shared between bitcoind and btcd nodes
-func (ps *HTTPPayloadStreamer) Stream(payloadChan chan BlockPayload) (*HTTPClientSubscription, error) {
+// the rpc client is shut down once streaming stops
+func (ps *HTTPPayloadStreamer) Stream(ctx context.Context, payloadChan chan BlockPayload, checkpoint *Checkpoint) (Subscription, error) {
 	logrus.Debug("streaming block payloads from btc")
 	client, err := rpcclient.New(ps.Config, nil)
 	if err != nil {
 		return nil, err
 	}
-	ticker := time.NewTicker(time.Second * 5)
-	errChan := make(chan error)
+	poller, err := newBlockPoller(instrument(client), checkpoint)
+	if err != nil {
+		client.Shutdown()
+		return nil, err
+	}
+	sub := &HTTPClientSubscription{
+		PollingSubscription: newPollingSubscription(ctx),
+	}
 	go func() {
-		for {
-			// start at
-			select {
-			case <-ticker.C:
-				height, err := client.GetBlockCount()
-				if err != nil {
-					errChan <- err
-					continue
-				}
-				blockHash, err := client.GetBlockHash(height)
-				if err != nil {
-					errChan <- err
-					continue
-				}
-				blockHashBytes := blockHash.CloneBytes()
-				if bytes.Equal(blockHashBytes, ps.lastHash) {
-					continue
-				}
-				block, err := client.GetBlock(blockHash)
-				if err != nil {
-					errChan <- err
-					continue
-				}
-				ps.lastHash = blockHashBytes
-				payloadChan <- BlockPayload{
-					Header:      &block.Header,
-					BlockHeight: height,
-					Txs:         msgTxsToUtilTxs(block.Transactions),
-				}
-			default:
-			}
-		}
+		sub.run(poller, payloadChan, DefaultPollInterval)
+		client.Shutdown()
 	}()
-	return &HTTPClientSubscription{client: client, errChan: errChan}, nil
+	return sub, nil
 }
 
-// HTTPClientSubscription is a wrapper around the underlying bitcoind rpc client
+// HTTPClientSubscription is the subscription to the underlying bitcoind rpc client, which is shut down when polling stops
 // TODO: use ZMQ from bitcoind or use websockets from btcd
 type HTTPClientSubscription struct {
-	client  *rpcclient.Client
-	errChan chan error
-}
-
-// Unsubscribe satisfies the rpc.Subscription interface
-func (bcs *HTTPClientSubscription) Unsubscribe() {
-	bcs.client.Shutdown()
-}
-
-// Err() satisfies the rpc.Subscription interface
-func (bcs *HTTPClientSubscription) Err() <-chan error {
-	return bcs.errChan
+	*PollingSubscription
 }
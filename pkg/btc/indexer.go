@@ -17,12 +17,15 @@
 package btc
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/sirupsen/logrus"
 
 	"github.com/jmoiron/sqlx"
-	"github.com/lib/pq"
 
 	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/prom"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
 )
 
@@ -32,16 +35,26 @@ type Indexer interface {
 }
 
 type CIDIndexer struct {
-	db *postgres.DB
+	db         *postgres.DB
+	partitions *partitioner
 }
 
 func NewCIDIndexer(db *postgres.DB) *CIDIndexer {
 	return &CIDIndexer{
-		db: db,
+		db:         db,
+		partitions: newPartitioner(db),
 	}
 }
 
 func (in *CIDIndexer) Index(cids CIDPayload) error {
+	blockNumber, err := strconv.ParseInt(cids.HeaderCID.BlockNumber, 10, 64)
+	if err != nil {
+		return err
+	}
+	if err := in.partitions.ensure(blockNumber); err != nil {
+		return err
+	}
+
 	// Begin new db tx
 	tx, err := in.db.Beginx()
 	if err != nil {
@@ -58,43 +71,50 @@ func (in *CIDIndexer) Index(cids CIDPayload) error {
 		}
 	}()
 
-	headerID, err := in.indexHeaderCID(tx, cids.HeaderCID)
+	if err = shareGCLock(tx); err != nil {
+		return err
+	}
+
+	headerID, err := in.indexHeaderCID(tx, cids.HeaderCID, 1)
 	if err != nil {
 		logrus.Error("btc indexer error when indexing header")
 		return err
 	}
-	err = in.indexTransactionCIDs(tx, cids.TransactionCIDs, headerID)
+	err = in.indexTransactionCIDs(tx, cids.TransactionCIDs, headerID, blockNumber)
 	if err != nil {
 		logrus.Error("btc indexer error when indexing transactions")
 	}
 	return err
 }
 
-func (in *CIDIndexer) indexHeaderCID(tx *sqlx.Tx, header HeaderModel) (int64, error) {
+// indexHeaderCID upserts the header; a new header starts with validations as its times_validated,
+// while republishing an indexed header leaves its times_validated alone since only the validation pass counts
+func (in *CIDIndexer) indexHeaderCID(tx *sqlx.Tx, header HeaderModel, validations int64) (int64, error) {
+	defer prom.PublishDuration("btc.header_cids", time.Now())
 	var headerID int64
 	err := tx.QueryRowx(`INSERT INTO btc.header_cids (block_number, block_hash, parent_hash, cid, timestamp, bits, node_id, mh_key, times_validated)
 							VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-							ON CONFLICT (block_number, block_hash) DO UPDATE SET (parent_hash, cid, timestamp, bits, node_id, mh_key, times_validated) = ($3, $4, $5, $6, $7, $8, btc.header_cids.times_validated + 1)
+							ON CONFLICT (block_number, block_hash) DO UPDATE SET (parent_hash, cid, timestamp, bits, node_id, mh_key) = ($3, $4, $5, $6, $7, $8)
 							RETURNING id`,
-		header.BlockNumber, header.BlockHash, header.ParentHash, header.CID, header.Timestamp, header.Bits, in.db.NodeID, header.MhKey, 1).Scan(&headerID)
+		header.BlockNumber, header.BlockHash, header.ParentHash, header.CID, header.Timestamp, header.Bits, in.db.NodeID, header.MhKey, validations).Scan(&headerID)
 	return headerID, err
 }
 
-func (in *CIDIndexer) indexTransactionCIDs(tx *sqlx.Tx, transactions []TxModelWithInsAndOuts, headerID int64) error {
+func (in *CIDIndexer) indexTransactionCIDs(tx *sqlx.Tx, transactions []TxModelWithInsAndOuts, headerID, blockNumber int64) error {
 	for _, transaction := range transactions {
-		txID, err := in.indexTransactionCID(tx, transaction, headerID)
+		txID, err := in.indexTransactionCID(tx, transaction, headerID, blockNumber)
 		if err != nil {
 			logrus.Error("btc indexer error when indexing header")
 			return err
 		}
 		for _, input := range transaction.TxInputs {
-			if err := in.indexTxInput(tx, input, txID); err != nil {
+			if err := in.indexTxInput(tx, input, txID, blockNumber); err != nil {
 				logrus.Error("btc indexer error when indexing tx inputs")
 				return err
 			}
 		}
 		for _, output := range transaction.TxOutputs {
-			if err := in.indexTxOutput(tx, output, txID); err != nil {
+			if err := in.indexTxOutput(tx, output, txID, blockNumber); err != nil {
 				logrus.Error("btc indexer error when indexing tx outputs")
 				return err
 			}
@@ -103,28 +123,31 @@ func (in *CIDIndexer) indexTransactionCIDs(tx *sqlx.Tx, transactions []TxModelWi
 	return nil
 }
 
-func (in *CIDIndexer) indexTransactionCID(tx *sqlx.Tx, transaction TxModelWithInsAndOuts, headerID int64) (int64, error) {
+func (in *CIDIndexer) indexTransactionCID(tx *sqlx.Tx, transaction TxModelWithInsAndOuts, headerID, blockNumber int64) (int64, error) {
+	defer prom.PublishDuration("btc.transaction_cids", time.Now())
 	var txID int64
-	err := tx.QueryRowx(`INSERT INTO btc.transaction_cids (header_id, tx_hash, index, cid, segwit, witness_hash, mh_key)
-							VALUES ($1, $2, $3, $4, $5, $6, $7)
-							ON CONFLICT (header_id, tx_hash) DO UPDATE SET (index, cid, segwit, witness_hash, mh_key) = ($3, $4, $5, $6, $7)
+	err := tx.QueryRowx(`INSERT INTO btc.transaction_cids (header_id, tx_hash, index, cid, segwit, witness_hash, mh_key, block_number)
+							VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+							ON CONFLICT (block_number, header_id, tx_hash) DO UPDATE SET (index, cid, segwit, witness_hash, mh_key) = ($3, $4, $5, $6, $7)
 							RETURNING id`,
-		headerID, transaction.TxHash, transaction.Index, transaction.CID, transaction.SegWit, transaction.WitnessHash, transaction.MhKey).Scan(&txID)
+		headerID, transaction.TxHash, transaction.Index, transaction.CID, transaction.SegWit, transaction.WitnessHash, transaction.MhKey, blockNumber).Scan(&txID)
 	return txID, err
 }
 
-func (in *CIDIndexer) indexTxInput(tx *sqlx.Tx, txInput TxInput, txID int64) error {
-	_, err := tx.Exec(`INSERT INTO btc.tx_inputs (tx_id, index, witness, sig_script, outpoint_tx_hash, outpoint_index)
-						VALUES ($1, $2, $3, $4, $5, $6)
-						ON CONFLICT (tx_id, index) DO UPDATE SET (witness, sig_script, outpoint_tx_hash, outpoint_index) = ($3, $4, $5, $6)`,
-		txID, txInput.Index, pq.Array(txInput.TxWitness), txInput.SignatureScript, txInput.PreviousOutPointHash, txInput.PreviousOutPointIndex)
+func (in *CIDIndexer) indexTxInput(tx *sqlx.Tx, txInput TxInput, txID, blockNumber int64) error {
+	defer prom.PublishDuration("btc.tx_inputs", time.Now())
+	_, err := tx.Exec(`INSERT INTO btc.tx_inputs (tx_id, index, witness, sig_script, outpoint_tx_hash, outpoint_index, block_number)
+						VALUES ($1, $2, $3, $4, $5, $6, $7)
+						ON CONFLICT (block_number, tx_id, index) DO UPDATE SET (witness, sig_script, outpoint_tx_hash, outpoint_index) = ($3, $4, $5, $6)`,
+		txID, txInput.Index, txInput.TxWitness, txInput.SignatureScript, txInput.PreviousOutPointHash, txInput.PreviousOutPointIndex, blockNumber)
 	return err
 }
 
-func (in *CIDIndexer) indexTxOutput(tx *sqlx.Tx, txOuput TxOutput, txID int64) error {
-	_, err := tx.Exec(`INSERT INTO btc.tx_outputs (tx_id, index, value, pk_script, script_class, addresses, required_sigs)
-							VALUES ($1, $2, $3, $4, $5, $6, $7)
-							ON CONFLICT (tx_id, index) DO UPDATE SET (value, pk_script, script_class, addresses, required_sigs) = ($3, $4, $5, $6, $7)`,
-		txID, txOuput.Index, txOuput.Value, txOuput.PkScript, txOuput.ScriptClass, txOuput.Addresses, txOuput.RequiredSigs)
+func (in *CIDIndexer) indexTxOutput(tx *sqlx.Tx, txOuput TxOutput, txID, blockNumber int64) error {
+	defer prom.PublishDuration("btc.tx_outputs", time.Now())
+	_, err := tx.Exec(`INSERT INTO btc.tx_outputs (tx_id, index, value, pk_script, script_class, addresses, required_sigs, block_number, script_hash)
+							VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+							ON CONFLICT (block_number, tx_id, index) DO UPDATE SET (value, pk_script, script_class, addresses, required_sigs, script_hash) = ($3, $4, $5, $6, $7, $9)`,
+		txID, txOuput.Index, txOuput.Value, txOuput.PkScript, txOuput.ScriptClass, txOuput.Addresses, txOuput.RequiredSigs, blockNumber, txOuput.ScriptHash)
 	return err
 }
@@ -0,0 +1,90 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc
+
+import (
+	"context"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/prom"
+)
+
+// instrumentedClient wraps a BlockClient, recording the latency and errors of its calls
+type instrumentedClient struct {
+	client BlockClient
+}
+
+// instrument returns the client wrapped so that its calls are recorded in the rpc metrics
+func instrument(client BlockClient) BlockClient {
+	return &instrumentedClient{client: client}
+}
+
+// GetBlockCount satisfies the BlockClient interface
+func (ic *instrumentedClient) GetBlockCount() (int64, error) {
+	start := time.Now()
+	count, err := ic.client.GetBlockCount()
+	prom.RPCCall("getblockcount", start, err)
+	if err == nil {
+		prom.SetChainHead(count)
+	}
+	return count, err
+}
+
+// GetBlockHash satisfies the BlockClient interface
+func (ic *instrumentedClient) GetBlockHash(blockHeight int64) (*chainhash.Hash, error) {
+	start := time.Now()
+	hash, err := ic.client.GetBlockHash(blockHeight)
+	prom.RPCCall("getblockhash", start, err)
+	return hash, err
+}
+
+// GetBlock satisfies the BlockClient interface
+func (ic *instrumentedClient) GetBlock(blockHash *chainhash.Hash) (*wire.MsgBlock, error) {
+	start := time.Now()
+	block, err := ic.client.GetBlock(blockHash)
+	prom.RPCCall("getblock", start, err)
+	return block, err
+}
+
+// GetBlockHashContext satisfies the ContextBlockClient interface
+// if the wrapped client cannot be cancelled, the request runs to completion regardless of the context
+func (ic *instrumentedClient) GetBlockHashContext(ctx context.Context, blockHeight int64) (*chainhash.Hash, error) {
+	cc, ok := ic.client.(ContextBlockClient)
+	if !ok {
+		return ic.GetBlockHash(blockHeight)
+	}
+	start := time.Now()
+	hash, err := cc.GetBlockHashContext(ctx, blockHeight)
+	prom.RPCCall("getblockhash", start, err)
+	return hash, err
+}
+
+// GetBlockContext satisfies the ContextBlockClient interface
+// if the wrapped client cannot be cancelled, the request runs to completion regardless of the context
+func (ic *instrumentedClient) GetBlockContext(ctx context.Context, blockHash *chainhash.Hash) (*wire.MsgBlock, error) {
+	cc, ok := ic.client.(ContextBlockClient)
+	if !ok {
+		return ic.GetBlock(blockHash)
+	}
+	start := time.Now()
+	block, err := cc.GetBlockContext(ctx, blockHash)
+	prom.RPCCall("getblock", start, err)
+	return block, err
+}
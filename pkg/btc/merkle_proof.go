@@ -0,0 +1,314 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// maxProofTxs bounds the number of transactions a proof can claim for its block, as bitcoind bounds it: by the
+// number of the smallest possible transactions that fit in a block
+const maxProofTxs = blockchain.MaxBlockWeight / 240
+
+// MerkleChildren returns the hashes of the left and right children of the inner merkle tree node with the hash
+type MerkleChildren func(node chainhash.Hash) (left, right chainhash.Hash, err error)
+
+// NewTxOutProof builds the partial merkle tree proving that the transactions at the matched positions are in the block
+// with the header and numTxs transactions, in the format of bitcoind's gettxoutproof (a BIP37 merkleblock)
+// The tree is walked down from the header's merkle root, using children to look up the nodes above the matches
+func NewTxOutProof(header *wire.BlockHeader, numTxs uint32, matches []uint32, children MerkleChildren) (*wire.MsgMerkleBlock, error) {
+	if numTxs == 0 {
+		return nil, errors.New("a block has at least one transaction")
+	}
+	matched := make(map[uint32]bool, len(matches))
+	for _, pos := range matches {
+		if pos >= numTxs {
+			return nil, fmt.Errorf("position %d is out of range for a block with %d transactions", pos, numTxs)
+		}
+		matched[pos] = true
+	}
+	b := &proofBuilder{
+		proof:   wire.NewMsgMerkleBlock(header),
+		numTxs:  numTxs,
+		matched: matched,
+	}
+	b.proof.Transactions = numTxs
+	if err := b.traverse(treeHeight(numTxs), 0, header.MerkleRoot, children); err != nil {
+		return nil, err
+	}
+	b.proof.Flags = make([]byte, (len(b.bits)+7)/8)
+	for i, bit := range b.bits {
+		if bit {
+			b.proof.Flags[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return b.proof, nil
+}
+
+type proofBuilder struct {
+	proof   *wire.MsgMerkleBlock
+	numTxs  uint32
+	matched map[uint32]bool
+	bits    []bool
+}
+
+// traverse visits the node at the height and position depth first, recording whether a match lies below it and the
+// hashes of the nodes below which nothing matches, and of the matches themselves
+func (b *proofBuilder) traverse(height, pos uint32, hash chainhash.Hash, children MerkleChildren) error {
+	parentOfMatch := false
+	for p := pos << height; p < (pos+1)<<height && p < b.numTxs; p++ {
+		if b.matched[p] {
+			parentOfMatch = true
+			break
+		}
+	}
+	b.bits = append(b.bits, parentOfMatch)
+	if height == 0 || !parentOfMatch {
+		h := hash
+		return b.proof.AddTxHash(&h)
+	}
+	left, right, err := children(hash)
+	if err != nil {
+		return err
+	}
+	if err := b.traverse(height-1, pos*2, left, children); err != nil {
+		return err
+	}
+	if pos*2+1 < treeWidth(b.numTxs, height-1) {
+		return b.traverse(height-1, pos*2+1, right, children)
+	}
+	return nil
+}
+
+// VerifyTxOutProof checks the partial merkle tree of a proof made by NewTxOutProof (or bitcoind's gettxoutproof)
+// against the merkle root of its header, returning the hashes of the transactions it proves, in block order
+// It does not check that the header is in the chain
+func VerifyTxOutProof(proof *wire.MsgMerkleBlock) ([]chainhash.Hash, error) {
+	if proof.Transactions == 0 {
+		return nil, errors.New("proof claims a block without transactions")
+	}
+	if proof.Transactions > maxProofTxs {
+		return nil, fmt.Errorf("proof claims %d transactions, more than fit in a block", proof.Transactions)
+	}
+	if uint32(len(proof.Hashes)) > proof.Transactions {
+		return nil, errors.New("proof has more hashes than transactions")
+	}
+	if len(proof.Flags)*8 < len(proof.Hashes) {
+		return nil, errors.New("proof has fewer flag bits than hashes")
+	}
+	e := &proofExtractor{proof: proof}
+	root, err := e.traverse(treeHeight(proof.Transactions), 0)
+	if err != nil {
+		return nil, err
+	}
+	if (e.bitsUsed+7)/8 != len(proof.Flags) {
+		return nil, errors.New("proof has unused flag bytes")
+	}
+	if e.hashesUsed != len(proof.Hashes) {
+		return nil, errors.New("proof has unused hashes")
+	}
+	if !root.IsEqual(&proof.Header.MerkleRoot) {
+		return nil, fmt.Errorf("proof produces merkle root %s, header has %s", root.String(), proof.Header.MerkleRoot.String())
+	}
+	return e.matches, nil
+}
+
+type proofExtractor struct {
+	proof      *wire.MsgMerkleBlock
+	bitsUsed   int
+	hashesUsed int
+	matches    []chainhash.Hash
+}
+
+// traverse consumes the flag bits and hashes of the node at the height and position, returning its hash
+func (e *proofExtractor) traverse(height, pos uint32) (chainhash.Hash, error) {
+	if e.bitsUsed >= len(e.proof.Flags)*8 {
+		return chainhash.Hash{}, errors.New("proof runs out of flag bits")
+	}
+	parentOfMatch := e.proof.Flags[e.bitsUsed/8]&(1<<uint(e.bitsUsed%8)) != 0
+	e.bitsUsed++
+	if height == 0 || !parentOfMatch {
+		if e.hashesUsed >= len(e.proof.Hashes) {
+			return chainhash.Hash{}, errors.New("proof runs out of hashes")
+		}
+		hash := *e.proof.Hashes[e.hashesUsed]
+		e.hashesUsed++
+		if height == 0 && parentOfMatch {
+			e.matches = append(e.matches, hash)
+		}
+		return hash, nil
+	}
+	left, err := e.traverse(height-1, pos*2)
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+	right := left
+	if pos*2+1 < treeWidth(e.proof.Transactions, height-1) {
+		if right, err = e.traverse(height-1, pos*2+1); err != nil {
+			return chainhash.Hash{}, err
+		}
+		// identical siblings would let a block with duplicated transactions share the merkle root of the real one (CVE-2012-2459)
+		if right.IsEqual(&left) {
+			return chainhash.Hash{}, errors.New("proof has identical sibling hashes")
+		}
+	}
+	return *blockchain.HashMerkleBranches(&left, &right), nil
+}
+
+// treeWidth returns the number of nodes at the height of the merkle tree of numTxs transactions
+func treeWidth(numTxs, height uint32) uint32 {
+	return (numTxs + (1 << height) - 1) >> height
+}
+
+// treeHeight returns the height of the root of the merkle tree of numTxs transactions
+func treeHeight(numTxs uint32) uint32 {
+	var height uint32
+	for treeWidth(numTxs, height) > 1 {
+		height++
+	}
+	return height
+}
+
+// TxHashChildren returns the MerkleChildren of the merkle tree of the transaction hashes, in block order
+func TxHashChildren(txHashes []chainhash.Hash) MerkleChildren {
+	nodes := make(map[chainhash.Hash][2]chainhash.Hash)
+	layer := make([]chainhash.Hash, len(txHashes))
+	copy(layer, txHashes)
+	for len(layer) > 1 {
+		if len(layer)%2 != 0 {
+			layer = append(layer, layer[len(layer)-1])
+		}
+		next := make([]chainhash.Hash, len(layer)/2)
+		for i := range next {
+			next[i] = *blockchain.HashMerkleBranches(&layer[i*2], &layer[i*2+1])
+			nodes[next[i]] = [2]chainhash.Hash{layer[i*2], layer[i*2+1]}
+		}
+		layer = next
+	}
+	return func(node chainhash.Hash) (chainhash.Hash, chainhash.Hash, error) {
+		pair, ok := nodes[node]
+		if !ok {
+			return chainhash.Hash{}, chainhash.Hash{}, fmt.Errorf("%s is not an inner node of the transactions' merkle tree", node.String())
+		}
+		return pair[0], pair[1], nil
+	}
+}
+
+// TxOutProof returns the partial merkle tree proving that the transactions with the hashes are in the header's block,
+// as bitcoind's gettxoutproof does
+// It walks the stored tx trie down from the header's merkle root. The trie's leaves are the CIDs of the transactions
+// serialized with their witnesses, so for blocks with witness transactions it does not hang off the merkle root; their
+// proofs are built from the indexed transaction hashes instead. Either way, the proof is verified before it is returned
+func (r *DBChainReader) TxOutProof(header *IndexedHeader, txHashes []string) (*wire.MsgMerkleBlock, error) {
+	wireHeader, err := header.WireHeader()
+	if err != nil {
+		return nil, err
+	}
+	txs, err := r.Txs(header)
+	if err != nil {
+		return nil, err
+	}
+	if len(txs) == 0 {
+		return nil, fmt.Errorf("block %s has no transactions indexed", header.BlockHash)
+	}
+	positions := make(map[string]uint32, len(txs))
+	hashes := make([]chainhash.Hash, len(txs))
+	for i, tx := range txs {
+		if tx.Index != int64(i) {
+			return nil, fmt.Errorf("transaction %d of block %s is not indexed", i, header.BlockHash)
+		}
+		hash, err := chainhash.NewHashFromStr(tx.TxHash)
+		if err != nil {
+			return nil, err
+		}
+		positions[tx.TxHash] = uint32(i)
+		hashes[i] = *hash
+	}
+	matches := make([]uint32, len(txHashes))
+	for i, txHash := range txHashes {
+		pos, ok := positions[txHash]
+		if !ok {
+			return nil, fmt.Errorf("transaction %s in block %s is %w", txHash, header.BlockHash, ErrNotIndexed)
+		}
+		matches[i] = pos
+	}
+
+	children := r.trieChildren
+	if _, err := r.txIPLD(wireHeader.MerkleRoot); errors.Is(err, ErrNotIndexed) {
+		children = TxHashChildren(hashes)
+	} else if err != nil {
+		return nil, err
+	}
+	proof, err := NewTxOutProof(wireHeader, uint32(len(txs)), matches, children)
+	if err != nil {
+		return nil, err
+	}
+	proved, err := VerifyTxOutProof(proof)
+	if err != nil {
+		return nil, fmt.Errorf("proof for block %s does not verify: %v", header.BlockHash, err)
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i] < matches[j] })
+	var proving []chainhash.Hash
+	for i, pos := range matches {
+		if i == 0 || pos != matches[i-1] {
+			proving = append(proving, hashes[pos])
+		}
+	}
+	if len(proved) != len(proving) {
+		return nil, fmt.Errorf("proof for block %s proves %d transactions, not %d", header.BlockHash, len(proved), len(proving))
+	}
+	for i := range proved {
+		if !proved[i].IsEqual(&proving[i]) {
+			return nil, fmt.Errorf("proof for block %s proves transaction %s, which was not asked for", header.BlockHash, proved[i].String())
+		}
+	}
+	return proof, nil
+}
+
+// trieChildren looks up the children of an inner node of a stored tx trie
+func (r *DBChainReader) trieChildren(node chainhash.Hash) (chainhash.Hash, chainhash.Hash, error) {
+	data, err := r.txIPLD(node)
+	if err != nil {
+		return chainhash.Hash{}, chainhash.Hash{}, err
+	}
+	if len(data) != 2*chainhash.HashSize {
+		return chainhash.Hash{}, chainhash.Hash{}, fmt.Errorf("tx trie node %s is not an inner node", node.String())
+	}
+	var left, right chainhash.Hash
+	copy(left[:], data[:chainhash.HashSize])
+	copy(right[:], data[chainhash.HashSize:])
+	return left, right, nil
+}
+
+// txIPLD returns the stored data of the transaction or tx trie node IPLD with the hash
+func (r *DBChainReader) txIPLD(hash chainhash.Hash) ([]byte, error) {
+	data, err := r.txIPLDs([]chainhash.Hash{hash})
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := data[hash]
+	if !ok {
+		return nil, fmt.Errorf("IPLD %s is %w", hash.String(), ErrNotIndexed)
+	}
+	return raw, nil
+}
@@ -0,0 +1,158 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc_test
+
+import (
+	"bytes"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/bloom"
+	"github.com/ipfs/go-cid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/ipfs/ipld"
+)
+
+// proofBlock returns a block of n distinct transactions with a consistent merkle root
+func proofBlock(n int) *btcutil.Block {
+	msgBlock := wire.NewMsgBlock(&wire.BlockHeader{Version: 1})
+	for i := 0; i < n; i++ {
+		tx := wire.NewMsgTx(1)
+		tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, uint32(i)), nil, nil))
+		tx.AddTxOut(wire.NewTxOut(int64(i), []byte{0x51}))
+		msgBlock.AddTransaction(tx)
+	}
+	block := btcutil.NewBlock(msgBlock)
+	merkles := blockchain.BuildMerkleTreeStore(block.Transactions(), false)
+	msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+	return btcutil.NewBlock(msgBlock)
+}
+
+func txHashes(block *btcutil.Block) []chainhash.Hash {
+	hashes := make([]chainhash.Hash, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		hashes[i] = *tx.Hash()
+	}
+	return hashes
+}
+
+func encodeProof(proof *wire.MsgMerkleBlock) []byte {
+	buf := new(bytes.Buffer)
+	Expect(proof.BtcEncode(buf, wire.ProtocolVersion, wire.BaseEncoding)).To(Succeed())
+	return buf.Bytes()
+}
+
+var _ = Describe("TxOutProof", func() {
+	It("Builds the same partial merkle trees as BIP37 merkleblocks, and verifies them", func() {
+		for _, n := range []int{1, 2, 3, 5, 7, 16, 17} {
+			block := proofBlock(n)
+			hashes := txHashes(block)
+			for _, matches := range [][]uint32{{0}, {uint32(n - 1)}, {uint32(n / 2)}, {0, uint32(n - 1)}} {
+				filter := bloom.NewFilter(uint32(len(matches)), 0, 0.000001, wire.BloomUpdateNone)
+				var expected []chainhash.Hash
+				for i := range hashes {
+					for _, pos := range matches {
+						if pos == uint32(i) {
+							filter.AddHash(&hashes[i])
+							expected = append(expected, hashes[i])
+							break
+						}
+					}
+				}
+				reference, _ := bloom.NewMerkleBlock(block, filter)
+
+				proof, err := btc.NewTxOutProof(&block.MsgBlock().Header, uint32(n), matches, btc.TxHashChildren(hashes))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(encodeProof(proof)).To(Equal(encodeProof(reference)))
+				proved, err := btc.VerifyTxOutProof(proof)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(proved).To(Equal(expected))
+			}
+		}
+	})
+
+	It("Walks a stored tx trie", func() {
+		block := proofBlock(5)
+		txCIDs := make([]cid.Cid, 0)
+		trie := make(map[chainhash.Hash][]byte)
+		for _, tx := range block.Transactions() {
+			node, err := ipld.NewBtcTx(tx.MsgTx())
+			Expect(err).ToNot(HaveOccurred())
+			txCIDs = append(txCIDs, node.Cid())
+		}
+		for _, node := range ipld.TxTrieFromCIDs(txCIDs) {
+			var hash chainhash.Hash
+			copy(hash[:], node.BTCSha())
+			trie[hash] = node.RawData()
+		}
+		children := func(node chainhash.Hash) (left, right chainhash.Hash, err error) {
+			data, ok := trie[node]
+			Expect(ok).To(BeTrue())
+			copy(left[:], data[:32])
+			copy(right[:], data[32:])
+			return left, right, nil
+		}
+
+		proof, err := btc.NewTxOutProof(&block.MsgBlock().Header, 5, []uint32{3}, children)
+		Expect(err).ToNot(HaveOccurred())
+		proved, err := btc.VerifyTxOutProof(proof)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(proved).To(Equal([]chainhash.Hash{*block.Transactions()[3].Hash()}))
+	})
+
+	It("Rejects proofs that do not produce the header's merkle root", func() {
+		block := proofBlock(7)
+		hashes := txHashes(block)
+		proof, err := btc.NewTxOutProof(&block.MsgBlock().Header, 7, []uint32{4}, btc.TxHashChildren(hashes))
+		Expect(err).ToNot(HaveOccurred())
+
+		tampered := *proof
+		tampered.Hashes = append([]*chainhash.Hash{&chainhash.Hash{2}}, proof.Hashes[1:]...)
+		_, err = btc.VerifyTxOutProof(&tampered)
+		Expect(err).To(HaveOccurred())
+
+		tampered = *proof
+		tampered.Header.MerkleRoot = chainhash.Hash{3}
+		_, err = btc.VerifyTxOutProof(&tampered)
+		Expect(err).To(HaveOccurred())
+
+		tampered = *proof
+		tampered.Flags = append(append([]byte{}, proof.Flags...), 0)
+		_, err = btc.VerifyTxOutProof(&tampered)
+		Expect(err).To(HaveOccurred())
+
+		tampered = *proof
+		tampered.Transactions = 2
+		_, err = btc.VerifyTxOutProof(&tampered)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Rejects proofs with identical siblings", func() {
+		block := proofBlock(3)
+		hashes := append(txHashes(block), txHashes(block)[2])
+		header := block.MsgBlock().Header
+		proof, err := btc.NewTxOutProof(&header, 4, []uint32{3}, btc.TxHashChildren(hashes))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = btc.VerifyTxOutProof(proof)
+		Expect(err).To(MatchError(ContainSubstring("identical sibling")))
+	})
+})
@@ -0,0 +1,51 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package mocks
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// BlockClient is a mock btc.BlockClient reporting a fixed tip
+type BlockClient struct {
+	sync.Mutex
+	Tip         int64
+	TipErr      error
+	CountCalled int
+}
+
+// GetBlockCount returns the Tip and TipErr
+func (bc *BlockClient) GetBlockCount() (int64, error) {
+	bc.Lock()
+	defer bc.Unlock()
+	bc.CountCalled++
+	return bc.Tip, bc.TipErr
+}
+
+// GetBlockHash mock method
+func (bc *BlockClient) GetBlockHash(blockHeight int64) (*chainhash.Hash, error) {
+	return nil, errors.New("mock block client has no blocks")
+}
+
+// GetBlock mock method
+func (bc *BlockClient) GetBlock(blockHash *chainhash.Hash) (*wire.MsgBlock, error) {
+	return nil, errors.New("mock block client has no blocks")
+}
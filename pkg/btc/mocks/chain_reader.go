@@ -0,0 +1,545 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package mocks
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+)
+
+// ChainReader is an in-memory btc.ChainReader over the blocks added to it
+// Err, when set, is returned by every method; Calls counts the calls made to each method
+type ChainReader struct {
+	sync.Mutex
+	Err   error
+	Calls map[string]int
+
+	converter *btc.PayloadConverter
+	headers   []btc.IndexedHeader
+	canonical map[int64]int
+	txs       map[int64][]btc.IndexedTx
+	inputs    map[int64][]btc.TxInput
+	outputs   map[int64][]btc.TxOutput
+	txCount   int64
+	outCount  int64
+}
+
+// NewChainReader creates an empty ChainReader decoding output addresses for the chain
+func NewChainReader(params *chaincfg.Params) *ChainReader {
+	return &ChainReader{
+		Calls:     make(map[string]int),
+		converter: btc.NewPayloadConverter(params),
+		canonical: make(map[int64]int),
+		txs:       make(map[int64][]btc.IndexedTx),
+		inputs:    make(map[int64][]btc.TxInput),
+		outputs:   make(map[int64][]btc.TxOutput),
+	}
+}
+
+// ChildBlock returns a block building on the parent with the transactions, with its merkle root set
+// The nonce tells apart the children of one parent
+func ChildBlock(parent *wire.BlockHeader, nonce uint32, txs ...*wire.MsgTx) *wire.MsgBlock {
+	block := wire.NewMsgBlock(&wire.BlockHeader{
+		Version:   parent.Version,
+		PrevBlock: parent.BlockHash(),
+		Timestamp: parent.Timestamp.Add(600e9),
+		Bits:      parent.Bits,
+		Nonce:     nonce,
+	})
+	for _, tx := range txs {
+		block.AddTransaction(tx)
+	}
+	merkles := blockchain.BuildMerkleTreeStore(btcutil.NewBlock(block).Transactions(), false)
+	block.Header.MerkleRoot = *merkles[len(merkles)-1]
+	return block
+}
+
+// Add indexes the block at the height, as the canonical block there if canonical is set, and returns its header
+func (cr *ChainReader) Add(height int64, block *wire.MsgBlock, canonical bool) *btc.IndexedHeader {
+	cr.Lock()
+	defer cr.Unlock()
+	var buf bytes.Buffer
+	block.Header.Serialize(&buf)
+	header := btc.IndexedHeader{
+		ID:             int64(len(cr.headers) + 1),
+		BlockNumber:    height,
+		BlockHash:      block.Header.BlockHash().String(),
+		ParentHash:     block.Header.PrevBlock.String(),
+		TimesValidated: 1,
+		Data:           buf.Bytes(),
+	}
+	cr.headers = append(cr.headers, header)
+	if _, ok := cr.canonical[height]; canonical || !ok {
+		cr.canonical[height] = len(cr.headers) - 1
+	}
+	payload := btc.BlockPayload{BlockHeight: height, Header: &block.Header, Txs: btcutil.NewBlock(block).Transactions()}
+	converted, err := cr.converter.Convert(payload)
+	if err != nil {
+		panic(err)
+	}
+	for i, meta := range converted.TxMetaData {
+		cr.txCount++
+		var txBuf bytes.Buffer
+		block.Transactions[i].Serialize(&txBuf)
+		tx := btc.IndexedTx{
+			ID:          cr.txCount,
+			HeaderID:    header.ID,
+			BlockNumber: height,
+			Index:       int64(i),
+			TxHash:      meta.TxHash,
+			Data:        txBuf.Bytes(),
+		}
+		cr.txs[header.ID] = append(cr.txs[header.ID], tx)
+		for _, in := range meta.TxInputs {
+			in.TxID, in.BlockNumber = tx.ID, height
+			cr.inputs[tx.ID] = append(cr.inputs[tx.ID], in)
+		}
+		for _, out := range meta.TxOutputs {
+			cr.outCount++
+			out.ID, out.TxID, out.BlockNumber = cr.outCount, tx.ID, height
+			cr.outputs[tx.ID] = append(cr.outputs[tx.ID], out)
+		}
+	}
+	return &header
+}
+
+func (cr *ChainReader) call(method string) error {
+	cr.Calls[method]++
+	return cr.Err
+}
+
+// isCanonical returns whether the header is the canonical one at its height
+func (cr *ChainReader) isCanonical(headerID int64) bool {
+	header := cr.headers[headerID-1]
+	return cr.headers[cr.canonical[header.BlockNumber]].ID == headerID
+}
+
+// heights returns the heights with a canonical header, in order
+func (cr *ChainReader) heights() []int64 {
+	heights := make([]int64, 0, len(cr.canonical))
+	for height := range cr.canonical {
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+	return heights
+}
+
+// Tip mock method
+func (cr *ChainReader) Tip() (*btc.IndexedHeader, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("Tip"); err != nil {
+		return nil, err
+	}
+	heights := cr.heights()
+	if len(heights) == 0 {
+		return nil, fmt.Errorf("no headers are %w", btc.ErrNotIndexed)
+	}
+	header := cr.headers[cr.canonical[heights[len(heights)-1]]]
+	return &header, nil
+}
+
+// HeaderAt mock method
+func (cr *ChainReader) HeaderAt(height int64) (*btc.IndexedHeader, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("HeaderAt"); err != nil {
+		return nil, err
+	}
+	i, ok := cr.canonical[height]
+	if !ok {
+		return nil, fmt.Errorf("height %d is %w", height, btc.ErrNotIndexed)
+	}
+	header := cr.headers[i]
+	return &header, nil
+}
+
+// HeaderByHash mock method
+func (cr *ChainReader) HeaderByHash(hash string) (*btc.IndexedHeader, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("HeaderByHash"); err != nil {
+		return nil, err
+	}
+	for _, header := range cr.headers {
+		if header.BlockHash == hash {
+			return &header, nil
+		}
+	}
+	return nil, fmt.Errorf("block %s is %w", hash, btc.ErrNotIndexed)
+}
+
+// IsCanonical mock method
+func (cr *ChainReader) IsCanonical(header *btc.IndexedHeader) (bool, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("IsCanonical"); err != nil {
+		return false, err
+	}
+	return cr.isCanonical(header.ID), nil
+}
+
+// Txs mock method
+func (cr *ChainReader) Txs(header *btc.IndexedHeader) ([]btc.IndexedTx, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("Txs"); err != nil {
+		return nil, err
+	}
+	return append([]btc.IndexedTx{}, cr.txs[header.ID]...), nil
+}
+
+// TxCount mock method
+func (cr *ChainReader) TxCount(header *btc.IndexedHeader) (int64, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("TxCount"); err != nil {
+		return 0, err
+	}
+	return int64(len(cr.txs[header.ID])), nil
+}
+
+// BlockTxs mock method
+func (cr *ChainReader) BlockTxs(header *btc.IndexedHeader, afterIndex int64, limit int) ([]btc.IndexedTx, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("BlockTxs"); err != nil {
+		return nil, err
+	}
+	var txs []btc.IndexedTx
+	for _, tx := range cr.txs[header.ID] {
+		if tx.Index > afterIndex && len(txs) < limit {
+			txs = append(txs, tx)
+		}
+	}
+	return txs, nil
+}
+
+// Tx mock method
+func (cr *ChainReader) Tx(txHash string) (*btc.IndexedTx, *btc.IndexedHeader, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("Tx"); err != nil {
+		return nil, nil, err
+	}
+	if tx := cr.canonicalTx(func(tx btc.IndexedTx) bool { return tx.TxHash == txHash }); tx != nil {
+		header := cr.headers[tx.HeaderID-1]
+		return tx, &header, nil
+	}
+	return nil, nil, fmt.Errorf("transaction %s is %w", txHash, btc.ErrNotIndexed)
+}
+
+// Spender mock method
+func (cr *ChainReader) Spender(txHash string, index uint32) (*btc.IndexedTx, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("Spender"); err != nil {
+		return nil, err
+	}
+	if tx := cr.canonicalTx(func(tx btc.IndexedTx) bool { return cr.spends(tx, txHash, index) }); tx != nil {
+		return tx, nil
+	}
+	return nil, fmt.Errorf("spender of output %d of %s is %w", index, txHash, btc.ErrNotIndexed)
+}
+
+// canonicalTx returns the first transaction in the canonical chain that matches
+func (cr *ChainReader) canonicalTx(match func(tx btc.IndexedTx) bool) *btc.IndexedTx {
+	for _, height := range cr.heights() {
+		for _, tx := range cr.txs[cr.headers[cr.canonical[height]].ID] {
+			if match(tx) {
+				return &tx
+			}
+		}
+	}
+	return nil
+}
+
+// spends returns whether the transaction spends the output
+func (cr *ChainReader) spends(tx btc.IndexedTx, txHash string, index uint32) bool {
+	for _, in := range cr.inputs[tx.ID] {
+		if in.PreviousOutPointHash == txHash && in.PreviousOutPointIndex == index {
+			return true
+		}
+	}
+	return false
+}
+
+// Headers mock method
+func (cr *ChainReader) Headers(from, to int64, limit int) ([]btc.IndexedHeader, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("Headers"); err != nil {
+		return nil, err
+	}
+	var headers []btc.IndexedHeader
+	for _, height := range cr.heights() {
+		if height >= from && height <= to && len(headers) < limit {
+			headers = append(headers, cr.headers[cr.canonical[height]])
+		}
+	}
+	return headers, nil
+}
+
+// Inputs mock method
+func (cr *ChainReader) Inputs(tx *btc.IndexedTx) ([]btc.TxInput, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("Inputs"); err != nil {
+		return nil, err
+	}
+	return append([]btc.TxInput{}, cr.inputs[tx.ID]...), nil
+}
+
+// Outputs mock method
+func (cr *ChainReader) Outputs(tx *btc.IndexedTx) ([]btc.TxOutput, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("Outputs"); err != nil {
+		return nil, err
+	}
+	return append([]btc.TxOutput{}, cr.outputs[tx.ID]...), nil
+}
+
+// AddressOutputs mock method
+func (cr *ChainReader) AddressOutputs(address string, from, to, afterID int64, limit int) ([]btc.AddressOutput, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("AddressOutputs"); err != nil {
+		return nil, err
+	}
+	return cr.matchingOutputs(func(out btc.TxOutput) bool { return paysTo(out, address) }, from, to, afterID, limit), nil
+}
+
+// AddressUnspentOutputs mock method
+func (cr *ChainReader) AddressUnspentOutputs(address string, from, to, afterID int64, limit int) ([]btc.AddressOutput, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("AddressUnspentOutputs"); err != nil {
+		return nil, err
+	}
+	return cr.matchingOutputs(cr.unspent(func(out btc.TxOutput) bool { return paysTo(out, address) }), from, to, afterID, limit), nil
+}
+
+// unspent matches the outputs matched by match that no canonical transaction spends
+func (cr *ChainReader) unspent(match func(out btc.TxOutput) bool) func(out btc.TxOutput) bool {
+	txHashes := make(map[int64]string)
+	for _, txs := range cr.txs {
+		for _, tx := range txs {
+			txHashes[tx.ID] = tx.TxHash
+		}
+	}
+	return func(out btc.TxOutput) bool {
+		txHash, index := txHashes[out.TxID], uint32(out.Index)
+		return match(out) && cr.canonicalTx(func(tx btc.IndexedTx) bool { return cr.spends(tx, txHash, index) }) == nil
+	}
+}
+
+// ScriptHashOutputs mock method
+func (cr *ChainReader) ScriptHashOutputs(scriptHash []byte, from, to, afterID int64, limit int) ([]btc.AddressOutput, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("ScriptHashOutputs"); err != nil {
+		return nil, err
+	}
+	return cr.matchingOutputs(func(out btc.TxOutput) bool { return bytes.Equal(out.ScriptHash, scriptHash) }, from, to, afterID, limit), nil
+}
+
+// ScriptHashUnspentOutputs mock method
+func (cr *ChainReader) ScriptHashUnspentOutputs(scriptHash []byte, from, to, afterID int64, limit int) ([]btc.AddressOutput, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("ScriptHashUnspentOutputs"); err != nil {
+		return nil, err
+	}
+	match := cr.unspent(func(out btc.TxOutput) bool { return bytes.Equal(out.ScriptHash, scriptHash) })
+	return cr.matchingOutputs(match, from, to, afterID, limit), nil
+}
+
+func (cr *ChainReader) matchingOutputs(match func(out btc.TxOutput) bool, from, to, afterID int64, limit int) []btc.AddressOutput {
+	var outputs []btc.AddressOutput
+	for _, height := range cr.heights() {
+		if height < from || height > to {
+			continue
+		}
+		for _, tx := range cr.txs[cr.headers[cr.canonical[height]].ID] {
+			for _, out := range cr.outputs[tx.ID] {
+				if (height == from && out.ID <= afterID) || !match(out) || len(outputs) >= limit {
+					continue
+				}
+				outputs = append(outputs, btc.AddressOutput{TxOutput: out, TxHash: tx.TxHash, HeaderID: tx.HeaderID})
+			}
+		}
+	}
+	return outputs
+}
+
+// AddressTxs mock method
+func (cr *ChainReader) AddressTxs(address string, from, to, afterIndex int64, limit int) ([]btc.IndexedTx, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("AddressTxs"); err != nil {
+		return nil, err
+	}
+	return cr.matchingTxs(func(out btc.TxOutput) bool { return paysTo(out, address) }, from, to, afterIndex, limit), nil
+}
+
+// ScriptHashTxs mock method
+func (cr *ChainReader) ScriptHashTxs(scriptHash []byte, from, to, afterIndex int64, limit int) ([]btc.IndexedTx, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("ScriptHashTxs"); err != nil {
+		return nil, err
+	}
+	return cr.matchingTxs(func(out btc.TxOutput) bool { return bytes.Equal(out.ScriptHash, scriptHash) }, from, to, afterIndex, limit), nil
+}
+
+// matchingTxs returns the canonical transactions with a matching output or spending one, whether or not the output is canonical
+// ScriptHashesTouched mock method
+func (cr *ChainReader) ScriptHashesTouched(scriptHashes [][]byte, from, to int64) ([][]byte, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("ScriptHashesTouched"); err != nil {
+		return nil, err
+	}
+	var touched [][]byte
+	for _, scriptHash := range scriptHashes {
+		scriptHash := scriptHash
+		if len(cr.matchingTxs(func(out btc.TxOutput) bool { return bytes.Equal(out.ScriptHash, scriptHash) }, from, to, -1, 1)) > 0 {
+			touched = append(touched, scriptHash)
+		}
+	}
+	return touched, nil
+}
+
+func (cr *ChainReader) matchingTxs(match func(out btc.TxOutput) bool, from, to, afterIndex int64, limit int) []btc.IndexedTx {
+	funded := make(map[wire.OutPoint]bool)
+	for _, txs := range cr.txs {
+		for _, tx := range txs {
+			hash, _ := chainhash.NewHashFromStr(tx.TxHash)
+			for _, out := range cr.outputs[tx.ID] {
+				if match(out) {
+					funded[wire.OutPoint{Hash: *hash, Index: uint32(out.Index)}] = true
+				}
+			}
+		}
+	}
+	var matched []btc.IndexedTx
+	for _, height := range cr.heights() {
+		if height < from || height > to {
+			continue
+		}
+		for _, tx := range cr.txs[cr.headers[cr.canonical[height]].ID] {
+			if (height == from && tx.Index <= afterIndex) || len(matched) >= limit {
+				continue
+			}
+			relevant := false
+			for _, out := range cr.outputs[tx.ID] {
+				relevant = relevant || match(out)
+			}
+			for _, in := range cr.inputs[tx.ID] {
+				hash, _ := chainhash.NewHashFromStr(in.PreviousOutPointHash)
+				relevant = relevant || funded[wire.OutPoint{Hash: *hash, Index: in.PreviousOutPointIndex}]
+			}
+			if relevant {
+				matched = append(matched, tx)
+			}
+		}
+	}
+	return matched
+}
+
+func paysTo(out btc.TxOutput, address string) bool {
+	for _, addr := range out.Addresses {
+		if addr == address {
+			return true
+		}
+	}
+	return false
+}
+
+// TxOutProof mock method
+func (cr *ChainReader) TxOutProof(header *btc.IndexedHeader, txHashes []string) (*wire.MsgMerkleBlock, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("TxOutProof"); err != nil {
+		return nil, err
+	}
+	wireHeader, err := header.WireHeader()
+	if err != nil {
+		return nil, err
+	}
+	txs := cr.txs[header.ID]
+	hashes := make([]chainhash.Hash, len(txs))
+	positions := make(map[string]uint32, len(txs))
+	for i, tx := range txs {
+		hash, _ := chainhash.NewHashFromStr(tx.TxHash)
+		hashes[i] = *hash
+		positions[tx.TxHash] = uint32(i)
+	}
+	matches := make([]uint32, len(txHashes))
+	for i, txHash := range txHashes {
+		pos, ok := positions[txHash]
+		if !ok {
+			return nil, fmt.Errorf("transaction %s in block %s is %w", txHash, header.BlockHash, btc.ErrNotIndexed)
+		}
+		matches[i] = pos
+	}
+	return btc.NewTxOutProof(wireHeader, uint32(len(txs)), matches, btc.TxHashChildren(hashes))
+}
+
+// BlockFromIPLDs mock method; the block is assembled from the transactions added with its header, and checked against
+// its merkle root
+func (cr *ChainReader) BlockFromIPLDs(header *btc.IndexedHeader) (*wire.MsgBlock, error) {
+	cr.Lock()
+	defer cr.Unlock()
+	if err := cr.call("BlockFromIPLDs"); err != nil {
+		return nil, err
+	}
+	wireHeader, err := header.WireHeader()
+	if err != nil {
+		return nil, err
+	}
+	txs := cr.txs[header.ID]
+	if len(txs) == 0 {
+		return nil, fmt.Errorf("block %s has no transactions indexed", header.BlockHash)
+	}
+	block := wire.NewMsgBlock(wireHeader)
+	for i := range txs {
+		msgTx, err := txs[i].MsgTx()
+		if err != nil {
+			return nil, err
+		}
+		if err := block.AddTransaction(msgTx); err != nil {
+			return nil, err
+		}
+	}
+	merkles := blockchain.BuildMerkleTreeStore(btcutil.NewBlock(block).Transactions(), false)
+	if root := merkles[len(merkles)-1]; !root.IsEqual(&wireHeader.MerkleRoot) {
+		return nil, fmt.Errorf("transactions of block %s do not produce its merkle root", header.BlockHash)
+	}
+	return block, nil
+}
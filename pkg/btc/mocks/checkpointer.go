@@ -0,0 +1,60 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package mocks
+
+import (
+	"sync"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+)
+
+// Checkpointer is the underlying struct for the Checkpointer interface
+// Like the DBCheckpointer, it ignores saves below the current checkpoint
+type Checkpointer struct {
+	sync.Mutex
+	Checkpoint *btc.Checkpoint
+	Saved      []int64
+	ReturnErr  error
+}
+
+// Load returns the current checkpoint
+func (c *Checkpointer) Load() (*btc.Checkpoint, error) {
+	c.Lock()
+	defer c.Unlock()
+	return c.Checkpoint, c.ReturnErr
+}
+
+// Save records the block as the checkpoint, and its height in Saved
+func (c *Checkpointer) Save(height int64, hash string) error {
+	c.Lock()
+	defer c.Unlock()
+	c.Saved = append(c.Saved, height)
+	if c.Checkpoint == nil || c.Checkpoint.BlockNumber <= height {
+		c.Checkpoint = &btc.Checkpoint{BlockNumber: height, BlockHash: hash}
+	}
+	return c.ReturnErr
+}
+
+// Height returns the height of the current checkpoint, or -1 if there is none
+func (c *Checkpointer) Height() int64 {
+	c.Lock()
+	defer c.Unlock()
+	if c.Checkpoint == nil {
+		return -1
+	}
+	return c.Checkpoint.BlockNumber
+}
@@ -53,3 +53,17 @@ func (pc *IterativePayloadConverter) Convert(payload btc.BlockPayload) (*btc.Con
 	pc.iteration++
 	return returnPayload, pc.ReturnErr
 }
+
+// PassThroughConverter is the underlying struct for the Converter interface
+// It converts every payload to a ConvertedPayload without transaction metadata, failing those at the heights in Errs
+type PassThroughConverter struct {
+	Errs map[int64]error
+}
+
+// Convert wraps the payload in a ConvertedPayload
+func (pc *PassThroughConverter) Convert(payload btc.BlockPayload) (*btc.ConvertedPayload, error) {
+	if err := pc.Errs[payload.BlockHeight]; err != nil {
+		return nil, err
+	}
+	return &btc.ConvertedPayload{BlockPayload: payload}, nil
+}
@@ -0,0 +1,55 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package mocks
+
+import (
+	"sync"
+)
+
+// FailedBlock is a block recorded by the FailedBlockRecorder mock
+type FailedBlock struct {
+	Height   int64
+	Hash     string
+	Attempts int
+	Cause    error
+}
+
+// FailedBlockRecorder is the underlying struct for the FailedBlockRecorder interface
+type FailedBlockRecorder struct {
+	sync.Mutex
+	Failed    []FailedBlock
+	ReturnErr error
+}
+
+// RecordFailure records the failed block
+func (r *FailedBlockRecorder) RecordFailure(height int64, hash string, attempts int, cause error) error {
+	r.Lock()
+	defer r.Unlock()
+	r.Failed = append(r.Failed, FailedBlock{Height: height, Hash: hash, Attempts: attempts, Cause: cause})
+	return r.ReturnErr
+}
+
+// Heights returns the heights of the failed blocks, in the order they were recorded
+func (r *FailedBlockRecorder) Heights() []int64 {
+	r.Lock()
+	defer r.Unlock()
+	heights := make([]int64, len(r.Failed))
+	for i, failed := range r.Failed {
+		heights[i] = failed.Height
+	}
+	return heights
+}
@@ -0,0 +1,123 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package mocks
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+)
+
+// JobTracker is the underlying struct for the JobTracker interface
+// It hands out the Batches in turn and records how each claimed batch was released
+type JobTracker struct {
+	sync.Mutex
+	Batches      []btc.ResyncBatch
+	HeartbeatErr error
+	Heartbeats   int
+	DoneBatches  []uint64
+	FailedErrs   map[uint64]error
+}
+
+// Job returns job 1
+func (jt *JobTracker) Job(kind string, t shared.DataType, rng [2]uint64, batches [][2]uint64, retry bool) (int64, error) {
+	return 1, nil
+}
+
+// Unfinished returns job 1 while it has batches left to claim
+func (jt *JobTracker) Unfinished(kind string, maxAttempts int) ([]int64, error) {
+	jt.Lock()
+	defer jt.Unlock()
+	if len(jt.Batches) == 0 {
+		return nil, nil
+	}
+	return []int64{1}, nil
+}
+
+// Filling returns the ranges of the batches left to claim
+func (jt *JobTracker) Filling(maxAttempts int) ([][2]uint64, error) {
+	jt.Lock()
+	defer jt.Unlock()
+	rngs := make([][2]uint64, len(jt.Batches))
+	for i, batch := range jt.Batches {
+		rngs[i] = [2]uint64{batch.Start, batch.Stop}
+	}
+	return rngs, nil
+}
+
+// Pending returns the batches left to claim
+func (jt *JobTracker) Pending(jobID int64, maxAttempts int) ([]btc.ResyncBatch, error) {
+	jt.Lock()
+	defer jt.Unlock()
+	return append([]btc.ResyncBatch{}, jt.Batches...), nil
+}
+
+// Claim hands out the next batch, or nil if there are none left
+func (jt *JobTracker) Claim(jobIDs []int64, owner string, lease time.Duration, maxAttempts int) (*btc.ResyncBatch, error) {
+	jt.Lock()
+	defer jt.Unlock()
+	if len(jt.Batches) == 0 {
+		return nil, nil
+	}
+	batch := jt.Batches[0]
+	jt.Batches = jt.Batches[1:]
+	batch.Status = btc.BatchRunning
+	batch.Attempts++
+	batch.LeasedBy = &owner
+	return &batch, nil
+}
+
+// Heartbeat counts the heartbeat and returns the HeartbeatErr
+func (jt *JobTracker) Heartbeat(jobID int64, start uint64, owner string, lease time.Duration) error {
+	jt.Lock()
+	defer jt.Unlock()
+	jt.Heartbeats++
+	return jt.HeartbeatErr
+}
+
+// Done records the batch as done
+func (jt *JobTracker) Done(jobID int64, start uint64, owner string) error {
+	jt.Lock()
+	defer jt.Unlock()
+	jt.DoneBatches = append(jt.DoneBatches, start)
+	return nil
+}
+
+// Failed records the batch as failed with the error
+func (jt *JobTracker) Failed(jobID int64, start uint64, owner string, err error) error {
+	jt.Lock()
+	defer jt.Unlock()
+	if jt.FailedErrs == nil {
+		jt.FailedErrs = make(map[uint64]error)
+	}
+	jt.FailedErrs[start] = err
+	return nil
+}
+
+// Finish returns the batches left to claim
+func (jt *JobTracker) Finish(jobID int64) ([]btc.ResyncBatch, error) {
+	return jt.Pending(jobID, 0)
+}
+
+// Released returns the number of batches recorded as done or failed
+func (jt *JobTracker) Released() int {
+	jt.Lock()
+	defer jt.Unlock()
+	return len(jt.DoneBatches) + len(jt.FailedErrs)
+}
@@ -17,6 +17,7 @@
 package mocks
 
 import (
+	"context"
 	"errors"
 	"sync/atomic"
 
@@ -32,7 +33,7 @@ type PayloadFetcher struct {
 }
 
 // FetchAt mock method
-func (fetcher *PayloadFetcher) FetchAt(blockHeights []uint64) ([]btc.BlockPayload, error) {
+func (fetcher *PayloadFetcher) FetchAt(ctx context.Context, blockHeights []uint64) ([]btc.BlockPayload, error) {
 	if fetcher.PayloadsToReturn == nil {
 		return nil, errors.New("mock StateDiffFetcher needs to be initialized with payloads to return")
 	}
@@ -17,6 +17,10 @@
 package mocks
 
 import (
+	"context"
+	"sync"
+	"time"
+
 	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
 )
 
@@ -27,11 +31,19 @@ type IPLDPublisher struct {
 }
 
 // Publish publishes an IPLDPayload to IPFS and returns the corresponding CIDPayload
-func (pub *IPLDPublisher) Publish(payload btc.ConvertedPayload) error {
+func (pub *IPLDPublisher) Publish(ctx context.Context, payload btc.ConvertedPayload) error {
 	pub.PassedIPLDPayload = payload
 	return pub.ReturnErr
 }
 
+// PublishBatch publishes a batch of IPLDPayloads; the last one is recorded as the PassedIPLDPayload
+func (pub *IPLDPublisher) PublishBatch(ctx context.Context, payloads []btc.ConvertedPayload) error {
+	for _, payload := range payloads {
+		pub.PassedIPLDPayload = payload
+	}
+	return pub.ReturnErr
+}
+
 // IterativeIPLDPublisher is the underlying struct for the Publisher interface; used in testing
 type IterativeIPLDPublisher struct {
 	PassedIPLDPayload []btc.ConvertedPayload
@@ -40,7 +52,106 @@ type IterativeIPLDPublisher struct {
 }
 
 // Publish publishes an IPLDPayload to IPFS and returns the corresponding CIDPayload
-func (pub *IterativeIPLDPublisher) Publish(payload btc.ConvertedPayload) error {
+func (pub *IterativeIPLDPublisher) Publish(ctx context.Context, payload btc.ConvertedPayload) error {
 	pub.PassedIPLDPayload = append(pub.PassedIPLDPayload, payload)
 	return pub.ReturnErr
 }
+
+// PublishBatch publishes a batch of IPLDPayloads
+func (pub *IterativeIPLDPublisher) PublishBatch(ctx context.Context, payloads []btc.ConvertedPayload) error {
+	pub.PassedIPLDPayload = append(pub.PassedIPLDPayload, payloads...)
+	return pub.ReturnErr
+}
+
+// HeightPublisher is the underlying struct for the Publisher and OrderedPublisher interfaces; its behaviour is set per
+// block height. Publishes at a height return the errors in Errs[height] in turn and then succeed; those at a height with
+// a chan in Hold first wait for it to be closed, or for their context to be done
+type HeightPublisher struct {
+	sync.Mutex
+	Errs         map[int64][]error
+	Hold         map[int64]chan struct{}
+	PrepareDelay map[int64]time.Duration
+	attempts     map[int64]int
+	published    []int64
+	optional     []int64
+}
+
+// Publish publishes the payload at its height
+func (pub *HeightPublisher) Publish(ctx context.Context, payload btc.ConvertedPayload) error {
+	return pub.publish(ctx, payload.BlockHeight)
+}
+
+// PublishBatch publishes the payloads in order, stopping at the first error
+func (pub *HeightPublisher) PublishBatch(ctx context.Context, payloads []btc.ConvertedPayload) error {
+	for _, payload := range payloads {
+		if err := pub.publish(ctx, payload.BlockHeight); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prepare waits out the PrepareDelay at the payload's height
+func (pub *HeightPublisher) Prepare(payload btc.ConvertedPayload) (*btc.PreparedPayload, error) {
+	pub.Lock()
+	delay := pub.PrepareDelay[payload.BlockHeight]
+	pub.Unlock()
+	time.Sleep(delay)
+	return &btc.PreparedPayload{ConvertedPayload: payload}, nil
+}
+
+// Commit publishes the prepared payload at its height, recording it if its parent is optional
+func (pub *HeightPublisher) Commit(ctx context.Context, prepared *btc.PreparedPayload) error {
+	if prepared.ParentOptional {
+		pub.Lock()
+		pub.optional = append(pub.optional, prepared.BlockHeight)
+		pub.Unlock()
+	}
+	return pub.publish(ctx, prepared.BlockHeight)
+}
+
+func (pub *HeightPublisher) publish(ctx context.Context, height int64) error {
+	pub.Lock()
+	hold := pub.Hold[height]
+	pub.Unlock()
+	if hold != nil {
+		select {
+		case <-hold:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	pub.Lock()
+	defer pub.Unlock()
+	if pub.attempts == nil {
+		pub.attempts = make(map[int64]int)
+	}
+	pub.attempts[height]++
+	if errs := pub.Errs[height]; len(errs) > 0 {
+		pub.Errs[height] = errs[1:]
+		return errs[0]
+	}
+	pub.published = append(pub.published, height)
+	return nil
+}
+
+// Attempts returns the number of times a publish was attempted at the height
+func (pub *HeightPublisher) Attempts(height int64) int {
+	pub.Lock()
+	defer pub.Unlock()
+	return pub.attempts[height]
+}
+
+// Published returns the heights published, in the order they were published
+func (pub *HeightPublisher) Published() []int64 {
+	pub.Lock()
+	defer pub.Unlock()
+	return append([]int64{}, pub.published...)
+}
+
+// ParentOptional returns the heights committed with an optional parent
+func (pub *HeightPublisher) ParentOptional() []int64 {
+	pub.Lock()
+	defer pub.Unlock()
+	return append([]int64{}, pub.optional...)
+}
@@ -24,14 +24,19 @@ import (
 type CIDRetriever struct {
 	GapsToRetrieve              []btc.DBGap
 	GapsToRetrieveErr           error
+	HeightsToValidate           []uint64
+	HeightsToValidateErr        error
 	CalledTimes                 int
 	FirstBlockNumberToReturn    int64
 	RetrieveFirstBlockNumberErr error
+	LastBlockNumberToReturn     int64
+	RetrieveLastBlockNumberErr  error
+	ValidationLevels            []int
 }
 
 // RetrieveLastBlockNumber mock method
-func (*CIDRetriever) RetrieveLastBlockNumber() (int64, error) {
-	panic("implement me")
+func (mcr *CIDRetriever) RetrieveLastBlockNumber() (int64, error) {
+	return mcr.LastBlockNumberToReturn, mcr.RetrieveLastBlockNumberErr
 }
 
 // RetrieveFirstBlockNumber mock method
@@ -40,11 +45,17 @@ func (mcr *CIDRetriever) RetrieveFirstBlockNumber() (int64, error) {
 }
 
 // RetrieveGapsInData mock method
-func (mcr *CIDRetriever) RetrieveGapsInData(int) ([]btc.DBGap, error) {
+func (mcr *CIDRetriever) RetrieveGapsInData() ([]btc.DBGap, error) {
 	mcr.CalledTimes++
 	return mcr.GapsToRetrieve, mcr.GapsToRetrieveErr
 }
 
+// RetrieveUnvalidatedHeights mock method
+func (mcr *CIDRetriever) RetrieveUnvalidatedHeights(validationLevel int) ([]uint64, error) {
+	mcr.ValidationLevels = append(mcr.ValidationLevels, validationLevel)
+	return mcr.HeightsToValidate, mcr.HeightsToValidateErr
+}
+
 // SetGapsToRetrieve mock method
 func (mcr *CIDRetriever) SetGapsToRetrieve(gaps []btc.DBGap) {
 	if mcr.GapsToRetrieve == nil {
@@ -17,26 +17,53 @@
 package mocks
 
 import (
+	"context"
+	"sync"
+
 	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
 )
 
 // PayloadStreamer mock struct
+// The first Stream call sends StreamPayloads; later ones, made when the stream is restarted, send ResumePayloads and
+// record the checkpoint they were made from
 type PayloadStreamer struct {
 	PassedPayloadChan chan btc.BlockPayload
-	ReturnSub         *btc.ClientSubscription
+	PassedCheckpoint  *btc.Checkpoint
+	ReturnSub         btc.Subscription
 	ReturnErr         error
 	StreamPayloads    []btc.BlockPayload
+	ResumePayloads    []btc.BlockPayload
+
+	mu       sync.Mutex
+	streamed bool
+	restarts []*btc.Checkpoint
 }
 
 // Stream mock method
-func (sds *PayloadStreamer) Stream(payloadChan chan btc.BlockPayload) (*btc.ClientSubscription, error) {
+func (sds *PayloadStreamer) Stream(ctx context.Context, payloadChan chan btc.BlockPayload, checkpoint *btc.Checkpoint) (btc.Subscription, error) {
+	sds.mu.Lock()
+	defer sds.mu.Unlock()
+	if sds.streamed {
+		sds.restarts = append(sds.restarts, checkpoint)
+		go send(payloadChan, sds.ResumePayloads)
+		return NewSubscription(), sds.ReturnErr
+	}
+	sds.streamed = true
 	sds.PassedPayloadChan = payloadChan
+	sds.PassedCheckpoint = checkpoint
+	go send(payloadChan, sds.StreamPayloads)
+	return sds.ReturnSub, sds.ReturnErr
+}
 
-	go func() {
-		for _, payload := range sds.StreamPayloads {
-			sds.PassedPayloadChan <- payload
-		}
-	}()
+// Restarts returns the checkpoints the stream was restarted from
+func (sds *PayloadStreamer) Restarts() []*btc.Checkpoint {
+	sds.mu.Lock()
+	defer sds.mu.Unlock()
+	return append([]*btc.Checkpoint(nil), sds.restarts...)
+}
 
-	return sds.ReturnSub, sds.ReturnErr
+func send(payloadChan chan btc.BlockPayload, payloads []btc.BlockPayload) {
+	for _, payload := range payloads {
+		payloadChan <- payload
+	}
 }
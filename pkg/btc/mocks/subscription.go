@@ -0,0 +1,41 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package mocks
+
+// Subscription is the underlying struct for the Subscription interface
+type Subscription struct {
+	ErrChan      chan error
+	Unsubscribed chan struct{}
+}
+
+// NewSubscription returns a new Subscription mock
+func NewSubscription() *Subscription {
+	return &Subscription{
+		ErrChan:      make(chan error),
+		Unsubscribed: make(chan struct{}),
+	}
+}
+
+// Unsubscribe closes the Unsubscribed chan
+func (s *Subscription) Unsubscribe() {
+	close(s.Unsubscribed)
+}
+
+// Err returns the ErrChan
+func (s *Subscription) Err() <-chan error {
+	return s.ErrChan
+}
@@ -330,6 +330,7 @@ var (
 					ScriptClass:  uint8(sClass1),
 					RequiredSigs: int64(numOfSigs1),
 					Addresses:    stringSliceFromAddresses(addresses1),
+					ScriptHash:   btc.ScriptHash(MockBlock.Transactions[0].TxOut[0].PkScript),
 				},
 			},
 		},
@@ -389,6 +390,7 @@ var (
 					ScriptClass:  uint8(sClass2a),
 					RequiredSigs: int64(numOfSigs2a),
 					Addresses:    stringSliceFromAddresses(addresses2a),
+					ScriptHash:   btc.ScriptHash(MockBlock.Transactions[1].TxOut[0].PkScript),
 				},
 				{
 					Index: 1,
@@ -406,6 +408,7 @@ var (
 					ScriptClass:  uint8(sClass2b),
 					RequiredSigs: int64(numOfSigs2b),
 					Addresses:    stringSliceFromAddresses(addresses2b),
+					ScriptHash:   btc.ScriptHash(MockBlock.Transactions[1].TxOut[1].PkScript),
 				},
 			},
 		},
@@ -464,6 +467,7 @@ var (
 					ScriptClass:  uint8(sClass3a),
 					RequiredSigs: int64(numOfSigs3a),
 					Addresses:    stringSliceFromAddresses(addresses3a),
+					ScriptHash:   btc.ScriptHash(MockBlock.Transactions[2].TxOut[0].PkScript),
 				},
 				{
 					Index: 1,
@@ -481,6 +485,7 @@ var (
 					ScriptClass:  uint8(sClass3b),
 					RequiredSigs: int64(numOfSigs3b),
 					Addresses:    stringSliceFromAddresses(addresses3b),
+					ScriptHash:   btc.ScriptHash(MockBlock.Transactions[2].TxOut[1].PkScript),
 				},
 			},
 		},
@@ -522,6 +527,7 @@ var (
 					ScriptClass:  uint8(sClass1),
 					RequiredSigs: int64(numOfSigs1),
 					Addresses:    stringSliceFromAddresses(addresses1),
+					ScriptHash:   btc.ScriptHash(MockBlock.Transactions[0].TxOut[0].PkScript),
 				},
 			},
 		},
@@ -583,6 +589,7 @@ var (
 					ScriptClass:  uint8(sClass2a),
 					RequiredSigs: int64(numOfSigs2a),
 					Addresses:    stringSliceFromAddresses(addresses2a),
+					ScriptHash:   btc.ScriptHash(MockBlock.Transactions[1].TxOut[0].PkScript),
 				},
 				{
 					Index: 1,
@@ -600,6 +607,7 @@ var (
 					ScriptClass:  uint8(sClass2b),
 					RequiredSigs: int64(numOfSigs2b),
 					Addresses:    stringSliceFromAddresses(addresses2b),
+					ScriptHash:   btc.ScriptHash(MockBlock.Transactions[1].TxOut[1].PkScript),
 				},
 			},
 		},
@@ -660,6 +668,7 @@ var (
 					ScriptClass:  uint8(sClass3a),
 					RequiredSigs: int64(numOfSigs3a),
 					Addresses:    stringSliceFromAddresses(addresses3a),
+					ScriptHash:   btc.ScriptHash(MockBlock.Transactions[2].TxOut[0].PkScript),
 				},
 				{
 					Index: 1,
@@ -677,6 +686,7 @@ var (
 					ScriptClass:  uint8(sClass3b),
 					RequiredSigs: int64(numOfSigs3b),
 					Addresses:    stringSliceFromAddresses(addresses3b),
+					ScriptHash:   btc.ScriptHash(MockBlock.Transactions[2].TxOut[1].PkScript),
 				},
 			},
 		},
@@ -60,13 +60,14 @@ type TxModelWithInsAndOuts struct {
 
 // TxInput is the db model for btc.tx_inputs table
 type TxInput struct {
-	ID                    int64    `db:"id"`
-	TxID                  int64    `db:"tx_id"`
-	Index                 int64    `db:"index"`
-	TxWitness             []string `db:"witness"`
-	SignatureScript       []byte   `db:"sig_script"`
-	PreviousOutPointIndex uint32   `db:"outpoint_tx_hash"`
-	PreviousOutPointHash  string   `db:"outpoint_index"`
+	ID                    int64          `db:"id"`
+	TxID                  int64          `db:"tx_id"`
+	Index                 int64          `db:"index"`
+	TxWitness             pq.StringArray `db:"witness"`
+	SignatureScript       []byte         `db:"sig_script"`
+	PreviousOutPointIndex uint32         `db:"outpoint_index"`
+	PreviousOutPointHash  string         `db:"outpoint_tx_hash"`
+	BlockNumber           int64          `db:"block_number"`
 }
 
 // TxOutput is the db model for btc.tx_outputs table
@@ -79,4 +80,6 @@ type TxOutput struct {
 	ScriptClass  uint8          `db:"script_class"`
 	RequiredSigs int64          `db:"required_sigs"`
 	Addresses    pq.StringArray `db:"addresses"`
+	ScriptHash   []byte         `db:"script_hash"`
+	BlockNumber  int64          `db:"block_number"`
 }
@@ -0,0 +1,197 @@
+// VulcanizeDB
+// Copyright © 2020 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrSourcesDisagree is returned by the MultiFetcher for a batch in which no two sources agree on a block
+var ErrSourcesDisagree = errors.New("bitcoin sources disagree")
+
+// FetcherSource pairs a Fetcher with a name used to identify it in logs and errors
+type FetcherSource struct {
+	Name    string
+	Fetcher Fetcher
+}
+
+// MultiFetcher satisfies the Fetcher interface using a set of underlying sources
+// Batches are load-balanced across the sources in round-robin order, and a batch that errors or times out
+// on one source is retried on the next one
+// If cross-validation is on, every batch is also fetched from a second source and the block hashes are compared; the
+// blocks they disagree on are fetched from the remaining sources until two of them agree, and the batch fails otherwise
+type MultiFetcher struct {
+	sources       []FetcherSource
+	timeout       time.Duration
+	crossValidate bool
+	next          uint64
+}
+
+// NewMultiFetcher returns a pointer to a new MultiFetcher
+func NewMultiFetcher(sources []FetcherSource, timeout time.Duration, crossValidate bool) (*MultiFetcher, error) {
+	if len(sources) == 0 {
+		return nil, errors.New("bitcoin MultiFetcher requires at least one source")
+	}
+	if crossValidate && len(sources) < 2 {
+		return nil, errors.New("bitcoin MultiFetcher requires at least two sources to cross-validate")
+	}
+	return &MultiFetcher{
+		sources:       sources,
+		timeout:       timeout,
+		crossValidate: crossValidate,
+	}, nil
+}
+
+// FetchAt fetches the block payloads at the given block heights
+func (mf *MultiFetcher) FetchAt(ctx context.Context, blockHeights []uint64) ([]BlockPayload, error) {
+	start := int(atomic.AddUint64(&mf.next, 1)-1) % len(mf.sources)
+	payloads, used, err := mf.fetchWithFailover(ctx, blockHeights, start, -1)
+	if err != nil {
+		return nil, err
+	}
+	if !mf.crossValidate {
+		return payloads, nil
+	}
+	comparisons, other, err := mf.fetchWithFailover(ctx, blockHeights, (used+1)%len(mf.sources), used)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		logrus.Warnf("bitcoin MultiFetcher unable to cross-validate heights %d to %d: %v", blockHeights[0], blockHeights[len(blockHeights)-1], err)
+		for i := range payloads {
+			payloads[i].Unvalidated = true
+		}
+		return payloads, nil
+	}
+	var disputed []int
+	for i := range payloads {
+		if payloads[i].Header.BlockHash() != comparisons[i].Header.BlockHash() {
+			logrus.Warnf("bitcoin sources %s and %s disagree on the block at height %d", mf.sources[used].Name, mf.sources[other].Name, payloads[i].BlockHeight)
+			disputed = append(disputed, i)
+		}
+	}
+	if len(disputed) == 0 {
+		return payloads, nil
+	}
+	if err := mf.settle(ctx, payloads, comparisons, disputed, used, other); err != nil {
+		return nil, err
+	}
+	return payloads, nil
+}
+
+// settle fetches the disputed blocks from the sources other than the two that disagree on them, until for each one a
+// third source agrees with either of them; payloads is updated with the blocks agreed on
+// It returns ErrSourcesDisagree if any disputed block is left unsettled once the sources are exhausted
+func (mf *MultiFetcher) settle(ctx context.Context, payloads, comparisons []BlockPayload, disputed []int, used, other int) error {
+	for index := range mf.sources {
+		if len(disputed) == 0 {
+			return nil
+		}
+		if index == used || index == other {
+			continue
+		}
+		heights := make([]uint64, len(disputed))
+		for j, i := range disputed {
+			heights[j] = uint64(payloads[i].BlockHeight)
+		}
+		tiebreaks, err := mf.fetchFrom(ctx, mf.sources[index], heights)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			logrus.Warnf("bitcoin MultiFetcher source %s failed to settle a disagreement: %v", mf.sources[index].Name, err)
+			continue
+		}
+		unsettled := disputed[:0]
+		for j, i := range disputed {
+			switch tiebreaks[j].Header.BlockHash() {
+			case payloads[i].Header.BlockHash():
+			case comparisons[i].Header.BlockHash():
+				payloads[i] = comparisons[i]
+			default:
+				unsettled = append(unsettled, i)
+			}
+		}
+		disputed = unsettled
+	}
+	if len(disputed) == 0 {
+		return nil
+	}
+	heights := make([]string, len(disputed))
+	for j, i := range disputed {
+		heights[j] = fmt.Sprint(payloads[i].BlockHeight)
+	}
+	return fmt.Errorf("%w on the blocks at heights %s", ErrSourcesDisagree, strings.Join(heights, ", "))
+}
+
+// fetchWithFailover tries each source, beginning at start and skipping the excluded index, until one succeeds
+// it returns the payloads and the index of the source that produced them, or the context's error once it is done
+func (mf *MultiFetcher) fetchWithFailover(ctx context.Context, blockHeights []uint64, start, exclude int) ([]BlockPayload, int, error) {
+	errs := make([]string, 0, len(mf.sources))
+	for i := 0; i < len(mf.sources); i++ {
+		index := (start + i) % len(mf.sources)
+		if index == exclude {
+			continue
+		}
+		payloads, err := mf.fetchFrom(ctx, mf.sources[index], blockHeights)
+		if err == nil {
+			return payloads, index, nil
+		}
+		if ctx.Err() != nil {
+			return nil, -1, ctx.Err()
+		}
+		logrus.Warnf("bitcoin MultiFetcher source %s failed, failing over: %v", mf.sources[index].Name, err)
+		errs = append(errs, fmt.Sprintf("%s: %v", mf.sources[index].Name, err))
+	}
+	return nil, -1, fmt.Errorf("bitcoin MultiFetcher all sources failed: %s", strings.Join(errs, "; "))
+}
+
+// fetchFrom fetches from a single source, giving up after the timeout or once the context is done
+// the request is cancelled through its context where the source supports it (Esplora does, a btcd rpcclient does
+// not); otherwise it runs to completion in the background and its result is discarded
+func (mf *MultiFetcher) fetchFrom(ctx context.Context, source FetcherSource, blockHeights []uint64) ([]BlockPayload, error) {
+	type result struct {
+		payloads []BlockPayload
+		err      error
+	}
+	ctx, cancel := context.WithTimeout(ctx, mf.timeout)
+	defer cancel()
+	resChan := make(chan result, 1)
+	go func() {
+		payloads, err := source.Fetcher.FetchAt(ctx, blockHeights)
+		resChan <- result{payloads: payloads, err: err}
+	}()
+	select {
+	case res := <-resChan:
+		if res.err == nil && len(res.payloads) != len(blockHeights) {
+			return nil, fmt.Errorf("expected %d payloads, got %d", len(blockHeights), len(res.payloads))
+		}
+		return res.payloads, res.err
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("timed out after %s", mf.timeout.String())
+		}
+		return nil, ctx.Err()
+	}
+}
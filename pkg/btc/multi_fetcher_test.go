@@ -0,0 +1,153 @@
+// VulcanizeDB
+// Copyright © 2020 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+)
+
+var _ = Describe("MultiFetcher", func() {
+	var (
+		height      = uint64(mocks.MockBlockHeight)
+		forkHeader  = mocks.MockBlock.Header
+		good, other *mocks.PayloadFetcher
+		failing     *mocks.PayloadFetcher
+	)
+	forkHeader.Nonce++
+	BeforeEach(func() {
+		good = &mocks.PayloadFetcher{
+			PayloadsToReturn: map[uint64]btc.BlockPayload{height: mocks.MockBlockPayload},
+		}
+		other = &mocks.PayloadFetcher{
+			PayloadsToReturn: map[uint64]btc.BlockPayload{height: mocks.MockBlockPayload},
+		}
+		failing = &mocks.PayloadFetcher{
+			PayloadsToReturn: map[uint64]btc.BlockPayload{},
+			FetchErrs:        map[uint64]error{height: errors.New("node unavailable")},
+		}
+	})
+
+	It("Requires two sources to cross-validate", func() {
+		_, err := btc.NewMultiFetcher([]btc.FetcherSource{{Name: "good", Fetcher: good}}, time.Second, true)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Load-balances batches across sources", func() {
+		fetcher, err := btc.NewMultiFetcher([]btc.FetcherSource{{Name: "good", Fetcher: good}, {Name: "other", Fetcher: other}}, time.Second, false)
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 4; i++ {
+			_, err := fetcher.FetchAt(context.Background(), []uint64{height})
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(good.CalledTimes).To(Equal(int64(2)))
+		Expect(other.CalledTimes).To(Equal(int64(2)))
+	})
+
+	It("Fails over to the next source on error", func() {
+		fetcher, err := btc.NewMultiFetcher([]btc.FetcherSource{{Name: "failing", Fetcher: failing}, {Name: "good", Fetcher: good}}, time.Second, false)
+		Expect(err).ToNot(HaveOccurred())
+		payloads, err := fetcher.FetchAt(context.Background(), []uint64{height})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(payloads).To(Equal([]btc.BlockPayload{mocks.MockBlockPayload}))
+		Expect(failing.CalledTimes).To(Equal(int64(1)))
+		Expect(good.CalledTimes).To(Equal(int64(1)))
+	})
+
+	It("Returns an error when every source fails", func() {
+		fetcher, err := btc.NewMultiFetcher([]btc.FetcherSource{{Name: "failing", Fetcher: failing}}, time.Second, false)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = fetcher.FetchAt(context.Background(), []uint64{height})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("node unavailable"))
+	})
+
+	It("Leaves payloads validated when independent sources agree", func() {
+		fetcher, err := btc.NewMultiFetcher([]btc.FetcherSource{{Name: "good", Fetcher: good}, {Name: "other", Fetcher: other}}, time.Second, true)
+		Expect(err).ToNot(HaveOccurred())
+		payloads, err := fetcher.FetchAt(context.Background(), []uint64{height})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(payloads[0].Unvalidated).To(BeFalse())
+		Expect(good.CalledTimes).To(Equal(int64(1)))
+		Expect(other.CalledTimes).To(Equal(int64(1)))
+	})
+
+	It("Fails the batch when two sources disagree and no other source settles it", func() {
+		other.PayloadsToReturn[height] = btc.BlockPayload{
+			BlockHeight: mocks.MockBlockHeight,
+			Header:      &forkHeader,
+			Txs:         mocks.MockTransactions,
+		}
+		fetcher, err := btc.NewMultiFetcher([]btc.FetcherSource{{Name: "good", Fetcher: good}, {Name: "other", Fetcher: other}, {Name: "failing", Fetcher: failing}}, time.Second, true)
+		Expect(err).ToNot(HaveOccurred())
+		payloads, err := fetcher.FetchAt(context.Background(), []uint64{height})
+		Expect(errors.Is(err, btc.ErrSourcesDisagree)).To(BeTrue())
+		Expect(payloads).To(BeNil())
+		Expect(failing.CalledTimes).To(Equal(int64(1)))
+	})
+
+	It("Settles a disagreement with the block a third source agrees on", func() {
+		fork := btc.BlockPayload{
+			BlockHeight: mocks.MockBlockHeight,
+			Header:      &forkHeader,
+			Txs:         mocks.MockTransactions,
+		}
+		other.PayloadsToReturn[height] = fork
+		third := &mocks.PayloadFetcher{PayloadsToReturn: map[uint64]btc.BlockPayload{height: fork}}
+		fetcher, err := btc.NewMultiFetcher([]btc.FetcherSource{{Name: "good", Fetcher: good}, {Name: "other", Fetcher: other}, {Name: "third", Fetcher: third}}, time.Second, true)
+		Expect(err).ToNot(HaveOccurred())
+		payloads, err := fetcher.FetchAt(context.Background(), []uint64{height})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(payloads[0].Header).To(Equal(&forkHeader))
+		Expect(payloads[0].Unvalidated).To(BeFalse())
+		Expect(third.CalledAtBlockHeights).To(Equal([][]uint64{{height}}))
+	})
+
+	It("Marks payloads unvalidated when no second source is available", func() {
+		fetcher, err := btc.NewMultiFetcher([]btc.FetcherSource{{Name: "good", Fetcher: good}, {Name: "failing", Fetcher: failing}}, time.Second, true)
+		Expect(err).ToNot(HaveOccurred())
+		payloads, err := fetcher.FetchAt(context.Background(), []uint64{height})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(payloads[0].Unvalidated).To(BeTrue())
+	})
+
+	It("Fails over when a source times out", func() {
+		slow := &slowFetcher{delay: time.Second}
+		fetcher, err := btc.NewMultiFetcher([]btc.FetcherSource{{Name: "slow", Fetcher: slow}, {Name: "good", Fetcher: good}}, 50*time.Millisecond, false)
+		Expect(err).ToNot(HaveOccurred())
+		payloads, err := fetcher.FetchAt(context.Background(), []uint64{height})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(payloads).To(Equal([]btc.BlockPayload{mocks.MockBlockPayload}))
+	})
+})
+
+type slowFetcher struct {
+	delay time.Duration
+}
+
+func (sf *slowFetcher) FetchAt(ctx context.Context, blockHeights []uint64) ([]btc.BlockPayload, error) {
+	time.Sleep(sf.delay)
+	return []btc.BlockPayload{{Header: &wire.BlockHeader{}}}, nil
+}
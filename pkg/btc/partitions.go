@@ -0,0 +1,85 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+)
+
+// partitionSize returns the number of block heights covered by each partition of the btc tables,
+// or 0 if the tables have not been partitioned with db/partitioning/partition_btc_tables_by_block_number.sql
+func partitionSize(db *postgres.DB) (int64, error) {
+	var exists sql.NullString
+	if err := db.Get(&exists, `SELECT to_regclass('btc.partitioning')::TEXT`); err != nil {
+		return 0, err
+	}
+	if !exists.Valid {
+		return 0, nil
+	}
+	var size int64
+	err := db.Get(&size, `SELECT partition_size FROM btc.partitioning`)
+	return size, err
+}
+
+// partitioner makes sure the partitions covering a block height exist before rows at that height are published
+// partitions can't be created inside the publishing tx without holding a lock on the parent tables until it commits,
+// so they are created in their own statement beforehand; ranges that have been created are remembered
+type partitioner struct {
+	db      *postgres.DB
+	mux     sync.Mutex
+	loaded  bool
+	size    int64
+	created map[int64]bool
+}
+
+func newPartitioner(db *postgres.DB) *partitioner {
+	return &partitioner{
+		db:      db,
+		created: make(map[int64]bool),
+	}
+}
+
+// ensure creates any missing partitions for the given block heights
+// it does nothing if the btc tables are not partitioned
+func (p *partitioner) ensure(heights ...int64) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if !p.loaded {
+		size, err := partitionSize(p.db)
+		if err != nil {
+			return err
+		}
+		p.size, p.loaded = size, true
+	}
+	if p.size == 0 {
+		return nil
+	}
+	for _, height := range heights {
+		from := height - height%p.size
+		if p.created[from] {
+			continue
+		}
+		if _, err := p.db.Exec(`SELECT btc.create_block_partitions($1)`, from); err != nil {
+			return err
+		}
+		p.created[from] = true
+	}
+	return nil
+}
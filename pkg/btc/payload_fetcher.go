@@ -17,7 +17,9 @@
 package btc
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/btcsuite/btcd/wire"
@@ -25,15 +27,16 @@ import (
 )
 
 // Fetcher interface for substituting mocks in tests
+// FetchAt gives up, returning the context's error, once the context is done
 type Fetcher interface {
-	FetchAt(blockHeights []uint64) ([]BlockPayload, error)
+	FetchAt(ctx context.Context, blockHeights []uint64) ([]BlockPayload, error)
 }
 
 // PayloadFetcher satisfies the PayloadFetcher interface for bitcoin
 type PayloadFetcher struct {
 	// PayloadFetcher is thread-safe as long as the underlying client is thread-safe, since it has/modifies no other state
 	// http.Client is thread-safe
-	client *rpcclient.Client
+	client ContextBlockClient
 }
 
 // NewStateDiffFetcher returns a PayloadFetcher
@@ -43,19 +46,31 @@ func NewPayloadFetcher(c *rpcclient.ConnConfig) (*PayloadFetcher, error) {
 		return nil, err
 	}
 	return &PayloadFetcher{
-		client: client,
+		client: &instrumentedClient{client: client},
 	}, nil
 }
 
+// NewEsploraFetcher returns a PayloadFetcher that retrieves blocks from an Esplora REST api
+func NewEsploraFetcher(path string, timeout time.Duration) *PayloadFetcher {
+	return &PayloadFetcher{
+		client: &instrumentedClient{client: NewEsploraClient(path, timeout)},
+	}
+}
+
 // FetchAt fetches the block payloads at the given block heights
-func (fetcher *PayloadFetcher) FetchAt(blockHeights []uint64) ([]BlockPayload, error) {
+// the context is passed down to each request, so an Esplora request is cancelled once it is done; a btcd rpcclient
+// request cannot be cancelled, so one already made to the node runs to completion and only its result is discarded
+func (fetcher *PayloadFetcher) FetchAt(ctx context.Context, blockHeights []uint64) ([]BlockPayload, error) {
 	blockPayloads := make([]BlockPayload, len(blockHeights))
 	for i, height := range blockHeights {
-		hash, err := fetcher.client.GetBlockHash(int64(height))
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hash, err := fetcher.client.GetBlockHashContext(ctx, int64(height))
 		if err != nil {
 			return nil, fmt.Errorf("bitcoin PayloadFetcher GetBlockHash err at blockheight %d: %s", height, err.Error())
 		}
-		block, err := fetcher.client.GetBlock(hash)
+		block, err := fetcher.client.GetBlockContext(ctx, hash)
 		if err != nil {
 			return nil, fmt.Errorf("bitcoin PayloadFetcher GetBlock err at blockheight %d: %s", height, err.Error())
 		}
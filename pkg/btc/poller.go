@@ -0,0 +1,162 @@
+// VulcanizeDB
+// Copyright © 2020 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc
+
+import (
+	"context"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/sirupsen/logrus"
+)
+
+// maxReorgDepth is the number of recently sent block hashes the poller remembers for reorg detection
+const maxReorgDepth = 100
+
+// blockPoller walks a BlockClient from a starting height up to the chain tip and then tracks the tip,
+// sending every block in height order; when a block does not build on the one previously sent at the
+// height below it, the poller steps back and resends the new branch
+type blockPoller struct {
+	client     BlockClient
+	nextHeight int64
+	sent       map[int64]chainhash.Hash
+}
+
+// newBlockPoller returns a poller that begins after the checkpoint, or at the tip if the checkpoint is nil
+func newBlockPoller(client BlockClient, checkpoint *Checkpoint) (*blockPoller, error) {
+	bp := &blockPoller{
+		client:     client,
+		nextHeight: -1,
+		sent:       make(map[int64]chainhash.Hash),
+	}
+	if checkpoint != nil {
+		hash, err := chainhash.NewHashFromStr(checkpoint.BlockHash)
+		if err != nil {
+			return nil, err
+		}
+		bp.nextHeight = checkpoint.BlockNumber + 1
+		bp.sent[checkpoint.BlockNumber] = *hash
+		logrus.Infof("bitcoin streamer catching up from checkpoint at height %d", checkpoint.BlockNumber)
+	}
+	return bp, nil
+}
+
+// poll sends every block between the last one sent and the current tip
+// it returns early, without error, if quit is closed
+func (bp *blockPoller) poll(payloadChan chan BlockPayload, quit <-chan struct{}) error {
+	tip, err := bp.client.GetBlockCount()
+	if err != nil {
+		return err
+	}
+	if bp.nextHeight < 0 {
+		bp.nextHeight = tip
+	}
+	if bp.nextHeight > tip {
+		// nothing new above us, but the tip itself may have been replaced
+		if last, ok := bp.sent[tip]; ok {
+			hash, err := bp.client.GetBlockHash(tip)
+			if err != nil {
+				return err
+			}
+			if hash.IsEqual(&last) {
+				return nil
+			}
+			bp.nextHeight = tip
+		}
+	}
+	for bp.nextHeight <= tip {
+		hash, err := bp.client.GetBlockHash(bp.nextHeight)
+		if err != nil {
+			return err
+		}
+		block, err := bp.client.GetBlock(hash)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-quit:
+			return nil
+		default:
+		}
+		if parent, ok := bp.sent[bp.nextHeight-1]; ok && !block.Header.PrevBlock.IsEqual(&parent) {
+			logrus.Warnf("bitcoin chain reorganization detected at height %d", bp.nextHeight-1)
+			delete(bp.sent, bp.nextHeight-1)
+			bp.nextHeight--
+			continue
+		}
+		select {
+		case payloadChan <- BlockPayload{
+			BlockHeight: bp.nextHeight,
+			Header:      &block.Header,
+			Txs:         msgTxsToUtilTxs(block.Transactions),
+		}:
+		case <-quit:
+			return nil
+		}
+		bp.sent[bp.nextHeight] = *hash
+		delete(bp.sent, bp.nextHeight-maxReorgDepth)
+		bp.nextHeight++
+	}
+	return nil
+}
+
+// PollingSubscription is the Subscription returned by streamers that poll for new blocks
+// It is closed when it is unsubscribed or when the context it was created with is done
+type PollingSubscription struct {
+	errChan chan error
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func newPollingSubscription(ctx context.Context) *PollingSubscription {
+	ctx, cancel := context.WithCancel(ctx)
+	return &PollingSubscription{
+		errChan: make(chan error),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// run polls for new blocks on every tick until the subscription is closed
+func (ps *PollingSubscription) run(poller *blockPoller, payloadChan chan BlockPayload, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := poller.poll(payloadChan, ps.ctx.Done()); err != nil {
+				select {
+				case ps.errChan <- err:
+				case <-ps.ctx.Done():
+					return
+				}
+			}
+		case <-ps.ctx.Done():
+			return
+		}
+	}
+}
+
+// Unsubscribe satisfies the rpc.Subscription interface
+func (ps *PollingSubscription) Unsubscribe() {
+	ps.cancel()
+}
+
+// Err satisfies the rpc.Subscription interface
+func (ps *PollingSubscription) Err() <-chan error {
+	return ps.errChan
+}
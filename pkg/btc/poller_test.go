@@ -0,0 +1,107 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+)
+
+var _ = Describe("Poller", func() {
+	var (
+		chain       *chainStandIn
+		chainServer *httptest.Server
+		payloadChan chan btc.BlockPayload
+		sub         btc.Subscription
+	)
+	BeforeEach(func() {
+		chain = &chainStandIn{base: 100}
+		chain.extend(5, 0)
+		chainServer = httptest.NewServer(chain)
+		payloadChan = make(chan btc.BlockPayload)
+	})
+	AfterEach(func() {
+		sub.Unsubscribe()
+		chainServer.Close()
+	})
+	stream := func(checkpoint *btc.Checkpoint) {
+		var err error
+		streamer := btc.NewEsploraPayloadStreamer(chainServer.URL, time.Second, 10*time.Millisecond)
+		sub, err = streamer.Stream(context.Background(), payloadChan, checkpoint)
+		Expect(err).ToNot(HaveOccurred())
+	}
+	checkpointAt := func(height int64) *btc.Checkpoint {
+		return &btc.Checkpoint{BlockNumber: height, BlockHash: chain.at(height).BlockHash().String()}
+	}
+	receive := func(n int) []int64 {
+		heights := make([]int64, 0, n)
+		for i := 0; i < n; i++ {
+			var payload btc.BlockPayload
+			Eventually(payloadChan).Should(Receive(&payload))
+			Expect(payload.Header.BlockHash()).To(Equal(chain.at(payload.BlockHeight).BlockHash()))
+			heights = append(heights, payload.BlockHeight)
+		}
+		return heights
+	}
+
+	It("Begins at the tip without a checkpoint", func() {
+		stream(nil)
+		Expect(receive(1)).To(Equal([]int64{104}))
+		Consistently(payloadChan, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("Catches up from the checkpoint as the chain grows", func() {
+		stream(checkpointAt(100))
+		Expect(receive(4)).To(Equal([]int64{101, 102, 103, 104}))
+		chain.extend(3, 0)
+		Expect(receive(3)).To(Equal([]int64{105, 106, 107}))
+		Consistently(payloadChan, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("Resends the tip when it is replaced at the same height", func() {
+		stream(checkpointAt(104))
+		Consistently(payloadChan, 50*time.Millisecond).ShouldNot(Receive())
+		chain.truncate(103)
+		chain.extend(1, 1)
+		Expect(receive(1)).To(Equal([]int64{104}))
+		Consistently(payloadChan, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("Steps back through a reorg deeper than one block", func() {
+		stream(checkpointAt(100))
+		Expect(receive(4)).To(Equal([]int64{101, 102, 103, 104}))
+		chain.truncate(101)
+		chain.extend(4, 1)
+		Expect(receive(4)).To(Equal([]int64{102, 103, 104, 105}))
+		Consistently(payloadChan, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("Reports errors from the source on the subscription", func() {
+		chainServer.Config.Handler = http.NotFoundHandler()
+		stream(checkpointAt(100))
+		var err error
+		Eventually(sub.Err()).Should(Receive(&err))
+		Expect(err.Error()).To(ContainSubstring("404"))
+	})
+})
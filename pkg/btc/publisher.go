@@ -17,16 +17,52 @@
 package btc
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"strconv"
 
+	"github.com/btcsuite/btcd/wire"
+	"github.com/jmoiron/sqlx"
+
 	"github.com/vulcanize/ipld-btc-indexer/pkg/ipfs/ipld"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
 )
 
+// ErrParentMismatch is returned by Commit when a header does not build on the header indexed at the height below it
+var ErrParentMismatch = errors.New("parent hash does not match the header indexed at the previous height")
+
+// ErrMissingParent is returned by Commit when no header is indexed at the height below a header that requires its parent
+var ErrMissingParent = errors.New("no header is indexed at the previous height")
+
 // Publisher interface for substituting mocks in tests
+// The payloads are written in a tx begun with the context, so cancelling it rolls back anything not yet committed
 type Publisher interface {
-	Publish(payload ConvertedPayload) error
+	Publish(ctx context.Context, payload ConvertedPayload) error
+	// PublishBatch publishes the payloads atomically; either all of them are committed or none are
+	PublishBatch(ctx context.Context, payloads []ConvertedPayload) error
+}
+
+// OrderedPublisher is a Publisher that splits generating the IPLDs from writing them to Postgres,
+// so that the former can be done in parallel while the latter is done in height order
+type OrderedPublisher interface {
+	Publisher
+	Prepare(payload ConvertedPayload) (*PreparedPayload, error)
+	Commit(ctx context.Context, prepared *PreparedPayload) error
+}
+
+// PreparedPayload is a ConvertedPayload along with the IPLD objects generated from it
+// Returned by IPLDPublisher.Prepare
+// Passed to IPLDPublisher.Commit
+type PreparedPayload struct {
+	ConvertedPayload
+	// ParentOptional lets Commit index the header when no header is indexed at the previous height yet, as for the
+	// first block of a stream that does not begin from a checkpoint
+	ParentOptional bool
+	headerNode     *ipld.BtcHeader
+	txNodes        []*ipld.BtcTx
+	txTrieNodes    []*ipld.BtcTxTrie
 }
 
 // IPLDPublisher satisfies the IPLDPublisher interface for bitcoin
@@ -44,15 +80,60 @@ func NewIPLDPublisher(db *postgres.DB) *IPLDPublisher {
 }
 
 // Publish publishes an IPLDPayload to IPFS and returns the corresponding CIDPayload
-func (pub *IPLDPublisher) Publish(payload ConvertedPayload) error {
-	// Generate the iplds
+func (pub *IPLDPublisher) Publish(ctx context.Context, payload ConvertedPayload) error {
+	prepared, err := pub.Prepare(payload)
+	if err != nil {
+		return err
+	}
+	return pub.write(ctx, []*PreparedPayload{prepared}, false)
+}
+
+// PublishBatch publishes and indexes the payloads together in a single tx
+func (pub *IPLDPublisher) PublishBatch(ctx context.Context, payloads []ConvertedPayload) error {
+	prepared := make([]*PreparedPayload, len(payloads))
+	for i, payload := range payloads {
+		var err error
+		if prepared[i], err = pub.Prepare(payload); err != nil {
+			return err
+		}
+	}
+	return pub.write(ctx, prepared, false)
+}
+
+// Prepare generates the IPLDs for the payload without touching the database
+func (pub *IPLDPublisher) Prepare(payload ConvertedPayload) (*PreparedPayload, error) {
+	return prepare(payload)
+}
+
+func prepare(payload ConvertedPayload) (*PreparedPayload, error) {
 	headerNode, txNodes, txTrieNodes, err := ipld.FromHeaderAndTxs(payload.Header, payload.Txs)
 	if err != nil {
+		return nil, err
+	}
+	return &PreparedPayload{
+		ConvertedPayload: payload,
+		headerNode:       headerNode,
+		txNodes:          txNodes,
+		txTrieNodes:      txTrieNodes,
+	}, nil
+}
+
+// Commit publishes and indexes a prepared payload
+// It returns an error wrapping ErrParentMismatch, without writing anything, if headers are already indexed at the
+// previous height and none of them is the payload's parent, or wrapping ErrMissingParent if none are and the payload's
+// parent is not optional
+func (pub *IPLDPublisher) Commit(ctx context.Context, prepared *PreparedPayload) error {
+	return pub.write(ctx, []*PreparedPayload{prepared}, true)
+}
+
+// write publishes and indexes the prepared payloads in a single tx
+func (pub *IPLDPublisher) write(ctx context.Context, prepared []*PreparedPayload, checkParent bool) (err error) {
+	if err = pub.indexer.partitions.ensure(heights(prepared)...); err != nil {
 		return err
 	}
 
 	// Begin new db tx
-	tx, err := pub.indexer.db.Beginx()
+	tx, err := pub.indexer.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -67,54 +148,115 @@ func (pub *IPLDPublisher) Publish(payload ConvertedPayload) error {
 		}
 	}()
 
+	if err = shareGCLock(tx); err != nil {
+		return err
+	}
+
+	for _, payload := range prepared {
+		if checkParent {
+			if err = verifyParent(tx, payload.Height(), payload.Header, payload.ParentOptional); err != nil {
+				return err
+			}
+		}
+		if err = pub.publishAndIndex(tx, payload); err != nil {
+			return err
+		}
+	}
+	return checkBatchLease(ctx, tx)
+}
+
+func (pub *IPLDPublisher) publishAndIndex(tx *sqlx.Tx, payload *PreparedPayload) error {
 	// Publish trie nodes
-	for _, node := range txTrieNodes {
+	for _, node := range payload.txTrieNodes {
 		if err := shared.PublishIPLD(tx, node); err != nil {
 			return err
 		}
 	}
 
 	// Publish and index header
-	if err := shared.PublishIPLD(tx, headerNode); err != nil {
+	if err := shared.PublishIPLD(tx, payload.headerNode); err != nil {
 		return err
 	}
-	header := HeaderModel{
-		CID:         headerNode.Cid().String(),
-		MhKey:       shared.MultihashKeyFromCID(headerNode.Cid()),
-		ParentHash:  payload.Header.PrevBlock.String(),
-		BlockNumber: strconv.Itoa(int(payload.BlockPayload.BlockHeight)),
-		BlockHash:   payload.Header.BlockHash().String(),
-		Timestamp:   payload.Header.Timestamp.UnixNano(),
-		Bits:        payload.Header.Bits,
-	}
-	headerID, err := pub.indexer.indexHeaderCID(tx, header)
+	headerID, err := pub.indexer.indexHeaderCID(tx, payload.headerModel(), payload.validations())
 	if err != nil {
 		return err
 	}
 
 	// Publish and index txs
-	for i, txNode := range txNodes {
+	for i, txNode := range payload.txNodes {
 		if err := shared.PublishIPLD(tx, txNode); err != nil {
 			return err
 		}
 		txModel := payload.TxMetaData[i]
 		txModel.CID = txNode.Cid().String()
 		txModel.MhKey = shared.MultihashKeyFromCID(txNode.Cid())
-		txID, err := pub.indexer.indexTransactionCID(tx, txModel, headerID)
+		txID, err := pub.indexer.indexTransactionCID(tx, txModel, headerID, payload.BlockHeight)
 		if err != nil {
 			return err
 		}
 		for _, input := range txModel.TxInputs {
-			if err := pub.indexer.indexTxInput(tx, input, txID); err != nil {
+			if err := pub.indexer.indexTxInput(tx, input, txID, payload.BlockHeight); err != nil {
 				return err
 			}
 		}
 		for _, output := range txModel.TxOutputs {
-			if err := pub.indexer.indexTxOutput(tx, output, txID); err != nil {
+			if err := pub.indexer.indexTxOutput(tx, output, txID, payload.BlockHeight); err != nil {
 				return err
 			}
 		}
 	}
+	return nil
+}
+
+// heights returns the block heights of the prepared payloads
+func heights(prepared []*PreparedPayload) []int64 {
+	hs := make([]int64, len(prepared))
+	for i, payload := range prepared {
+		hs[i] = payload.BlockHeight
+	}
+	return hs
+}
 
-	return err
+// headerModel returns the btc.header_cids row for the payload
+func (payload *PreparedPayload) headerModel() HeaderModel {
+	return HeaderModel{
+		CID:         payload.headerNode.Cid().String(),
+		MhKey:       shared.MultihashKeyFromCID(payload.headerNode.Cid()),
+		ParentHash:  payload.Header.PrevBlock.String(),
+		BlockNumber: strconv.Itoa(int(payload.BlockPayload.BlockHeight)),
+		BlockHash:   payload.Header.BlockHash().String(),
+		Timestamp:   payload.Header.Timestamp.UnixNano(),
+		Bits:        payload.Header.Bits,
+	}
+}
+
+// validations returns the times_validated the payload's header starts with when it is first indexed
+func (payload *PreparedPayload) validations() int64 {
+	if payload.Unvalidated {
+		return 0
+	}
+	return 1
+}
+
+// verifyParent checks that the header builds on one of the headers indexed at the previous height
+// if there are none, it is an error unless the parent is optional
+func verifyParent(tx *sqlx.Tx, height int64, header *wire.BlockHeader, optional bool) error {
+	var hashes []string
+	pgStr := `SELECT block_hash FROM btc.header_cids WHERE block_number = $1`
+	if err := tx.Select(&hashes, pgStr, height-1); err != nil {
+		return err
+	}
+	if len(hashes) == 0 {
+		if optional {
+			return nil
+		}
+		return fmt.Errorf("%w: block %s at height %d", ErrMissingParent, header.BlockHash().String(), height)
+	}
+	parent := header.PrevBlock.String()
+	for _, hash := range hashes {
+		if hash == parent {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: block %s at height %d", ErrParentMismatch, header.BlockHash().String(), height)
 }
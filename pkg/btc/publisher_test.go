@@ -18,6 +18,9 @@ package btc_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"strings"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-ipfs-blockstore"
@@ -52,7 +55,7 @@ var _ = Describe("PublishAndIndexer", func() {
 
 	Describe("Publish", func() {
 		It("Published and indexes header and transaction IPLDs in a single tx", func() {
-			err = repo.Publish(mocks.MockConvertedPayload)
+			err = repo.Publish(context.Background(), mocks.MockConvertedPayload)
 			Expect(err).ToNot(HaveOccurred())
 			pgStr := `SELECT * FROM btc.header_cids
 				WHERE block_number = $1`
@@ -117,4 +120,89 @@ var _ = Describe("PublishAndIndexer", func() {
 			}
 		})
 	})
+
+	Describe("PublishBatch", func() {
+		It("Publishes every payload in the batch", func() {
+			err = repo.PublishBatch(context.Background(), payloadsAt(mocks.MockBlockHeight, 3))
+			Expect(err).ToNot(HaveOccurred())
+			var count int
+			err = db.Get(&count, `SELECT COUNT(*) FROM btc.header_cids`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(3))
+		})
+
+		It("Publishes none of the batch if any payload fails", func() {
+			payloads := payloadsAt(mocks.MockBlockHeight, 3)
+			payloads[2].TxMetaData = append([]btc.TxModelWithInsAndOuts{}, payloads[2].TxMetaData...)
+			payloads[2].TxMetaData[0].TxHash = strings.Repeat("f", 100)
+			err = repo.PublishBatch(context.Background(), payloads)
+			Expect(err).To(HaveOccurred())
+			var count int
+			err = db.Get(&count, `SELECT COUNT(*) FROM btc.header_cids`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(0))
+		})
+	})
+
+	Describe("Commit", func() {
+		var child btc.ConvertedPayload
+		BeforeEach(func() {
+			childHeader := mocks.MockBlock.Header
+			childHeader.PrevBlock = mocks.MockBlock.Header.BlockHash()
+			child = mocks.MockConvertedPayload
+			child.BlockPayload = btc.BlockPayload{
+				BlockHeight: mocks.MockBlockHeight + 1,
+				Header:      &childHeader,
+				Txs:         mocks.MockTransactions,
+			}
+		})
+
+		It("Commits a block that builds on the header indexed below it", func() {
+			err = repo.Publish(context.Background(), mocks.MockConvertedPayload)
+			Expect(err).ToNot(HaveOccurred())
+			prepared, err := repo.Prepare(child)
+			Expect(err).ToNot(HaveOccurred())
+			err = repo.Commit(context.Background(), prepared)
+			Expect(err).ToNot(HaveOccurred())
+			var count int
+			err = db.Get(&count, `SELECT COUNT(*) FROM btc.header_cids WHERE block_number = $1`, child.BlockHeight)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(1))
+		})
+
+		It("Refuses a block whose parent doesn't match the header indexed below it", func() {
+			err = repo.Publish(context.Background(), mocks.MockConvertedPayload)
+			Expect(err).ToNot(HaveOccurred())
+			child.Header.PrevBlock[0]++
+			prepared, err := repo.Prepare(child)
+			Expect(err).ToNot(HaveOccurred())
+			err = repo.Commit(context.Background(), prepared)
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, btc.ErrParentMismatch)).To(BeTrue())
+			var count int
+			err = db.Get(&count, `SELECT COUNT(*) FROM btc.header_cids WHERE block_number = $1`, child.BlockHeight)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(0))
+		})
+
+		It("Refuses a block with no header indexed below it", func() {
+			prepared, err := repo.Prepare(child)
+			Expect(err).ToNot(HaveOccurred())
+			err = repo.Commit(context.Background(), prepared)
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, btc.ErrMissingParent)).To(BeTrue())
+			var count int
+			err = db.Get(&count, `SELECT COUNT(*) FROM btc.header_cids`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(0))
+		})
+
+		It("Commits a block with no header indexed below it if its parent is optional", func() {
+			prepared, err := repo.Prepare(child)
+			Expect(err).ToNot(HaveOccurred())
+			prepared.ParentOptional = true
+			err = repo.Commit(context.Background(), prepared)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
 })
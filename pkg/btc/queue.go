@@ -0,0 +1,197 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultLease       = time.Minute
+	DefaultMaxAttempts = 3
+)
+
+// BatchQueue lets any number of processes cooperatively work through the batches of the same resync jobs
+// Workers claim batches with SELECT ... FOR UPDATE SKIP LOCKED and hold a lease on each one, which they extend with
+// heartbeats while processing it; a batch whose lease expires, e.g. because its process died, is claimed by another worker
+type BatchQueue struct {
+	Jobs        JobTracker
+	Owner       string
+	Lease       time.Duration
+	MaxAttempts int
+}
+
+// NewBatchQueue returns a pointer to a new BatchQueue, owned by this process
+func NewBatchQueue(jobs JobTracker, lease time.Duration, maxAttempts int) *BatchQueue {
+	if lease <= 0 {
+		lease = DefaultLease
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	hostname, _ := os.Hostname()
+	return &BatchQueue{
+		Jobs:        jobs,
+		Owner:       fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), time.Now().UnixNano()),
+		Lease:       lease,
+		MaxAttempts: maxAttempts,
+	}
+}
+
+// ClaimedBatch is a batch leased to this process, whose lease is extended with heartbeats until it is released
+// The batch is processed with its context, which is cancelled if the lease is lost, so that the work is rolled back
+// rather than committed alongside the new owner's; publishes made with it also check the lease before they commit, in
+// case it is lost between heartbeats
+type ClaimedBatch struct {
+	ResyncBatch
+	queue  *BatchQueue
+	ctx    context.Context
+	cancel context.CancelFunc
+	lost   int32
+	stop   chan bool
+}
+
+// Claim claims the next batch of the jobs and starts heartbeating its lease, returning nil if there is none to claim
+// The batch's context is derived from ctx
+func (q *BatchQueue) Claim(ctx context.Context, jobIDs []int64) (*ClaimedBatch, error) {
+	batch, err := q.Jobs.Claim(jobIDs, q.Owner, q.Lease, q.MaxAttempts)
+	if err != nil || batch == nil {
+		return nil, err
+	}
+	logrus.Debugf("bitcoin batch queue claimed heights %d to %d, attempt %d", batch.Start, batch.Stop, batch.Attempts)
+	claimed := &ClaimedBatch{
+		ResyncBatch: *batch,
+		queue:       q,
+		stop:        make(chan bool),
+	}
+	claimed.ctx, claimed.cancel = context.WithCancel(WithBatchLease(ctx, batch.JobID, batch.Start, q.Owner))
+	go claimed.heartbeat()
+	return claimed, nil
+}
+
+// Context returns the context the batch is processed with; it is done once the lease is lost
+func (c *ClaimedBatch) Context() context.Context {
+	return c.ctx
+}
+
+// LeaseLost reports whether another worker has taken over the batch
+func (c *ClaimedBatch) LeaseLost() bool {
+	return atomic.LoadInt32(&c.lost) == 1
+}
+
+// Release stops heartbeating the batch's lease and records it as done, or as failed if err is not nil
+// Nothing is recorded if the lease was lost, since the batch then belongs to another worker
+func (c *ClaimedBatch) Release(err error) {
+	close(c.stop)
+	c.cancel()
+	if c.LeaseLost() {
+		logrus.Warnf("bitcoin batch queue lost the lease on heights %d to %d, leaving them to the worker that took them over", c.Start, c.Stop)
+		return
+	}
+	if err != nil {
+		logrus.Errorf("bitcoin batch queue failed heights %d to %d: %v", c.Start, c.Stop, err)
+		err = c.queue.Jobs.Failed(c.JobID, c.Start, c.queue.Owner, err)
+	} else {
+		err = c.queue.Jobs.Done(c.JobID, c.Start, c.queue.Owner)
+	}
+	if err != nil {
+		logrus.Errorf("bitcoin batch queue error recording heights %d to %d: %v", c.Start, c.Stop, err)
+	}
+}
+
+// heartbeat extends the lease on the batch until it is released, cancelling the batch's context if the lease is lost
+func (c *ClaimedBatch) heartbeat() {
+	ticker := time.NewTicker(c.queue.Lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := c.queue.Jobs.Heartbeat(c.JobID, c.Start, c.queue.Owner, c.queue.Lease)
+			if err == ErrLeaseLost {
+				logrus.Errorf("bitcoin batch queue lost the lease on heights %d to %d, abandoning them", c.Start, c.Stop)
+				atomic.StoreInt32(&c.lost, 1)
+				c.cancel()
+				return
+			}
+			if err != nil {
+				logrus.Errorf("bitcoin batch queue heartbeat error for heights %d to %d: %v", c.Start, c.Stop, err)
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Drain processes the batches of the jobs with the given number of workers until every batch is either done or out
+// of attempts, including the batches leased by other processes
+// Each batch is processed with a context derived from workCtx, which is cancelled if the batch's lease is lost
+// It returns early, without error, if ctx is cancelled
+func (q *BatchQueue) Drain(ctx, workCtx context.Context, jobIDs []int64, workers int, process func(ctx context.Context, batch ResyncBatch) error) error {
+	for {
+		wg := new(sync.WaitGroup)
+		for i := 1; i <= workers; i++ {
+			wg.Add(1)
+			go q.work(ctx, workCtx, wg, i, jobIDs, process)
+		}
+		wg.Wait()
+		if ctx.Err() != nil {
+			return nil
+		}
+		outstanding := 0
+		for _, jobID := range jobIDs {
+			pending, err := q.Jobs.Pending(jobID, q.MaxAttempts)
+			if err != nil {
+				return err
+			}
+			outstanding += len(pending)
+		}
+		if outstanding == 0 {
+			return nil
+		}
+		// the remaining batches are leased by other workers; wait for them to finish or for their leases to expire
+		logrus.Infof("bitcoin batch queue waiting on %d batches leased by other workers", outstanding)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(q.Lease / 2):
+		}
+	}
+}
+
+// work claims and processes batches until there are none left to claim
+func (q *BatchQueue) work(ctx, workCtx context.Context, wg *sync.WaitGroup, id int, jobIDs []int64, process func(ctx context.Context, batch ResyncBatch) error) {
+	defer wg.Done()
+	for ctx.Err() == nil {
+		batch, err := q.Claim(workCtx, jobIDs)
+		if err != nil {
+			logrus.Errorf("bitcoin batch queue worker %d claim error: %v", id, err)
+			return
+		}
+		if batch == nil {
+			return
+		}
+		batch.Release(process(batch.Context(), batch.ResyncBatch))
+	}
+	logrus.Infof("bitcoin batch queue worker %d shutting down", id)
+}
@@ -0,0 +1,85 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+)
+
+var _ = Describe("BatchQueue", func() {
+	var (
+		jobs  *mocks.JobTracker
+		queue *btc.BatchQueue
+	)
+	BeforeEach(func() {
+		jobs = &mocks.JobTracker{Batches: []btc.ResyncBatch{{JobID: 1, Start: 0, Stop: 9}, {JobID: 1, Start: 10, Stop: 19}}}
+		queue = btc.NewBatchQueue(jobs, 30*time.Millisecond, 3)
+	})
+
+	It("Records each batch as done or failed once it is processed", func() {
+		err := queue.Drain(context.Background(), context.Background(), []int64{1}, 1, func(ctx context.Context, batch btc.ResyncBatch) error {
+			if batch.Start == 10 {
+				return errors.New("mock process error")
+			}
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(jobs.DoneBatches).To(Equal([]uint64{0}))
+		Expect(jobs.FailedErrs).To(HaveKey(uint64(10)))
+	})
+
+	It("Extends the lease while the batch is processed", func() {
+		batch, err := queue.Claim(context.Background(), []int64{1})
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(func() int {
+			jobs.Lock()
+			defer jobs.Unlock()
+			return jobs.Heartbeats
+		}).Should(BeNumerically(">=", 2))
+		Expect(batch.Context().Err()).ToNot(HaveOccurred())
+		batch.Release(nil)
+		Expect(batch.Context().Err()).To(HaveOccurred())
+		Expect(jobs.DoneBatches).To(Equal([]uint64{0}))
+	})
+
+	It("Cancels the batch's context once its lease is lost, and leaves it to the new owner", func() {
+		jobs.HeartbeatErr = btc.ErrLeaseLost
+		batch, err := queue.Claim(context.Background(), []int64{1})
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(batch.Context().Done()).Should(BeClosed())
+		Expect(batch.LeaseLost()).To(BeTrue())
+		batch.Release(batch.Context().Err())
+		Expect(jobs.Released()).To(BeZero())
+	})
+
+	It("Keeps working through transient heartbeat errors", func() {
+		jobs.HeartbeatErr = errors.New("mock db error")
+		batch, err := queue.Claim(context.Background(), []int64{1})
+		Expect(err).ToNot(HaveOccurred())
+		Consistently(batch.Context().Done(), 100*time.Millisecond).ShouldNot(BeClosed())
+		batch.Release(nil)
+		Expect(jobs.DoneBatches).To(Equal([]uint64{0}))
+	})
+})
@@ -0,0 +1,298 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+)
+
+// Statuses of the batches of a resync job
+const (
+	BatchPending = "pending"
+	BatchRunning = "running"
+	BatchDone    = "done"
+	BatchFailed  = "failed"
+)
+
+// Kinds of resync jobs; backfill only takes part in the jobs it created itself
+const (
+	ResyncJob   = "resync"
+	BackfillJob = "backfill"
+)
+
+// ErrLeaseLost is returned when a worker updates a batch whose lease it no longer holds
+var ErrLeaseLost = errors.New("bitcoin resync batch lease lost")
+
+// ResyncBatch is a range of heights resynced as a unit, and the outcome of the attempts to resync it
+type ResyncBatch struct {
+	JobID     int64   `db:"job_id"`
+	Start     uint64  `db:"start_block"`
+	Stop      uint64  `db:"stop_block"`
+	Status    string  `db:"status"`
+	Attempts  int     `db:"attempts"`
+	LastError *string `db:"last_error"`
+	LeasedBy  *string `db:"leased_by"`
+}
+
+// JobTracker interface for substituting mocks in tests
+type JobTracker interface {
+	// Job returns the id of the unfinished job of the kind resyncing the data type over the range, creating it with the
+	// given batches if there is none; if retry is set, the failed batches of a resumed job get a fresh set of attempts
+	Job(kind string, t shared.DataType, rng [2]uint64, batches [][2]uint64, retry bool) (int64, error)
+	// Unfinished returns the ids of the unfinished jobs of the kind that still have pending batches
+	Unfinished(kind string, maxAttempts int) ([]int64, error)
+	// Filling returns the ranges of the pending batches of all unfinished jobs
+	Filling(maxAttempts int) ([][2]uint64, error)
+	// Pending returns the batches of the job that are not done and can still be claimed, or are leased by a worker
+	Pending(jobID int64, maxAttempts int) ([]ResyncBatch, error)
+	// Claim leases a batch of the jobs to the owner, returning nil if there is none to claim
+	Claim(jobIDs []int64, owner string, lease time.Duration, maxAttempts int) (*ResyncBatch, error)
+	Heartbeat(jobID int64, start uint64, owner string, lease time.Duration) error
+	Done(jobID int64, start uint64, owner string) error
+	Failed(jobID int64, start uint64, owner string, err error) error
+	// Finish returns the batches of the job that are not done, marking the job finished if there are none
+	Finish(jobID int64) ([]ResyncBatch, error)
+}
+
+// DBJobTracker satisfies the JobTracker interface by recording resync jobs in Postgres
+type DBJobTracker struct {
+	db *postgres.DB
+}
+
+// NewDBJobTracker returns a new DBJobTracker struct
+func NewDBJobTracker(db *postgres.DB) *DBJobTracker {
+	return &DBJobTracker{
+		db: db,
+	}
+}
+
+// Job returns the id of the unfinished job of the kind resyncing the data type over the range
+// A job left unfinished by an earlier run is resumed; its batches keep their attempts, so that joining a job in progress
+// doesn't retry the batches that have already failed, unless retry is set, in which case the attempts of its failed
+// and abandoned batches are reset. Otherwise a new job is recorded with the given batches
+// Concurrent callers are serialized by an advisory lock on the job, so that they all resume the same one
+func (jt *DBJobTracker) Job(kind string, t shared.DataType, rng [2]uint64, batches [][2]uint64, retry bool) (int64, error) {
+	tx, err := jt.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	key := fmt.Sprintf("btc.resync_jobs:%s:%s:%d:%d", kind, t.String(), rng[0], rng[1])
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, key); err != nil {
+		shared.Rollback(tx)
+		return 0, err
+	}
+	var jobID int64
+	pgStr := `SELECT id FROM btc.resync_jobs
+			WHERE kind = $1 AND data_type = $2 AND start_block = $3 AND stop_block = $4 AND finished_at IS NULL
+			ORDER BY id DESC LIMIT 1
+			FOR UPDATE`
+	err = tx.Get(&jobID, pgStr, kind, t.String(), rng[0], rng[1])
+	switch err {
+	case nil:
+		logrus.Infof("bitcoin resync resuming job %d for %s data from %d to %d", jobID, t.String(), rng[0], rng[1])
+		if !retry {
+			break
+		}
+		pgStr = `UPDATE btc.resync_batches SET (status, attempts, leased_by, lease_expires_at, updated_at) = ($2, 0, NULL, NULL, NOW())
+				WHERE job_id = $1 AND (status = $3 OR (status = $4 AND lease_expires_at < NOW()))`
+		if _, err := tx.Exec(pgStr, jobID, BatchPending, BatchFailed, BatchRunning); err != nil {
+			shared.Rollback(tx)
+			return 0, err
+		}
+	case sql.ErrNoRows:
+		pgStr = `INSERT INTO btc.resync_jobs (kind, data_type, start_block, stop_block) VALUES ($1, $2, $3, $4) RETURNING id`
+		if err := tx.QueryRowx(pgStr, kind, t.String(), rng[0], rng[1]).Scan(&jobID); err != nil {
+			shared.Rollback(tx)
+			return 0, err
+		}
+		for _, batch := range batches {
+			pgStr = `INSERT INTO btc.resync_batches (job_id, start_block, stop_block) VALUES ($1, $2, $3)`
+			if _, err := tx.Exec(pgStr, jobID, batch[0], batch[1]); err != nil {
+				shared.Rollback(tx)
+				return 0, err
+			}
+		}
+	default:
+		shared.Rollback(tx)
+		return 0, err
+	}
+	return jobID, tx.Commit()
+}
+
+// Pending returns the batches of the job that are not done and can still be claimed, or are leased by a worker
+func (jt *DBJobTracker) Pending(jobID int64, maxAttempts int) ([]ResyncBatch, error) {
+	var batches []ResyncBatch
+	pgStr := `SELECT job_id, start_block, stop_block, status, attempts, last_error, leased_by FROM btc.resync_batches
+			WHERE job_id = $1 AND status <> $2
+			AND (attempts < $3 OR (status = $4 AND lease_expires_at >= NOW()))
+			ORDER BY start_block`
+	return batches, jt.db.Select(&batches, pgStr, jobID, BatchDone, maxAttempts, BatchRunning)
+}
+
+// Unfinished returns the ids of the unfinished jobs of the kind that still have batches that can be claimed, or are
+// leased by a worker
+func (jt *DBJobTracker) Unfinished(kind string, maxAttempts int) ([]int64, error) {
+	var jobIDs []int64
+	pgStr := `SELECT DISTINCT resync_jobs.id FROM btc.resync_jobs
+			INNER JOIN btc.resync_batches ON (resync_batches.job_id = resync_jobs.id)
+			WHERE resync_jobs.kind = $1 AND resync_jobs.finished_at IS NULL AND resync_batches.status <> $2
+			AND (resync_batches.attempts < $3 OR (resync_batches.status = $4 AND resync_batches.lease_expires_at >= NOW()))
+			ORDER BY resync_jobs.id`
+	return jobIDs, jt.db.Select(&jobIDs, pgStr, kind, BatchDone, maxAttempts, BatchRunning)
+}
+
+// Filling returns the ranges of the batches of all unfinished jobs that can be claimed, or are leased by a worker
+func (jt *DBJobTracker) Filling(maxAttempts int) ([][2]uint64, error) {
+	var batches []ResyncBatch
+	pgStr := `SELECT resync_batches.start_block, resync_batches.stop_block FROM btc.resync_batches
+			INNER JOIN btc.resync_jobs ON (resync_batches.job_id = resync_jobs.id)
+			WHERE resync_jobs.finished_at IS NULL AND resync_batches.status <> $1
+			AND (resync_batches.attempts < $2 OR (resync_batches.status = $3 AND resync_batches.lease_expires_at >= NOW()))
+			ORDER BY resync_batches.start_block`
+	if err := jt.db.Select(&batches, pgStr, BatchDone, maxAttempts, BatchRunning); err != nil {
+		return nil, err
+	}
+	rngs := make([][2]uint64, len(batches))
+	for i, batch := range batches {
+		rngs[i] = [2]uint64{batch.Start, batch.Stop}
+	}
+	return rngs, nil
+}
+
+// Claim leases the first claimable batch of the jobs to the owner, counting it as an attempt
+// A batch can be claimed if it is pending or failed, or if its lease has expired, and it has been attempted fewer than
+// maxAttempts times; batches locked by concurrent claims are skipped rather than waited on
+func (jt *DBJobTracker) Claim(jobIDs []int64, owner string, lease time.Duration, maxAttempts int) (*ResyncBatch, error) {
+	batch := new(ResyncBatch)
+	pgStr := `UPDATE btc.resync_batches
+			SET (status, attempts, leased_by, lease_expires_at, updated_at) = ($2, attempts + 1, $3, NOW() + make_interval(secs => $4), NOW())
+			WHERE (job_id, start_block) = (
+				SELECT job_id, start_block FROM btc.resync_batches
+				WHERE job_id = ANY($1) AND attempts < $5
+				AND (status IN ($6, $7) OR (status = $2 AND lease_expires_at < NOW()))
+				ORDER BY job_id, start_block
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING job_id, start_block, stop_block, status, attempts, last_error, leased_by`
+	err := jt.db.Get(batch, pgStr, pq.Array(jobIDs), BatchRunning, owner, lease.Seconds(), maxAttempts, BatchPending, BatchFailed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// Heartbeat extends the owner's lease on a batch
+func (jt *DBJobTracker) Heartbeat(jobID int64, start uint64, owner string, lease time.Duration) error {
+	pgStr := `UPDATE btc.resync_batches SET lease_expires_at = NOW() + make_interval(secs => $4)
+			WHERE job_id = $1 AND start_block = $2 AND leased_by = $3 AND status = $5`
+	return jt.leasedExec(pgStr, jobID, start, owner, lease.Seconds(), BatchRunning)
+}
+
+// Done marks a batch leased by the owner as done, releasing the lease
+func (jt *DBJobTracker) Done(jobID int64, start uint64, owner string) error {
+	pgStr := `UPDATE btc.resync_batches SET (status, last_error, leased_by, lease_expires_at, updated_at) = ($4, NULL, NULL, NULL, NOW())
+			WHERE job_id = $1 AND start_block = $2 AND leased_by = $3 AND status = $5`
+	return jt.leasedExec(pgStr, jobID, start, owner, BatchDone, BatchRunning)
+}
+
+// Failed marks a batch leased by the owner as failed, recording the error and releasing the lease
+func (jt *DBJobTracker) Failed(jobID int64, start uint64, owner string, batchErr error) error {
+	pgStr := `UPDATE btc.resync_batches SET (status, last_error, leased_by, lease_expires_at, updated_at) = ($4, $5, NULL, NULL, NOW())
+			WHERE job_id = $1 AND start_block = $2 AND leased_by = $3 AND status = $6`
+	return jt.leasedExec(pgStr, jobID, start, owner, BatchFailed, batchErr.Error(), BatchRunning)
+}
+
+// leasedExec executes an update of a leased batch, returning ErrLeaseLost if the owner no longer holds the lease
+func (jt *DBJobTracker) leasedExec(pgStr string, args ...interface{}) error {
+	res, err := jt.db.Exec(pgStr, args...)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// batchLeaseKey is the context key of the batch lease that the publishes made with the context are done under
+type batchLeaseKey struct{}
+
+type batchLease struct {
+	jobID int64
+	start uint64
+	owner string
+}
+
+// WithBatchLease returns a context whose publishes only commit while the owner still leases the batch of the job
+func WithBatchLease(ctx context.Context, jobID int64, start uint64, owner string) context.Context {
+	return context.WithValue(ctx, batchLeaseKey{}, batchLease{jobID: jobID, start: start, owner: owner})
+}
+
+// checkBatchLease is called in a publish tx right before it commits; if the context carries a batch lease, it locks the
+// batch's row and returns ErrLeaseLost unless the owner still leases it
+// The row stays locked until the tx ends, and claims skip locked rows, so no other worker can take the batch over
+// between the check and the commit
+func checkBatchLease(ctx context.Context, tx *sqlx.Tx) error {
+	lease, ok := ctx.Value(batchLeaseKey{}).(batchLease)
+	if !ok {
+		return nil
+	}
+	var leasedBy string
+	pgStr := `SELECT leased_by FROM btc.resync_batches
+			WHERE job_id = $1 AND start_block = $2 AND leased_by = $3 AND status = $4
+			FOR UPDATE`
+	err := tx.Get(&leasedBy, pgStr, lease.jobID, lease.start, lease.owner, BatchRunning)
+	if err == sql.ErrNoRows {
+		return ErrLeaseLost
+	}
+	return err
+}
+
+// Finish returns the batches of the job that are not done, marking the job finished if there are none
+func (jt *DBJobTracker) Finish(jobID int64) ([]ResyncBatch, error) {
+	var unfinished []ResyncBatch
+	pgStr := `SELECT job_id, start_block, stop_block, status, attempts, last_error, leased_by FROM btc.resync_batches
+			WHERE job_id = $1 AND status <> $2
+			ORDER BY start_block`
+	if err := jt.db.Select(&unfinished, pgStr, jobID, BatchDone); err != nil {
+		return nil, err
+	}
+	if len(unfinished) > 0 {
+		return unfinished, nil
+	}
+	_, err := jt.db.Exec(`UPDATE btc.resync_jobs SET finished_at = NOW() WHERE id = $1`, jobID)
+	return nil, err
+}
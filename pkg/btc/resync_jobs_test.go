@@ -0,0 +1,195 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+)
+
+var _ = Describe("DBJobTracker", func() {
+	var (
+		db      *postgres.DB
+		err     error
+		tracker *btc.DBJobTracker
+		owner   = "worker"
+		rng     = [2]uint64{0, 29}
+		batches = [][2]uint64{{0, 9}, {10, 19}, {20, 29}}
+	)
+	BeforeEach(func() {
+		db, err = shared.SetupDB()
+		Expect(err).ToNot(HaveOccurred())
+		tracker = btc.NewDBJobTracker(db)
+	})
+	AfterEach(func() {
+		btc.TearDownDB(db)
+	})
+
+	It("Records a new job with all of its batches pending", func() {
+		jobID, err := tracker.Job(btc.ResyncJob, shared.Full, rng, batches, false)
+		Expect(err).ToNot(HaveOccurred())
+		pending, err := tracker.Pending(jobID, 3)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pending).To(HaveLen(3))
+		Expect(pending[1].Start).To(Equal(uint64(10)))
+		Expect(pending[1].Stop).To(Equal(uint64(19)))
+		Expect(pending[1].Status).To(Equal(btc.BatchPending))
+	})
+
+	It("Resumes an unfinished job, only retrying its failed batches when asked to", func() {
+		jobID, err := tracker.Job(btc.ResyncJob, shared.Full, rng, batches, false)
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 3; i++ {
+			batch, err := tracker.Claim([]int64{jobID}, owner, time.Minute, 3)
+			Expect(err).ToNot(HaveOccurred())
+			if batch.Start == 0 {
+				Expect(tracker.Done(jobID, 0, owner)).To(Succeed())
+				continue
+			}
+			Expect(batch.Start).To(Equal(uint64(10)))
+			Expect(tracker.Failed(jobID, 10, owner, errors.New("node unavailable"))).To(Succeed())
+		}
+		pending, err := tracker.Pending(jobID, 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pending).To(HaveLen(1))
+		Expect(pending[0].Start).To(Equal(uint64(20)))
+
+		joinedID, err := tracker.Job(btc.ResyncJob, shared.Full, rng, batches, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(joinedID).To(Equal(jobID))
+		pending, err = tracker.Pending(joinedID, 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pending).To(HaveLen(1))
+		Expect(pending[0].Start).To(Equal(uint64(20)))
+
+		resumedID, err := tracker.Job(btc.ResyncJob, shared.Full, rng, batches, true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resumedID).To(Equal(jobID))
+		pending, err = tracker.Pending(resumedID, 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pending).To(HaveLen(2))
+		Expect(pending[0].Start).To(Equal(uint64(10)))
+		Expect(pending[0].Attempts).To(Equal(0))
+		Expect(*pending[0].LastError).To(Equal("node unavailable"))
+	})
+
+	It("Leases each batch to a single worker until its lease expires", func() {
+		jobID, err := tracker.Job(btc.ResyncJob, shared.Full, [2]uint64{0, 9}, batches[:1], false)
+		Expect(err).ToNot(HaveOccurred())
+		batch, err := tracker.Claim([]int64{jobID}, owner, time.Second, 3)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(batch.Status).To(Equal(btc.BatchRunning))
+		Expect(batch.Attempts).To(Equal(1))
+		other, err := tracker.Claim([]int64{jobID}, "other", time.Second, 3)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(other).To(BeNil())
+
+		_, err = db.Exec(`UPDATE btc.resync_batches SET lease_expires_at = NOW() - INTERVAL '1 second'`)
+		Expect(err).ToNot(HaveOccurred())
+		other, err = tracker.Claim([]int64{jobID}, "other", time.Second, 3)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(other.Start).To(Equal(batch.Start))
+		Expect(*other.LeasedBy).To(Equal("other"))
+		Expect(other.Attempts).To(Equal(2))
+		Expect(tracker.Heartbeat(jobID, batch.Start, owner, time.Second)).To(Equal(btc.ErrLeaseLost))
+		Expect(tracker.Done(jobID, batch.Start, owner)).To(Equal(btc.ErrLeaseLost))
+		Expect(tracker.Heartbeat(jobID, other.Start, "other", time.Second)).To(Succeed())
+		Expect(tracker.Done(jobID, other.Start, "other")).To(Succeed())
+	})
+
+	It("Rolls back a publish made under a batch lease that another worker took over", func() {
+		jobID, err := tracker.Job(btc.ResyncJob, shared.Full, [2]uint64{0, 9}, batches[:1], false)
+		Expect(err).ToNot(HaveOccurred())
+		batch, err := tracker.Claim([]int64{jobID}, owner, time.Second, 3)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = db.Exec(`UPDATE btc.resync_batches SET lease_expires_at = NOW() - INTERVAL '1 second'`)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = tracker.Claim([]int64{jobID}, "other", time.Second, 3)
+		Expect(err).ToNot(HaveOccurred())
+
+		ctx := btc.WithBatchLease(context.Background(), jobID, batch.Start, owner)
+		for _, publisher := range []btc.Publisher{btc.NewIPLDPublisher(db), btc.NewBulkPublisher(db)} {
+			err = publisher.PublishBatch(ctx, []btc.ConvertedPayload{mocks.MockConvertedPayload})
+			Expect(err).To(Equal(btc.ErrLeaseLost))
+			var count int
+			Expect(db.Get(&count, `SELECT COUNT(*) FROM btc.header_cids`)).To(Succeed())
+			Expect(count).To(BeZero())
+		}
+
+		ctx = btc.WithBatchLease(context.Background(), jobID, batch.Start, "other")
+		Expect(btc.NewIPLDPublisher(db).PublishBatch(ctx, []btc.ConvertedPayload{mocks.MockConvertedPayload})).To(Succeed())
+	})
+
+	It("Reports the unfinished jobs of a kind and the ranges being filled", func() {
+		resyncID, err := tracker.Job(btc.ResyncJob, shared.Full, rng, batches, false)
+		Expect(err).ToNot(HaveOccurred())
+		backfillID, err := tracker.Job(btc.BackfillJob, shared.Full, [2]uint64{100, 109}, [][2]uint64{{100, 109}}, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tracker.Job(btc.BackfillJob, shared.Full, [2]uint64{200, 209}, [][2]uint64{{200, 209}}, false)).ToNot(Equal(backfillID))
+		_, err = db.Exec(`UPDATE btc.resync_batches SET status = $1 WHERE start_block = 200`, btc.BatchDone)
+		Expect(err).ToNot(HaveOccurred())
+
+		unfinished, err := tracker.Unfinished(btc.BackfillJob, 3)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unfinished).To(Equal([]int64{backfillID}))
+		Expect(unfinished).ToNot(ContainElement(resyncID))
+		filling, err := tracker.Filling(3)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(filling).To(Equal([][2]uint64{{0, 9}, {10, 19}, {20, 29}, {100, 109}}))
+	})
+
+	It("Only finishes a job once all of its batches are done", func() {
+		jobID, err := tracker.Job(btc.ResyncJob, shared.Full, rng, batches, false)
+		Expect(err).ToNot(HaveOccurred())
+		for _, start := range []uint64{0, 10} {
+			_, err := tracker.Claim([]int64{jobID}, owner, time.Minute, 3)
+			Expect(err).ToNot(HaveOccurred())
+			if start == 0 {
+				Expect(tracker.Done(jobID, start, owner)).To(Succeed())
+			} else {
+				Expect(tracker.Failed(jobID, start, owner, errors.New("node unavailable"))).To(Succeed())
+			}
+		}
+		unfinished, err := tracker.Finish(jobID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unfinished).To(HaveLen(2))
+		Expect(unfinished[0].Status).To(Equal(btc.BatchFailed))
+
+		for _, start := range []uint64{10, 20} {
+			batch, err := tracker.Claim([]int64{jobID}, owner, time.Minute, 3)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(batch.Start).To(Equal(start))
+			Expect(tracker.Done(jobID, start, owner)).To(Succeed())
+		}
+		unfinished, err = tracker.Finish(jobID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unfinished).To(BeEmpty())
+
+		newID, err := tracker.Job(btc.ResyncJob, shared.Full, rng, batches, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(newID).ToNot(Equal(jobID))
+	})
+})
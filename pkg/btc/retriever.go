@@ -23,13 +23,15 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/prom"
 )
 
 // Retriever interface fr substituting mocks in tests
 type Retriever interface {
 	RetrieveFirstBlockNumber() (int64, error)
 	RetrieveLastBlockNumber() (int64, error)
-	RetrieveGapsInData(validationLevel int) ([]DBGap, error)
+	RetrieveGapsInData() ([]DBGap, error)
+	RetrieveUnvalidatedHeights(validationLevel int) ([]uint64, error)
 }
 
 // GapRetriever type for Bitcoin
@@ -65,7 +67,7 @@ type DBGap struct {
 }
 
 // RetrieveGapsInData is used to find the the block numbers at which we are missing data in the db
-func (bcr *GapRetriever) RetrieveGapsInData(validationLevel int) ([]DBGap, error) {
+func (bcr *GapRetriever) RetrieveGapsInData() ([]DBGap, error) {
 	log.Info("searching for gaps in the btc ipfs watcher database")
 	startingBlock, err := bcr.RetrieveFirstBlockNumber()
 	if err != nil {
@@ -101,16 +103,22 @@ func (bcr *GapRetriever) RetrieveGapsInData(validationLevel int) ([]DBGap, error
 		}
 	}
 
-	// Find sections of blocks where we are below the validation level
-	// There will be no overlap between these "gaps" and the ones above
-	pgStr = `SELECT block_number FROM btc.header_cids
+	gaps := append(initialGap, emptyGaps...)
+	prom.SetGapCount(len(gaps))
+	return gaps, nil
+}
+
+// RetrieveUnvalidatedHeights is used to find the block numbers whose headers have been validated against the node
+// fewer than validationLevel times
+func (bcr *GapRetriever) RetrieveUnvalidatedHeights(validationLevel int) ([]uint64, error) {
+	pgStr := `SELECT DISTINCT block_number FROM btc.header_cids
 			WHERE times_validated < $1
 			ORDER BY block_number`
 	var heights []uint64
 	if err := bcr.db.Select(&heights, pgStr, validationLevel); err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
-	return append(append(initialGap, emptyGaps...), MissingHeightsToGaps(heights)...), nil
+	return heights, nil
 }
 
 // MissingHeightsToGaps returns a slice of gaps from a slice of missing block heights
@@ -121,7 +129,7 @@ func MissingHeightsToGaps(heights []uint64) []DBGap {
 	validationGaps := make([]DBGap, 0)
 	start := heights[0]
 	lastHeight := start
-	for i, height := range heights[1:] {
+	for _, height := range heights[1:] {
 		if height != lastHeight+1 {
 			validationGaps = append(validationGaps, DBGap{
 				Start: start,
@@ -129,13 +137,10 @@ func MissingHeightsToGaps(heights []uint64) []DBGap {
 			})
 			start = height
 		}
-		if i+2 == len(heights) {
-			validationGaps = append(validationGaps, DBGap{
-				Start: start,
-				Stop:  height,
-			})
-		}
 		lastHeight = height
 	}
-	return validationGaps
+	return append(validationGaps, DBGap{
+		Start: start,
+		Stop:  lastHeight,
+	})
 }
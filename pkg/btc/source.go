@@ -0,0 +1,116 @@
+// VulcanizeDB
+// Copyright © 2020 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/rpcclient"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+)
+
+const (
+	DefaultPollInterval = time.Second * 5
+	DefaultHTTPTimeout  = time.Second * 15
+)
+
+// SourceConfig holds the settings needed to build Fetchers and Streamers for the configured data source(s)
+type SourceConfig struct {
+	Type          shared.SourceType
+	RPCConfigs    []*rpcclient.ConnConfig // Bitcoin rpc client configs, used by the RPC source
+	EsploraPaths  []string                // Esplora api urls, used by the Esplora source
+	Timeout       time.Duration           // HTTP request timeout
+	CrossValidate bool                    // Fetch each block from two sources and compare them before publishing
+}
+
+// NewFetcher returns a Fetcher for the configured data source(s)
+// If more than one endpoint is configured, or cross-validation is on, a MultiFetcher is returned
+func NewFetcher(c SourceConfig) (Fetcher, error) {
+	var sources []FetcherSource
+	switch c.Type {
+	case shared.RPC:
+		for _, conf := range c.RPCConfigs {
+			fetcher, err := NewPayloadFetcher(conf)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, FetcherSource{Name: conf.Host, Fetcher: fetcher})
+		}
+	case shared.Esplora:
+		for _, path := range c.EsploraPaths {
+			sources = append(sources, FetcherSource{Name: path, Fetcher: NewEsploraFetcher(path, c.timeout())})
+		}
+	default:
+		return nil, fmt.Errorf("bitcoin fetcher: unsupported source type %s", c.Type.String())
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("bitcoin fetcher: no %s endpoints configured", c.Type.String())
+	}
+	if len(sources) == 1 && !c.CrossValidate {
+		return sources[0].Fetcher, nil
+	}
+	return NewMultiFetcher(sources, c.timeout(), c.CrossValidate)
+}
+
+// NewStreamer returns a Streamer for the configured data source; only the first endpoint is streamed from
+func NewStreamer(c SourceConfig) (Streamer, error) {
+	switch c.Type {
+	case shared.RPC:
+		if len(c.RPCConfigs) == 0 {
+			return nil, fmt.Errorf("bitcoin streamer: no %s endpoints configured", c.Type.String())
+		}
+		return NewHTTPPayloadStreamer(c.RPCConfigs[0]), nil
+	case shared.Esplora:
+		if len(c.EsploraPaths) == 0 {
+			return nil, fmt.Errorf("bitcoin streamer: no %s endpoints configured", c.Type.String())
+		}
+		return NewEsploraPayloadStreamer(c.EsploraPaths[0], c.timeout(), DefaultPollInterval), nil
+	default:
+		return nil, fmt.Errorf("bitcoin streamer: unsupported source type %s", c.Type.String())
+	}
+}
+
+// NewBlockClient returns a BlockClient for the first endpoint of the configured data source
+func NewBlockClient(c SourceConfig) (BlockClient, error) {
+	switch c.Type {
+	case shared.RPC:
+		if len(c.RPCConfigs) == 0 {
+			return nil, fmt.Errorf("bitcoin client: no %s endpoints configured", c.Type.String())
+		}
+		client, err := rpcclient.New(c.RPCConfigs[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		return instrument(client), nil
+	case shared.Esplora:
+		if len(c.EsploraPaths) == 0 {
+			return nil, fmt.Errorf("bitcoin client: no %s endpoints configured", c.Type.String())
+		}
+		return instrument(NewEsploraClient(c.EsploraPaths[0], c.timeout())), nil
+	default:
+		return nil, fmt.Errorf("bitcoin client: unsupported source type %s", c.Type.String())
+	}
+}
+
+func (c SourceConfig) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return DefaultHTTPTimeout
+	}
+	return c.Timeout
+}
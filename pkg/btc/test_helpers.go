@@ -35,9 +35,18 @@ func TearDownDB(db *postgres.DB) {
 	Expect(err).NotTo(HaveOccurred())
 	_, err = tx.Exec(`DELETE FROM btc.tx_outputs`)
 	Expect(err).NotTo(HaveOccurred())
+	_, err = tx.Exec(`DELETE FROM btc.validation_failures`)
+	Expect(err).NotTo(HaveOccurred())
+	_, err = tx.Exec(`DELETE FROM btc.resync_jobs`)
+	Expect(err).NotTo(HaveOccurred())
 	_, err = tx.Exec(`DELETE FROM blocks`)
 	Expect(err).NotTo(HaveOccurred())
 
 	err = tx.Commit()
 	Expect(err).NotTo(HaveOccurred())
 }
+
+// SetAfterMark sets a function for the garbage collector to call between marking and sweeping
+func (gc *DBGarbageCollector) SetAfterMark(afterMark func()) {
+	gc.afterMark = afterMark
+}
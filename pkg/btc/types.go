@@ -30,6 +30,9 @@ type BlockPayload struct {
 	BlockHeight int64
 	Header      *wire.BlockHeader
 	Txs         []*btcutil.Tx
+	// Set when cross-validation could not confirm this block against an independent source
+	// such blocks are still published, but are not counted as validated
+	Unvalidated bool
 }
 
 // ConvertedPayload is a custom type which packages raw BTC data for publishing to IPFS and filtering to subscribers
@@ -0,0 +1,145 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/ipfs/ipld"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+)
+
+// Validator interface for substituting mocks in tests
+type Validator interface {
+	Validate(payloads []BlockPayload) ([]BlockPayload, error)
+	// Vacuum vacuums the tables cleaned since it was last called; it is called once at the end of a validation pass
+	Vacuum() error
+}
+
+// DBValidator satisfies the Validator interface for bitcoin
+// It compares the indexed data with blocks freshly fetched from the node, only counting a header as validated when they match
+type DBValidator struct {
+	db      *postgres.DB
+	cleaner Cleaner
+	pruned  int32
+}
+
+// NewDBValidator returns a new DBValidator struct
+func NewDBValidator(db *postgres.DB) *DBValidator {
+	return &DBValidator{
+		db:      db,
+		cleaner: NewDBCleaner(db),
+	}
+}
+
+// Validate compares each fetched payload with the data indexed at its height
+// When the indexed header hash and transaction CIDs match it increments the header's times_validated; when they don't,
+// the mismatch is recorded in btc.validation_failures and the height is cleaned out, without vacuuming
+// It returns the payloads that failed validation so that the caller can resync their heights by publishing them
+// Payloads that cross-validation could not confirm are skipped
+func (v *DBValidator) Validate(payloads []BlockPayload) ([]BlockPayload, error) {
+	var failed []BlockPayload
+	var rngs [][2]uint64
+	for _, payload := range payloads {
+		if payload.Unvalidated {
+			logrus.Warnf("bitcoin validator skipping unconfirmed block at height %d", payload.BlockHeight)
+			continue
+		}
+		headerID, indexedHash, reason, err := v.compare(payload)
+		if err != nil {
+			return nil, err
+		}
+		if reason == "" {
+			if _, err := v.db.Exec(`UPDATE btc.header_cids SET times_validated = times_validated + 1 WHERE id = $1`, headerID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		logrus.Warnf("bitcoin block at height %d failed validation: %s", payload.BlockHeight, reason)
+		pgStr := `INSERT INTO btc.validation_failures (node_id, block_number, indexed_hash, node_hash, reason) VALUES ($1, $2, $3, $4, $5)`
+		if _, err := v.db.Exec(pgStr, v.db.NodeID, payload.BlockHeight, indexedHash, payload.Header.BlockHash().String(), reason); err != nil {
+			return nil, err
+		}
+		failed = append(failed, payload)
+		rngs = append(rngs, [2]uint64{uint64(payload.BlockHeight), uint64(payload.BlockHeight)})
+	}
+	if len(rngs) > 0 {
+		if err := v.cleaner.Prune(rngs, shared.Full); err != nil {
+			return nil, err
+		}
+		atomic.StoreInt32(&v.pruned, 1)
+	}
+	return failed, nil
+}
+
+// Vacuum vacuums the tables if any height was cleaned out since it was last called
+func (v *DBValidator) Vacuum() error {
+	if !atomic.CompareAndSwapInt32(&v.pruned, 1, 0) {
+		return nil
+	}
+	return v.cleaner.Vacuum(shared.Full)
+}
+
+// compare compares the payload with the data indexed at its height
+// it returns the matching header's id, or the indexed hash and the reason they don't match
+func (v *DBValidator) compare(payload BlockPayload) (int64, *string, string, error) {
+	var headers []struct {
+		ID        int64  `db:"id"`
+		BlockHash string `db:"block_hash"`
+	}
+	if err := v.db.Select(&headers, `SELECT id, block_hash FROM btc.header_cids WHERE block_number = $1`, payload.BlockHeight); err != nil {
+		return 0, nil, "", err
+	}
+	nodeHash := payload.Header.BlockHash().String()
+	switch {
+	case len(headers) == 0:
+		return 0, nil, "no header is indexed", nil
+	case len(headers) > 1:
+		for _, header := range headers {
+			if header.BlockHash != nodeHash {
+				hash := header.BlockHash
+				return 0, &hash, fmt.Sprintf("stale header %s is indexed alongside the node's", header.BlockHash), nil
+			}
+		}
+	case headers[0].BlockHash != nodeHash:
+		return 0, &headers[0].BlockHash, fmt.Sprintf("indexed header %s does not match the node's %s", headers[0].BlockHash, nodeHash), nil
+	}
+	header := headers[0]
+
+	var cids []string
+	pgStr := `SELECT cid FROM btc.transaction_cids WHERE block_number = $1 AND header_id = $2 ORDER BY index`
+	if err := v.db.Select(&cids, pgStr, payload.BlockHeight, header.ID); err != nil {
+		return 0, nil, "", err
+	}
+	if len(cids) != len(payload.Txs) {
+		return 0, &header.BlockHash, fmt.Sprintf("%d transactions are indexed, the node has %d", len(cids), len(payload.Txs)), nil
+	}
+	for i, tx := range payload.Txs {
+		txNode, err := ipld.NewBtcTx(tx.MsgTx())
+		if err != nil {
+			return 0, nil, "", err
+		}
+		if txNode.Cid().String() != cids[i] {
+			return 0, &header.BlockHash, fmt.Sprintf("transaction %d is indexed as %s, the node's is %s", i, cids[i], txNode.Cid().String()), nil
+		}
+	}
+	return header.ID, nil, "", nil
+}
@@ -0,0 +1,110 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc_test
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+)
+
+var _ = Describe("DBValidator", func() {
+	var (
+		db         *postgres.DB
+		err        error
+		validator  *btc.DBValidator
+		forkHeader = mocks.MockBlock.Header
+	)
+	forkHeader.Nonce++
+	BeforeEach(func() {
+		db, err = shared.SetupDB()
+		Expect(err).ToNot(HaveOccurred())
+		validator = btc.NewDBValidator(db)
+		err = btc.NewIPLDPublisher(db).Publish(context.Background(), mocks.MockConvertedPayload)
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		btc.TearDownDB(db)
+	})
+
+	It("Increments times_validated when the indexed block matches the node's", func() {
+		failed, err := validator.Validate([]btc.BlockPayload{mocks.MockBlockPayload})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(failed).To(BeEmpty())
+		var timesValidated int
+		err = db.Get(&timesValidated, `SELECT times_validated FROM btc.header_cids WHERE block_number = $1`, mocks.MockBlockHeight)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(timesValidated).To(Equal(1))
+		var failures int
+		err = db.Get(&failures, `SELECT count(*) FROM btc.validation_failures`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(failures).To(Equal(0))
+	})
+
+	It("Records a mismatched header and cleans out its height", func() {
+		payload := btc.BlockPayload{
+			BlockHeight: mocks.MockBlockHeight,
+			Header:      &forkHeader,
+			Txs:         mocks.MockTransactions,
+		}
+		failed, err := validator.Validate([]btc.BlockPayload{payload})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(failed).To(Equal([]btc.BlockPayload{payload}))
+		var failure struct {
+			IndexedHash string `db:"indexed_hash"`
+			NodeHash    string `db:"node_hash"`
+		}
+		err = db.Get(&failure, `SELECT indexed_hash, node_hash FROM btc.validation_failures WHERE block_number = $1`, mocks.MockBlockHeight)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(failure.IndexedHash).To(Equal(mocks.MockBlock.Header.BlockHash().String()))
+		Expect(failure.NodeHash).To(Equal(forkHeader.BlockHash().String()))
+		var headers int
+		err = db.Get(&headers, `SELECT count(*) FROM btc.header_cids`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(headers).To(Equal(0))
+		Expect(validator.Vacuum()).To(Succeed())
+	})
+
+	It("Records mismatched transactions", func() {
+		payload := mocks.MockBlockPayload
+		payload.Txs = mocks.MockTransactions[:1]
+		failed, err := validator.Validate([]btc.BlockPayload{payload})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(failed).To(HaveLen(1))
+		var reason string
+		err = db.Get(&reason, `SELECT reason FROM btc.validation_failures WHERE block_number = $1`, mocks.MockBlockHeight)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reason).To(ContainSubstring("transactions are indexed, the node has 1"))
+	})
+
+	It("Skips payloads that could not be cross-validated", func() {
+		payload := mocks.MockBlockPayload
+		payload.Unvalidated = true
+		failed, err := validator.Validate([]btc.BlockPayload{payload})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(failed).To(BeEmpty())
+		var timesValidated int
+		err = db.Get(&timesValidated, `SELECT times_validated FROM btc.header_cids WHERE block_number = $1`, mocks.MockBlockHeight)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(timesValidated).To(Equal(0))
+	})
+})
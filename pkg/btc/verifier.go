@@ -0,0 +1,251 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/ipfs/go-cid"
+	"github.com/lib/pq"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/ipfs/ipld"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+)
+
+// VerificationFailure describes an indexed header whose stored data failed verification
+type VerificationFailure struct {
+	BlockNumber int64
+	BlockHash   string
+	Reason      string
+}
+
+// Verifier interface for substituting mocks in tests
+type Verifier interface {
+	Verify(start, stop uint64) ([]VerificationFailure, error)
+}
+
+// DBVerifier satisfies the Verifier interface for bitcoin
+// For every header indexed in a block range it checks that each of its IPLDs (the header, the txs, and the tx trie) hashes
+// to its multihash key, that the raw header and txs decode to the indexed hashes, that the txs produce the header's
+// merkle root, and that the header's parent is indexed at the height below, if any header is
+type DBVerifier struct {
+	db *postgres.DB
+}
+
+// NewDBVerifier returns a new DBVerifier struct
+func NewDBVerifier(db *postgres.DB) *DBVerifier {
+	return &DBVerifier{
+		db: db,
+	}
+}
+
+type verifiedHeader struct {
+	ID          int64  `db:"id"`
+	BlockNumber int64  `db:"block_number"`
+	BlockHash   string `db:"block_hash"`
+	ParentHash  string `db:"parent_hash"`
+	CID         string `db:"cid"`
+	MhKey       string `db:"mh_key"`
+	Data        []byte `db:"data"`
+}
+
+type verifiedTx struct {
+	HeaderID int64  `db:"header_id"`
+	Index    int64  `db:"index"`
+	TxHash   string `db:"tx_hash"`
+	CID      string `db:"cid"`
+	MhKey    string `db:"mh_key"`
+	Data     []byte `db:"data"`
+}
+
+// Verify checks the data indexed within the block range, returning a VerificationFailure for every header that fails
+func (v *DBVerifier) Verify(start, stop uint64) ([]VerificationFailure, error) {
+	var headers []verifiedHeader
+	pgStr := `SELECT header_cids.id, block_number, block_hash, parent_hash, cid, mh_key, blocks.data
+			FROM btc.header_cids
+			LEFT JOIN public.blocks ON (blocks.key = header_cids.mh_key)
+			WHERE block_number BETWEEN $1 AND $2
+			ORDER BY block_number`
+	if err := v.db.Select(&headers, pgStr, start, stop); err != nil {
+		return nil, err
+	}
+	var txs []verifiedTx
+	pgStr = `SELECT header_id, index, tx_hash, cid, mh_key, blocks.data
+			FROM btc.transaction_cids
+			LEFT JOIN public.blocks ON (blocks.key = transaction_cids.mh_key)
+			WHERE block_number BETWEEN $1 AND $2
+			ORDER BY header_id, index`
+	if err := v.db.Select(&txs, pgStr, start, stop); err != nil {
+		return nil, err
+	}
+	txsByHeader := make(map[int64][]verifiedTx)
+	for _, tx := range txs {
+		txsByHeader[tx.HeaderID] = append(txsByHeader[tx.HeaderID], tx)
+	}
+	// the hashes indexed at each height, including the one below the range, to check that parents are chained
+	var parentStart uint64
+	if start > 0 {
+		parentStart = start - 1
+	}
+	var indexed []struct {
+		BlockNumber int64  `db:"block_number"`
+		BlockHash   string `db:"block_hash"`
+	}
+	pgStr = `SELECT block_number, block_hash FROM btc.header_cids WHERE block_number BETWEEN $1 AND $2`
+	if err := v.db.Select(&indexed, pgStr, parentStart, stop); err != nil {
+		return nil, err
+	}
+	hashesAt := make(map[int64][]string)
+	for _, header := range indexed {
+		hashesAt[header.BlockNumber] = append(hashesAt[header.BlockNumber], header.BlockHash)
+	}
+
+	var failures []VerificationFailure
+	for _, header := range headers {
+		reason, err := v.verify(header, txsByHeader[header.ID], hashesAt[header.BlockNumber-1])
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			failures = append(failures, VerificationFailure{
+				BlockNumber: header.BlockNumber,
+				BlockHash:   header.BlockHash,
+				Reason:      reason,
+			})
+		}
+	}
+	return failures, nil
+}
+
+// verify checks a single header and its txs, returning the reason it failed or an empty string if it passed
+func (v *DBVerifier) verify(header verifiedHeader, txs []verifiedTx, parents []string) (string, error) {
+	if reason := checkIPLD("header", header.CID, header.MhKey, header.Data); reason != "" {
+		return reason, nil
+	}
+	var wireHeader wire.BlockHeader
+	if err := wireHeader.Deserialize(bytes.NewReader(header.Data)); err != nil {
+		return fmt.Sprintf("header IPLD does not decode: %v", err), nil
+	}
+	if wireHeader.BlockHash().String() != header.BlockHash {
+		return fmt.Sprintf("header IPLD hashes to %s", wireHeader.BlockHash().String()), nil
+	}
+	if wireHeader.PrevBlock.String() != header.ParentHash {
+		return fmt.Sprintf("indexed parent hash %s does not match header IPLD parent %s", header.ParentHash, wireHeader.PrevBlock.String()), nil
+	}
+	if len(parents) > 0 && !containsString(parents, header.ParentHash) {
+		return fmt.Sprintf("parent %s is not among the headers indexed at height %d", header.ParentHash, header.BlockNumber-1), nil
+	}
+
+	if len(txs) == 0 {
+		return "no transactions indexed", nil
+	}
+	msgTxs := make([]*btcutil.Tx, len(txs))
+	txCIDs := make([]cid.Cid, len(txs))
+	for i, tx := range txs {
+		if tx.Index != int64(i) {
+			return fmt.Sprintf("transaction %d is not indexed", i), nil
+		}
+		if reason := checkIPLD(fmt.Sprintf("transaction %d", i), tx.CID, tx.MhKey, tx.Data); reason != "" {
+			return reason, nil
+		}
+		msgTx := new(wire.MsgTx)
+		if err := msgTx.Deserialize(bytes.NewReader(tx.Data)); err != nil {
+			return fmt.Sprintf("transaction %d IPLD does not decode: %v", i, err), nil
+		}
+		msgTxs[i] = btcutil.NewTx(msgTx)
+		if msgTxs[i].Hash().String() != tx.TxHash {
+			return fmt.Sprintf("transaction %d IPLD hashes to %s, not the indexed %s", i, msgTxs[i].Hash().String(), tx.TxHash), nil
+		}
+		var err error
+		if txCIDs[i], err = cid.Decode(tx.CID); err != nil {
+			return "", err
+		}
+	}
+	merkles := blockchain.BuildMerkleTreeStore(msgTxs, false)
+	if root := merkles[len(merkles)-1]; !root.IsEqual(&wireHeader.MerkleRoot) {
+		return fmt.Sprintf("transactions produce merkle root %s, header has %s", root.String(), wireHeader.MerkleRoot.String()), nil
+	}
+
+	trie := ipld.TxTrieFromCIDs(txCIDs)
+	keys := make([]string, len(trie))
+	for i, node := range trie {
+		keys[i] = shared.MultihashKeyFromCID(node.Cid())
+	}
+	var stored []struct {
+		Key  string `db:"key"`
+		Data []byte `db:"data"`
+	}
+	if err := v.db.Select(&stored, `SELECT key, data FROM public.blocks WHERE key = ANY($1)`, pq.Array(keys)); err != nil {
+		return "", err
+	}
+	storedData := make(map[string][]byte, len(stored))
+	for _, block := range stored {
+		storedData[block.Key] = block.Data
+	}
+	for i, node := range trie {
+		data, ok := storedData[keys[i]]
+		if !ok {
+			return fmt.Sprintf("tx trie node %s is missing", node.Cid().String()), nil
+		}
+		if !bytes.Equal(data, node.RawData()) {
+			return fmt.Sprintf("tx trie node %s does not match its stored data", node.Cid().String()), nil
+		}
+	}
+	return "", nil
+}
+
+// checkIPLD checks that the data is present, hashes to its multihash key, and that the key matches the CID
+// it returns the reason the check failed, or an empty string if it passed
+func checkIPLD(name, c, mhKey string, data []byte) string {
+	if data == nil {
+		return fmt.Sprintf("%s IPLD is missing", name)
+	}
+	if key, err := shared.MultihashKeyFromCIDString(c); err != nil || key != mhKey {
+		return fmt.Sprintf("%s CID %s does not match its mh_key", name, c)
+	}
+	hash, err := shared.MultihashFromKey(mhKey)
+	if err != nil {
+		return fmt.Sprintf("%s mh_key does not decode: %v", name, err)
+	}
+	decoded, err := multihash.Decode(hash)
+	if err != nil {
+		return fmt.Sprintf("%s mh_key does not decode: %v", name, err)
+	}
+	sum, err := multihash.Sum(data, decoded.Code, decoded.Length)
+	if err != nil {
+		return fmt.Sprintf("%s IPLD can't be rehashed: %v", name, err)
+	}
+	if !bytes.Equal(sum, hash) {
+		return fmt.Sprintf("%s IPLD does not hash to its key", name)
+	}
+	return ""
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
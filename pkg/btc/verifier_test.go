@@ -0,0 +1,106 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btc_test
+
+import (
+	"context"
+	"github.com/btcsuite/btcd/blockchain"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+)
+
+// consistentPayload returns the mock payload at the given height with its header committing to its txs
+func consistentPayload(height int64) btc.ConvertedPayload {
+	payload := mocks.MockConvertedPayload
+	payload.BlockHeight = height
+	header := *payload.Header
+	merkles := blockchain.BuildMerkleTreeStore(payload.Txs, false)
+	header.MerkleRoot = *merkles[len(merkles)-1]
+	payload.Header = &header
+	return payload
+}
+
+var _ = Describe("DBVerifier", func() {
+	var (
+		db       *postgres.DB
+		err      error
+		verifier *btc.DBVerifier
+		height   = uint64(mocks.MockBlockHeight)
+	)
+	BeforeEach(func() {
+		db, err = shared.SetupDB()
+		Expect(err).ToNot(HaveOccurred())
+		verifier = btc.NewDBVerifier(db)
+		err = btc.NewIPLDPublisher(db).Publish(context.Background(), consistentPayload(mocks.MockBlockHeight))
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		btc.TearDownDB(db)
+	})
+
+	It("Passes a block whose stored data is consistent", func() {
+		failures, err := verifier.Verify(height, height)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(failures).To(BeEmpty())
+	})
+
+	It("Reports an IPLD that does not hash to its key", func() {
+		_, err = db.Exec(`UPDATE public.blocks SET data = $1
+			WHERE key = (SELECT mh_key FROM btc.transaction_cids WHERE index = 1)`, []byte("corrupted"))
+		Expect(err).ToNot(HaveOccurred())
+		failures, err := verifier.Verify(height, height)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(failures).To(HaveLen(1))
+		Expect(failures[0].BlockNumber).To(Equal(mocks.MockBlockHeight))
+		Expect(failures[0].Reason).To(ContainSubstring("transaction 1 IPLD does not hash to its key"))
+	})
+
+	It("Reports an indexed tx hash that does not match the tx IPLD", func() {
+		_, err = db.Exec(`UPDATE btc.transaction_cids SET tx_hash = 'wrong' WHERE index = 0`)
+		Expect(err).ToNot(HaveOccurred())
+		failures, err := verifier.Verify(height, height)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(failures).To(HaveLen(1))
+		Expect(failures[0].Reason).To(ContainSubstring("not the indexed wrong"))
+	})
+
+	It("Reports missing tx trie nodes", func() {
+		_, err = db.Exec(`DELETE FROM public.blocks
+			WHERE key NOT IN (SELECT mh_key FROM btc.header_cids)
+			AND key NOT IN (SELECT mh_key FROM btc.transaction_cids)`)
+		Expect(err).ToNot(HaveOccurred())
+		failures, err := verifier.Verify(height, height)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(failures).To(HaveLen(1))
+		Expect(failures[0].Reason).To(ContainSubstring("tx trie node"))
+	})
+
+	It("Reports a header whose parent is not indexed at the height below", func() {
+		err = btc.NewIPLDPublisher(db).Publish(context.Background(), consistentPayload(mocks.MockBlockHeight-1))
+		Expect(err).ToNot(HaveOccurred())
+		failures, err := verifier.Verify(height-1, height)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(failures).To(HaveLen(1))
+		Expect(failures[0].BlockNumber).To(Equal(mocks.MockBlockHeight))
+		Expect(failures[0].Reason).To(ContainSubstring("is not among the headers indexed at height"))
+	})
+})
@@ -0,0 +1,63 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btcrpc
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// Env variables
+const (
+	RPC_ENABLED   = "RPC_ENABLED"
+	RPC_HTTP_ADDR = "RPC_HTTP_ADDR"
+	RPC_HTTP_PORT = "RPC_HTTP_PORT"
+	RPC_USER      = "RPC_USER"
+	RPC_PASSWORD  = "RPC_PASSWORD"
+)
+
+// Config holds the settings for the bitcoind JSON-RPC compatible server
+type Config struct {
+	Enabled  bool // Serve the RPC from the serve command
+	HTTPAddr string
+	HTTPPort int
+	User     string // Basic auth credentials required of clients, if set
+	Password string
+}
+
+// NewConfig is used to initialize an rpc config from a .toml file
+func NewConfig() Config {
+	viper.BindEnv("rpc.enabled", RPC_ENABLED)
+	viper.BindEnv("rpc.httpAddr", RPC_HTTP_ADDR)
+	viper.BindEnv("rpc.httpPort", RPC_HTTP_PORT)
+	viper.BindEnv("rpc.user", RPC_USER)
+	viper.BindEnv("rpc.password", RPC_PASSWORD)
+
+	return Config{
+		Enabled:  viper.GetBool("rpc.enabled"),
+		HTTPAddr: viper.GetString("rpc.httpAddr"),
+		HTTPPort: viper.GetInt("rpc.httpPort"),
+		User:     viper.GetString("rpc.user"),
+		Password: viper.GetString("rpc.password"),
+	}
+}
+
+// Address returns the host:port the rpc is served on
+func (c Config) Address() string {
+	return fmt.Sprintf("%s:%d", c.HTTPAddr, c.HTTPPort)
+}
@@ -0,0 +1,405 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btcrpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+)
+
+var (
+	errBlockNotFound = btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey, "Block not found")
+	errTxNotFound    = btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey, "No such mempool or blockchain transaction")
+	errOutOfRange    = btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter, "Block height out of range")
+)
+
+// getblockcount returns the height of the indexed tip
+func (s *Server) getBlockCount(params []json.RawMessage) (interface{}, error) {
+	tip, err := s.reader.Tip()
+	if err != nil {
+		return nil, err
+	}
+	return tip.BlockNumber, nil
+}
+
+// getbestblockhash returns the hash of the indexed tip
+func (s *Server) getBestBlockHash(params []json.RawMessage) (interface{}, error) {
+	tip, err := s.reader.Tip()
+	if err != nil {
+		return nil, err
+	}
+	return tip.BlockHash, nil
+}
+
+// getblockhash height returns the hash of the canonical block at the height
+func (s *Server) getBlockHash(params []json.RawMessage) (interface{}, error) {
+	var height int64
+	if err := requiredParam(params, 0, "height", &height); err != nil {
+		return nil, err
+	}
+	tip, err := s.reader.Tip()
+	if err != nil {
+		return nil, err
+	}
+	if height < 0 || height > tip.BlockNumber {
+		return nil, errOutOfRange
+	}
+	header, err := s.reader.HeaderAt(height)
+	if errors.Is(err, btc.ErrNotIndexed) {
+		return nil, errBlockNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return header.BlockHash, nil
+}
+
+// getblockheader blockhash ( verbose ) returns the serialized header as hex, or its fields if verbose (the default)
+func (s *Server) getBlockHeader(params []json.RawMessage) (interface{}, error) {
+	hash, err := hashParam(params, 0, "blockhash")
+	if err != nil {
+		return nil, err
+	}
+	verbose := true
+	if _, err := param(params, 1, "verbose", &verbose); err != nil {
+		return nil, err
+	}
+	header, err := s.header(hash)
+	if err != nil {
+		return nil, err
+	}
+	wireHeader, err := header.WireHeader()
+	if err != nil {
+		return nil, err
+	}
+	if !verbose {
+		return hex.EncodeToString(header.Data), nil
+	}
+	ctx, err := s.chainContext(header)
+	if err != nil {
+		return nil, err
+	}
+	return s.headerResult(header, wireHeader, ctx), nil
+}
+
+// getblock blockhash ( verbosity ) returns the serialized block as hex for verbosity 0, its fields and txids for
+// verbosity 1 (the default), and its fields and decoded transactions for verbosity 2
+func (s *Server) getBlock(params []json.RawMessage) (interface{}, error) {
+	hash, err := hashParam(params, 0, "blockhash")
+	if err != nil {
+		return nil, err
+	}
+	verbosity, err := verbosityParam(params, 1, "verbosity", 1)
+	if err != nil {
+		return nil, err
+	}
+	header, err := s.header(hash)
+	if err != nil {
+		return nil, err
+	}
+	msgBlock, err := s.reader.BlockFromIPLDs(header)
+	if err != nil {
+		return nil, err
+	}
+	block := btcutil.NewBlock(msgBlock)
+	if verbosity <= 0 {
+		raw, err := block.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		return hex.EncodeToString(raw), nil
+	}
+	ctx, err := s.chainContext(header)
+	if err != nil {
+		return nil, err
+	}
+	return s.blockResult(header, block, ctx, verbosity >= 2)
+}
+
+// getrawtransaction txid ( verbose blockhash ) returns the serialized transaction as hex, or its fields if verbose
+// Every transaction in the canonical chain can be looked up, as with bitcoind's txindex; if blockhash is given the
+// transaction is looked up in that block, whether or not it is canonical
+func (s *Server) getRawTransaction(params []json.RawMessage) (interface{}, error) {
+	txid, err := hashParam(params, 0, "txid")
+	if err != nil {
+		return nil, err
+	}
+	verbosity, err := verbosityParam(params, 1, "verbose", 0)
+	if err != nil {
+		return nil, err
+	}
+	var tx *btc.IndexedTx
+	var header *btc.IndexedHeader
+	if len(params) > 2 && string(params[2]) != "null" {
+		blockHash, err := hashParam(params, 2, "blockhash")
+		if err != nil {
+			return nil, err
+		}
+		if header, err = s.header(blockHash); err != nil {
+			return nil, err
+		}
+		if tx, err = s.txInBlock(header, txid); err != nil {
+			return nil, err
+		}
+	} else {
+		tx, header, err = s.reader.Tx(txid)
+		if errors.Is(err, btc.ErrNotIndexed) {
+			return nil, errTxNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if verbosity <= 0 {
+		return hex.EncodeToString(tx.Data), nil
+	}
+	msgTx, err := tx.MsgTx()
+	if err != nil {
+		return nil, err
+	}
+	wireHeader, err := header.WireHeader()
+	if err != nil {
+		return nil, err
+	}
+	ctx, err := s.chainContext(header)
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.txResult(msgTx)
+	if err != nil {
+		return nil, err
+	}
+	result.BlockHash = header.BlockHash
+	if ctx.confirmations > 0 {
+		result.Confirmations = uint64(ctx.confirmations)
+	}
+	result.Time = wireHeader.Timestamp.Unix()
+	result.Blocktime = wireHeader.Timestamp.Unix()
+	return result, nil
+}
+
+// gettxout txid n ( include_mempool ) returns the output if it is unspent in the canonical chain, and null otherwise
+// Outputs are considered unspent when no indexed transaction spends them, so spends in blocks missing from the index go unseen
+func (s *Server) getTxOut(params []json.RawMessage) (interface{}, error) {
+	txid, err := hashParam(params, 0, "txid")
+	if err != nil {
+		return nil, err
+	}
+	var n uint32
+	if err := requiredParam(params, 1, "n", &n); err != nil {
+		return nil, err
+	}
+	tx, header, err := s.reader.Tx(txid)
+	if errors.Is(err, btc.ErrNotIndexed) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	msgTx, err := tx.MsgTx()
+	if err != nil {
+		return nil, err
+	}
+	if int(n) >= len(msgTx.TxOut) {
+		return nil, nil
+	}
+	_, err = s.reader.Spender(txid, n)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, btc.ErrNotIndexed) {
+		return nil, err
+	}
+	tip, err := s.reader.Tip()
+	if err != nil {
+		return nil, err
+	}
+	out := msgTx.TxOut[n]
+	return &btcjson.GetTxOutResult{
+		BestBlock:     tip.BlockHash,
+		Confirmations: tip.BlockNumber - header.BlockNumber + 1,
+		Value:         btcutil.Amount(out.Value).ToBTC(),
+		ScriptPubKey:  s.scriptPubKey(out.PkScript),
+		Coinbase:      blockchain.IsCoinBaseTx(msgTx),
+	}, nil
+}
+
+// gettxoutproof ["txid",...] ( blockhash ) returns a hex encoded proof that the transactions are in a block
+// The block is the one given, whether or not it is canonical, or else the canonical block including the first transaction
+func (s *Server) getTxOutProof(params []json.RawMessage) (interface{}, error) {
+	var raw []json.RawMessage
+	if err := requiredParam(params, 0, "txids", &raw); err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter, "Invalid parameter, txids must not be empty")
+	}
+	txids := make([]string, len(raw))
+	seen := make(map[string]bool, len(raw))
+	for i := range raw {
+		txid, err := hashParam(raw, i, "txid")
+		if err != nil {
+			return nil, err
+		}
+		if seen[txid] {
+			return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter, "Invalid parameter, duplicated txid: "+txid)
+		}
+		seen[txid] = true
+		txids[i] = txid
+	}
+	var header *btc.IndexedHeader
+	if len(params) > 1 && string(params[1]) != "null" {
+		blockHash, err := hashParam(params, 1, "blockhash")
+		if err != nil {
+			return nil, err
+		}
+		if header, err = s.header(blockHash); err != nil {
+			return nil, err
+		}
+	} else {
+		_, txHeader, err := s.reader.Tx(txids[0])
+		if errors.Is(err, btc.ErrNotIndexed) {
+			return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey, "Transaction not yet in block")
+		}
+		if err != nil {
+			return nil, err
+		}
+		header = txHeader
+	}
+	proof, err := s.reader.TxOutProof(header, txids)
+	if errors.Is(err, btc.ErrNotIndexed) {
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey, "Not all transactions found in specified or retrieved block")
+	}
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err := proof.BtcEncode(buf, wire.ProtocolVersion, wire.BaseEncoding); err != nil {
+		return nil, err
+	}
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+// verifytxoutproof proof returns the txids the proof commits to, or an empty list if it does not verify
+// The proof's block must be canonical, and must have as many transactions as the proof claims
+func (s *Server) verifyTxOutProof(params []json.RawMessage) (interface{}, error) {
+	var str string
+	if err := requiredParam(params, 0, "proof", &str); err != nil {
+		return nil, err
+	}
+	raw, err := hex.DecodeString(str)
+	if err != nil {
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter, fmt.Sprintf("proof must be hexadecimal string (not '%s')", str))
+	}
+	proof := new(wire.MsgMerkleBlock)
+	if err := proof.BtcDecode(bytes.NewReader(raw), wire.ProtocolVersion, wire.BaseEncoding); err != nil {
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCDeserialization, fmt.Sprintf("proof does not decode: %v", err))
+	}
+	txids := []string{}
+	proved, err := btc.VerifyTxOutProof(proof)
+	if err != nil {
+		return txids, nil
+	}
+	header, err := s.reader.HeaderByHash(proof.Header.BlockHash().String())
+	if errors.Is(err, btc.ErrNotIndexed) {
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey, "Block not found in chain")
+	}
+	if err != nil {
+		return nil, err
+	}
+	canonical, err := s.reader.IsCanonical(header)
+	if err != nil {
+		return nil, err
+	}
+	if !canonical {
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey, "Block not found in chain")
+	}
+	txs, err := s.reader.Txs(header)
+	if err != nil {
+		return nil, err
+	}
+	if uint32(len(txs)) != proof.Transactions {
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey, "Invalid number of transactions")
+	}
+	for i := range proved {
+		txids = append(txids, proved[i].String())
+	}
+	return txids, nil
+}
+
+// header returns the indexed header with the hash
+func (s *Server) header(hash string) (*btc.IndexedHeader, error) {
+	header, err := s.reader.HeaderByHash(hash)
+	if errors.Is(err, btc.ErrNotIndexed) {
+		return nil, errBlockNotFound
+	}
+	return header, err
+}
+
+// txInBlock returns the transaction with the txid from the block
+func (s *Server) txInBlock(header *btc.IndexedHeader, txid string) (*btc.IndexedTx, error) {
+	txs, err := s.reader.Txs(header)
+	if err != nil {
+		return nil, err
+	}
+	for i := range txs {
+		if txs[i].TxHash == txid {
+			return &txs[i], nil
+		}
+	}
+	return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey, "No such transaction found in the provided block")
+}
+
+// chainContext describes where the header sits relative to the canonical chain
+type chainContext struct {
+	// blocks on top of and including the header, or -1 if it is not canonical, as bitcoind reports it
+	confirmations int64
+	// the hash of the canonical block above the header, if there is one
+	nextHash string
+}
+
+func (s *Server) chainContext(header *btc.IndexedHeader) (chainContext, error) {
+	canonical, err := s.reader.IsCanonical(header)
+	if err != nil {
+		return chainContext{}, err
+	}
+	if !canonical {
+		return chainContext{confirmations: -1}, nil
+	}
+	tip, err := s.reader.Tip()
+	if err != nil {
+		return chainContext{}, err
+	}
+	ctx := chainContext{confirmations: tip.BlockNumber - header.BlockNumber + 1}
+	next, err := s.reader.HeaderAt(header.BlockNumber + 1)
+	if err == nil && next.ParentHash == header.BlockHash {
+		ctx.nextHash = next.BlockHash
+	} else if err != nil && !errors.Is(err, btc.ErrNotIndexed) {
+		return chainContext{}, err
+	}
+	return ctx, nil
+}
@@ -0,0 +1,203 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btcrpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+)
+
+// BlockResult models the data returned by getblock for verbosity 1 and 2
+// It differs from btcjson.GetBlockVerboseResult in returning the decoded transactions under tx, as bitcoind does
+type BlockResult struct {
+	Hash          string      `json:"hash"`
+	Confirmations int64       `json:"confirmations"`
+	StrippedSize  int32       `json:"strippedsize"`
+	Size          int32       `json:"size"`
+	Weight        int32       `json:"weight"`
+	Height        int64       `json:"height"`
+	Version       int32       `json:"version"`
+	VersionHex    string      `json:"versionHex"`
+	MerkleRoot    string      `json:"merkleroot"`
+	Tx            interface{} `json:"tx"`
+	Time          int64       `json:"time"`
+	Nonce         uint32      `json:"nonce"`
+	Bits          string      `json:"bits"`
+	Difficulty    float64     `json:"difficulty"`
+	NTx           int         `json:"nTx"`
+	PreviousHash  string      `json:"previousblockhash,omitempty"`
+	NextHash      string      `json:"nextblockhash,omitempty"`
+}
+
+func (s *Server) headerResult(header *btc.IndexedHeader, wireHeader *wire.BlockHeader, ctx chainContext) *btcjson.GetBlockHeaderVerboseResult {
+	result := &btcjson.GetBlockHeaderVerboseResult{
+		Hash:          header.BlockHash,
+		Confirmations: ctx.confirmations,
+		Height:        int32(header.BlockNumber),
+		Version:       wireHeader.Version,
+		VersionHex:    fmt.Sprintf("%08x", wireHeader.Version),
+		MerkleRoot:    wireHeader.MerkleRoot.String(),
+		Time:          wireHeader.Timestamp.Unix(),
+		Nonce:         uint64(wireHeader.Nonce),
+		Bits:          fmt.Sprintf("%08x", wireHeader.Bits),
+		Difficulty:    s.difficulty(wireHeader.Bits),
+		NextHash:      ctx.nextHash,
+	}
+	if header.BlockNumber > 0 {
+		result.PreviousHash = wireHeader.PrevBlock.String()
+	}
+	return result
+}
+
+func (s *Server) blockResult(header *btc.IndexedHeader, block *btcutil.Block, ctx chainContext, decodeTxs bool) (*BlockResult, error) {
+	msgBlock := block.MsgBlock()
+	wireHeader := msgBlock.Header
+	result := &BlockResult{
+		Hash:          header.BlockHash,
+		Confirmations: ctx.confirmations,
+		StrippedSize:  int32(msgBlock.SerializeSizeStripped()),
+		Size:          int32(msgBlock.SerializeSize()),
+		Weight:        int32(blockchain.GetBlockWeight(block)),
+		Height:        header.BlockNumber,
+		Version:       wireHeader.Version,
+		VersionHex:    fmt.Sprintf("%08x", wireHeader.Version),
+		MerkleRoot:    wireHeader.MerkleRoot.String(),
+		Time:          wireHeader.Timestamp.Unix(),
+		Nonce:         wireHeader.Nonce,
+		Bits:          fmt.Sprintf("%08x", wireHeader.Bits),
+		Difficulty:    s.difficulty(wireHeader.Bits),
+		NTx:           len(msgBlock.Transactions),
+		NextHash:      ctx.nextHash,
+	}
+	if header.BlockNumber > 0 {
+		result.PreviousHash = wireHeader.PrevBlock.String()
+	}
+	if !decodeTxs {
+		txids := make([]string, len(msgBlock.Transactions))
+		for i, tx := range block.Transactions() {
+			txids[i] = tx.Hash().String()
+		}
+		result.Tx = txids
+		return result, nil
+	}
+	txs := make([]*btcjson.TxRawResult, len(msgBlock.Transactions))
+	for i, msgTx := range msgBlock.Transactions {
+		tx, err := s.txResult(msgTx)
+		if err != nil {
+			return nil, err
+		}
+		txs[i] = tx
+	}
+	result.Tx = txs
+	return result, nil
+}
+
+// txResult returns the decoded transaction, without the fields describing the block it is in
+func (s *Server) txResult(msgTx *wire.MsgTx) (*btcjson.TxRawResult, error) {
+	var buf bytes.Buffer
+	if err := msgTx.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	tx := btcutil.NewTx(msgTx)
+	weight := blockchain.GetTransactionWeight(tx)
+	result := &btcjson.TxRawResult{
+		Hex:      hex.EncodeToString(buf.Bytes()),
+		Txid:     tx.Hash().String(),
+		Hash:     tx.WitnessHash().String(),
+		Size:     int32(msgTx.SerializeSize()),
+		Vsize:    int32((weight + blockchain.WitnessScaleFactor - 1) / blockchain.WitnessScaleFactor),
+		Weight:   int32(weight),
+		Version:  msgTx.Version,
+		LockTime: msgTx.LockTime,
+		Vin:      make([]btcjson.Vin, len(msgTx.TxIn)),
+		Vout:     make([]btcjson.Vout, len(msgTx.TxOut)),
+	}
+	coinbase := blockchain.IsCoinBaseTx(msgTx)
+	for i, in := range msgTx.TxIn {
+		vin := btcjson.Vin{
+			Sequence: in.Sequence,
+			Witness:  witnessToHex(in.Witness),
+		}
+		if coinbase {
+			vin.Coinbase = hex.EncodeToString(in.SignatureScript)
+		} else {
+			asm, _ := txscript.DisasmString(in.SignatureScript)
+			vin.Txid = in.PreviousOutPoint.Hash.String()
+			vin.Vout = in.PreviousOutPoint.Index
+			vin.ScriptSig = &btcjson.ScriptSig{
+				Asm: asm,
+				Hex: hex.EncodeToString(in.SignatureScript),
+			}
+		}
+		result.Vin[i] = vin
+	}
+	for i, out := range msgTx.TxOut {
+		result.Vout[i] = btcjson.Vout{
+			Value:        btcutil.Amount(out.Value).ToBTC(),
+			N:            uint32(i),
+			ScriptPubKey: s.scriptPubKey(out.PkScript),
+		}
+	}
+	return result, nil
+}
+
+func (s *Server) scriptPubKey(pkScript []byte) btcjson.ScriptPubKeyResult {
+	asm, _ := txscript.DisasmString(pkScript)
+	class, addrs, reqSigs, _ := txscript.ExtractPkScriptAddrs(pkScript, s.params)
+	var addresses []string
+	for _, addr := range addrs {
+		addresses = append(addresses, addr.EncodeAddress())
+	}
+	return btcjson.ScriptPubKeyResult{
+		Asm:       asm,
+		Hex:       hex.EncodeToString(pkScript),
+		ReqSigs:   int32(reqSigs),
+		Type:      class.String(),
+		Addresses: addresses,
+	}
+}
+
+// difficulty returns the ratio of the chain's proof of work limit to the target the bits encode
+func (s *Server) difficulty(bits uint32) float64 {
+	target := blockchain.CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return 0
+	}
+	ratio, _ := new(big.Rat).SetFrac(blockchain.CompactToBig(s.params.PowLimitBits), target).Float64()
+	return ratio
+}
+
+func witnessToHex(witness wire.TxWitness) []string {
+	if len(witness) == 0 {
+		return nil
+	}
+	items := make([]string, len(witness))
+	for i, item := range witness {
+		items[i] = hex.EncodeToString(item)
+	}
+	return items
+}
@@ -0,0 +1,220 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btcrpc
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+)
+
+// MaxRequestSize bounds the size of a request body
+const MaxRequestSize = 1 << 20
+
+// handler answers a single method call; a *btcjson.RPCError it returns is passed on to the client as is
+type handler func(s *Server, params []json.RawMessage) (interface{}, error)
+
+// handlers maps the supported bitcoind methods to their handlers
+var handlers = map[string]handler{
+	"getbestblockhash":  (*Server).getBestBlockHash,
+	"getblock":          (*Server).getBlock,
+	"getblockcount":     (*Server).getBlockCount,
+	"getblockhash":      (*Server).getBlockHash,
+	"getblockheader":    (*Server).getBlockHeader,
+	"getrawtransaction": (*Server).getRawTransaction,
+	"gettxout":          (*Server).getTxOut,
+	"gettxoutproof":     (*Server).getTxOutProof,
+	"verifytxoutproof":  (*Server).verifyTxOutProof,
+}
+
+// Server answers a read-only subset of the bitcoind JSON-RPC API from the index, over http
+// Requests are JSON-RPC 1.0 objects, or arrays of them for batches, and responses follow bitcoind's format and error codes
+type Server struct {
+	reader btc.ChainReader
+	// Chain config used to encode addresses and compute difficulty
+	params   *chaincfg.Params
+	user     string
+	password string
+}
+
+// NewServer creates a Server reading from the chain reader; if user is set, clients must authenticate with it and the password
+func NewServer(reader btc.ChainReader, params *chaincfg.Params, user, password string) *Server {
+	return &Server{
+		reader:   reader,
+		params:   params,
+		user:     user,
+		password: password,
+	}
+}
+
+// ServeHTTP satisfies the http.Handler interface
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "JSONRPC server handles only POST requests", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="jsonrpc"`)
+		http.Error(w, "", http.StatusUnauthorized)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var requests []json.RawMessage
+		if err := json.Unmarshal(body, &requests); err != nil {
+			s.write(w, http.StatusInternalServerError, response(nil, nil, btcjson.ErrRPCParse))
+			return
+		}
+		responses := make([]json.RawMessage, len(requests))
+		for i, request := range requests {
+			responses[i], _ = s.handle(request)
+		}
+		batch, err := json.Marshal(responses)
+		if err != nil {
+			log.Errorf("bitcoin json-rpc error marshalling batch response: %v", err)
+		}
+		s.write(w, http.StatusOK, batch)
+		return
+	}
+	resp, status := s.handle(body)
+	s.write(w, status, resp)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.user == "" {
+		return true
+	}
+	user, password, ok := r.BasicAuth()
+	return ok &&
+		subtle.ConstantTimeCompare([]byte(user), []byte(s.user)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) == 1
+}
+
+func (s *Server) write(w http.ResponseWriter, status int, body []byte) {
+	w.WriteHeader(status)
+	if _, err := w.Write(append(body, '\n')); err != nil {
+		log.Debugf("bitcoin json-rpc error writing response: %v", err)
+	}
+}
+
+// handle answers a single request, returning the response along with the http status bitcoind would send it with
+func (s *Server) handle(raw json.RawMessage) (json.RawMessage, int) {
+	var req btcjson.Request
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return response(nil, nil, btcjson.ErrRPCParse), http.StatusInternalServerError
+	}
+	h, ok := handlers[req.Method]
+	if !ok {
+		return response(req.ID, nil, btcjson.ErrRPCMethodNotFound), http.StatusNotFound
+	}
+	result, err := h(s, req.Params)
+	if err != nil {
+		var rpcErr *btcjson.RPCError
+		if !errors.As(err, &rpcErr) {
+			log.Errorf("bitcoin json-rpc %s error: %v", req.Method, err)
+			rpcErr = btcjson.NewRPCError(btcjson.ErrRPCDatabase, err.Error())
+		}
+		return response(req.ID, nil, rpcErr), http.StatusInternalServerError
+	}
+	return response(req.ID, result, nil), http.StatusOK
+}
+
+// response marshals a JSON-RPC 1.0 response
+func response(id interface{}, result interface{}, rpcErr *btcjson.RPCError) json.RawMessage {
+	resp, err := btcjson.MarshalResponse(id, result, rpcErr)
+	if err != nil {
+		log.Errorf("bitcoin json-rpc error marshalling response: %v", err)
+		resp, _ = btcjson.MarshalResponse(nil, nil, btcjson.ErrRPCInternal)
+	}
+	return resp
+}
+
+// param unmarshals the i'th parameter into v, returning false if it was not given or is null
+func param(params []json.RawMessage, i int, name string, v interface{}) (bool, error) {
+	if i >= len(params) || string(params[i]) == "null" {
+		return false, nil
+	}
+	if err := json.Unmarshal(params[i], v); err != nil {
+		return false, btcjson.NewRPCError(btcjson.ErrRPCType, fmt.Sprintf("%s is not of the expected type: %v", name, err))
+	}
+	return true, nil
+}
+
+// requiredParam unmarshals the i'th parameter into v, failing if it was not given
+func requiredParam(params []json.RawMessage, i int, name string, v interface{}) error {
+	ok, err := param(params, i, name, v)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return btcjson.NewRPCError(btcjson.ErrRPCInvalidParams.Code, fmt.Sprintf("missing required parameter %s", name))
+	}
+	return nil
+}
+
+// hashParam reads the i'th parameter as a block or transaction hash, returning it in its canonical form
+func hashParam(params []json.RawMessage, i int, name string) (string, error) {
+	var str string
+	if err := requiredParam(params, i, name, &str); err != nil {
+		return "", err
+	}
+	if len(str) != chainhash.MaxHashStringSize {
+		return "", btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter, fmt.Sprintf("%s must be of length %d (not %d, for '%s')", name, chainhash.MaxHashStringSize, len(str), str))
+	}
+	hash, err := chainhash.NewHashFromStr(str)
+	if err != nil {
+		return "", btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter, fmt.Sprintf("%s must be hexadecimal string (not '%s')", name, str))
+	}
+	return hash.String(), nil
+}
+
+// verbosityParam reads the i'th parameter as a verbosity, which bitcoind accepts as either a bool or a number
+func verbosityParam(params []json.RawMessage, i int, name string, def int) (int, error) {
+	if i >= len(params) || string(params[i]) == "null" {
+		return def, nil
+	}
+	var verbose bool
+	if err := json.Unmarshal(params[i], &verbose); err == nil {
+		if verbose {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	var verbosity int
+	if _, err := param(params, i, name, &verbosity); err != nil {
+		return 0, err
+	}
+	return verbosity, nil
+}
@@ -0,0 +1,372 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btcrpc_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btcrpc"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		reader *mocks.ChainReader
+		server *btcrpc.Server
+		// the mock block's transactions, under a header committing to them
+		block    = mocks.ChildBlock(&mocks.MockBlock.Header, 0, mocks.MockBlock.Transactions...)
+		funding  = block.Transactions[1]
+		spending *wire.MsgTx
+		child    *wire.MsgBlock
+		fork     *wire.MsgBlock
+	)
+	BeforeEach(func() {
+		coinbase := wire.NewMsgTx(1)
+		coinbase.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex}, SignatureScript: []byte{0x01, 0x02}})
+		coinbase.AddTxOut(wire.NewTxOut(50e8, funding.TxOut[0].PkScript))
+		spending = wire.NewMsgTx(1)
+		fundingHash := funding.TxHash()
+		spending.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&fundingHash, 0), []byte{0x51}, nil))
+		spending.AddTxOut(wire.NewTxOut(funding.TxOut[0].Value, funding.TxOut[1].PkScript))
+		child = mocks.ChildBlock(&block.Header, 1, coinbase, spending)
+		fork = mocks.ChildBlock(&block.Header, 2, coinbase)
+
+		reader = mocks.NewChainReader(&chaincfg.MainNetParams)
+		reader.Add(mocks.MockBlockHeight, block, true)
+		reader.Add(mocks.MockBlockHeight+1, child, true)
+		reader.Add(mocks.MockBlockHeight+1, fork, false)
+		server = btcrpc.NewServer(reader, &chaincfg.MainNetParams, "", "")
+	})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		return rec
+	}
+	call := func(method string, params ...interface{}) (int, btcjson.Response) {
+		req, err := btcjson.NewRequest(1, method, params)
+		Expect(err).ToNot(HaveOccurred())
+		body, err := json.Marshal(req)
+		Expect(err).ToNot(HaveOccurred())
+		rec := post(string(body))
+		var resp btcjson.Response
+		Expect(json.Unmarshal(rec.Body.Bytes(), &resp)).To(Succeed())
+		return rec.Code, resp
+	}
+	result := func(method string, v interface{}, params ...interface{}) {
+		code, resp := call(method, params...)
+		Expect(resp.Error).To(BeNil())
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(json.Unmarshal(resp.Result, v)).To(Succeed())
+	}
+	rpcError := func(method string, params ...interface{}) *btcjson.RPCError {
+		code, resp := call(method, params...)
+		Expect(code).To(Equal(http.StatusInternalServerError))
+		Expect(resp.Error).ToNot(BeNil())
+		return resp.Error
+	}
+
+	Describe("ServeHTTP", func() {
+		It("Only handles POST requests", func() {
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+
+		It("Answers a parse error for a malformed request", func() {
+			rec := post(`{"method": `)
+			Expect(rec.Code).To(Equal(http.StatusInternalServerError))
+			var resp btcjson.Response
+			Expect(json.Unmarshal(rec.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Error.Code).To(Equal(btcjson.ErrRPCParse.Code))
+		})
+
+		It("Answers a not found error for an unsupported method", func() {
+			code, resp := call("sendrawtransaction", "00")
+			Expect(code).To(Equal(http.StatusNotFound))
+			Expect(resp.Error.Code).To(Equal(btcjson.ErrRPCMethodNotFound.Code))
+		})
+
+		It("Answers a database error for a reader error", func() {
+			reader.Err = errors.New("mock reader error")
+			Expect(rpcError("getblockcount")).To(Equal(btcjson.NewRPCError(btcjson.ErrRPCDatabase, "mock reader error")))
+		})
+
+		It("Answers a parse error for a malformed batch", func() {
+			rec := post(`[{"jsonrpc":"1.0","id":1,"method":"getblockcount","params":[]}, not json]`)
+			Expect(rec.Code).To(Equal(http.StatusInternalServerError))
+			var resp btcjson.Response
+			Expect(json.Unmarshal(rec.Body.Bytes(), &resp)).To(Succeed())
+			Expect(resp.Error.Code).To(Equal(btcjson.ErrRPCParse.Code))
+		})
+
+		It("Answers each request of a batch, in order", func() {
+			rec := post(`[{"jsonrpc":"1.0","id":1,"method":"getblockcount","params":[]},` +
+				`{"jsonrpc":"1.0","id":2,"method":"nosuchmethod","params":[]},` +
+				`{"jsonrpc":"1.0","id":"last","method":"getbestblockhash","params":[]}]`)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			var resps []btcjson.Response
+			Expect(json.Unmarshal(rec.Body.Bytes(), &resps)).To(Succeed())
+			Expect(resps).To(HaveLen(3))
+			Expect(string(resps[0].Result)).To(Equal("1338"))
+			Expect(*resps[0].ID).To(BeEquivalentTo(1))
+			Expect(resps[1].Error.Code).To(Equal(btcjson.ErrRPCMethodNotFound.Code))
+			Expect(string(resps[2].Result)).To(Equal(`"` + child.BlockHash().String() + `"`))
+			Expect(*resps[2].ID).To(Equal("last"))
+		})
+	})
+
+	Describe("Authentication", func() {
+		BeforeEach(func() {
+			server = btcrpc.NewServer(reader, &chaincfg.MainNetParams, "user", "password")
+		})
+		request := func(user, password string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"jsonrpc":"1.0","id":1,"method":"getblockcount","params":[]}`))
+			if user != "" {
+				req.SetBasicAuth(user, password)
+			}
+			server.ServeHTTP(rec, req)
+			return rec
+		}
+
+		It("Refuses requests without credentials", func() {
+			rec := request("", "")
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+			Expect(rec.Header().Get("WWW-Authenticate")).To(Equal(`Basic realm="jsonrpc"`))
+		})
+
+		It("Refuses requests with the wrong credentials", func() {
+			Expect(request("user", "wrong").Code).To(Equal(http.StatusUnauthorized))
+			Expect(request("wrong", "password").Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("Answers requests with the right credentials", func() {
+			Expect(request("user", "password").Code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Describe("Parameters", func() {
+		It("Fails when a required parameter is missing", func() {
+			Expect(rpcError("getblockhash").Code).To(Equal(btcjson.ErrRPCInvalidParams.Code))
+		})
+
+		It("Fails when a parameter has the wrong type", func() {
+			Expect(rpcError("getblockhash", "tip").Code).To(Equal(btcjson.ErrRPCType))
+			Expect(rpcError("getblock", block.BlockHash().String(), "verbose").Code).To(Equal(btcjson.ErrRPCType))
+		})
+
+		It("Fails on a hash of the wrong length or that is not hex", func() {
+			err := rpcError("getblock", "00ff")
+			Expect(err.Code).To(Equal(btcjson.ErrRPCInvalidParameter))
+			Expect(err.Message).To(ContainSubstring("must be of length 64"))
+			err = rpcError("getblock", strings.Repeat("zz", 32))
+			Expect(err.Code).To(Equal(btcjson.ErrRPCInvalidParameter))
+			Expect(err.Message).To(ContainSubstring("must be hexadecimal string"))
+		})
+
+		It("Answers block not found for an unknown hash", func() {
+			Expect(rpcError("getblock", strings.Repeat("00", 32))).To(Equal(btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey, "Block not found")))
+		})
+
+		It("Fails on a height out of range", func() {
+			Expect(rpcError("getblockhash", 1339).Code).To(Equal(btcjson.ErrRPCInvalidParameter))
+			Expect(rpcError("getblockhash", -1).Code).To(Equal(btcjson.ErrRPCInvalidParameter))
+		})
+	})
+
+	Describe("Chain methods", func() {
+		It("Returns the height and hash of the tip", func() {
+			var count int64
+			result("getblockcount", &count)
+			Expect(count).To(Equal(mocks.MockBlockHeight + 1))
+			var hash string
+			result("getbestblockhash", &hash)
+			Expect(hash).To(Equal(child.BlockHash().String()))
+		})
+
+		It("Returns the hash of the canonical block at a height", func() {
+			var hash string
+			result("getblockhash", &hash, mocks.MockBlockHeight+1)
+			Expect(hash).To(Equal(child.BlockHash().String()))
+		})
+
+		It("Returns the header, serialized or verbose", func() {
+			var raw string
+			result("getblockheader", &raw, block.BlockHash().String(), false)
+			var buf bytes.Buffer
+			Expect(block.Header.Serialize(&buf)).To(Succeed())
+			Expect(raw).To(Equal(hex.EncodeToString(buf.Bytes())))
+
+			var header btcjson.GetBlockHeaderVerboseResult
+			result("getblockheader", &header, block.BlockHash().String())
+			Expect(header.Hash).To(Equal(block.BlockHash().String()))
+			Expect(header.Height).To(BeEquivalentTo(mocks.MockBlockHeight))
+			Expect(header.Confirmations).To(BeEquivalentTo(2))
+			Expect(header.NextHash).To(Equal(child.BlockHash().String()))
+			Expect(header.PreviousHash).To(Equal(block.Header.PrevBlock.String()))
+			Expect(header.MerkleRoot).To(Equal(block.Header.MerkleRoot.String()))
+		})
+	})
+
+	Describe("getblock", func() {
+		It("Returns the serialized block for verbosity 0, reassembled from its IPLDs", func() {
+			var buf bytes.Buffer
+			Expect(block.Serialize(&buf)).To(Succeed())
+			var raw string
+			result("getblock", &raw, block.BlockHash().String(), 0)
+			Expect(raw).To(Equal(hex.EncodeToString(buf.Bytes())))
+			result("getblock", &raw, block.BlockHash().String(), false)
+			Expect(raw).To(Equal(hex.EncodeToString(buf.Bytes())))
+			Expect(reader.Calls["BlockFromIPLDs"]).To(Equal(2))
+			Expect(reader.Calls["Txs"]).To(BeZero())
+		})
+
+		It("Returns the block's fields and txids for verbosity 1, the default", func() {
+			var verbose struct {
+				btcrpc.BlockResult
+				Tx []string `json:"tx"`
+			}
+			result("getblock", &verbose, block.BlockHash().String())
+			Expect(verbose.Hash).To(Equal(block.BlockHash().String()))
+			Expect(verbose.Height).To(Equal(mocks.MockBlockHeight))
+			Expect(verbose.Confirmations).To(BeEquivalentTo(2))
+			Expect(verbose.NTx).To(Equal(len(block.Transactions)))
+			Expect(verbose.Size).To(BeEquivalentTo(block.SerializeSize()))
+			Expect(verbose.NextHash).To(Equal(child.BlockHash().String()))
+			Expect(verbose.Tx).To(HaveLen(len(block.Transactions)))
+			for i, tx := range block.Transactions {
+				Expect(verbose.Tx[i]).To(Equal(tx.TxHash().String()))
+			}
+			var explicit struct {
+				Tx []string `json:"tx"`
+			}
+			result("getblock", &explicit, block.BlockHash().String(), true)
+			Expect(explicit.Tx).To(Equal(verbose.Tx))
+		})
+
+		It("Returns the block's decoded transactions for verbosity 2", func() {
+			var verbose struct {
+				Tx []btcjson.TxRawResult `json:"tx"`
+			}
+			result("getblock", &verbose, block.BlockHash().String(), 2)
+			Expect(verbose.Tx).To(HaveLen(len(block.Transactions)))
+			Expect(verbose.Tx[0].Vin[0].Coinbase).ToNot(BeEmpty())
+			Expect(verbose.Tx[1].Txid).To(Equal(funding.TxHash().String()))
+			Expect(verbose.Tx[1].Vout).To(HaveLen(len(funding.TxOut)))
+			Expect(verbose.Tx[1].Vout[0].ScriptPubKey.Addresses).To(Equal([]string(mocks.MockTxsMetaData[1].TxOutputs[0].Addresses)))
+		})
+
+		It("Reports a block off the canonical chain with -1 confirmations", func() {
+			var verbose btcrpc.BlockResult
+			result("getblock", &verbose, fork.BlockHash().String())
+			Expect(verbose.Confirmations).To(BeEquivalentTo(-1))
+			Expect(verbose.NextHash).To(BeEmpty())
+		})
+	})
+
+	Describe("gettxout", func() {
+		It("Returns an unspent output", func() {
+			var out btcjson.GetTxOutResult
+			result("gettxout", &out, funding.TxHash().String(), 1)
+			Expect(out.BestBlock).To(Equal(child.BlockHash().String()))
+			Expect(out.Confirmations).To(BeEquivalentTo(2))
+			Expect(out.Value).To(BeNumerically("==", float64(funding.TxOut[1].Value)/1e8))
+			Expect(out.Coinbase).To(BeFalse())
+			Expect(out.ScriptPubKey.Hex).To(Equal(hex.EncodeToString(funding.TxOut[1].PkScript)))
+		})
+
+		It("Returns null for a spent output", func() {
+			code, resp := call("gettxout", funding.TxHash().String(), 0)
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(string(resp.Result)).To(Equal("null"))
+		})
+
+		It("Returns null for an output out of range or an unknown transaction", func() {
+			_, resp := call("gettxout", funding.TxHash().String(), len(funding.TxOut))
+			Expect(string(resp.Result)).To(Equal("null"))
+			_, resp = call("gettxout", strings.Repeat("00", 32), 0)
+			Expect(string(resp.Result)).To(Equal("null"))
+		})
+
+		It("Reports a coinbase output", func() {
+			var out btcjson.GetTxOutResult
+			result("gettxout", &out, child.Transactions[0].TxHash().String(), 0)
+			Expect(out.Coinbase).To(BeTrue())
+			Expect(out.Confirmations).To(BeEquivalentTo(1))
+		})
+	})
+
+	Describe("getrawtransaction", func() {
+		It("Returns the serialized transaction, or its fields if verbose", func() {
+			var buf bytes.Buffer
+			Expect(spending.Serialize(&buf)).To(Succeed())
+			var raw string
+			result("getrawtransaction", &raw, spending.TxHash().String())
+			Expect(raw).To(Equal(hex.EncodeToString(buf.Bytes())))
+
+			var tx btcjson.TxRawResult
+			result("getrawtransaction", &tx, spending.TxHash().String(), 1)
+			Expect(tx.BlockHash).To(Equal(child.BlockHash().String()))
+			Expect(tx.Confirmations).To(BeEquivalentTo(1))
+			Expect(tx.Vin[0].Txid).To(Equal(funding.TxHash().String()))
+		})
+
+		It("Looks the transaction up in the given block", func() {
+			var raw string
+			result("getrawtransaction", &raw, child.Transactions[0].TxHash().String(), 0, fork.BlockHash().String())
+			Expect(raw).ToNot(BeEmpty())
+			Expect(rpcError("getrawtransaction", spending.TxHash().String(), 0, fork.BlockHash().String()).Code).To(Equal(btcjson.ErrRPCInvalidAddressOrKey))
+		})
+	})
+
+	Describe("gettxoutproof and verifytxoutproof", func() {
+		It("Proves transactions in a block and verifies the proof", func() {
+			var proof string
+			result("gettxoutproof", &proof, []string{spending.TxHash().String()})
+			var txids []string
+			result("verifytxoutproof", &txids, proof)
+			Expect(txids).To(Equal([]string{spending.TxHash().String()}))
+		})
+
+		It("Refuses duplicated or missing transactions", func() {
+			txid := spending.TxHash().String()
+			Expect(rpcError("gettxoutproof", []string{txid, txid}).Code).To(Equal(btcjson.ErrRPCInvalidParameter))
+			Expect(rpcError("gettxoutproof", []string{}).Code).To(Equal(btcjson.ErrRPCInvalidParameter))
+			Expect(rpcError("gettxoutproof", []string{txid}, block.BlockHash().String()).Code).To(Equal(btcjson.ErrRPCInvalidAddressOrKey))
+		})
+
+		It("Refuses a proof for a block off the canonical chain", func() {
+			var proof string
+			result("gettxoutproof", &proof, []string{child.Transactions[0].TxHash().String()}, fork.BlockHash().String())
+			err := rpcError("verifytxoutproof", proof)
+			Expect(err.Message).To(Equal("Block not found in chain"))
+		})
+	})
+})
@@ -0,0 +1,26 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package btcrpc
+
+import (
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+)
+
+// NewService creates a node.Service serving the server's bitcoin json-rpc on the given address
+func NewService(addr string, server *Server) *shared.HTTPService {
+	return shared.NewHTTPService("bitcoin json-rpc", addr, server)
+}
@@ -0,0 +1,90 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package electrum
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Env variables
+const (
+	ELECTRUM_ENABLED       = "ELECTRUM_ENABLED"
+	ELECTRUM_TCP_ADDR      = "ELECTRUM_TCP_ADDR"
+	ELECTRUM_TCP_PORT      = "ELECTRUM_TCP_PORT"
+	ELECTRUM_POLL_INTERVAL = "ELECTRUM_POLL_INTERVAL"
+	ELECTRUM_WRITE_TIMEOUT = "ELECTRUM_WRITE_TIMEOUT"
+
+	ELECTRUM_MAX_CONNECTIONS   = "ELECTRUM_MAX_CONNECTIONS"
+	ELECTRUM_MAX_SUBSCRIPTIONS = "ELECTRUM_MAX_SUBSCRIPTIONS"
+)
+
+// Config holds the settings for the Electrum protocol server
+type Config struct {
+	Enabled      bool // Serve the Electrum protocol from the serve command
+	TCPAddr      string
+	TCPPort      int
+	PollInterval time.Duration // how often the indexed tip is checked for the notifications sent to subscribed clients
+	WriteTimeout time.Duration // how long a client has to read a message before its connection is closed
+	// how many clients are served at once, and how many script hashes each of them can subscribe to
+	MaxConnections   int
+	MaxSubscriptions int
+}
+
+// NewConfig is used to initialize an electrum config from a .toml file
+func NewConfig() Config {
+	viper.BindEnv("electrum.enabled", ELECTRUM_ENABLED)
+	viper.BindEnv("electrum.tcpAddr", ELECTRUM_TCP_ADDR)
+	viper.BindEnv("electrum.tcpPort", ELECTRUM_TCP_PORT)
+	viper.BindEnv("electrum.pollInterval", ELECTRUM_POLL_INTERVAL)
+	viper.BindEnv("electrum.writeTimeout", ELECTRUM_WRITE_TIMEOUT)
+	viper.BindEnv("electrum.maxConnections", ELECTRUM_MAX_CONNECTIONS)
+	viper.BindEnv("electrum.maxSubscriptions", ELECTRUM_MAX_SUBSCRIPTIONS)
+
+	pollInterval := viper.GetInt("electrum.pollInterval")
+	if pollInterval < 1 {
+		pollInterval = int(PollInterval.Seconds())
+	}
+	writeTimeout := viper.GetInt("electrum.writeTimeout")
+	if writeTimeout < 1 {
+		writeTimeout = int(WriteTimeout.Seconds())
+	}
+	maxConnections := viper.GetInt("electrum.maxConnections")
+	if maxConnections < 1 {
+		maxConnections = MaxConnections
+	}
+	maxSubscriptions := viper.GetInt("electrum.maxSubscriptions")
+	if maxSubscriptions < 1 {
+		maxSubscriptions = MaxSubscriptions
+	}
+	return Config{
+		Enabled:          viper.GetBool("electrum.enabled"),
+		TCPAddr:          viper.GetString("electrum.tcpAddr"),
+		TCPPort:          viper.GetInt("electrum.tcpPort"),
+		PollInterval:     time.Second * time.Duration(pollInterval),
+		WriteTimeout:     time.Second * time.Duration(writeTimeout),
+		MaxConnections:   maxConnections,
+		MaxSubscriptions: maxSubscriptions,
+	}
+}
+
+// Address returns the host:port the electrum protocol is served on
+func (c Config) Address() string {
+	return fmt.Sprintf("%s:%d", c.TCPAddr, c.TCPPort)
+}
@@ -0,0 +1,19 @@
+package electrum_test
+
+import (
+	"io/ioutil"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+func TestElectrum(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "BTC Electrum Suite Test")
+}
+
+var _ = BeforeSuite(func() {
+	logrus.SetOutput(ioutil.Discard)
+})
@@ -0,0 +1,515 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package electrum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	v "github.com/vulcanize/ipld-btc-indexer/version"
+)
+
+const (
+	// MaxHeaders bounds the count of blockchain.block.headers
+	MaxHeaders = 2016
+	// MaxHistory bounds the number of transactions or outputs of a script hash that are looked up; scripts with more
+	// are refused, as other Electrum servers do
+	MaxHistory = 10000
+)
+
+// handler answers a single method call; an *rpcError it returns is passed on to the client as is
+type handler func(s *Server, sess *session, params []json.RawMessage) (interface{}, error)
+
+// handlers maps the supported Electrum methods to their handlers
+var handlers = map[string]handler{
+	"blockchain.block.header":            (*Server).blockHeader,
+	"blockchain.block.headers":           (*Server).blockHeaders,
+	"blockchain.headers.subscribe":       (*Server).headersSubscribe,
+	"blockchain.scripthash.get_balance":  (*Server).scriptHashGetBalance,
+	"blockchain.scripthash.get_history":  (*Server).scriptHashGetHistory,
+	"blockchain.scripthash.listunspent":  (*Server).scriptHashListUnspent,
+	"blockchain.scripthash.subscribe":    (*Server).scriptHashSubscribe,
+	"blockchain.scripthash.unsubscribe":  (*Server).scriptHashUnsubscribe,
+	"blockchain.transaction.get":         (*Server).transactionGet,
+	"blockchain.transaction.get_merkle":  (*Server).transactionGetMerkle,
+	"blockchain.transaction.id_from_pos": (*Server).transactionIDFromPos,
+	"server.banner":                      (*Server).serverBanner,
+	"server.donation_address":            (*Server).serverDonationAddress,
+	"server.features":                    (*Server).serverFeatures,
+	"server.peers.subscribe":             (*Server).serverPeersSubscribe,
+	"server.ping":                        (*Server).serverPing,
+	"server.version":                     (*Server).serverVersion,
+}
+
+func serverVersionString() string {
+	return "ipld-btc-indexer " + v.VersionWithMeta
+}
+
+// server.version client_name protocol_version returns the server's version and the protocol version spoken
+func (s *Server) serverVersion(sess *session, params []json.RawMessage) (interface{}, error) {
+	return []string{serverVersionString(), ProtocolVersion}, nil
+}
+
+// server.ping does nothing; clients use it to keep the connection alive
+func (s *Server) serverPing(sess *session, params []json.RawMessage) (interface{}, error) {
+	return nil, nil
+}
+
+// server.banner returns the server's banner
+func (s *Server) serverBanner(sess *session, params []json.RawMessage) (interface{}, error) {
+	return serverVersionString() + ", serving the canonical chain of its index; there is no mempool", nil
+}
+
+// server.donation_address returns no address
+func (s *Server) serverDonationAddress(sess *session, params []json.RawMessage) (interface{}, error) {
+	return "", nil
+}
+
+// server.peers.subscribe returns no peers; the server does not take part in peer discovery
+func (s *Server) serverPeersSubscribe(sess *session, params []json.RawMessage) (interface{}, error) {
+	return []interface{}{}, nil
+}
+
+// server.features describes the server
+func (s *Server) serverFeatures(sess *session, params []json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{
+		"genesis_hash":   s.params.GenesisHash.String(),
+		"hosts":          map[string]interface{}{},
+		"protocol_max":   ProtocolVersion,
+		"protocol_min":   ProtocolVersion,
+		"pruning":        nil,
+		"server_version": serverVersionString(),
+		"hash_function":  "sha256",
+	}, nil
+}
+
+// blockchain.headers.subscribe returns the indexed tip, and subscribes the session to the new tips
+func (s *Server) headersSubscribe(sess *session, params []json.RawMessage) (interface{}, error) {
+	tip, err := s.reader.Tip()
+	if err != nil {
+		return nil, err
+	}
+	sess.mu.Lock()
+	sess.headers = true
+	sess.mu.Unlock()
+	return headerResult(tip), nil
+}
+
+// headerResult is the notification and result of blockchain.headers.subscribe
+func headerResult(header *btc.IndexedHeader) map[string]interface{} {
+	return map[string]interface{}{
+		"height": header.BlockNumber,
+		"hex":    hex.EncodeToString(header.Data),
+	}
+}
+
+// blockchain.block.header height cp_height returns the canonical header at the height
+func (s *Server) blockHeader(sess *session, params []json.RawMessage) (interface{}, error) {
+	var height int64
+	if err := requiredParam(params, 0, "height", &height); err != nil {
+		return nil, err
+	}
+	if err := noCheckpoint(params, 1); err != nil {
+		return nil, err
+	}
+	header, err := s.reader.HeaderAt(height)
+	if err != nil {
+		return nil, err
+	}
+	return hex.EncodeToString(header.Data), nil
+}
+
+// blockchain.block.headers start_height count cp_height returns up to count consecutive canonical headers
+// starting at start_height, stopping early at the tip or at the first height that is not indexed
+func (s *Server) blockHeaders(sess *session, params []json.RawMessage) (interface{}, error) {
+	var start, count int64
+	if err := requiredParam(params, 0, "start_height", &start); err != nil {
+		return nil, err
+	}
+	if err := requiredParam(params, 1, "count", &count); err != nil {
+		return nil, err
+	}
+	if err := noCheckpoint(params, 2); err != nil {
+		return nil, err
+	}
+	if start < 0 || count < 0 {
+		return nil, &rpcError{Code: codeInvalidParams, Message: "start_height and count must not be negative"}
+	}
+	if count > MaxHeaders {
+		count = MaxHeaders
+	}
+	var raw strings.Builder
+	n := 0
+	if count > 0 {
+		headers, err := s.reader.Headers(start, start+count-1, int(count))
+		if err != nil {
+			return nil, err
+		}
+		for i, header := range headers {
+			if header.BlockNumber != start+int64(i) {
+				break
+			}
+			raw.WriteString(hex.EncodeToString(header.Data))
+			n++
+		}
+	}
+	return map[string]interface{}{
+		"count": n,
+		"hex":   raw.String(),
+		"max":   MaxHeaders,
+	}, nil
+}
+
+// noCheckpoint refuses a non-zero cp_height; header proofs against a checkpoint are not supported
+func noCheckpoint(params []json.RawMessage, i int) error {
+	var cpHeight int64
+	if _, err := param(params, i, "cp_height", &cpHeight); err != nil {
+		return err
+	}
+	if cpHeight != 0 {
+		return &rpcError{Code: codeBadRequest, Message: "checkpoint proofs (cp_height) are not supported"}
+	}
+	return nil
+}
+
+// blockchain.scripthash.get_history scripthash returns the canonical transactions paying to or spending from the script
+func (s *Server) scriptHashGetHistory(sess *session, params []json.RawMessage) (interface{}, error) {
+	scriptHash, err := scriptHashParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	txs, err := s.history(scriptHash)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]map[string]interface{}, len(txs))
+	for i, tx := range txs {
+		history[i] = map[string]interface{}{
+			"height":  tx.BlockNumber,
+			"tx_hash": tx.TxHash,
+		}
+	}
+	return history, nil
+}
+
+// blockchain.scripthash.get_balance scripthash returns the value of the canonical outputs paying to the script that are unspent
+func (s *Server) scriptHashGetBalance(sess *session, params []json.RawMessage) (interface{}, error) {
+	scriptHash, err := scriptHashParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	unspent, err := s.unspent(scriptHash)
+	if err != nil {
+		return nil, err
+	}
+	var confirmed int64
+	for _, output := range unspent {
+		confirmed += output.Value
+	}
+	return map[string]interface{}{
+		"confirmed":   confirmed,
+		"unconfirmed": 0,
+	}, nil
+}
+
+// blockchain.scripthash.listunspent scripthash returns the canonical outputs paying to the script that are unspent
+func (s *Server) scriptHashListUnspent(sess *session, params []json.RawMessage) (interface{}, error) {
+	scriptHash, err := scriptHashParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	unspent, err := s.unspent(scriptHash)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]map[string]interface{}, len(unspent))
+	for i, output := range unspent {
+		results[i] = map[string]interface{}{
+			"height":  output.BlockNumber,
+			"tx_hash": output.TxHash,
+			"tx_pos":  output.Index,
+			"value":   output.Value,
+		}
+	}
+	return results, nil
+}
+
+// blockchain.scripthash.subscribe scripthash returns the status of the script, and subscribes the session to its changes
+func (s *Server) scriptHashSubscribe(sess *session, params []json.RawMessage) (interface{}, error) {
+	var scriptHash string
+	if err := requiredParam(params, 0, "scripthash", &scriptHash); err != nil {
+		return nil, err
+	}
+	sess.mu.Lock()
+	_, subscribed := sess.scriptHashes[scriptHash]
+	full := len(sess.scriptHashes) >= s.maxSubscriptions
+	sess.mu.Unlock()
+	if !subscribed && full {
+		return nil, &rpcError{Code: codeBadRequest, Message: fmt.Sprintf("too many subscriptions: at most %d script hashes per session", s.maxSubscriptions)}
+	}
+	status, err := s.status(scriptHash)
+	if err != nil {
+		return nil, err
+	}
+	sess.mu.Lock()
+	sess.scriptHashes[scriptHash] = status
+	sess.mu.Unlock()
+	return status, nil
+}
+
+// blockchain.scripthash.unsubscribe scripthash unsubscribes the session from the script, returning whether it was subscribed
+func (s *Server) scriptHashUnsubscribe(sess *session, params []json.RawMessage) (interface{}, error) {
+	var scriptHash string
+	if err := requiredParam(params, 0, "scripthash", &scriptHash); err != nil {
+		return nil, err
+	}
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	_, subscribed := sess.scriptHashes[scriptHash]
+	delete(sess.scriptHashes, scriptHash)
+	return subscribed, nil
+}
+
+// status returns the Electrum status of the script with the hash: the sha256 of its history, as "tx_hash:height:"
+// for each transaction, or nil if it has none
+func (s *Server) status(scriptHash string) (*string, error) {
+	hash, err := decodeScriptHash(scriptHash)
+	if err != nil {
+		return nil, err
+	}
+	txs, err := s.history(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(txs) == 0 {
+		return nil, nil
+	}
+	var history strings.Builder
+	for _, tx := range txs {
+		history.WriteString(tx.TxHash + ":" + strconv.FormatInt(tx.BlockNumber, 10) + ":")
+	}
+	digest := sha256.Sum256([]byte(history.String()))
+	status := hex.EncodeToString(digest[:])
+	return &status, nil
+}
+
+// history returns the canonical transactions paying to or spending from the script with the hash, in chain order
+func (s *Server) history(scriptHash []byte) ([]btc.IndexedTx, error) {
+	txs, err := s.reader.ScriptHashTxs(scriptHash, 0, math.MaxInt64, -1, MaxHistory+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(txs) > MaxHistory {
+		return nil, &rpcError{Code: codeBadRequest, Message: fmt.Sprintf("history too large: more than %d transactions", MaxHistory)}
+	}
+	return txs, nil
+}
+
+// unspent returns the canonical outputs paying to the script with the hash that no canonical input spends
+func (s *Server) unspent(scriptHash []byte) ([]btc.AddressOutput, error) {
+	outputs, err := s.reader.ScriptHashUnspentOutputs(scriptHash, 0, math.MaxInt64, 0, MaxHistory+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(outputs) > MaxHistory {
+		return nil, &rpcError{Code: codeBadRequest, Message: fmt.Sprintf("too many unspent outputs: more than %d", MaxHistory)}
+	}
+	return outputs, nil
+}
+
+// blockchain.transaction.get tx_hash verbose returns the raw canonical transaction
+func (s *Server) transactionGet(sess *session, params []json.RawMessage) (interface{}, error) {
+	txHash, err := hashParam(params, 0, "tx_hash")
+	if err != nil {
+		return nil, err
+	}
+	var verbose bool
+	if _, err := param(params, 1, "verbose", &verbose); err != nil {
+		return nil, err
+	}
+	if verbose {
+		return nil, &rpcError{Code: codeBadRequest, Message: "verbose transactions are not supported"}
+	}
+	tx, _, err := s.reader.Tx(txHash)
+	if err != nil {
+		return nil, err
+	}
+	return hex.EncodeToString(tx.Data), nil
+}
+
+// blockchain.transaction.get_merkle tx_hash height returns the merkle branch proving the canonical transaction is in
+// the block at the height
+func (s *Server) transactionGetMerkle(sess *session, params []json.RawMessage) (interface{}, error) {
+	txHash, err := hashParam(params, 0, "tx_hash")
+	if err != nil {
+		return nil, err
+	}
+	var height int64
+	if err := requiredParam(params, 1, "height", &height); err != nil {
+		return nil, err
+	}
+	tx, header, err := s.reader.Tx(txHash)
+	if err != nil {
+		return nil, err
+	}
+	if header.BlockNumber != height {
+		return nil, &rpcError{Code: codeBadRequest, Message: fmt.Sprintf("transaction %s is not in the block at height %d", txHash, height)}
+	}
+	txs, err := s.reader.Txs(header)
+	if err != nil {
+		return nil, err
+	}
+	branch, err := merkleBranch(txs, int(tx.Index))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"block_height": height,
+		"merkle":       branch,
+		"pos":          tx.Index,
+	}, nil
+}
+
+// blockchain.transaction.id_from_pos height tx_pos merkle returns the hash of the transaction at the position of the
+// canonical block at the height and, if merkle is set, its merkle branch
+func (s *Server) transactionIDFromPos(sess *session, params []json.RawMessage) (interface{}, error) {
+	var height, pos int64
+	if err := requiredParam(params, 0, "height", &height); err != nil {
+		return nil, err
+	}
+	if err := requiredParam(params, 1, "tx_pos", &pos); err != nil {
+		return nil, err
+	}
+	var merkle bool
+	if _, err := param(params, 2, "merkle", &merkle); err != nil {
+		return nil, err
+	}
+	header, err := s.reader.HeaderAt(height)
+	if err != nil {
+		return nil, err
+	}
+	txs, err := s.reader.Txs(header)
+	if err != nil {
+		return nil, err
+	}
+	if pos < 0 || pos >= int64(len(txs)) {
+		return nil, &rpcError{Code: codeBadRequest, Message: fmt.Sprintf("no transaction at position %d of the block at height %d", pos, height)}
+	}
+	if !merkle {
+		return txs[pos].TxHash, nil
+	}
+	branch, err := merkleBranch(txs, int(pos))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"tx_hash": txs[pos].TxHash,
+		"merkle":  branch,
+	}, nil
+}
+
+// merkleBranch returns the hashes, bottom up, that the hash of the transaction at the position is combined with to
+// compute the merkle root of the block's transactions
+func merkleBranch(txs []btc.IndexedTx, pos int) ([]string, error) {
+	level := make([]*chainhash.Hash, len(txs))
+	for i, tx := range txs {
+		hash, err := chainhash.NewHashFromStr(tx.TxHash)
+		if err != nil {
+			return nil, err
+		}
+		level[i] = hash
+	}
+	branch := []string{}
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		branch = append(branch, level[pos^1].String())
+		next := make([]*chainhash.Hash, len(level)/2)
+		for i := range next {
+			next[i] = blockchain.HashMerkleBranches(level[2*i], level[2*i+1])
+		}
+		level, pos = next, pos/2
+	}
+	return branch, nil
+}
+
+// param unmarshals the i'th parameter into v, returning whether it was given
+func param(params []json.RawMessage, i int, name string, v interface{}) (bool, error) {
+	if i >= len(params) || string(params[i]) == "null" {
+		return false, nil
+	}
+	if err := json.Unmarshal(params[i], v); err != nil {
+		return false, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("%s is not of the expected type: %v", name, err)}
+	}
+	return true, nil
+}
+
+// requiredParam unmarshals the i'th parameter into v, failing if it was not given
+func requiredParam(params []json.RawMessage, i int, name string, v interface{}) error {
+	ok, err := param(params, i, name, v)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("missing required parameter %s", name)}
+	}
+	return nil
+}
+
+// hashParam reads the i'th parameter as a transaction hash, returning it in its canonical form
+func hashParam(params []json.RawMessage, i int, name string) (string, error) {
+	var str string
+	if err := requiredParam(params, i, name, &str); err != nil {
+		return "", err
+	}
+	hash, err := chainhash.NewHashFromStr(str)
+	if err != nil || len(str) != chainhash.MaxHashStringSize {
+		return "", &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("%s must be a %d character hex string", name, chainhash.MaxHashStringSize)}
+	}
+	return hash.String(), nil
+}
+
+// scriptHashParam reads the i'th parameter as a script hash
+func scriptHashParam(params []json.RawMessage, i int) ([]byte, error) {
+	var str string
+	if err := requiredParam(params, i, "scripthash", &str); err != nil {
+		return nil, err
+	}
+	return decodeScriptHash(str)
+}
+
+// decodeScriptHash decodes a script hash from the byte reversed hex encoding Electrum clients use
+func decodeScriptHash(str string) ([]byte, error) {
+	hash, err := hex.DecodeString(str)
+	if err != nil || len(hash) != sha256.Size {
+		return nil, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("scripthash must be a %d character hex string", sha256.Size*2)}
+	}
+	for i, j := 0, len(hash)-1; i < j; i, j = i+1, j-1 {
+		hash[i], hash[j] = hash[j], hash[i]
+	}
+	return hash, nil
+}
@@ -0,0 +1,466 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package electrum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+)
+
+const (
+	// ProtocolVersion is the version of the Electrum protocol spoken
+	ProtocolVersion = "1.4"
+	// PollInterval is the default interval at which the indexed tip is checked for changes
+	PollInterval = time.Second * 5
+	// MaxLineSize bounds the size of a request line
+	MaxLineSize = 1 << 20
+	// WriteTimeout is the default time a client has to read a message before its session is closed
+	WriteTimeout = time.Second * 10
+	// MaxConnections is the default number of sessions served at once; further connections are closed on accept
+	MaxConnections = 1000
+	// MaxSubscriptions is the default number of script hashes a session can subscribe to
+	MaxSubscriptions = 10000
+	// touchedBatchSize bounds the number of script hashes checked for new transactions in one query
+	touchedBatchSize = 1000
+)
+
+// JSON-RPC error codes; BadRequest is the code Electrum servers answer failed lookups with
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+	codeBadRequest     = 1
+)
+
+// rpcError is an error passed on to the client with its code
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+type request struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type resultResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+}
+
+type errorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   *rpcError       `json:"error"`
+}
+
+type notification struct {
+	JSONRPC string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+// session is a client connection and its subscriptions
+type session struct {
+	conn         net.Conn
+	writeTimeout time.Duration
+	writeMu      sync.Mutex
+	// guards the subscriptions, which the poller reads
+	mu      sync.Mutex
+	headers bool
+	// subscribed script hashes, as sent by the client, mapped to the last status sent for them
+	scriptHashes map[string]*string
+}
+
+// send writes a message as a line, failing if the client does not read it within the write timeout
+func (sess *session) send(msg interface{}) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+	if err := sess.conn.SetWriteDeadline(time.Now().Add(sess.writeTimeout)); err != nil {
+		return err
+	}
+	_, err = sess.conn.Write(append(line, '\n'))
+	return err
+}
+
+// Server answers the Electrum protocol (newline delimited JSON-RPC over TCP) from the index
+// It has no mempool: history, balances and unspent outputs are those of the canonical chain in the index, and
+// clients subscribed to headers or script hashes are notified when the indexed tip changes
+type Server struct {
+	reader btc.ChainReader
+	// Chain config providing the genesis hash
+	params       *chaincfg.Params
+	pollInterval time.Duration
+	writeTimeout time.Duration
+	// Caps on the sessions served at once, and on the script hashes each of them subscribes to
+	maxConnections   int
+	maxSubscriptions int
+
+	listener net.Listener
+	mu       sync.Mutex
+	sessions map[*session]struct{}
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewServer creates a Server reading from the chain reader and checking its tip for changes every pollInterval
+// A session whose client does not read a message within writeTimeout is closed; at most maxConnections sessions are
+// served at once, each subscribed to at most maxSubscriptions script hashes
+func NewServer(reader btc.ChainReader, params *chaincfg.Params, pollInterval, writeTimeout time.Duration, maxConnections, maxSubscriptions int) *Server {
+	return &Server{
+		reader:           reader,
+		params:           params,
+		pollInterval:     pollInterval,
+		writeTimeout:     writeTimeout,
+		maxConnections:   maxConnections,
+		maxSubscriptions: maxSubscriptions,
+		sessions:         make(map[*session]struct{}),
+		quit:             make(chan struct{}),
+	}
+}
+
+// Start serves the connections accepted on the listener, and starts polling the tip, until Close is called
+func (s *Server) Start(listener net.Listener) {
+	s.listener = listener
+	s.wg.Add(2)
+	go s.accept()
+	go s.poll()
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				log.Warnf("electrum accept error: %v", err)
+				time.Sleep(time.Second)
+				continue
+			}
+			log.Errorf("electrum accept error: %v", err)
+			return
+		}
+		sess := &session{conn: conn, writeTimeout: s.writeTimeout, scriptHashes: make(map[string]*string)}
+		s.mu.Lock()
+		select {
+		case <-s.quit:
+			s.mu.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		if len(s.sessions) >= s.maxConnections {
+			s.mu.Unlock()
+			log.Debugf("electrum refusing %s: %d sessions already open", conn.RemoteAddr(), s.maxConnections)
+			conn.Close()
+			continue
+		}
+		s.sessions[sess] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveSession(sess)
+	}
+}
+
+// Close stops accepting connections, closes the open ones and waits for their handlers to return
+func (s *Server) Close() error {
+	s.mu.Lock()
+	close(s.quit)
+	err := s.listener.Close()
+	for sess := range s.sessions {
+		sess.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// serveSession answers the session's requests, in order, until it is closed
+func (s *Server) serveSession(sess *session) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.sessions, sess)
+		s.mu.Unlock()
+		sess.conn.Close()
+	}()
+	scanner := bufio.NewScanner(sess.conn)
+	scanner.Buffer(make([]byte, 0, 4096), MaxLineSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := sess.send(s.handleLine(sess, line)); err != nil {
+			log.Debugf("electrum session %s write error: %v", sess.conn.RemoteAddr(), err)
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Debugf("electrum session %s read error: %v", sess.conn.RemoteAddr(), err)
+	}
+}
+
+// handleLine answers a request, or a batch of them
+func (s *Server) handleLine(sess *session, line []byte) interface{} {
+	if line[0] != '[' {
+		var req request
+		if err := json.Unmarshal(line, &req); err != nil {
+			return errorResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: codeParseError, Message: err.Error()}}
+		}
+		return s.handle(sess, req)
+	}
+	var reqs []request
+	if err := json.Unmarshal(line, &reqs); err != nil {
+		return errorResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: codeParseError, Message: err.Error()}}
+	}
+	if len(reqs) == 0 {
+		return errorResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: codeInvalidRequest, Message: "empty batch"}}
+	}
+	responses := make([]interface{}, len(reqs))
+	for i, req := range reqs {
+		responses[i] = s.handle(sess, req)
+	}
+	return responses
+}
+
+func (s *Server) handle(sess *session, req request) interface{} {
+	if req.ID == nil {
+		req.ID = json.RawMessage("null")
+	}
+	h, ok := handlers[req.Method]
+	if !ok {
+		return errorResponse{JSONRPC: "2.0", ID: req.ID, Error: &rpcError{Code: codeMethodNotFound, Message: "unknown method " + req.Method}}
+	}
+	var params []json.RawMessage
+	if len(req.Params) > 0 && string(req.Params) != "null" {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return errorResponse{JSONRPC: "2.0", ID: req.ID, Error: &rpcError{Code: codeInvalidParams, Message: "params must be an array"}}
+		}
+	}
+	result, err := h(s, sess, params)
+	if err != nil {
+		var rpcErr *rpcError
+		switch {
+		case errors.As(err, &rpcErr):
+		case errors.Is(err, btc.ErrNotIndexed):
+			rpcErr = &rpcError{Code: codeBadRequest, Message: err.Error()}
+		default:
+			log.Errorf("electrum %s error: %v", req.Method, err)
+			rpcErr = &rpcError{Code: codeInternalError, Message: err.Error()}
+		}
+		return errorResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr}
+	}
+	return resultResponse{JSONRPC: "2.0", ID: req.ID, Result: result}
+}
+
+// poll checks the indexed tip every poll interval, and notifies the subscribed sessions when it changes
+func (s *Server) poll() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+	var last *btc.IndexedHeader
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+		}
+		tip, err := s.reader.Tip()
+		if errors.Is(err, btc.ErrNotIndexed) {
+			continue
+		}
+		if err != nil {
+			log.Errorf("electrum tip poll error: %v", err)
+			continue
+		}
+		if last != nil && tip.BlockHash == last.BlockHash {
+			continue
+		}
+		// when the tip extends the last one, only the scripts touched by the blocks above it can have a new status;
+		// otherwise, on the first poll or after a reorg, every subscribed script is looked up
+		from := int64(-1)
+		if last != nil && tip.BlockNumber > last.BlockNumber {
+			header, err := s.reader.HeaderAt(last.BlockNumber)
+			if err == nil && header.BlockHash == last.BlockHash {
+				from = last.BlockNumber + 1
+			}
+		}
+		last = tip
+		s.mu.Lock()
+		sessions := make([]*session, 0, len(s.sessions))
+		for sess := range s.sessions {
+			sessions = append(sessions, sess)
+		}
+		s.mu.Unlock()
+		statuses := s.statuses(subscribed(sessions), from, tip.BlockNumber)
+		for _, sess := range sessions {
+			if err := s.notify(sess, tip, statuses); err != nil {
+				// a failed write leaves a partial line, or a client that is not reading; drop the session
+				log.Debugf("electrum session %s notification error: %v", sess.conn.RemoteAddr(), err)
+				sess.conn.Close()
+			}
+		}
+	}
+}
+
+// scriptStatus is the status of a script hash at a tip, or the error looking it up
+type scriptStatus struct {
+	status *string
+	err    error
+}
+
+// subscribed returns the script hashes the sessions are subscribed to, each once
+func subscribed(sessions []*session) []string {
+	seen := make(map[string]bool)
+	var scriptHashes []string
+	for _, sess := range sessions {
+		sess.mu.Lock()
+		for scriptHash := range sess.scriptHashes {
+			if !seen[scriptHash] {
+				seen[scriptHash] = true
+				scriptHashes = append(scriptHashes, scriptHash)
+			}
+		}
+		sess.mu.Unlock()
+	}
+	return scriptHashes
+}
+
+// statuses looks up the statuses, at the tip at height to, of the script hashes that transactions from the height from
+// pay to or spend from, which are found in batches of touchedBatchSize; with from below 0, or if finding them fails,
+// every script hash is looked up
+// Each script hash is looked up once, however many sessions subscribe to it
+func (s *Server) statuses(scriptHashes []string, from, to int64) map[string]scriptStatus {
+	changed := scriptHashes
+	if from >= 0 {
+		touched, err := s.touched(scriptHashes, from, to)
+		if err != nil {
+			log.Errorf("electrum script hash lookup error: %v", err)
+		} else {
+			changed = touched
+		}
+	}
+	statuses := make(map[string]scriptStatus, len(changed))
+	for _, scriptHash := range changed {
+		var lookup scriptStatus
+		lookup.status, lookup.err = s.status(scriptHash)
+		if lookup.err != nil {
+			log.Errorf("electrum script hash %s status error: %v", scriptHash, lookup.err)
+		}
+		statuses[scriptHash] = lookup
+	}
+	return statuses
+}
+
+// touched returns those of the script hashes that transactions between the heights from and to pay to or spend from
+func (s *Server) touched(scriptHashes []string, from, to int64) ([]string, error) {
+	byHash := make(map[string]string, len(scriptHashes))
+	batch := make([][]byte, 0, touchedBatchSize)
+	var touched []string
+	for i, scriptHash := range scriptHashes {
+		// subscriptions are only stored for script hashes that decoded
+		hash, _ := decodeScriptHash(scriptHash)
+		byHash[string(hash)] = scriptHash
+		batch = append(batch, hash)
+		if len(batch) < touchedBatchSize && i < len(scriptHashes)-1 {
+			continue
+		}
+		hashes, err := s.reader.ScriptHashesTouched(batch, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, hash := range hashes {
+			touched = append(touched, byHash[string(hash)])
+		}
+		batch = batch[:0]
+	}
+	return touched, nil
+}
+
+// notify sends the session the new tip, if it subscribed to headers, and the new status of each of its subscribed
+// script hashes whose status changed; it returns only write errors
+// statuses holds the statuses at the tip of the script hashes that may have changed; the others are skipped
+func (s *Server) notify(sess *session, tip *btc.IndexedHeader, statuses map[string]scriptStatus) error {
+	sess.mu.Lock()
+	headers := sess.headers
+	scriptHashes := make([]string, 0, len(sess.scriptHashes))
+	for scriptHash := range sess.scriptHashes {
+		scriptHashes = append(scriptHashes, scriptHash)
+	}
+	sess.mu.Unlock()
+	if headers {
+		if err := sess.send(notification{JSONRPC: "2.0", Method: "blockchain.headers.subscribe", Params: []interface{}{headerResult(tip)}}); err != nil {
+			return err
+		}
+	}
+	for _, scriptHash := range scriptHashes {
+		lookup, ok := statuses[scriptHash]
+		if !ok || lookup.err != nil {
+			continue
+		}
+		status := lookup.status
+		sess.mu.Lock()
+		last, subscribed := sess.scriptHashes[scriptHash]
+		changed := subscribed && !equalStatus(last, status)
+		if changed {
+			sess.scriptHashes[scriptHash] = status
+		}
+		sess.mu.Unlock()
+		if !changed {
+			continue
+		}
+		if err := sess.send(notification{JSONRPC: "2.0", Method: "blockchain.scripthash.subscribe", Params: []interface{}{scriptHash, status}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func equalStatus(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
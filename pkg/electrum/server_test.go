@@ -0,0 +1,273 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package electrum_test
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/electrum"
+)
+
+// pipeListener hands the server the server ends of in-memory connections
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (l *pipeListener) Close() error {
+	close(l.closed)
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+// dial connects a client to the server through the listener
+func (l *pipeListener) dial() *client {
+	serverConn, clientConn := net.Pipe()
+	l.conns <- serverConn
+	return &client{conn: clientConn, reader: bufio.NewReader(clientConn)}
+}
+
+type client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	nextID int
+}
+
+type message struct {
+	ID     *int              `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Result json.RawMessage   `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// read reads the next message the server sends
+func (c *client) read() message {
+	Expect(c.conn.SetReadDeadline(time.Now().Add(time.Second * 5))).To(Succeed())
+	line, err := c.reader.ReadBytes('\n')
+	Expect(err).ToNot(HaveOccurred())
+	var msg message
+	Expect(json.Unmarshal(line, &msg)).To(Succeed())
+	return msg
+}
+
+// call sends a request and returns its result
+func (c *client) call(method string, params ...interface{}) json.RawMessage {
+	msg := c.request(method, params...)
+	Expect(msg.Error).To(BeNil())
+	return msg.Result
+}
+
+// request sends a request and returns the response
+func (c *client) request(method string, params ...interface{}) message {
+	c.nextID++
+	line, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": c.nextID, "method": method, "params": params})
+	Expect(err).ToNot(HaveOccurred())
+	Expect(c.conn.SetWriteDeadline(time.Now().Add(time.Second * 5))).To(Succeed())
+	_, err = c.conn.Write(append(line, '\n'))
+	Expect(err).ToNot(HaveOccurred())
+	msg := c.read()
+	Expect(*msg.ID).To(Equal(c.nextID))
+	return msg
+}
+
+// electrumScriptHash returns the Electrum script hash of the script: its sha256, byte reversed, in hex
+func electrumScriptHash(pkScript []byte) string {
+	hash := btc.ScriptHash(pkScript)
+	for i, j := 0, len(hash)-1; i < j; i, j = i+1, j-1 {
+		hash[i], hash[j] = hash[j], hash[i]
+	}
+	return hex.EncodeToString(hash)
+}
+
+var _ = Describe("Server", func() {
+	var (
+		reader       *mocks.ChainReader
+		server       *electrum.Server
+		listener     *pipeListener
+		writeTimeout = time.Millisecond * 50
+		// no script has a history under this hash
+		unusedScriptHash = hex.EncodeToString(make([]byte, 32))
+		// the mock block's transactions, under a header committing to them
+		parent  = mocks.ChildBlock(&mocks.MockBlock.Header, 0, mocks.MockBlock.Transactions...)
+		funding = parent.Transactions[1]
+		child   *wire.MsgBlock
+	)
+	BeforeEach(func() {
+		coinbase := wire.NewMsgTx(1)
+		coinbase.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex}, SignatureScript: []byte{0x01, 0x02}})
+		coinbase.AddTxOut(wire.NewTxOut(50e8, funding.TxOut[0].PkScript))
+		child = mocks.ChildBlock(&parent.Header, 1, coinbase)
+
+		reader = mocks.NewChainReader(&chaincfg.MainNetParams)
+		reader.Add(mocks.MockBlockHeight, parent, true)
+		server = electrum.NewServer(reader, &chaincfg.MainNetParams, time.Millisecond*10, writeTimeout, 3, 2)
+		listener = newPipeListener()
+		server.Start(listener)
+		// let the first poll see the tip, so that only the child is notified
+		Eventually(func() int { return calls(reader, "Tip") }).Should(BeNumerically(">", 0))
+	})
+	AfterEach(func() {
+		Expect(server.Close()).To(Succeed())
+	})
+
+	It("Looks up a script's status once per tip for all the sessions subscribed to it", func() {
+		scriptHash := electrumScriptHash(funding.TxOut[0].PkScript)
+		clients := []*client{listener.dial(), listener.dial()}
+		statuses := make([]string, len(clients))
+		for i, c := range clients {
+			Expect(json.Unmarshal(c.call("blockchain.scripthash.subscribe", scriptHash), &statuses[i])).To(Succeed())
+		}
+		Expect(statuses[1]).To(Equal(statuses[0]))
+		Expect(calls(reader, "ScriptHashTxs")).To(Equal(len(clients)))
+
+		reader.Add(mocks.MockBlockHeight+1, child, true)
+		// the clients read concurrently, as a session that is not read from is closed
+		msgs := make([]message, len(clients))
+		var wg sync.WaitGroup
+		for i, c := range clients {
+			wg.Add(1)
+			go func(i int, c *client) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				msgs[i] = c.read()
+			}(i, c)
+		}
+		wg.Wait()
+		for _, msg := range msgs {
+			Expect(msg.Method).To(Equal("blockchain.scripthash.subscribe"))
+			Expect(msg.Params).To(HaveLen(2))
+			var notified, status string
+			Expect(json.Unmarshal(msg.Params[0], &notified)).To(Succeed())
+			Expect(json.Unmarshal(msg.Params[1], &status)).To(Succeed())
+			Expect(notified).To(Equal(scriptHash))
+			Expect(status).ToNot(Equal(statuses[0]))
+		}
+		Expect(calls(reader, "ScriptHashTxs")).To(Equal(len(clients) + 1))
+		Expect(calls(reader, "ScriptHashesTouched")).To(Equal(1))
+	})
+
+	It("Only looks up the statuses of the scripts touched by the blocks above the last tip", func() {
+		c := listener.dial()
+		c.call("blockchain.headers.subscribe")
+		c.call("blockchain.scripthash.subscribe", unusedScriptHash)
+		Expect(calls(reader, "ScriptHashTxs")).To(Equal(1))
+
+		reader.Add(mocks.MockBlockHeight+1, child, true)
+		Expect(c.read().Method).To(Equal("blockchain.headers.subscribe"))
+		Expect(calls(reader, "ScriptHashesTouched")).To(Equal(1))
+		Expect(calls(reader, "ScriptHashTxs")).To(Equal(1))
+	})
+
+	It("Refuses subscriptions to more script hashes than a session is allowed", func() {
+		c := listener.dial()
+		c.call("blockchain.scripthash.subscribe", unusedScriptHash)
+		c.call("blockchain.scripthash.subscribe", electrumScriptHash(funding.TxOut[0].PkScript))
+		msg := c.request("blockchain.scripthash.subscribe", hex.EncodeToString(append(make([]byte, 31), 1)))
+		Expect(msg.Error).ToNot(BeNil())
+		Expect(msg.Error.Message).To(ContainSubstring("too many subscriptions"))
+		// resubscribing to a script hash does not count against the limit
+		c.call("blockchain.scripthash.subscribe", unusedScriptHash)
+	})
+
+	It("Closes the connections beyond the limit", func() {
+		clients := []*client{listener.dial(), listener.dial(), listener.dial()}
+		for _, c := range clients {
+			c.call("server.ping")
+		}
+		refused := listener.dial()
+		refused.conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err := refused.reader.ReadBytes('\n')
+		Expect(err).To(Equal(io.EOF))
+
+		Expect(clients[0].conn.Close()).To(Succeed())
+		Eventually(func() error {
+			c := listener.dial()
+			defer c.conn.Close()
+			c.conn.SetDeadline(time.Now().Add(time.Second))
+			line, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "server.ping"})
+			if _, err := c.conn.Write(append(line, '\n')); err != nil {
+				return err
+			}
+			_, err := c.reader.ReadBytes('\n')
+			return err
+		}).Should(Succeed())
+	})
+
+	It("Closes a session that does not read its notifications, and keeps notifying the others", func() {
+		stalled, reading := listener.dial(), listener.dial()
+		stalled.call("blockchain.headers.subscribe")
+		reading.call("blockchain.headers.subscribe")
+
+		reader.Add(mocks.MockBlockHeight+1, child, true)
+		msg := reading.read()
+		Expect(msg.Method).To(Equal("blockchain.headers.subscribe"))
+		var tip struct {
+			Height int64 `json:"height"`
+		}
+		Expect(msg.Params).To(HaveLen(1))
+		Expect(json.Unmarshal(msg.Params[0], &tip)).To(Succeed())
+		Expect(tip.Height).To(Equal(mocks.MockBlockHeight + 1))
+
+		// the stalled session's notification times out, unread, and its connection is closed
+		time.Sleep(writeTimeout * 4)
+		// fails once the server closed its end, and bounds the read if it did not
+		stalled.conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err := stalled.reader.ReadBytes('\n')
+		Expect(err).To(Equal(io.EOF))
+		reading.call("server.ping")
+	})
+})
+
+func calls(reader *mocks.ChainReader, method string) int {
+	reader.Lock()
+	defer reader.Unlock()
+	return reader.Calls[method]
+}
@@ -0,0 +1,70 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package electrum
+
+import (
+	"net"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+	log "github.com/sirupsen/logrus"
+)
+
+// Service serves the Server over tcp as a node.Service
+type Service struct {
+	addr    string
+	server  *Server
+	started bool
+}
+
+// NewService creates a Service serving the server on the given address
+func NewService(addr string, server *Server) *Service {
+	return &Service{
+		addr:   addr,
+		server: server,
+	}
+}
+
+// Protocols exports the services p2p protocols, this service has none
+func (es *Service) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{}
+}
+
+// APIs returns the RPC descriptors the service offers over the node's own rpc, it has none
+func (es *Service) APIs() []rpc.API {
+	return []rpc.API{}
+}
+
+// Start binds the address, so that a port conflict fails the start, and then serves the protocol in the background
+func (es *Service) Start(*p2p.Server) error {
+	listener, err := net.Listen("tcp", es.addr)
+	if err != nil {
+		return err
+	}
+	log.Infof("serving electrum at tcp://%s", es.addr)
+	es.server.Start(listener)
+	es.started = true
+	return nil
+}
+
+// Stop closes the listener and the open connections
+func (es *Service) Stop() error {
+	if !es.started {
+		return nil
+	}
+	return es.server.Close()
+}
@@ -0,0 +1,55 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package explorer
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// Env variables
+const (
+	EXPLORER_ENABLED   = "EXPLORER_ENABLED"
+	EXPLORER_HTTP_ADDR = "EXPLORER_HTTP_ADDR"
+	EXPLORER_HTTP_PORT = "EXPLORER_HTTP_PORT"
+)
+
+// Config holds the settings for the block explorer server
+type Config struct {
+	Enabled  bool // Serve the explorer API from the serve command
+	HTTPAddr string
+	HTTPPort int
+}
+
+// NewConfig is used to initialize an explorer config from a .toml file
+func NewConfig() Config {
+	viper.BindEnv("explorer.enabled", EXPLORER_ENABLED)
+	viper.BindEnv("explorer.httpAddr", EXPLORER_HTTP_ADDR)
+	viper.BindEnv("explorer.httpPort", EXPLORER_HTTP_PORT)
+
+	return Config{
+		Enabled:  viper.GetBool("explorer.enabled"),
+		HTTPAddr: viper.GetString("explorer.httpAddr"),
+		HTTPPort: viper.GetInt("explorer.httpPort"),
+	}
+}
+
+// Address returns the host:port the explorer API is served on
+func (c Config) Address() string {
+	return fmt.Sprintf("%s:%d", c.HTTPAddr, c.HTTPPort)
+}
@@ -0,0 +1,178 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package explorer
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+)
+
+// page is a page of a paginated list; NextCursor is passed as the after parameter to fetch the next page
+type page struct {
+	Items      interface{} `json:"items"`
+	NextCursor *string     `json:"next_cursor"`
+}
+
+// blockResult describes a block
+type blockResult struct {
+	ID                string `json:"id"`
+	Height            int64  `json:"height"`
+	Version           int32  `json:"version"`
+	Timestamp         int64  `json:"timestamp"`
+	TxCount           int    `json:"tx_count"`
+	MerkleRoot        string `json:"merkle_root"`
+	PreviousBlockHash string `json:"previousblockhash"`
+	Nonce             uint32 `json:"nonce"`
+	Bits              uint32 `json:"bits"`
+	CID               string `json:"cid"`
+	InBestChain       bool   `json:"in_best_chain"`
+	TimesValidated    int64  `json:"times_validated"`
+}
+
+// statusResult describes the block a transaction or output is confirmed in
+type statusResult struct {
+	Confirmed   bool   `json:"confirmed"`
+	BlockHeight int64  `json:"block_height"`
+	BlockHash   string `json:"block_hash"`
+	BlockTime   int64  `json:"block_time"`
+}
+
+// txResult describes a transaction, assembled from its indexed inputs and outputs and its decoded IPLD
+type txResult struct {
+	TxID     string       `json:"txid"`
+	Version  int32        `json:"version"`
+	LockTime uint32       `json:"locktime"`
+	Size     int          `json:"size"`
+	Weight   int64        `json:"weight"`
+	CID      string       `json:"cid"`
+	Vin      []vinResult  `json:"vin"`
+	Vout     []voutResult `json:"vout"`
+	Status   statusResult `json:"status"`
+}
+
+type vinResult struct {
+	TxID       string   `json:"txid"`
+	Vout       uint32   `json:"vout"`
+	ScriptSig  string   `json:"scriptsig"`
+	Witness    []string `json:"witness,omitempty"`
+	IsCoinbase bool     `json:"is_coinbase"`
+	Sequence   uint32   `json:"sequence"`
+}
+
+type voutResult struct {
+	ScriptPubKey          string   `json:"scriptpubkey"`
+	ScriptPubKeyType      string   `json:"scriptpubkey_type"`
+	ScriptPubKeyAddresses []string `json:"scriptpubkey_addresses,omitempty"`
+	Value                 int64    `json:"value"`
+}
+
+// outspendResult describes whether an output is spent, and by which input
+type outspendResult struct {
+	Spent  bool          `json:"spent"`
+	TxID   string        `json:"txid,omitempty"`
+	Vin    *uint32       `json:"vin,omitempty"`
+	Status *statusResult `json:"status,omitempty"`
+}
+
+// utxoResult describes an unspent output
+type utxoResult struct {
+	TxID   string       `json:"txid"`
+	Vout   uint32       `json:"vout"`
+	Value  int64        `json:"value"`
+	Status statusResult `json:"status"`
+}
+
+func newBlockResult(header *btc.IndexedHeader, wireHeader *wire.BlockHeader, txCount int, canonical bool) blockResult {
+	return blockResult{
+		ID:                header.BlockHash,
+		Height:            header.BlockNumber,
+		Version:           wireHeader.Version,
+		Timestamp:         wireHeader.Timestamp.Unix(),
+		TxCount:           txCount,
+		MerkleRoot:        wireHeader.MerkleRoot.String(),
+		PreviousBlockHash: header.ParentHash,
+		Nonce:             wireHeader.Nonce,
+		Bits:              wireHeader.Bits,
+		CID:               header.CID,
+		InBestChain:       canonical,
+		TimesValidated:    header.TimesValidated,
+	}
+}
+
+func newStatusResult(header *btc.IndexedHeader, wireHeader *wire.BlockHeader) statusResult {
+	return statusResult{
+		Confirmed:   true,
+		BlockHeight: header.BlockNumber,
+		BlockHash:   header.BlockHash,
+		BlockTime:   wireHeader.Timestamp.Unix(),
+	}
+}
+
+// newTxResult assembles a transaction from its rows in btc.tx_inputs and btc.tx_outputs, taking the fields that are
+// not indexed (version, lock time, sequences, size and weight) from the decoded IPLD
+func newTxResult(tx *btc.IndexedTx, inputs []btc.TxInput, outputs []btc.TxOutput, status statusResult) (*txResult, error) {
+	msgTx, err := tx.MsgTx()
+	if err != nil {
+		return nil, err
+	}
+	if len(inputs) != len(msgTx.TxIn) || len(outputs) != len(msgTx.TxOut) {
+		return nil, fmt.Errorf("transaction %s has %d inputs and %d outputs indexed but %d and %d in its IPLD",
+			tx.TxHash, len(inputs), len(outputs), len(msgTx.TxIn), len(msgTx.TxOut))
+	}
+	result := &txResult{
+		TxID:     tx.TxHash,
+		Version:  msgTx.Version,
+		LockTime: msgTx.LockTime,
+		Size:     msgTx.SerializeSize(),
+		Weight:   blockchain.GetTransactionWeight(btcutil.NewTx(msgTx)),
+		CID:      tx.CID,
+		Vin:      make([]vinResult, len(inputs)),
+		Vout:     make([]voutResult, len(outputs)),
+		Status:   status,
+	}
+	for i, input := range inputs {
+		result.Vin[i] = vinResult{
+			TxID:       input.PreviousOutPointHash,
+			Vout:       input.PreviousOutPointIndex,
+			ScriptSig:  hex.EncodeToString(input.SignatureScript),
+			Witness:    input.TxWitness,
+			IsCoinbase: isCoinbase(input),
+			Sequence:   msgTx.TxIn[i].Sequence,
+		}
+	}
+	for i, output := range outputs {
+		result.Vout[i] = voutResult{
+			ScriptPubKey:          hex.EncodeToString(output.PkScript),
+			ScriptPubKeyType:      txscript.ScriptClass(output.ScriptClass).String(),
+			ScriptPubKeyAddresses: output.Addresses,
+			Value:                 output.Value,
+		}
+	}
+	return result, nil
+}
+
+func isCoinbase(input btc.TxInput) bool {
+	return input.PreviousOutPointHash == (chainhash.Hash{}).String() && input.PreviousOutPointIndex == wire.MaxPrevOutIndex
+}
@@ -0,0 +1,407 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package explorer
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+)
+
+const (
+	// DefaultPageSize is the number of items in a page when limit is not given
+	DefaultPageSize = 25
+	// MaxPageSize bounds limit
+	MaxPageSize = 100
+)
+
+// httpError is an error answered with its status code
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{status: http.StatusBadRequest, msg: fmt.Sprintf(format, args...)}
+}
+
+var errNotFound = &httpError{status: http.StatusNotFound, msg: "not found"}
+
+// Server answers block explorer queries from the index, over http
+//
+//	GET /blocks/tip                the canonical tip
+//	GET /block/{hash|height}       a block by hash, or the canonical block at a height
+//	GET /block/{hash|height}/txs   a page of the block's transactions
+//	GET /tx/{txid}                 a canonical transaction
+//	GET /tx/{txid}/outspends       the canonical input spending each of the transaction's outputs, if any
+//	GET /address/{address}/txs     a page of the canonical transactions paying to or spending from the address
+//	GET /address/{address}/utxo    a page of the canonical outputs paying to the address that are not spent
+//
+// Pages hold at most the limit query parameter (default DefaultPageSize) items, and the next page is fetched by
+// passing the page's next_cursor as the after query parameter
+type Server struct {
+	reader btc.ChainReader
+}
+
+// NewServer creates a Server reading from the chain reader
+func NewServer(reader btc.ChainReader) *Server {
+	return &Server{
+		reader: reader,
+	}
+}
+
+// ServeHTTP satisfies the http.Handler interface
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "explorer server handles only GET requests", http.StatusMethodNotAllowed)
+		return
+	}
+	result, err := s.route(r.URL.Path, r.URL.Query())
+	if err != nil {
+		var httpErr *httpError
+		switch {
+		case errors.As(err, &httpErr):
+		case errors.Is(err, btc.ErrNotIndexed):
+			httpErr = &httpError{status: http.StatusNotFound, msg: err.Error()}
+		default:
+			log.Errorf("explorer %s error: %v", r.URL.Path, err)
+			httpErr = &httpError{status: http.StatusInternalServerError, msg: err.Error()}
+		}
+		writeJSON(w, httpErr.status, map[string]string{"error": httpErr.msg})
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// route dispatches the path to its handler
+func (s *Server) route(path string, query url.Values) (interface{}, error) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(segments) == 2 && segments[0] == "blocks" && segments[1] == "tip":
+		tip, err := s.reader.Tip()
+		if err != nil {
+			return nil, err
+		}
+		return s.block(tip)
+	case len(segments) == 2 && segments[0] == "block":
+		header, err := s.header(segments[1])
+		if err != nil {
+			return nil, err
+		}
+		return s.block(header)
+	case len(segments) == 3 && segments[0] == "block" && segments[2] == "txs":
+		return s.blockTxs(segments[1], query)
+	case len(segments) == 2 && segments[0] == "tx":
+		tx, header, err := s.reader.Tx(segments[1])
+		if err != nil {
+			return nil, err
+		}
+		return s.tx(tx, header)
+	case len(segments) == 3 && segments[0] == "tx" && segments[2] == "outspends":
+		return s.outspends(segments[1])
+	case len(segments) == 3 && segments[0] == "address" && segments[2] == "txs":
+		return s.addressTxs(segments[1], query)
+	case len(segments) == 3 && segments[0] == "address" && segments[2] == "utxo":
+		return s.addressUTXO(segments[1], query)
+	}
+	return nil, errNotFound
+}
+
+// header returns the block with the hash, or the canonical block at the height
+func (s *Server) header(id string) (*btc.IndexedHeader, error) {
+	if len(id) == chainhash.MaxHashStringSize {
+		if _, err := hex.DecodeString(id); err != nil {
+			return nil, badRequest("invalid block hash %s", id)
+		}
+		return s.reader.HeaderByHash(id)
+	}
+	height, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, badRequest("invalid block hash or height %s", id)
+	}
+	return s.reader.HeaderAt(height)
+}
+
+func (s *Server) block(header *btc.IndexedHeader) (*blockResult, error) {
+	wireHeader, err := header.WireHeader()
+	if err != nil {
+		return nil, err
+	}
+	txCount, err := s.reader.TxCount(header)
+	if err != nil {
+		return nil, err
+	}
+	canonical, err := s.reader.IsCanonical(header)
+	if err != nil {
+		return nil, err
+	}
+	result := newBlockResult(header, wireHeader, int(txCount), canonical)
+	return &result, nil
+}
+
+// blockTxs returns a page of the block's transactions, in block order
+func (s *Server) blockTxs(id string, query url.Values) (*page, error) {
+	limit, cursor, err := pageParams(query, 1)
+	if err != nil {
+		return nil, err
+	}
+	header, err := s.header(id)
+	if err != nil {
+		return nil, err
+	}
+	afterIndex := int64(-1)
+	if cursor != nil {
+		afterIndex = cursor[0]
+	}
+	txs, err := s.reader.BlockTxs(header, afterIndex, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*txResult, 0, limit)
+	p := &page{Items: &results}
+	if len(txs) > limit {
+		txs = txs[:limit]
+		p.NextCursor = encodeCursor(txs[limit-1].Index)
+	}
+	for i := range txs {
+		result, err := s.tx(&txs[i], header)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return p, nil
+}
+
+func (s *Server) tx(tx *btc.IndexedTx, header *btc.IndexedHeader) (*txResult, error) {
+	wireHeader, err := header.WireHeader()
+	if err != nil {
+		return nil, err
+	}
+	inputs, err := s.reader.Inputs(tx)
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := s.reader.Outputs(tx)
+	if err != nil {
+		return nil, err
+	}
+	return newTxResult(tx, inputs, outputs, newStatusResult(header, wireHeader))
+}
+
+// outspends returns, for each of the transaction's outputs, the canonical input spending it if there is one
+func (s *Server) outspends(txid string) ([]outspendResult, error) {
+	tx, _, err := s.reader.Tx(txid)
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := s.reader.Outputs(tx)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]outspendResult, len(outputs))
+	for i, output := range outputs {
+		spender, err := s.reader.Spender(txid, uint32(output.Index))
+		if errors.Is(err, btc.ErrNotIndexed) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		inputs, err := s.reader.Inputs(spender)
+		if err != nil {
+			return nil, err
+		}
+		header, err := s.reader.HeaderAt(spender.BlockNumber)
+		if err != nil {
+			return nil, err
+		}
+		status, err := statusOf(header)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = outspendResult{Spent: true, TxID: spender.TxHash, Status: &status}
+		for _, input := range inputs {
+			if input.PreviousOutPointHash == txid && int64(input.PreviousOutPointIndex) == output.Index {
+				vin := uint32(input.Index)
+				results[i].Vin = &vin
+				break
+			}
+		}
+	}
+	return results, nil
+}
+
+// addressTxs returns a page of the canonical transactions paying to or spending from the address, in chain order
+func (s *Server) addressTxs(address string, query url.Values) (*page, error) {
+	limit, cursor, err := pageParams(query, 2)
+	if err != nil {
+		return nil, err
+	}
+	from, afterIndex := int64(0), int64(-1)
+	if cursor != nil {
+		from, afterIndex = cursor[0], cursor[1]
+	}
+	txs, err := s.reader.AddressTxs(address, from, math.MaxInt64, afterIndex, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*txResult, 0, limit)
+	p := &page{Items: &results}
+	if len(txs) > limit {
+		txs = txs[:limit]
+		p.NextCursor = encodeCursor(txs[limit-1].BlockNumber, txs[limit-1].Index)
+	}
+	headers := make(map[int64]*btc.IndexedHeader)
+	for i := range txs {
+		header, err := s.canonicalHeader(headers, txs[i].BlockNumber)
+		if err != nil {
+			return nil, err
+		}
+		result, err := s.tx(&txs[i], header)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return p, nil
+}
+
+// addressUTXO returns a page of the canonical outputs paying to the address that no canonical input spends, in chain order
+func (s *Server) addressUTXO(address string, query url.Values) (*page, error) {
+	limit, cursor, err := pageParams(query, 2)
+	if err != nil {
+		return nil, err
+	}
+	from, afterID := int64(0), int64(0)
+	if cursor != nil {
+		from, afterID = cursor[0], cursor[1]
+	}
+	unspent, err := s.reader.AddressUnspentOutputs(address, from, math.MaxInt64, afterID, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]utxoResult, 0, limit)
+	p := &page{Items: &results}
+	if len(unspent) > limit {
+		unspent = unspent[:limit]
+		p.NextCursor = encodeCursor(unspent[limit-1].BlockNumber, unspent[limit-1].ID)
+	}
+	headers := make(map[int64]*btc.IndexedHeader)
+	for _, output := range unspent {
+		header, err := s.canonicalHeader(headers, output.BlockNumber)
+		if err != nil {
+			return nil, err
+		}
+		status, err := statusOf(header)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, utxoResult{
+			TxID:   output.TxHash,
+			Vout:   uint32(output.Index),
+			Value:  output.Value,
+			Status: status,
+		})
+	}
+	return p, nil
+}
+
+// canonicalHeader returns the canonical header at the height, caching it in headers
+func (s *Server) canonicalHeader(headers map[int64]*btc.IndexedHeader, height int64) (*btc.IndexedHeader, error) {
+	if header, ok := headers[height]; ok {
+		return header, nil
+	}
+	header, err := s.reader.HeaderAt(height)
+	if err != nil {
+		return nil, err
+	}
+	headers[height] = header
+	return header, nil
+}
+
+func statusOf(header *btc.IndexedHeader) (statusResult, error) {
+	wireHeader, err := header.WireHeader()
+	if err != nil {
+		return statusResult{}, err
+	}
+	return newStatusResult(header, wireHeader), nil
+}
+
+// pageParams parses the limit and the after cursor, with its n positions, from the query
+func pageParams(query url.Values, n int) (int, []int64, error) {
+	limit := DefaultPageSize
+	if raw := query.Get("limit"); raw != "" {
+		var err error
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 1 || limit > MaxPageSize {
+			return 0, nil, badRequest("limit must be between 1 and %d", MaxPageSize)
+		}
+	}
+	after := query.Get("after")
+	if after == "" {
+		return limit, nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(after)
+	if err != nil {
+		return 0, nil, badRequest("invalid cursor %s", after)
+	}
+	fields := strings.Split(string(raw), ":")
+	if len(fields) != n {
+		return 0, nil, badRequest("invalid cursor %s", after)
+	}
+	cursor := make([]int64, n)
+	for i, field := range fields {
+		if cursor[i], err = strconv.ParseInt(field, 10, 64); err != nil {
+			return 0, nil, badRequest("invalid cursor %s", after)
+		}
+	}
+	return limit, cursor, nil
+}
+
+// encodeCursor encodes an item's positions into an opaque, url safe cursor
+func encodeCursor(positions ...int64) *string {
+	fields := make([]string, len(positions))
+	for i, position := range positions {
+		fields[i] = strconv.FormatInt(position, 10)
+	}
+	cursor := base64.RawURLEncoding.EncodeToString([]byte(strings.Join(fields, ":")))
+	return &cursor
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Errorf("explorer response write error: %v", err)
+	}
+}
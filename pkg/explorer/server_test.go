@@ -0,0 +1,299 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package explorer_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/explorer"
+)
+
+type status struct {
+	Confirmed   bool   `json:"confirmed"`
+	BlockHeight int64  `json:"block_height"`
+	BlockHash   string `json:"block_hash"`
+}
+
+type block struct {
+	ID          string `json:"id"`
+	Height      int64  `json:"height"`
+	TxCount     int    `json:"tx_count"`
+	MerkleRoot  string `json:"merkle_root"`
+	InBestChain bool   `json:"in_best_chain"`
+}
+
+type tx struct {
+	TxID string `json:"txid"`
+	Vin  []struct {
+		TxID       string `json:"txid"`
+		Vout       uint32 `json:"vout"`
+		IsCoinbase bool   `json:"is_coinbase"`
+	} `json:"vin"`
+	Vout []struct {
+		Value int64 `json:"value"`
+	} `json:"vout"`
+	Status status `json:"status"`
+}
+
+type outspend struct {
+	Spent  bool    `json:"spent"`
+	TxID   string  `json:"txid"`
+	Vin    *uint32 `json:"vin"`
+	Status *status `json:"status"`
+}
+
+type utxo struct {
+	TxID   string `json:"txid"`
+	Vout   uint32 `json:"vout"`
+	Value  int64  `json:"value"`
+	Status status `json:"status"`
+}
+
+type txPage struct {
+	Items      []tx    `json:"items"`
+	NextCursor *string `json:"next_cursor"`
+}
+
+type utxoPage struct {
+	Items      []utxo  `json:"items"`
+	NextCursor *string `json:"next_cursor"`
+}
+
+// addressOf returns the address that the script pays to
+func addressOf(pkScript []byte) string {
+	_, addresses, _, err := txscript.ExtractPkScriptAddrs(pkScript, &chaincfg.MainNetParams)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(addresses).To(HaveLen(1))
+	return addresses[0].EncodeAddress()
+}
+
+var _ = Describe("Server", func() {
+	var (
+		reader *mocks.ChainReader
+		server *explorer.Server
+		// the mock block's transactions, under a header committing to them
+		parent   = mocks.ChildBlock(&mocks.MockBlock.Header, 0, mocks.MockBlock.Transactions...)
+		funding  = parent.Transactions[1]
+		coinbase *wire.MsgTx
+		spending *wire.MsgTx
+		child    *wire.MsgBlock
+		fork     *wire.MsgBlock
+	)
+	BeforeEach(func() {
+		coinbase = wire.NewMsgTx(1)
+		coinbase.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex}, SignatureScript: []byte{0x01, 0x02}})
+		coinbase.AddTxOut(wire.NewTxOut(50e8, funding.TxOut[0].PkScript))
+		spending = wire.NewMsgTx(1)
+		fundingHash := funding.TxHash()
+		spending.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&fundingHash, 0), []byte{0x51}, nil))
+		spending.AddTxOut(wire.NewTxOut(funding.TxOut[0].Value, funding.TxOut[1].PkScript))
+		child = mocks.ChildBlock(&parent.Header, 1, coinbase, spending)
+		fork = mocks.ChildBlock(&parent.Header, 2, coinbase)
+
+		reader = mocks.NewChainReader(&chaincfg.MainNetParams)
+		reader.Add(mocks.MockBlockHeight, parent, true)
+		reader.Add(mocks.MockBlockHeight+1, child, true)
+		reader.Add(mocks.MockBlockHeight+1, fork, false)
+		server = explorer.NewServer(reader)
+	})
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+	get := func(path string, v interface{}) {
+		rec := request(http.MethodGet, path)
+		Expect(rec.Code).To(Equal(http.StatusOK), rec.Body.String())
+		Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(json.Unmarshal(rec.Body.Bytes(), v)).To(Succeed())
+	}
+	getError := func(path string, code int) string {
+		rec := request(http.MethodGet, path)
+		Expect(rec.Code).To(Equal(code))
+		var body map[string]string
+		Expect(json.Unmarshal(rec.Body.Bytes(), &body)).To(Succeed())
+		return body["error"]
+	}
+
+	Describe("ServeHTTP", func() {
+		It("Only handles GET requests", func() {
+			Expect(request(http.MethodPost, "/blocks/tip").Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+
+		It("Answers not found for an unknown path", func() {
+			Expect(getError("/blocks", http.StatusNotFound)).To(Equal("not found"))
+			Expect(getError("/tx/a/b/c", http.StatusNotFound)).To(Equal("not found"))
+		})
+
+		It("Answers an internal error for a reader error", func() {
+			reader.Err = errors.New("mock reader error")
+			Expect(getError("/blocks/tip", http.StatusInternalServerError)).To(Equal("mock reader error"))
+		})
+	})
+
+	Describe("blocks", func() {
+		It("Describes the canonical tip", func() {
+			var result block
+			get("/blocks/tip", &result)
+			Expect(result.ID).To(Equal(child.BlockHash().String()))
+			Expect(result.Height).To(Equal(mocks.MockBlockHeight + 1))
+			Expect(result.TxCount).To(Equal(2))
+			Expect(result.MerkleRoot).To(Equal(child.Header.MerkleRoot.String()))
+			Expect(result.InBestChain).To(BeTrue())
+		})
+
+		It("Describes a block by height or by hash, counting its transactions without reading them", func() {
+			var result block
+			get(fmt.Sprintf("/block/%d", mocks.MockBlockHeight), &result)
+			Expect(result.ID).To(Equal(parent.BlockHash().String()))
+			Expect(result.TxCount).To(Equal(len(parent.Transactions)))
+			Expect(reader.Calls["Txs"]).To(BeZero())
+
+			get("/block/"+fork.BlockHash().String(), &result)
+			Expect(result.ID).To(Equal(fork.BlockHash().String()))
+			Expect(result.InBestChain).To(BeFalse())
+		})
+
+		It("Rejects an invalid block id and answers not found for an unindexed one", func() {
+			Expect(getError("/block/abc", http.StatusBadRequest)).To(ContainSubstring("invalid block hash or height"))
+			getError(fmt.Sprintf("/block/%d", mocks.MockBlockHeight+2), http.StatusNotFound)
+		})
+
+		It("Pages through a block's transactions", func() {
+			Expect(len(parent.Transactions)).To(BeNumerically(">", 2))
+			var first txPage
+			get(fmt.Sprintf("/block/%d/txs?limit=2", mocks.MockBlockHeight), &first)
+			Expect(first.Items).To(HaveLen(2))
+			Expect(first.Items[0].TxID).To(Equal(parent.Transactions[0].TxHash().String()))
+			Expect(first.Items[1].TxID).To(Equal(parent.Transactions[1].TxHash().String()))
+			Expect(first.NextCursor).ToNot(BeNil())
+
+			var rest txPage
+			get(fmt.Sprintf("/block/%d/txs?limit=%d&after=%s", mocks.MockBlockHeight, len(parent.Transactions), *first.NextCursor), &rest)
+			Expect(rest.Items).To(HaveLen(len(parent.Transactions) - 2))
+			Expect(rest.Items[0].TxID).To(Equal(parent.Transactions[2].TxHash().String()))
+			Expect(rest.NextCursor).To(BeNil())
+			Expect(reader.Calls["Txs"]).To(BeZero())
+		})
+	})
+
+	Describe("tx", func() {
+		It("Describes a canonical transaction", func() {
+			var result tx
+			get("/tx/"+spending.TxHash().String(), &result)
+			Expect(result.TxID).To(Equal(spending.TxHash().String()))
+			Expect(result.Vin).To(HaveLen(1))
+			Expect(result.Vin[0].TxID).To(Equal(funding.TxHash().String()))
+			Expect(result.Vin[0].Vout).To(BeZero())
+			Expect(result.Vout).To(HaveLen(1))
+			Expect(result.Vout[0].Value).To(Equal(funding.TxOut[0].Value))
+			Expect(result.Status).To(Equal(status{Confirmed: true, BlockHeight: mocks.MockBlockHeight + 1, BlockHash: child.BlockHash().String()}))
+
+			get("/tx/"+coinbase.TxHash().String(), &result)
+			Expect(result.Vin[0].IsCoinbase).To(BeTrue())
+		})
+
+		It("Answers not found for an unindexed transaction", func() {
+			getError("/tx/"+strings.Repeat("0", 64), http.StatusNotFound)
+		})
+
+		It("Describes the canonical input spending each output", func() {
+			var results []outspend
+			get("/tx/"+funding.TxHash().String()+"/outspends", &results)
+			Expect(results).To(HaveLen(len(funding.TxOut)))
+			Expect(results[0].Spent).To(BeTrue())
+			Expect(results[0].TxID).To(Equal(spending.TxHash().String()))
+			Expect(*results[0].Vin).To(BeZero())
+			Expect(results[0].Status.BlockHeight).To(Equal(mocks.MockBlockHeight + 1))
+			for _, result := range results[1:] {
+				Expect(result).To(Equal(outspend{}))
+			}
+		})
+	})
+
+	Describe("address", func() {
+		It("Pages through the canonical transactions paying to or spending from an address", func() {
+			address := addressOf(funding.TxOut[0].PkScript)
+			var first txPage
+			get("/address/"+address+"/txs?limit=2", &first)
+			Expect(first.Items).To(HaveLen(2))
+			Expect(first.Items[0].TxID).To(Equal(funding.TxHash().String()))
+			Expect(first.Items[1].TxID).To(Equal(coinbase.TxHash().String()))
+			Expect(first.NextCursor).ToNot(BeNil())
+
+			var rest txPage
+			get("/address/"+address+"/txs?after="+*first.NextCursor, &rest)
+			Expect(rest.Items).To(HaveLen(1))
+			Expect(rest.Items[0].TxID).To(Equal(spending.TxHash().String()))
+			Expect(rest.NextCursor).To(BeNil())
+		})
+
+		It("Lists the unspent outputs paying to an address without looking up their spenders", func() {
+			var result utxoPage
+			get("/address/"+addressOf(funding.TxOut[0].PkScript)+"/utxo", &result)
+			Expect(result.Items).To(Equal([]utxo{{
+				TxID:   coinbase.TxHash().String(),
+				Value:  50e8,
+				Status: status{Confirmed: true, BlockHeight: mocks.MockBlockHeight + 1, BlockHash: child.BlockHash().String()},
+			}}))
+			Expect(result.NextCursor).To(BeNil())
+			Expect(reader.Calls["AddressUnspentOutputs"]).To(Equal(1))
+			Expect(reader.Calls["Spender"]).To(BeZero())
+		})
+
+		It("Pages through the unspent outputs paying to an address", func() {
+			address := addressOf(funding.TxOut[1].PkScript)
+			var first utxoPage
+			get("/address/"+address+"/utxo?limit=1", &first)
+			Expect(first.Items).To(HaveLen(1))
+			Expect(first.Items[0].TxID).To(Equal(funding.TxHash().String()))
+			Expect(first.Items[0].Vout).To(Equal(uint32(1)))
+			Expect(first.NextCursor).ToNot(BeNil())
+
+			var rest utxoPage
+			get("/address/"+address+"/utxo?limit=1&after="+*first.NextCursor, &rest)
+			Expect(rest.Items).To(HaveLen(1))
+			Expect(rest.Items[0].TxID).To(Equal(spending.TxHash().String()))
+			Expect(rest.Items[0].Vout).To(BeZero())
+			Expect(rest.NextCursor).To(BeNil())
+		})
+
+		It("Rejects an out of range limit and an invalid cursor", func() {
+			address := addressOf(funding.TxOut[0].PkScript)
+			for _, limit := range []int{0, explorer.MaxPageSize + 1} {
+				Expect(getError("/address/"+address+"/utxo?limit="+strconv.Itoa(limit), http.StatusBadRequest)).
+					To(Equal(fmt.Sprintf("limit must be between 1 and %d", explorer.MaxPageSize)))
+			}
+			Expect(getError("/address/"+address+"/utxo?after=***", http.StatusBadRequest)).To(ContainSubstring("invalid cursor"))
+			Expect(getError("/address/"+address+"/txs?after=MQ", http.StatusBadRequest)).To(ContainSubstring("invalid cursor"))
+		})
+	})
+})
@@ -0,0 +1,65 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package gc
+
+import (
+	"github.com/spf13/viper"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/node"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+	"github.com/vulcanize/ipld-btc-indexer/utils"
+)
+
+// Env variables
+const (
+	GC_BATCH_SIZE = "GC_BATCH_SIZE"
+	GC_DRY_RUN    = "GC_DRY_RUN"
+
+	DefaultBatchSize = 10000
+)
+
+// Config holds the parameters needed to garbage collect unreachable IPLDs
+type Config struct {
+	DB       *postgres.DB
+	DBConfig postgres.Config
+	NodeInfo node.Node
+
+	BatchSize uint64 // Number of block heights marked, and IPLDs swept, at a time
+	DryRun    bool   // Only report the unreachable IPLDs, without deleting them
+}
+
+// NewConfig fills and returns a gc config from toml parameters
+func NewConfig() *Config {
+	c := new(Config)
+
+	viper.BindEnv("bitcoin.httpPath", shared.BTC_HTTP_PATH)
+	viper.BindEnv("gc.batchSize", GC_BATCH_SIZE)
+	viper.BindEnv("gc.dryRun", GC_DRY_RUN)
+
+	c.BatchSize = uint64(viper.GetInt64("gc.batchSize"))
+	if c.BatchSize == 0 {
+		c.BatchSize = DefaultBatchSize
+	}
+	c.DryRun = viper.GetBool("gc.dryRun")
+
+	c.NodeInfo, _ = shared.GetBtcNodeAndClient(viper.GetString("bitcoin.httpPath"))
+	c.DBConfig.Init()
+	db := utils.LoadPostgres(c.DBConfig, c.NodeInfo)
+	c.DB = &db
+	return c
+}
@@ -0,0 +1,55 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package graphql
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// Env variables
+const (
+	GRAPHQL_ENABLED   = "GRAPHQL_ENABLED"
+	GRAPHQL_HTTP_ADDR = "GRAPHQL_HTTP_ADDR"
+	GRAPHQL_HTTP_PORT = "GRAPHQL_HTTP_PORT"
+)
+
+// Config holds the settings for the GraphQL server
+type Config struct {
+	Enabled  bool // Serve the GraphQL API from the serve command
+	HTTPAddr string
+	HTTPPort int
+}
+
+// NewConfig is used to initialize a graphql config from a .toml file
+func NewConfig() Config {
+	viper.BindEnv("graphql.enabled", GRAPHQL_ENABLED)
+	viper.BindEnv("graphql.httpAddr", GRAPHQL_HTTP_ADDR)
+	viper.BindEnv("graphql.httpPort", GRAPHQL_HTTP_PORT)
+
+	return Config{
+		Enabled:  viper.GetBool("graphql.enabled"),
+		HTTPAddr: viper.GetString("graphql.httpAddr"),
+		HTTPPort: viper.GetInt("graphql.httpPort"),
+	}
+}
+
+// Address returns the host:port the graphql API is served on
+func (c Config) Address() string {
+	return fmt.Sprintf("%s:%d", c.HTTPAddr, c.HTTPPort)
+}
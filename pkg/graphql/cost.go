@@ -0,0 +1,65 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+)
+
+// MaxCost bounds the reads a query makes from the index: each lookup costs one, and each row it returns one more
+// MaxDepth and MaxPageSize alone still let the pages nested in a query multiply into millions of reads
+const MaxCost = 20000
+
+type costKey struct{}
+
+// costBudget counts the reads made by a query against its limit
+type costBudget struct {
+	limit int64
+	spent int64
+}
+
+// withCostLimit returns a context carrying a fresh budget of limit reads
+func withCostLimit(ctx context.Context, limit int64) context.Context {
+	return context.WithValue(ctx, costKey{}, &costBudget{limit: limit})
+}
+
+// spend charges n reads to the query's budget, failing once it is exceeded so that the query stops fanning out
+// Resolvers charge a lookup before making it, and the rows it returned after
+func spend(ctx context.Context, n int) error {
+	budget, ok := ctx.Value(costKey{}).(*costBudget)
+	if !ok {
+		return nil
+	}
+	if atomic.AddInt64(&budget.spent, int64(n)) > budget.limit {
+		return fmt.Errorf("query exceeds the limit of %d reads from the index", budget.limit)
+	}
+	return nil
+}
+
+// Handler serves the schema over http, giving each query a budget of MaxCost reads
+func Handler(schema *graphql.Schema) http.Handler {
+	handler := &relay.Handler{Schema: schema}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r.WithContext(withCostLimit(r.Context(), MaxCost)))
+	})
+}
@@ -0,0 +1,661 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package graphql
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+)
+
+// Resolver is the root resolver of the schema
+type Resolver struct {
+	reader btc.ChainReader
+}
+
+// NewResolver returns a new Resolver reading from the chain reader
+func NewResolver(reader btc.ChainReader) *Resolver {
+	return &Resolver{
+		reader: reader,
+	}
+}
+
+// Block resolves a block by hash or number, or the tip
+func (r *Resolver) Block(ctx context.Context, args struct {
+	Hash   *string
+	Number *Long
+}) (*Block, error) {
+	if err := spend(ctx, 1); err != nil {
+		return nil, err
+	}
+	var header *btc.IndexedHeader
+	var err error
+	switch {
+	case args.Hash != nil:
+		header, err = r.reader.HeaderByHash(*args.Hash)
+	case args.Number != nil:
+		header, err = r.reader.HeaderAt(int64(*args.Number))
+	default:
+		header, err = r.reader.Tip()
+	}
+	if errors.Is(err, btc.ErrNotIndexed) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newBlock(r.reader, header)
+}
+
+// Blocks resolves a page of the canonical blocks in a height range
+func (r *Resolver) Blocks(ctx context.Context, args struct {
+	From  *Long
+	To    *Long
+	First *int32
+	After *string
+}) (*BlockConnection, error) {
+	page := pageArgs{First: args.First, After: args.After}
+	size, err := page.size()
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := page.cursor(1)
+	if err != nil {
+		return nil, err
+	}
+	from, to := heightRange(args.From, args.To)
+	if cursor != nil && cursor[0] >= from {
+		from = cursor[0] + 1
+	}
+	if err := spend(ctx, 1); err != nil {
+		return nil, err
+	}
+	headers, err := r.reader.Headers(from, to, size+1)
+	if err != nil {
+		return nil, err
+	}
+	if err := spend(ctx, len(headers)); err != nil {
+		return nil, err
+	}
+	connection := &BlockConnection{pageInfo: &PageInfo{}}
+	if len(headers) > size {
+		headers = headers[:size]
+		connection.pageInfo.hasNextPage = true
+	}
+	for i := range headers {
+		block, err := newBlock(r.reader, &headers[i])
+		if err != nil {
+			return nil, err
+		}
+		connection.nodes = append(connection.nodes, block)
+	}
+	if len(headers) > 0 {
+		connection.pageInfo.endCursor = encodeCursor(headers[len(headers)-1].BlockNumber)
+	}
+	return connection, nil
+}
+
+// Transaction resolves a canonical transaction by hash
+func (r *Resolver) Transaction(ctx context.Context, args struct{ Hash string }) (*Transaction, error) {
+	if err := spend(ctx, 1); err != nil {
+		return nil, err
+	}
+	tx, header, err := r.reader.Tx(args.Hash)
+	if errors.Is(err, btc.ErrNotIndexed) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newTransaction(r.reader, tx, header)
+}
+
+// Address resolves an address; it is not validated, an address no output pays to has no outputs
+func (r *Resolver) Address(args struct{ Address string }) *Address {
+	return &Address{
+		reader:  r.reader,
+		address: args.Address,
+	}
+}
+
+// heightRange returns the range bounded by from and to, which default to the genesis block and no upper bound
+func heightRange(from, to *Long) (int64, int64) {
+	start, end := int64(0), int64(math.MaxInt64)
+	if from != nil {
+		start = int64(*from)
+	}
+	if to != nil {
+		end = int64(*to)
+	}
+	return start, end
+}
+
+// Block resolves an indexed header
+type Block struct {
+	reader btc.ChainReader
+	header *btc.IndexedHeader
+	wire   *wire.BlockHeader
+}
+
+func newBlock(reader btc.ChainReader, header *btc.IndexedHeader) (*Block, error) {
+	wireHeader, err := header.WireHeader()
+	if err != nil {
+		return nil, err
+	}
+	return &Block{
+		reader: reader,
+		header: header,
+		wire:   wireHeader,
+	}, nil
+}
+
+func (b *Block) Hash() string {
+	return b.header.BlockHash
+}
+
+func (b *Block) Number() Long {
+	return Long(b.header.BlockNumber)
+}
+
+func (b *Block) ParentHash() string {
+	return b.header.ParentHash
+}
+
+func (b *Block) Parent(ctx context.Context) (*Block, error) {
+	if err := spend(ctx, 1); err != nil {
+		return nil, err
+	}
+	parent, err := b.reader.HeaderByHash(b.header.ParentHash)
+	if errors.Is(err, btc.ErrNotIndexed) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newBlock(b.reader, parent)
+}
+
+func (b *Block) Cid() string {
+	return b.header.CID
+}
+
+func (b *Block) Version() int32 {
+	return b.wire.Version
+}
+
+func (b *Block) MerkleRoot() string {
+	return b.wire.MerkleRoot.String()
+}
+
+func (b *Block) Timestamp() Long {
+	return Long(b.wire.Timestamp.Unix())
+}
+
+func (b *Block) Bits() Long {
+	return Long(b.wire.Bits)
+}
+
+func (b *Block) Nonce() Long {
+	return Long(b.wire.Nonce)
+}
+
+func (b *Block) TimesValidated() int32 {
+	return int32(b.header.TimesValidated)
+}
+
+func (b *Block) Canonical(ctx context.Context) (bool, error) {
+	if err := spend(ctx, 1); err != nil {
+		return false, err
+	}
+	return b.reader.IsCanonical(b.header)
+}
+
+func (b *Block) Raw() string {
+	return hex.EncodeToString(b.header.Data)
+}
+
+func (b *Block) TransactionCount(ctx context.Context) (int32, error) {
+	if err := spend(ctx, 1); err != nil {
+		return 0, err
+	}
+	count, err := b.reader.TxCount(b.header)
+	return int32(count), err
+}
+
+// Transactions resolves a page of the block's transactions
+func (b *Block) Transactions(ctx context.Context, args struct {
+	First *int32
+	After *string
+}) (*TransactionConnection, error) {
+	page := pageArgs{First: args.First, After: args.After}
+	size, err := page.size()
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := page.cursor(1)
+	if err != nil {
+		return nil, err
+	}
+	afterIndex := int64(-1)
+	if cursor != nil {
+		afterIndex = cursor[0]
+	}
+	if err := spend(ctx, 1); err != nil {
+		return nil, err
+	}
+	txs, err := b.reader.BlockTxs(b.header, afterIndex, size+1)
+	if err != nil {
+		return nil, err
+	}
+	if err := spend(ctx, len(txs)); err != nil {
+		return nil, err
+	}
+	connection := &TransactionConnection{pageInfo: &PageInfo{}}
+	if len(txs) > size {
+		txs = txs[:size]
+		connection.pageInfo.hasNextPage = true
+	}
+	for i := range txs {
+		tx, err := newTransaction(b.reader, &txs[i], b.header)
+		if err != nil {
+			return nil, err
+		}
+		connection.nodes = append(connection.nodes, tx)
+	}
+	if len(txs) > 0 {
+		connection.pageInfo.endCursor = encodeCursor(txs[len(txs)-1].Index)
+	}
+	return connection, nil
+}
+
+// Transaction resolves an indexed transaction
+type Transaction struct {
+	reader btc.ChainReader
+	tx     *btc.IndexedTx
+	// The header including the transaction, if it is known
+	header *btc.IndexedHeader
+	msgTx  *wire.MsgTx
+}
+
+func newTransaction(reader btc.ChainReader, tx *btc.IndexedTx, header *btc.IndexedHeader) (*Transaction, error) {
+	msgTx, err := tx.MsgTx()
+	if err != nil {
+		return nil, err
+	}
+	return &Transaction{
+		reader: reader,
+		tx:     tx,
+		header: header,
+		msgTx:  msgTx,
+	}, nil
+}
+
+func (t *Transaction) Hash() string {
+	return t.tx.TxHash
+}
+
+func (t *Transaction) WitnessHash() *string {
+	if !t.msgTx.HasWitness() {
+		return nil
+	}
+	hash := t.msgTx.WitnessHash().String()
+	return &hash
+}
+
+func (t *Transaction) Index() int32 {
+	return int32(t.tx.Index)
+}
+
+func (t *Transaction) Cid() string {
+	return t.tx.CID
+}
+
+func (t *Transaction) Version() int32 {
+	return t.msgTx.Version
+}
+
+func (t *Transaction) LockTime() Long {
+	return Long(t.msgTx.LockTime)
+}
+
+func (t *Transaction) Segwit() bool {
+	return t.msgTx.HasWitness()
+}
+
+func (t *Transaction) Raw() string {
+	return hex.EncodeToString(t.tx.Data)
+}
+
+// Block resolves the block including the transaction; transactions looked up without their header are canonical
+func (t *Transaction) Block(ctx context.Context) (*Block, error) {
+	header := t.header
+	if header == nil {
+		if err := spend(ctx, 1); err != nil {
+			return nil, err
+		}
+		var err error
+		if header, err = t.reader.HeaderAt(t.tx.BlockNumber); err != nil {
+			return nil, err
+		}
+		if header.ID != t.tx.HeaderID {
+			return nil, fmt.Errorf("transaction %s is not in the canonical block at height %d", t.tx.TxHash, t.tx.BlockNumber)
+		}
+	}
+	return newBlock(t.reader, header)
+}
+
+func (t *Transaction) Inputs(ctx context.Context) ([]*Input, error) {
+	if err := spend(ctx, 1); err != nil {
+		return nil, err
+	}
+	inputs, err := t.reader.Inputs(t.tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := spend(ctx, len(inputs)); err != nil {
+		return nil, err
+	}
+	resolvers := make([]*Input, len(inputs))
+	for i := range inputs {
+		resolvers[i] = &Input{
+			reader: t.reader,
+			tx:     t,
+			input:  inputs[i],
+		}
+	}
+	return resolvers, nil
+}
+
+func (t *Transaction) Outputs(ctx context.Context) ([]*Output, error) {
+	if err := spend(ctx, 1); err != nil {
+		return nil, err
+	}
+	outputs, err := t.reader.Outputs(t.tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := spend(ctx, len(outputs)); err != nil {
+		return nil, err
+	}
+	resolvers := make([]*Output, len(outputs))
+	for i := range outputs {
+		resolvers[i] = &Output{
+			reader: t.reader,
+			tx:     t,
+			txHash: t.tx.TxHash,
+			output: outputs[i],
+		}
+	}
+	return resolvers, nil
+}
+
+// Input resolves a transaction input
+type Input struct {
+	reader btc.ChainReader
+	tx     *Transaction
+	input  btc.TxInput
+}
+
+func (i *Input) Index() int32 {
+	return int32(i.input.Index)
+}
+
+func (i *Input) Transaction() *Transaction {
+	return i.tx
+}
+
+func (i *Input) OutpointHash() string {
+	return i.input.PreviousOutPointHash
+}
+
+func (i *Input) OutpointIndex() Long {
+	return Long(i.input.PreviousOutPointIndex)
+}
+
+func (i *Input) Coinbase() bool {
+	return i.input.PreviousOutPointHash == (chainhash.Hash{}).String() && i.input.PreviousOutPointIndex == wire.MaxPrevOutIndex
+}
+
+func (i *Input) SigScript() string {
+	return hex.EncodeToString(i.input.SignatureScript)
+}
+
+func (i *Input) Witness() []string {
+	if i.input.TxWitness == nil {
+		return []string{}
+	}
+	return i.input.TxWitness
+}
+
+// SpentOutput resolves the output the input spends
+func (i *Input) SpentOutput(ctx context.Context) (*Output, error) {
+	if i.Coinbase() {
+		return nil, nil
+	}
+	if err := spend(ctx, 1); err != nil {
+		return nil, err
+	}
+	tx, header, err := i.reader.Tx(i.input.PreviousOutPointHash)
+	if errors.Is(err, btc.ErrNotIndexed) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	spent, err := newTransaction(i.reader, tx, header)
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := spent.Outputs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, output := range outputs {
+		if output.output.Index == int64(i.input.PreviousOutPointIndex) {
+			return output, nil
+		}
+	}
+	return nil, nil
+}
+
+// Output resolves a transaction output
+type Output struct {
+	reader btc.ChainReader
+	// The transaction creating the output, if it is already resolved, and its hash
+	tx     *Transaction
+	txHash string
+	output btc.TxOutput
+}
+
+func (o *Output) Index() int32 {
+	return int32(o.output.Index)
+}
+
+func (o *Output) Transaction(ctx context.Context) (*Transaction, error) {
+	if o.tx != nil {
+		return o.tx, nil
+	}
+	if err := spend(ctx, 1); err != nil {
+		return nil, err
+	}
+	tx, header, err := o.reader.Tx(o.txHash)
+	if err != nil {
+		return nil, err
+	}
+	return newTransaction(o.reader, tx, header)
+}
+
+func (o *Output) Value() Long {
+	return Long(o.output.Value)
+}
+
+func (o *Output) PkScript() string {
+	return hex.EncodeToString(o.output.PkScript)
+}
+
+func (o *Output) ScriptClass() string {
+	return txscript.ScriptClass(o.output.ScriptClass).String()
+}
+
+func (o *Output) RequiredSigs() int32 {
+	return int32(o.output.RequiredSigs)
+}
+
+func (o *Output) Addresses() []string {
+	if o.output.Addresses == nil {
+		return []string{}
+	}
+	return o.output.Addresses
+}
+
+// SpentBy resolves the canonical input spending the output
+func (o *Output) SpentBy(ctx context.Context) (*Input, error) {
+	if err := spend(ctx, 1); err != nil {
+		return nil, err
+	}
+	spender, err := o.reader.Spender(o.txHash, uint32(o.output.Index))
+	if errors.Is(err, btc.ErrNotIndexed) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tx, err := newTransaction(o.reader, spender, nil)
+	if err != nil {
+		return nil, err
+	}
+	inputs, err := tx.Inputs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, input := range inputs {
+		if input.input.PreviousOutPointHash == o.txHash && int64(input.input.PreviousOutPointIndex) == o.output.Index {
+			return input, nil
+		}
+	}
+	return nil, fmt.Errorf("transaction %s does not spend output %d of %s", spender.TxHash, o.output.Index, o.txHash)
+}
+
+// Address resolves the outputs paying to an address
+type Address struct {
+	reader  btc.ChainReader
+	address string
+}
+
+func (a *Address) Address() string {
+	return a.address
+}
+
+// Outputs resolves a page of the canonical outputs paying to the address in a height range
+func (a *Address) Outputs(ctx context.Context, args struct {
+	From  *Long
+	To    *Long
+	First *int32
+	After *string
+}) (*OutputConnection, error) {
+	page := pageArgs{First: args.First, After: args.After}
+	size, err := page.size()
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := page.cursor(2)
+	if err != nil {
+		return nil, err
+	}
+	from, to := heightRange(args.From, args.To)
+	afterID := int64(0)
+	if cursor != nil && cursor[0] >= from {
+		from, afterID = cursor[0], cursor[1]
+	}
+	if err := spend(ctx, 1); err != nil {
+		return nil, err
+	}
+	outputs, err := a.reader.AddressOutputs(a.address, from, to, afterID, size+1)
+	if err != nil {
+		return nil, err
+	}
+	if err := spend(ctx, len(outputs)); err != nil {
+		return nil, err
+	}
+	connection := &OutputConnection{pageInfo: &PageInfo{}}
+	if len(outputs) > size {
+		outputs = outputs[:size]
+		connection.pageInfo.hasNextPage = true
+	}
+	for _, output := range outputs {
+		connection.nodes = append(connection.nodes, &Output{
+			reader: a.reader,
+			txHash: output.TxHash,
+			output: output.TxOutput,
+		})
+	}
+	if len(outputs) > 0 {
+		last := outputs[len(outputs)-1]
+		connection.pageInfo.endCursor = encodeCursor(last.BlockNumber, last.ID)
+	}
+	return connection, nil
+}
+
+// BlockConnection resolves a page of blocks
+type BlockConnection struct {
+	nodes    []*Block
+	pageInfo *PageInfo
+}
+
+func (c *BlockConnection) Nodes() []*Block {
+	return c.nodes
+}
+
+func (c *BlockConnection) PageInfo() *PageInfo {
+	return c.pageInfo
+}
+
+// TransactionConnection resolves a page of transactions
+type TransactionConnection struct {
+	nodes    []*Transaction
+	pageInfo *PageInfo
+}
+
+func (c *TransactionConnection) Nodes() []*Transaction {
+	return c.nodes
+}
+
+func (c *TransactionConnection) PageInfo() *PageInfo {
+	return c.pageInfo
+}
+
+// OutputConnection resolves a page of outputs
+type OutputConnection struct {
+	nodes    []*Output
+	pageInfo *PageInfo
+}
+
+func (c *OutputConnection) Nodes() []*Output {
+	return c.nodes
+}
+
+func (c *OutputConnection) PageInfo() *PageInfo {
+	return c.pageInfo
+}
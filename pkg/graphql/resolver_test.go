@@ -0,0 +1,297 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package graphql_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/graphql"
+)
+
+// response is a GraphQL response, with the data left to decode
+type response struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+var _ = Describe("Resolver", func() {
+	var (
+		reader   *mocks.ChainReader
+		handler  http.Handler
+		block    = mocks.ChildBlock(&mocks.MockBlock.Header, 0, mocks.MockBlock.Transactions...)
+		funding  = block.Transactions[1]
+		spending *wire.MsgTx
+		child    *wire.MsgBlock
+		fork     *wire.MsgBlock
+		address  = mocks.MockTxsMetaData[1].TxOutputs[0].Addresses[0]
+	)
+	BeforeEach(func() {
+		coinbase := wire.NewMsgTx(1)
+		coinbase.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex}, SignatureScript: []byte{0x01, 0x02}})
+		coinbase.AddTxOut(wire.NewTxOut(50e8, funding.TxOut[0].PkScript))
+		spending = wire.NewMsgTx(1)
+		fundingHash := funding.TxHash()
+		spending.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&fundingHash, 0), []byte{0x51}, nil))
+		spending.AddTxOut(wire.NewTxOut(funding.TxOut[0].Value, funding.TxOut[1].PkScript))
+		child = mocks.ChildBlock(&block.Header, 1, coinbase, spending)
+		fork = mocks.ChildBlock(&block.Header, 2, coinbase)
+
+		reader = mocks.NewChainReader(&chaincfg.MainNetParams)
+		reader.Add(mocks.MockBlockHeight, block, true)
+		reader.Add(mocks.MockBlockHeight+1, child, true)
+		reader.Add(mocks.MockBlockHeight+1, fork, false)
+		schema, err := graphql.NewSchema(reader)
+		Expect(err).ToNot(HaveOccurred())
+		handler = graphql.Handler(schema)
+	})
+
+	exec := func(query string) response {
+		body, err := json.Marshal(map[string]interface{}{"query": query})
+		Expect(err).ToNot(HaveOccurred())
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, graphql.Path, bytes.NewReader(body)))
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		var resp response
+		Expect(json.Unmarshal(rec.Body.Bytes(), &resp)).To(Succeed())
+		return resp
+	}
+	query := func(q string, v interface{}) {
+		resp := exec(q)
+		Expect(resp.Errors).To(BeEmpty())
+		Expect(json.Unmarshal(resp.Data, v)).To(Succeed())
+	}
+
+	Describe("block", func() {
+		It("Resolves a block by hash, by number, or the tip", func() {
+			var res struct {
+				ByHash   struct{ Hash, ParentHash string }
+				ByNumber struct {
+					Hash      string
+					Canonical bool
+				}
+				Tip  struct{ Number int64 }
+				Fork struct {
+					Canonical bool
+					Parent    struct{ Hash string }
+				}
+			}
+			query(`{
+				byHash: block(hash: "`+block.BlockHash().String()+`") { hash parentHash }
+				byNumber: block(number: 1338) { hash canonical }
+				tip: block { number }
+				fork: block(hash: "`+fork.BlockHash().String()+`") { canonical parent { hash } }
+			}`, &res)
+			Expect(res.ByHash.Hash).To(Equal(block.BlockHash().String()))
+			Expect(res.ByHash.ParentHash).To(Equal(mocks.MockBlock.BlockHash().String()))
+			Expect(res.ByNumber.Hash).To(Equal(child.BlockHash().String()))
+			Expect(res.ByNumber.Canonical).To(BeTrue())
+			Expect(res.Tip.Number).To(Equal(mocks.MockBlockHeight + 1))
+			Expect(res.Fork.Canonical).To(BeFalse())
+			Expect(res.Fork.Parent.Hash).To(Equal(block.BlockHash().String()))
+		})
+
+		It("Resolves an unknown block to null", func() {
+			var res struct{ Block *struct{ Hash string } }
+			query(`{ block(number: 5) { hash } }`, &res)
+			Expect(res.Block).To(BeNil())
+		})
+
+		It("Counts the block's transactions without reading them", func() {
+			var res struct {
+				Block struct{ TransactionCount int }
+			}
+			query(`{ block(number: 1337) { transactionCount } }`, &res)
+			Expect(res.Block.TransactionCount).To(Equal(len(block.Transactions)))
+			Expect(reader.Calls["TxCount"]).To(Equal(1))
+			Expect(reader.Calls["Txs"]).To(BeZero())
+			Expect(reader.Calls["BlockTxs"]).To(BeZero())
+		})
+
+		It("Pages through the block's transactions", func() {
+			type page struct {
+				Block struct {
+					Transactions struct {
+						Nodes    []struct{ Hash string }
+						PageInfo struct {
+							EndCursor   string
+							HasNextPage bool
+						}
+					}
+				}
+			}
+			var first page
+			query(`{ block(number: 1337) { transactions(first: 2) { nodes { hash } pageInfo { endCursor hasNextPage } } } }`, &first)
+			Expect(first.Block.Transactions.Nodes).To(HaveLen(2))
+			Expect(first.Block.Transactions.Nodes[1].Hash).To(Equal(funding.TxHash().String()))
+			Expect(first.Block.Transactions.PageInfo.HasNextPage).To(BeTrue())
+
+			var second page
+			query(`{ block(number: 1337) { transactions(first: 2, after: "`+first.Block.Transactions.PageInfo.EndCursor+`") { nodes { hash } pageInfo { endCursor hasNextPage } } } }`, &second)
+			Expect(second.Block.Transactions.Nodes).To(HaveLen(1))
+			Expect(second.Block.Transactions.Nodes[0].Hash).To(Equal(block.Transactions[2].TxHash().String()))
+			Expect(second.Block.Transactions.PageInfo.HasNextPage).To(BeFalse())
+			Expect(reader.Calls["Txs"]).To(BeZero())
+		})
+	})
+
+	Describe("blocks", func() {
+		It("Pages through the canonical blocks in a height range", func() {
+			var res struct {
+				Blocks struct {
+					Nodes    []struct{ Hash string }
+					PageInfo struct {
+						EndCursor   string
+						HasNextPage bool
+					}
+				}
+			}
+			query(`{ blocks(from: 1000, first: 1) { nodes { hash } pageInfo { endCursor hasNextPage } } }`, &res)
+			Expect(res.Blocks.Nodes).To(HaveLen(1))
+			Expect(res.Blocks.Nodes[0].Hash).To(Equal(block.BlockHash().String()))
+			Expect(res.Blocks.PageInfo.HasNextPage).To(BeTrue())
+			query(`{ blocks(from: 1000, first: 1, after: "`+res.Blocks.PageInfo.EndCursor+`") { nodes { hash } pageInfo { endCursor hasNextPage } } }`, &res)
+			Expect(res.Blocks.Nodes).To(HaveLen(1))
+			Expect(res.Blocks.Nodes[0].Hash).To(Equal(child.BlockHash().String()))
+			Expect(res.Blocks.PageInfo.HasNextPage).To(BeFalse())
+		})
+
+		It("Refuses a page larger than the maximum", func() {
+			resp := exec(`{ blocks(first: 1000) { nodes { hash } } }`)
+			Expect(resp.Errors).ToNot(BeEmpty())
+			Expect(resp.Errors[0].Message).To(ContainSubstring("first must be between 0 and"))
+		})
+	})
+
+	Describe("transaction", func() {
+		It("Resolves a transaction's block, inputs and outputs, and what they spend and are spent by", func() {
+			var res struct {
+				Spending struct {
+					Block  struct{ Hash string }
+					Inputs []struct {
+						Coinbase    bool
+						SpentOutput struct {
+							Value       int64
+							Transaction struct{ Hash string }
+						}
+					}
+				}
+				Funding struct {
+					Outputs []struct {
+						Addresses []string
+						SpentBy   *struct{ Transaction struct{ Hash string } }
+					}
+				}
+			}
+			query(`{
+				spending: transaction(hash: "`+spending.TxHash().String()+`") {
+					block { hash }
+					inputs { coinbase spentOutput { value transaction { hash } } }
+				}
+				funding: transaction(hash: "`+funding.TxHash().String()+`") {
+					outputs { addresses spentBy { transaction { hash } } }
+				}
+			}`, &res)
+			Expect(res.Spending.Block.Hash).To(Equal(child.BlockHash().String()))
+			Expect(res.Spending.Inputs).To(HaveLen(1))
+			Expect(res.Spending.Inputs[0].Coinbase).To(BeFalse())
+			Expect(res.Spending.Inputs[0].SpentOutput.Value).To(Equal(funding.TxOut[0].Value))
+			Expect(res.Spending.Inputs[0].SpentOutput.Transaction.Hash).To(Equal(funding.TxHash().String()))
+			Expect(res.Funding.Outputs).To(HaveLen(len(funding.TxOut)))
+			Expect(res.Funding.Outputs[0].Addresses).To(Equal([]string{address}))
+			Expect(res.Funding.Outputs[0].SpentBy.Transaction.Hash).To(Equal(spending.TxHash().String()))
+			Expect(res.Funding.Outputs[1].SpentBy).To(BeNil())
+		})
+
+		It("Resolves an unknown transaction to null", func() {
+			var res struct{ Transaction *struct{ Hash string } }
+			query(`{ transaction(hash: "`+strings.Repeat("00", 32)+`") { hash } }`, &res)
+			Expect(res.Transaction).To(BeNil())
+		})
+
+		It("Returns the reader's errors", func() {
+			reader.Err = errors.New("mock reader error")
+			resp := exec(`{ transaction(hash: "` + spending.TxHash().String() + `") { hash } }`)
+			Expect(resp.Errors).To(HaveLen(1))
+			Expect(resp.Errors[0].Message).To(Equal("mock reader error"))
+		})
+	})
+
+	Describe("address", func() {
+		It("Pages through the canonical outputs paying to the address", func() {
+			var res struct {
+				Address struct {
+					Outputs struct {
+						Nodes []struct {
+							Value       int64
+							Transaction struct{ Hash string }
+						}
+						PageInfo struct{ HasNextPage bool }
+					}
+				}
+			}
+			query(`{ address(address: "`+address+`") { outputs(first: 5) { nodes { value transaction { hash } } pageInfo { hasNextPage } } } }`, &res)
+			Expect(res.Address.Outputs.Nodes).To(HaveLen(2))
+			Expect(res.Address.Outputs.Nodes[0].Transaction.Hash).To(Equal(funding.TxHash().String()))
+			Expect(res.Address.Outputs.Nodes[1].Transaction.Hash).To(Equal(child.Transactions[0].TxHash().String()))
+			Expect(res.Address.Outputs.PageInfo.HasNextPage).To(BeFalse())
+		})
+	})
+
+	Describe("Limits", func() {
+		It("Refuses queries nested deeper than the maximum depth", func() {
+			resp := exec(`{ block { parent { parent { parent { parent { parent { parent { parent { parent { parent { parent { parent { hash } } } } } } } } } } } } }`)
+			Expect(resp.Errors).ToNot(BeEmpty())
+			Expect(resp.Errors[0].Message).To(ContainSubstring("exceeds max depth"))
+			Expect(reader.Calls).To(BeEmpty())
+		})
+
+		It("Fails a query once it exceeds its reads from the index, rather than fanning out further", func() {
+			parent := &block.Header
+			for height := mocks.MockBlockHeight + 2; height < mocks.MockBlockHeight+102; height++ {
+				coinbase := wire.NewMsgTx(1)
+				coinbase.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex}, SignatureScript: []byte{byte(height), byte(height >> 8)}})
+				for i := 0; i < 100; i++ {
+					coinbase.AddTxOut(wire.NewTxOut(int64(i), funding.TxOut[1].PkScript))
+				}
+				next := mocks.ChildBlock(parent, 0, coinbase)
+				reader.Add(height, next, true)
+				parent = &next.Header
+			}
+			resp := exec(`{ blocks(first: 100) { nodes { transactions(first: 100) { nodes { outputs { spentBy { index } } } } } } }`)
+			Expect(resp.Errors).ToNot(BeEmpty())
+			Expect(resp.Errors[0].Message).To(ContainSubstring("exceeds the limit of 20000 reads"))
+			calls := 0
+			for _, n := range reader.Calls {
+				calls += n
+			}
+			Expect(calls).To(BeNumerically("<=", graphql.MaxCost))
+		})
+	})
+})
@@ -0,0 +1,130 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package graphql
+
+// schema is the GraphQL schema served over the index
+// Blocks are headers in btc.header_cids; the transactions, inputs and outputs under them, and the outputs paying to an
+// address, are read from btc.transaction_cids, btc.tx_inputs and btc.tx_outputs along with their IPLDs
+const schema = `
+    # Long is a 64 bit integer, used for heights, times and values in satoshis
+    scalar Long
+
+    schema {
+        query: Query
+    }
+
+    type Query {
+        # The block with the hash, whether or not it is canonical, or the canonical block at the number
+        # Without either it is the canonical tip
+        block(hash: String, number: Long): Block
+        # The canonical blocks at the indexed heights between from and to (inclusive), in height order
+        blocks(from: Long, to: Long, first: Int, after: String): BlockConnection!
+        # The canonical transaction with the hash
+        transaction(hash: String!): Transaction
+        address(address: String!): Address!
+    }
+
+    type Block {
+        hash: String!
+        number: Long!
+        parentHash: String!
+        # The parent block, if it is indexed
+        parent: Block
+        cid: String!
+        version: Int!
+        merkleRoot: String!
+        timestamp: Long!
+        bits: Long!
+        nonce: Long!
+        # The number of times the block has been validated against the node
+        timesValidated: Int!
+        # Whether the block is in the canonical chain of the index, rather than on a fork of it
+        canonical: Boolean!
+        # The serialized header, hex encoded
+        raw: String!
+        transactionCount: Int!
+        # The block's transactions, in block order
+        transactions(first: Int, after: String): TransactionConnection!
+    }
+
+    type Transaction {
+        hash: String!
+        # The hash including the witness data, for segwit transactions
+        witnessHash: String
+        index: Int!
+        cid: String!
+        version: Int!
+        lockTime: Long!
+        segwit: Boolean!
+        # The serialized transaction, hex encoded
+        raw: String!
+        block: Block!
+        inputs: [Input!]!
+        outputs: [Output!]!
+    }
+
+    type Input {
+        index: Int!
+        transaction: Transaction!
+        outpointHash: String!
+        outpointIndex: Long!
+        coinbase: Boolean!
+        sigScript: String!
+        witness: [String!]!
+        # The output the input spends, if it is in the canonical chain; null for a coinbase input
+        spentOutput: Output
+    }
+
+    type Output {
+        index: Int!
+        transaction: Transaction!
+        value: Long!
+        pkScript: String!
+        scriptClass: String!
+        requiredSigs: Int!
+        addresses: [String!]!
+        # The canonical input spending the output, if it has been spent in an indexed block
+        spentBy: Input
+    }
+
+    type Address {
+        address: String!
+        # The canonical outputs paying to the address created between the heights from and to (inclusive), in chain order
+        outputs(from: Long, to: Long, first: Int, after: String): OutputConnection!
+    }
+
+    type PageInfo {
+        # The cursor to pass as after to continue from the last node of the page
+        endCursor: String
+        hasNextPage: Boolean!
+    }
+
+    type BlockConnection {
+        nodes: [Block!]!
+        pageInfo: PageInfo!
+    }
+
+    type TransactionConnection {
+        nodes: [Transaction!]!
+        pageInfo: PageInfo!
+    }
+
+    type OutputConnection {
+        nodes: [Output!]!
+        pageInfo: PageInfo!
+    }
+`
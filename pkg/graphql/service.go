@@ -0,0 +1,48 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package graphql
+
+import (
+	"net/http"
+
+	"github.com/graph-gophers/graphql-go"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+)
+
+const (
+	// MaxDepth bounds the nesting of a query, as each level of the relationships between blocks, transactions,
+	// inputs and outputs can fan out into more lookups
+	MaxDepth = 12
+	// MaxParallelism bounds the resolvers of a query that run at once, and so the connections it holds
+	MaxParallelism = 4
+	// Path is the path the API is served at
+	Path = "/graphql"
+)
+
+// NewSchema parses the schema with its resolvers reading from the chain reader
+func NewSchema(reader btc.ChainReader) (*graphql.Schema, error) {
+	return graphql.ParseSchema(schema, NewResolver(reader), graphql.MaxDepth(MaxDepth), graphql.MaxParallelism(MaxParallelism))
+}
+
+// NewService creates a node.Service serving the schema at Path on the given address
+func NewService(addr string, schema *graphql.Schema) *shared.HTTPService {
+	mux := http.NewServeMux()
+	mux.Handle(Path, Handler(schema))
+	return shared.NewHTTPService("graphql", addr, mux)
+}
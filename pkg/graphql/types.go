@@ -0,0 +1,134 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package graphql
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultPageSize is the number of nodes in a page when first is not given
+	DefaultPageSize = 20
+	// MaxPageSize bounds first
+	MaxPageSize = 100
+)
+
+// Long is the 64 bit integer GraphQL scalar
+type Long int64
+
+// ImplementsGraphQLType satisfies the graphql-go scalar interface
+func (Long) ImplementsGraphQLType(name string) bool {
+	return name == "Long"
+}
+
+// UnmarshalGraphQL accepts integers, and decimal strings for values that do not fit a GraphQL Int literal
+func (l *Long) UnmarshalGraphQL(input interface{}) error {
+	switch input := input.(type) {
+	case int32:
+		*l = Long(input)
+	case int64:
+		*l = Long(input)
+	case float64:
+		if input != math.Trunc(input) {
+			return fmt.Errorf("%v is not an integer", input)
+		}
+		*l = Long(input)
+	case json.Number:
+		value, err := input.Int64()
+		if err != nil {
+			return err
+		}
+		*l = Long(value)
+	case string:
+		value, err := strconv.ParseInt(input, 10, 64)
+		if err != nil {
+			return err
+		}
+		*l = Long(value)
+	default:
+		return fmt.Errorf("unexpected type %T for Long", input)
+	}
+	return nil
+}
+
+// pageArgs are the arguments of a paginated field
+type pageArgs struct {
+	First *int32
+	After *string
+}
+
+// size returns the number of nodes requested
+func (args pageArgs) size() (int, error) {
+	if args.First == nil {
+		return DefaultPageSize, nil
+	}
+	if *args.First < 0 || *args.First > MaxPageSize {
+		return 0, fmt.Errorf("first must be between 0 and %d", MaxPageSize)
+	}
+	return int(*args.First), nil
+}
+
+// cursor decodes the after argument into its n positions, or returns nil if it is not given
+func (args pageArgs) cursor(n int) ([]int64, error) {
+	if args.After == nil {
+		return nil, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(*args.After)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor %q", *args.After)
+	}
+	fields := strings.Split(string(raw), ":")
+	if len(fields) != n {
+		return nil, fmt.Errorf("invalid cursor %q", *args.After)
+	}
+	positions := make([]int64, n)
+	for i, field := range fields {
+		if positions[i], err = strconv.ParseInt(field, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid cursor %q", *args.After)
+		}
+	}
+	return positions, nil
+}
+
+// encodeCursor encodes a node's positions into an opaque cursor
+func encodeCursor(positions ...int64) *string {
+	fields := make([]string, len(positions))
+	for i, position := range positions {
+		fields[i] = strconv.FormatInt(position, 10)
+	}
+	cursor := base64.StdEncoding.EncodeToString([]byte(strings.Join(fields, ":")))
+	return &cursor
+}
+
+// PageInfo resolves the pagination state of a connection
+type PageInfo struct {
+	endCursor   *string
+	hasNextPage bool
+}
+
+func (p *PageInfo) EndCursor() *string {
+	return p.endCursor
+}
+
+func (p *PageInfo) HasNextPage() bool {
+	return p.hasNextPage
+}
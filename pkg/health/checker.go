@@ -0,0 +1,237 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+)
+
+// Pinger is the part of the database the health check needs
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+const (
+	// CheckTimeout bounds each call made to the database or the node by a check
+	CheckTimeout = time.Second * 5
+	// GapCheckInterval is how often the readiness check searches the database for gaps; the search is expensive
+	GapCheckInterval = time.Minute
+)
+
+// Check is the result of a single check
+type Check struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Report is the result of a set of checks; it is OK only if every check is
+type Report struct {
+	OK     bool             `json:"ok"`
+	Checks map[string]Check `json:"checks"`
+}
+
+// seenGap is a gap in the indexed data along with the time it, or a gap it overlaps, was first found
+type seenGap struct {
+	btc.DBGap
+	since time.Time
+}
+
+// Checker runs the health and readiness checks against the database and the node
+type Checker struct {
+	db        Pinger
+	retriever btc.Retriever
+	client    btc.BlockClient
+	started   time.Time
+
+	// Maximum number of blocks the last indexed block may be behind the node's tip for the process to be ready
+	MaxHeadLag int64
+	// Maximum time a gap may go unfilled for the process to be ready; zero disables the gap check
+	// The heights below the first indexed block are not counted as a gap, since a sync started at the tip never fills them
+	MaxGapAge time.Duration
+
+	gapsLock      sync.Mutex
+	gaps          []seenGap
+	gapsCheckedAt time.Time
+	gapsErr       error
+}
+
+// NewChecker creates a Checker for the database and the node behind the client
+func NewChecker(db *postgres.DB, client btc.BlockClient, maxHeadLag int64, maxGapAge time.Duration) *Checker {
+	return NewRetrieverChecker(db, btc.NewGapRetriever(db), client, maxHeadLag, maxGapAge)
+}
+
+// NewRetrieverChecker creates a Checker that pings the database and reads the indexed data through the retriever
+func NewRetrieverChecker(db Pinger, retriever btc.Retriever, client btc.BlockClient, maxHeadLag int64, maxGapAge time.Duration) *Checker {
+	return &Checker{
+		db:         db,
+		retriever:  retriever,
+		client:     client,
+		started:    time.Now(),
+		MaxHeadLag: maxHeadLag,
+		MaxGapAge:  maxGapAge,
+	}
+}
+
+// Health reports whether the process is alive and can reach the database and the node
+func (c *Checker) Health() Report {
+	return report(map[string]Check{
+		"process":  {OK: true, Detail: fmt.Sprintf("up %s", time.Since(c.started).Round(time.Second))},
+		"database": c.checkDatabase(),
+		"node":     c.checkNode(),
+	})
+}
+
+// Ready reports whether the indexed data is caught up with the node's tip and has no gaps older than MaxGapAge
+func (c *Checker) Ready() Report {
+	checks := map[string]Check{
+		"head_lag": c.checkHeadLag(),
+	}
+	if c.MaxGapAge > 0 {
+		checks["gaps"] = c.checkGaps()
+	}
+	return report(checks)
+}
+
+func report(checks map[string]Check) Report {
+	r := Report{OK: true, Checks: checks}
+	for _, check := range checks {
+		r.OK = r.OK && check.OK
+	}
+	return r
+}
+
+func failed(err error) Check {
+	return Check{Detail: err.Error()}
+}
+
+func (c *Checker) checkDatabase() Check {
+	ctx, cancel := context.WithTimeout(context.Background(), CheckTimeout)
+	defer cancel()
+	if err := c.db.PingContext(ctx); err != nil {
+		return failed(err)
+	}
+	return Check{OK: true}
+}
+
+func (c *Checker) checkNode() Check {
+	tip, err := c.nodeTip()
+	if err != nil {
+		return failed(err)
+	}
+	return Check{OK: true, Detail: fmt.Sprintf("tip at height %d", tip)}
+}
+
+func (c *Checker) checkHeadLag() Check {
+	tip, err := c.nodeTip()
+	if err != nil {
+		return failed(fmt.Errorf("node: %v", err))
+	}
+	head, err := c.retriever.RetrieveLastBlockNumber()
+	if err == sql.ErrNoRows {
+		return Check{Detail: "no blocks indexed"}
+	}
+	if err != nil {
+		return failed(fmt.Errorf("database: %v", err))
+	}
+	lag := tip - head
+	detail := fmt.Sprintf("indexed head at height %d is %d blocks behind the node's tip at %d", head, lag, tip)
+	return Check{OK: lag <= c.MaxHeadLag, Detail: detail}
+}
+
+func (c *Checker) checkGaps() Check {
+	gaps, err := c.refreshGaps()
+	if err != nil {
+		return failed(err)
+	}
+	now := time.Now()
+	var stale []btc.DBGap
+	for _, gap := range gaps {
+		if now.Sub(gap.since) > c.MaxGapAge {
+			stale = append(stale, gap.DBGap)
+		}
+	}
+	if len(stale) == 0 {
+		return Check{OK: true, Detail: fmt.Sprintf("%d gaps, none unfilled for longer than %s", len(gaps), c.MaxGapAge)}
+	}
+	return Check{Detail: fmt.Sprintf("%d of %d gaps unfilled for longer than %s, the first from %d to %d",
+		len(stale), len(gaps), c.MaxGapAge, stale[0].Start, stale[0].Stop)}
+}
+
+// refreshGaps searches for gaps above the first indexed block if the last search is older than GapCheckInterval
+// a gap that overlaps one found by an earlier search (e.g. because it has been partially filled since) keeps its age
+func (c *Checker) refreshGaps() ([]seenGap, error) {
+	c.gapsLock.Lock()
+	defer c.gapsLock.Unlock()
+	if time.Since(c.gapsCheckedAt) < GapCheckInterval {
+		return c.gaps, c.gapsErr
+	}
+	c.gapsCheckedAt = time.Now()
+	gaps, err := c.retriever.RetrieveGapsInData()
+	if err == sql.ErrNoRows {
+		gaps, err = nil, nil
+	}
+	var first int64
+	if err == nil && len(gaps) > 0 {
+		first, err = c.retriever.RetrieveFirstBlockNumber()
+	}
+	c.gapsErr = err
+	if err != nil {
+		return nil, err
+	}
+	seen := make([]seenGap, 0, len(gaps))
+	for _, gap := range gaps {
+		if int64(gap.Stop) < first {
+			continue
+		}
+		s := seenGap{DBGap: gap, since: c.gapsCheckedAt}
+		for _, prev := range c.gaps {
+			if prev.Start <= gap.Stop && gap.Start <= prev.Stop && prev.since.Before(s.since) {
+				s.since = prev.since
+			}
+		}
+		seen = append(seen, s)
+	}
+	c.gaps = seen
+	return seen, nil
+}
+
+// nodeTip asks the node for its tip, giving up after CheckTimeout
+func (c *Checker) nodeTip() (int64, error) {
+	type result struct {
+		tip int64
+		err error
+	}
+	res := make(chan result, 1)
+	go func() {
+		tip, err := c.client.GetBlockCount()
+		res <- result{tip, err}
+	}()
+	select {
+	case r := <-res:
+		return r.tip, r.err
+	case <-time.After(CheckTimeout):
+		return 0, errors.New("timed out waiting for the node")
+	}
+}
@@ -0,0 +1,162 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package health_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/health"
+)
+
+// pinger is a stand-in for the database that fails its pings with err
+type pinger struct {
+	err error
+}
+
+func (p *pinger) PingContext(ctx context.Context) error {
+	return p.err
+}
+
+var _ = Describe("Checker", func() {
+	var (
+		db        *pinger
+		retriever *mocks.CIDRetriever
+		client    *mocks.BlockClient
+		checker   *health.Checker
+	)
+	BeforeEach(func() {
+		db = &pinger{}
+		retriever = &mocks.CIDRetriever{LastBlockNumberToReturn: 1000}
+		client = &mocks.BlockClient{Tip: 1003}
+		checker = health.NewRetrieverChecker(db, retriever, client, 6, time.Hour)
+	})
+
+	Describe("Health", func() {
+		It("Is OK when the database and node are reachable", func() {
+			report := checker.Health()
+			Expect(report.OK).To(BeTrue())
+			Expect(report.Checks).To(HaveKey("process"))
+			Expect(report.Checks["database"].OK).To(BeTrue())
+			Expect(report.Checks["node"]).To(Equal(health.Check{OK: true, Detail: "tip at height 1003"}))
+		})
+
+		It("Fails when the database is unreachable", func() {
+			db.err = errors.New("mock ping error")
+			report := checker.Health()
+			Expect(report.OK).To(BeFalse())
+			Expect(report.Checks["database"]).To(Equal(health.Check{Detail: "mock ping error"}))
+			Expect(report.Checks["node"].OK).To(BeTrue())
+		})
+
+		It("Fails when the node is unreachable", func() {
+			client.TipErr = errors.New("mock node error")
+			report := checker.Health()
+			Expect(report.OK).To(BeFalse())
+			Expect(report.Checks["node"]).To(Equal(health.Check{Detail: "mock node error"}))
+		})
+	})
+
+	Describe("Ready", func() {
+		It("Is OK when the indexed head is within the maximum lag of the node's tip and there are no gaps", func() {
+			report := checker.Ready()
+			Expect(report.OK).To(BeTrue())
+			Expect(report.Checks["head_lag"].OK).To(BeTrue())
+			Expect(report.Checks["head_lag"].Detail).To(ContainSubstring("3 blocks behind"))
+			Expect(report.Checks["gaps"].OK).To(BeTrue())
+		})
+
+		It("Fails when the indexed head lags too far behind the node's tip", func() {
+			client.Tip = 1007
+			report := checker.Ready()
+			Expect(report.OK).To(BeFalse())
+			Expect(report.Checks["head_lag"].OK).To(BeFalse())
+			Expect(report.Checks["head_lag"].Detail).To(ContainSubstring("7 blocks behind"))
+		})
+
+		It("Fails when no blocks are indexed", func() {
+			retriever.RetrieveLastBlockNumberErr = sql.ErrNoRows
+			report := checker.Ready()
+			Expect(report.OK).To(BeFalse())
+			Expect(report.Checks["head_lag"]).To(Equal(health.Check{Detail: "no blocks indexed"}))
+		})
+
+		It("Fails when the node or database cannot be read", func() {
+			client.TipErr = errors.New("mock node error")
+			Expect(checker.Ready().Checks["head_lag"]).To(Equal(health.Check{Detail: "node: mock node error"}))
+			client.TipErr = nil
+			retriever.RetrieveLastBlockNumberErr = errors.New("mock db error")
+			Expect(checker.Ready().Checks["head_lag"]).To(Equal(health.Check{Detail: "database: mock db error"}))
+		})
+
+		It("Tolerates gaps until they have gone unfilled for longer than the maximum gap age", func() {
+			retriever.SetGapsToRetrieve([]btc.DBGap{{Start: 10, Stop: 20}})
+			Expect(checker.Ready().OK).To(BeTrue())
+			checker.MaxGapAge = time.Millisecond
+			time.Sleep(5 * time.Millisecond)
+			report := checker.Ready()
+			Expect(report.OK).To(BeFalse())
+			Expect(report.Checks["gaps"].Detail).To(ContainSubstring("the first from 10 to 20"))
+		})
+
+		It("Ignores the unindexed heights below the first indexed block", func() {
+			retriever.FirstBlockNumberToReturn = 900
+			retriever.SetGapsToRetrieve([]btc.DBGap{{Start: 0, Stop: 899}, {Start: 950, Stop: 960}})
+			checker.MaxGapAge = time.Millisecond
+			checker.Ready()
+			time.Sleep(5 * time.Millisecond)
+			report := checker.Ready()
+			Expect(report.OK).To(BeFalse())
+			Expect(report.Checks["gaps"].Detail).To(Equal("1 of 1 gaps unfilled for longer than 1ms, the first from 950 to 960"))
+
+			retriever.GapsToRetrieve = retriever.GapsToRetrieve[:1]
+			checker = health.NewRetrieverChecker(db, retriever, client, 6, time.Millisecond)
+			checker.Ready()
+			time.Sleep(5 * time.Millisecond)
+			Expect(checker.Ready().OK).To(BeTrue())
+		})
+
+		It("Searches for gaps at most once per interval", func() {
+			checker.Ready()
+			checker.Ready()
+			Expect(retriever.CalledTimes).To(Equal(1))
+		})
+
+		It("Skips the gap check when the maximum gap age is zero", func() {
+			checker.MaxGapAge = 0
+			retriever.GapsToRetrieveErr = errors.New("mock gap error")
+			report := checker.Ready()
+			Expect(report.OK).To(BeTrue())
+			Expect(report.Checks).ToNot(HaveKey("gaps"))
+			Expect(retriever.CalledTimes).To(BeZero())
+		})
+
+		It("Fails when the gaps cannot be searched for", func() {
+			retriever.GapsToRetrieveErr = errors.New("mock gap error")
+			report := checker.Ready()
+			Expect(report.OK).To(BeFalse())
+			Expect(report.Checks["gaps"]).To(Equal(health.Check{Detail: "mock gap error"}))
+		})
+	})
+})
@@ -0,0 +1,64 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package health
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Env variables
+const (
+	HEALTH_ENABLED      = "HEALTH_ENABLED"
+	HEALTH_HTTP_ADDR    = "HEALTH_HTTP_ADDR"
+	HEALTH_HTTP_PORT    = "HEALTH_HTTP_PORT"
+	HEALTH_MAX_HEAD_LAG = "HEALTH_MAX_HEAD_LAG"
+	HEALTH_MAX_GAP_AGE  = "HEALTH_MAX_GAP_AGE"
+)
+
+// Config holds the settings for the health and readiness checks and the server exposing them
+type Config struct {
+	Enabled    bool
+	HTTPAddr   string
+	HTTPPort   int
+	MaxHeadLag int64
+	MaxGapAge  time.Duration
+}
+
+// NewConfig is used to initialize a health config from a .toml file
+func NewConfig() Config {
+	viper.BindEnv("health.enabled", HEALTH_ENABLED)
+	viper.BindEnv("health.httpAddr", HEALTH_HTTP_ADDR)
+	viper.BindEnv("health.httpPort", HEALTH_HTTP_PORT)
+	viper.BindEnv("health.maxHeadLag", HEALTH_MAX_HEAD_LAG)
+	viper.BindEnv("health.maxGapAge", HEALTH_MAX_GAP_AGE)
+
+	return Config{
+		Enabled:    viper.GetBool("health.enabled"),
+		HTTPAddr:   viper.GetString("health.httpAddr"),
+		HTTPPort:   viper.GetInt("health.httpPort"),
+		MaxHeadLag: viper.GetInt64("health.maxHeadLag"),
+		MaxGapAge:  time.Minute * time.Duration(viper.GetInt("health.maxGapAge")),
+	}
+}
+
+// Address returns the host:port the checks are served on
+func (c Config) Address() string {
+	return fmt.Sprintf("%s:%d", c.HTTPAddr, c.HTTPPort)
+}
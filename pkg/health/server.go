@@ -0,0 +1,61 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+)
+
+// Handler returns an http.Handler serving the health report at /healthz and the readiness report at /readyz
+// both respond 200 when every check passes and 503 otherwise, with the report as the JSON body
+func Handler(c *Checker) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, c.Health())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, c.Ready())
+	})
+	return mux
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	if !report.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Errorf("health server error writing report: %v", err)
+	}
+}
+
+// Serve binds the given address and serves the health and readiness reports on it in the background
+// It returns an error if the address cannot be bound, so that a port conflict fails the command rather than leaving it
+// running without its checks
+func Serve(addr string, c *Checker) (*shared.HTTPService, error) {
+	service := NewService(addr, c)
+	if err := service.Start(nil); err != nil {
+		return nil, fmt.Errorf("health server unable to listen on %s: %v", addr, err)
+	}
+	return service, nil
+}
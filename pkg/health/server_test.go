@@ -0,0 +1,115 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package health_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/health"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		db      *pinger
+		client  *mocks.BlockClient
+		checker *health.Checker
+	)
+	BeforeEach(func() {
+		db = &pinger{}
+		client = &mocks.BlockClient{Tip: 1003}
+		checker = health.NewRetrieverChecker(db, &mocks.CIDRetriever{LastBlockNumberToReturn: 1000}, client, 6, 0)
+	})
+	get := func(path string) (int, health.Report) {
+		rec := httptest.NewRecorder()
+		health.Handler(checker).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+		var report health.Report
+		Expect(json.Unmarshal(rec.Body.Bytes(), &report)).To(Succeed())
+		return rec.Code, report
+	}
+
+	It("Serves the health report at /healthz", func() {
+		code, report := get("/healthz")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(report.OK).To(BeTrue())
+		Expect(report.Checks).To(HaveKey("database"))
+
+		db.err = errors.New("mock ping error")
+		code, report = get("/healthz")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(report.OK).To(BeFalse())
+		Expect(report.Checks["database"]).To(Equal(health.Check{Detail: "mock ping error"}))
+	})
+
+	It("Serves the readiness report at /readyz", func() {
+		code, report := get("/readyz")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(report.Checks).To(HaveKey("head_lag"))
+
+		client.Tip = 2000
+		code, report = get("/readyz")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(report.Checks["head_lag"].OK).To(BeFalse())
+	})
+
+	It("Serves the checks on the address", func() {
+		service, err := health.Serve("127.0.0.1:0", checker)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(service.Stop()).To(Succeed())
+	})
+
+	It("Fails to serve on an address that is already bound", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		defer listener.Close()
+		_, err = health.Serve(listener.Addr().String(), checker)
+		Expect(err).To(MatchError(ContainSubstring("unable to listen")))
+	})
+
+	It("Fails to start the service on an address that is already bound", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		defer listener.Close()
+		Expect(health.NewService(listener.Addr().String(), checker).Start(nil)).ToNot(Succeed())
+	})
+
+	It("Serves the checks until the service is stopped", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		addr := listener.Addr().String()
+		listener.Close()
+		service := health.NewService(addr, checker)
+		Expect(service.Start(nil)).To(Succeed())
+		httpClient := http.Client{Timeout: time.Second}
+		res, err := httpClient.Get("http://" + addr + "/healthz")
+		Expect(err).ToNot(HaveOccurred())
+		res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		Expect(service.Stop()).To(Succeed())
+		_, err = httpClient.Get("http://" + addr + "/healthz")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -20,9 +20,9 @@ import (
 	"time"
 
 	"github.com/btcsuite/btcd/rpcclient"
-
 	"github.com/spf13/viper"
 
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/node"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
@@ -35,6 +35,10 @@ const (
 	BACKFILL_BATCH_SIZE       = "BACKFILL_BATCH_SIZE"
 	BACKFILL_WORKERS          = "BACKFILL_WORKERS"
 	BACKFILL_VALIDATION_LEVEL = "BACKFILL_VALIDATION_LEVEL"
+	BACKFILL_BULK_LOAD        = "BACKFILL_BULK_LOAD"
+	BACKFILL_COMMIT_SIZE      = "BACKFILL_COMMIT_SIZE"
+	BACKFILL_LEASE            = "BACKFILL_LEASE"
+	BACKFILL_DRAIN_TIMEOUT    = "BACKFILL_DRAIN_TIMEOUT"
 
 	BACKFILL_MAX_IDLE_CONNECTIONS = "BACKFILL_MAX_IDLE_CONNECTIONS"
 	BACKFILL_MAX_OPEN_CONNECTIONS = "BACKFILL_MAX_OPEN_CONNECTIONS"
@@ -45,24 +49,45 @@ const (
 type Config struct {
 	DB              *postgres.DB
 	DBConfig        postgres.Config
-	HTTPConfig      *rpcclient.ConnConfig
+	Source          btc.SourceConfig
 	Frequency       time.Duration
 	BatchSize       uint64
+	CommitSize      uint64 // Number of blocks published in each Postgres tx; defaults to BatchSize
 	Workers         uint64
 	ValidationLevel int
+	BulkLoad        bool          // Publish each batch with COPY instead of row-by-row inserts
 	Timeout         time.Duration // HTTP connection timeout in seconds
+	Lease           time.Duration // How long a worker's claim on a batch lasts without a heartbeat
+	DrainTimeout    time.Duration // Time given to the tasks in progress to finish on shutdown
 	NodeInfo        node.Node
 }
 
 // NewConfig is used to initialize a historical config from a .toml file
-func NewConfig() *Config {
+func NewConfig() (*Config, error) {
 	c := new(Config)
+	if err := c.Init(); err != nil {
+		return nil, err
+	}
+	c.DBConfig.Init()
+	overrideDBConnConfig(&c.DBConfig)
+	db := utils.LoadPostgres(c.DBConfig, c.NodeInfo)
+	c.DB = &db
+	return c, nil
+}
+
+// Init loads every setting but the database connection from the .toml file, so that the caller can provide the DB
+func (c *Config) Init() error {
+	var err error
 
 	viper.BindEnv("bitcoin.httpPath", shared.BTC_HTTP_PATH)
 	viper.BindEnv("backfill.frequency", BACKFILL_FREQUENCY)
 	viper.BindEnv("backfill.batchSize", BACKFILL_BATCH_SIZE)
 	viper.BindEnv("backfill.workers", BACKFILL_WORKERS)
 	viper.BindEnv("backfill.validationLevel", BACKFILL_VALIDATION_LEVEL)
+	viper.BindEnv("backfill.bulkLoad", BACKFILL_BULK_LOAD)
+	viper.BindEnv("backfill.commitSize", BACKFILL_COMMIT_SIZE)
+	viper.BindEnv("backfill.lease", BACKFILL_LEASE)
+	viper.BindEnv("backfill.drainTimeout", BACKFILL_DRAIN_TIMEOUT)
 	viper.BindEnv("backfill.timeout", shared.HTTP_TIMEOUT)
 
 	timeout := viper.GetInt("backfill.timeout")
@@ -80,17 +105,28 @@ func NewConfig() *Config {
 	}
 	c.Frequency = frequency
 	c.BatchSize = uint64(viper.GetInt64("backfill.batchSize"))
+	c.CommitSize = uint64(viper.GetInt64("backfill.commitSize"))
 	c.Workers = uint64(viper.GetInt64("backfill.workers"))
 	c.ValidationLevel = viper.GetInt("backfill.validationLevel")
+	c.BulkLoad = viper.GetBool("backfill.bulkLoad")
+	c.Lease = time.Second * time.Duration(viper.GetInt("backfill.lease"))
+	drainTimeout := viper.GetInt("backfill.drainTimeout")
+	if drainTimeout < 1 {
+		drainTimeout = 30
+	}
+	c.DrainTimeout = time.Second * time.Duration(drainTimeout)
 
 	btcHTTP := viper.GetString("bitcoin.httpPath")
-	c.NodeInfo, c.HTTPConfig = shared.GetBtcNodeAndClient(btcHTTP)
-
-	c.DBConfig.Init()
-	overrideDBConnConfig(&c.DBConfig)
-	db := utils.LoadPostgres(c.DBConfig, c.NodeInfo)
-	c.DB = &db
-	return c
+	var clientConfig *rpcclient.ConnConfig
+	c.NodeInfo, clientConfig = shared.GetBtcNodeAndClient(btcHTTP)
+	c.Source.RPCConfigs = shared.GetBtcClientConfigs(btcHTTP, clientConfig)
+	c.Source.Type, c.Source.EsploraPaths, err = shared.GetBtcSource()
+	if err != nil {
+		return err
+	}
+	c.Source.Timeout = c.Timeout
+	c.Source.CrossValidate = shared.GetBtcCrossValidate()
+	return nil
 }
 
 func overrideDBConnConfig(con *postgres.Config) {
@@ -17,22 +17,30 @@
 package historical
 
 import (
+	"context"
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/btcsuite/btcd/chaincfg"
+	ethnode "github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/prom"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
 	"github.com/vulcanize/ipld-btc-indexer/utils"
 )
 
 // Backfill interface ffor filling in gaps in the ipld-btc-indexer db
+// This service is compatible with the Ethereum service interface (node.Service)
 type Backfill interface {
+	// APIs(), Protocols(), Start() and Stop()
+	ethnode.Service
 	// Method for the watcher to periodically check for and fill in gaps in its data using an archival node
 	Sync(wg *sync.WaitGroup)
-	Stop() error
 }
 
 // Service is the underlying struct type for filling in gaps in the watcher
@@ -45,18 +53,40 @@ type Service struct {
 	Retriever btc.Retriever
 	// Interface for fetching payloads over at historical blocks; over http
 	Fetcher btc.Fetcher
+	// Queue of the batches of backfill jobs, shared with any other processes backfilling the same database
+	Queue *btc.BatchQueue
+	// Interface for validating indexed blocks against the ones fetched from the node
+	Validator btc.Validator
 	// Check frequency
 	GapCheckFrequency time.Duration
 	// Size of batch fetches
 	BatchSize uint64
+	// Number of blocks published in each Postgres tx
+	CommitSize uint64
 	// Number of worker goroutines
 	Workers int64
-	// Channel for receiving quit signal
-	QuitChan chan bool
 	// Chain config for btc
 	ChainConfig *chaincfg.Params
-	// Headers with times_validated lower than this will be resynced
+	// Headers with times_validated lower than this will be validated against the node
 	validationLevel int
+	// Channel feeding the worker pool
+	tasks chan task
+	// Context cancelled to stop the service
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Context the blocks are fetched and published with; the tasks in progress when the service is stopped are given
+	// until it is cancelled, DrainTimeout later, to finish
+	workCtx    context.Context
+	workCancel context.CancelFunc
+	// wg for syncing serve processes
+	serveWg *sync.WaitGroup
+}
+
+// task is a unit of work for the worker pool: either a claimed batch of a gap to fill, or a set of heights to validate
+type task struct {
+	batch    *btc.ClaimedBatch
+	validate []uint64
+	done     *sync.WaitGroup
 }
 
 // NewBackfillService returns a new BackFillInterface
@@ -66,108 +96,299 @@ func NewBackfillService(settings *Config) (Backfill, error) {
 	bs.ChainConfig = &chaincfg.MainNetParams /// TODO make this configurable
 	bs.Converter = btc.NewPayloadConverter(bs.ChainConfig)
 	bs.Retriever = btc.NewGapRetriever(settings.DB)
-	bs.Fetcher, err = btc.NewPayloadFetcher(settings.HTTPConfig)
+	bs.Validator = btc.NewDBValidator(settings.DB)
+	bs.Queue = btc.NewBatchQueue(btc.NewDBJobTracker(settings.DB), settings.Lease, btc.DefaultMaxAttempts)
+	bs.Fetcher, err = btc.NewFetcher(settings.Source)
 	if err != nil {
 		return nil, err
 	}
-	bs.Publisher = btc.NewIPLDPublisher(settings.DB)
-	bs.BatchSize = settings.Workers
+	if settings.BulkLoad {
+		bs.Publisher = btc.NewBulkPublisher(settings.DB)
+	} else {
+		bs.Publisher = btc.NewIPLDPublisher(settings.DB)
+	}
+	bs.BatchSize = settings.BatchSize
 	if bs.BatchSize == 0 {
 		bs.BatchSize = shared.DefaultMaxBatchSize
 	}
+	bs.CommitSize = settings.CommitSize
+	if bs.CommitSize == 0 || bs.CommitSize > bs.BatchSize {
+		bs.CommitSize = bs.BatchSize
+	}
 	bs.Workers = int64(settings.Workers)
 	if bs.Workers == 0 {
 		bs.Workers = shared.DefaultMaxBatchNumber
 	}
 	bs.validationLevel = settings.ValidationLevel
 	bs.GapCheckFrequency = settings.Frequency
-	bs.QuitChan = make(chan bool)
+	bs.tasks = make(chan task)
+	bs.ctx, bs.cancel = context.WithCancel(context.Background())
+	bs.workCtx, bs.workCancel = utils.DrainContext(bs.ctx, settings.DrainTimeout)
 	return bs, nil
 }
 
-// Sync periodically checks for and fills in gaps in the watcher db
+// Sync starts the worker pool and periodically checks for and fills in gaps in the watcher db
+// Each pass waits for all of its work to be done before the timer for the next one starts, so passes never overlap
 func (bfs *Service) Sync(wg *sync.WaitGroup) {
-	ticker := time.NewTicker(bfs.GapCheckFrequency)
-	go func() {
+	for i := 1; i <= int(bfs.Workers); i++ {
 		wg.Add(1)
+		go bfs.work(wg, i)
+	}
+	wg.Add(1)
+	go func() {
 		defer wg.Done()
+		timer := time.NewTimer(bfs.GapCheckFrequency)
+		defer timer.Stop()
 		for {
 			select {
-			case <-bfs.QuitChan:
+			case <-bfs.ctx.Done():
 				log.Info("quiting bitcoin backfill process")
 				return
-			case <-ticker.C:
-				gaps, err := bfs.Retriever.RetrieveGapsInData(bfs.validationLevel)
-				if err != nil {
-					log.Errorf("bitcoin backFill gap retrieval error: %v", err)
-					continue
-				}
-				// spin up worker goroutines for this search pass
-				// we start and kill a new batch of workers for each pass
-				// so that we know each of the previous workers is done before we search for new gaps
-				heightsChan := make(chan []uint64)
-				for i := 1; i <= int(bfs.Workers); i++ {
-					go bfs.backFill(wg, i, heightsChan)
-				}
-				for _, gap := range gaps {
-					log.Infof("backfilling bitcoin data from %d to %d", gap.Start, gap.Stop)
-					blockRangeBins, err := utils.GetBlockHeightBins(gap.Start, gap.Stop, bfs.BatchSize)
-					if err != nil {
-						log.Errorf("bitcoin backfill gap binning error: %v", err)
-						continue
-					}
-					for _, heights := range blockRangeBins {
-						select {
-						case <-bfs.QuitChan:
-							log.Info("quiting bitcoin backfill process")
-							return
-						default:
-							heightsChan <- heights
-						}
-					}
-				}
-				// send a quit signal to each worker
-				// this blocks until each worker has finished its current task and is free to receive from the quit channel
-				for i := 1; i <= int(bfs.Workers); i++ {
-					bfs.QuitChan <- true
-				}
+			case <-timer.C:
+				bfs.pass()
+				timer.Reset(bfs.GapCheckFrequency)
 			}
 		}
 	}()
 	log.Info("bitcoin backfill process successfully spun up")
 }
 
-func (bfs *Service) backFill(wg *sync.WaitGroup, id int, heightChan chan []uint64) {
-	wg.Add(1)
+// Protocols exports the services p2p protocols, this service has none
+func (bfs *Service) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{}
+}
+
+// APIs returns the RPC descriptors the backfill service offers
+func (bfs *Service) APIs() []rpc.API {
+	return []rpc.API{}
+}
+
+// Start is used to begin the service when it is registered with a node.Node
+func (bfs *Service) Start(*p2p.Server) error {
+	log.Info("starting bitcoin backfill service")
+	bfs.serveWg = new(sync.WaitGroup)
+	bfs.Sync(bfs.serveWg)
+	return nil
+}
+
+// Stop cancels the service; workers finish the task they are on before shutting down, unless it takes longer than
+// the drain timeout, in which case its transaction is rolled back and the batch is left to be retried
+// If the service was begun with Start, it waits for the workers to exit
+func (bfs *Service) Stop() error {
+	log.Infof("stopping bitcoin backfill service")
+	bfs.cancel()
+	if bfs.serveWg != nil {
+		bfs.serveWg.Wait()
+	}
+	return nil
+}
+
+// pass fills the gaps in the db, and then validates the headers below the validation level
+func (bfs *Service) pass() {
+	jobIDs, err := bfs.gapJobs()
+	if err != nil {
+		log.Errorf("bitcoin backfill gap retrieval error: %v", err)
+	} else if len(jobIDs) > 0 {
+		bfs.fillGaps(jobIDs)
+	}
+	if bfs.ctx.Err() != nil {
+		return
+	}
+	heights, err := bfs.Retriever.RetrieveUnvalidatedHeights(bfs.validationLevel)
+	if err != nil {
+		log.Errorf("bitcoin backfill validation retrieval error: %v", err)
+		return
+	}
+	bfs.validate(heights)
+}
+
+// gapJobs records the gaps in the db as backfill jobs, returning their ids along with those of unfinished backfill jobs
+// Gaps are searched for outside the ranges that unfinished jobs are still filling, whether this process or another one
+// is filling them, so that the same heights are never queued twice
+func (bfs *Service) gapJobs() ([]int64, error) {
+	gaps, err := bfs.Retriever.RetrieveGapsInData()
+	if err != nil {
+		return nil, err
+	}
+	filling, err := bfs.Queue.Jobs.Filling(bfs.Queue.MaxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	jobIDs, err := bfs.Queue.Jobs.Unfinished(btc.BackfillJob, bfs.Queue.MaxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	rngs := make([][2]uint64, len(gaps))
+	for i, gap := range gaps {
+		rngs[i] = [2]uint64{gap.Start, gap.Stop}
+	}
+	for _, rng := range utils.SubtractBlockRanges(rngs, filling) {
+		blockRangeBins, err := utils.GetBlockHeightBins(rng[0], rng[1], bfs.BatchSize)
+		if err != nil {
+			return nil, err
+		}
+		batches := make([][2]uint64, len(blockRangeBins))
+		for i, heights := range blockRangeBins {
+			batches[i] = [2]uint64{heights[0], heights[len(heights)-1]}
+		}
+		// each pass is a new run over the gaps, so the batches that failed on the last one are retried
+		jobID, err := bfs.Queue.Jobs.Job(btc.BackfillJob, shared.Full, rng, batches, true)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("backfilling bitcoin data from %d to %d in job %d", rng[0], rng[1], jobID)
+		jobIDs = append(jobIDs, jobID)
+	}
+	return jobIDs, nil
+}
+
+// fillGaps claims the batches of the jobs and hands them to the worker pool until there are none left to claim,
+// returning once they are all done
+// Batches leased by other processes are left to them
+func (bfs *Service) fillGaps(jobIDs []int64) {
+	done := new(sync.WaitGroup)
+claim:
+	for bfs.ctx.Err() == nil {
+		batch, err := bfs.Queue.Claim(bfs.workCtx, jobIDs)
+		if err != nil {
+			log.Errorf("bitcoin backfill claim error: %v", err)
+			break
+		}
+		if batch == nil {
+			break
+		}
+		done.Add(1)
+		select {
+		case bfs.tasks <- task{batch: batch, done: done}:
+		case <-bfs.ctx.Done():
+			batch.Release(bfs.ctx.Err())
+			done.Done()
+			break claim
+		}
+	}
+	done.Wait()
+	for _, jobID := range jobIDs {
+		unfinished, err := bfs.Queue.Jobs.Finish(jobID)
+		if err != nil {
+			log.Errorf("bitcoin backfill job error: %v", err)
+			continue
+		}
+		if len(unfinished) > 0 {
+			log.Warnf("bitcoin backfill job %d has %d batches unfinished, they will be retried on a later pass", jobID, len(unfinished))
+		}
+	}
+}
+
+// validate hands the heights to the worker pool in batches, returning once they are all validated and the tables
+// cleaned for resyncing are vacuumed
+func (bfs *Service) validate(heights []uint64) {
+	if len(heights) > 0 {
+		log.Infof("validating %d bitcoin blocks against the node", len(heights))
+	}
+	done := new(sync.WaitGroup)
+	for start := 0; start < len(heights); start += int(bfs.BatchSize) {
+		end := start + int(bfs.BatchSize)
+		if end > len(heights) {
+			end = len(heights)
+		}
+		done.Add(1)
+		select {
+		case bfs.tasks <- task{validate: heights[start:end], done: done}:
+		case <-bfs.ctx.Done():
+			done.Done()
+			done.Wait()
+			bfs.vacuum()
+			return
+		}
+	}
+	done.Wait()
+	bfs.vacuum()
+}
+
+// vacuum vacuums the tables once the validation pass is over, if any heights were cleaned out for resyncing
+func (bfs *Service) vacuum() {
+	if err := bfs.Validator.Vacuum(); err != nil {
+		log.Errorf("bitcoin backfill vacuum error: %v", err)
+	}
+}
+
+// work processes tasks from the pool's channel until the service is stopped
+func (bfs *Service) work(wg *sync.WaitGroup, id int) {
 	defer wg.Done()
 	for {
 		select {
-		case heights := <-heightChan:
-			log.Debugf("bitcoin backfill worker %d processing section from %d to %d", id, heights[0], heights[len(heights)-1])
-			payloads, err := bfs.Fetcher.FetchAt(heights)
-			if err != nil {
-				log.Errorf("bitcoin backfill worker %d fetcher error: %s", id, err.Error())
+		case t := <-bfs.tasks:
+			if t.batch != nil {
+				log.Debugf("bitcoin backfill worker %d processing section from %d to %d", id, t.batch.Start, t.batch.Stop)
+				t.batch.Release(bfs.fill(t.batch.Context(), t.batch.ResyncBatch))
+			} else {
+				bfs.validateHeights(id, t.validate)
 			}
-			for _, payload := range payloads {
-				ipldPayload, err := bfs.Converter.Convert(payload)
-				if err != nil {
-					log.Errorf("bitcoin backfill worker %d converter error: %s", id, err.Error())
-				}
-				if err := bfs.Publisher.Publish(*ipldPayload); err != nil {
-					log.Errorf("bitcoin backfill worker %d publisher error: %s", id, err.Error())
-					continue
-				}
-			}
-			log.Infof("bitcoin backfill worker %d finished section from %d to %d", id, heights[0], heights[len(heights)-1])
-		case <-bfs.QuitChan:
+			t.done.Done()
+		case <-bfs.ctx.Done():
 			log.Infof("bitcoin backfill worker %d shutting down", id)
 			return
 		}
 	}
 }
 
-func (bfs *Service) Stop() error {
-	log.Infof("stopping bitcoin backfill service")
-	close(bfs.QuitChan)
+// fill fetches, converts and publishes the blocks in the batch, giving up once the context is done
+func (bfs *Service) fill(ctx context.Context, batch btc.ResyncBatch) error {
+	heights := make([]uint64, 0, batch.Stop-batch.Start+1)
+	for height := batch.Start; height <= batch.Stop; height++ {
+		heights = append(heights, height)
+	}
+	payloads, err := bfs.Fetcher.FetchAt(ctx, heights)
+	if err != nil {
+		return fmt.Errorf("fetcher error: %v", err)
+	}
+	return bfs.convertAndPublish(ctx, payloads)
+}
+
+// validateHeights validates the blocks at the heights against the node, resyncing the ones that fail
+func (bfs *Service) validateHeights(id int, heights []uint64) {
+	log.Debugf("bitcoin backfill worker %d validating %d blocks from %d to %d", id, len(heights), heights[0], heights[len(heights)-1])
+	payloads, err := bfs.Fetcher.FetchAt(bfs.workCtx, heights)
+	if err != nil {
+		log.Errorf("bitcoin backfill worker %d fetcher error: %s", id, err.Error())
+		return
+	}
+	failed, err := bfs.Validator.Validate(payloads)
+	if err != nil {
+		log.Errorf("bitcoin backfill worker %d validator error: %s", id, err.Error())
+		return
+	}
+	// the validator has cleared out the heights that failed, resync them with the node's blocks
+	if err := bfs.convertAndPublish(bfs.workCtx, failed); err != nil {
+		log.Errorf("bitcoin backfill worker %d %s", id, err.Error())
+	}
+}
+
+// convertAndPublish converts the fetched payloads and publishes them
+func (bfs *Service) convertAndPublish(ctx context.Context, payloads []btc.BlockPayload) error {
+	ipldPayloads := make([]btc.ConvertedPayload, 0, len(payloads))
+	for _, payload := range payloads {
+		ipldPayload, err := bfs.Converter.Convert(payload)
+		if err != nil {
+			return fmt.Errorf("converter error at height %d: %v", payload.BlockHeight, err)
+		}
+		ipldPayloads = append(ipldPayloads, *ipldPayload)
+	}
+	prom.BlocksConverted(len(ipldPayloads))
+	return bfs.publish(ctx, ipldPayloads)
+}
+
+// publish publishes the payloads in atomic batches of CommitSize blocks
+func (bfs *Service) publish(ctx context.Context, payloads []btc.ConvertedPayload) error {
+	for start := 0; start < len(payloads); start += int(bfs.CommitSize) {
+		end := start + int(bfs.CommitSize)
+		if end > len(payloads) {
+			end = len(payloads)
+		}
+		if err := bfs.Publisher.PublishBatch(ctx, payloads[start:end]); err != nil {
+			return fmt.Errorf("publisher error for heights %d to %d: %v", payloads[start].Height(), payloads[end-1].Height(), err)
+		}
+		prom.BlocksPublished(end - start)
+	}
 	return nil
 }
@@ -19,6 +19,7 @@ package ipld
 import (
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
+	"github.com/ipfs/go-cid"
 	node "github.com/ipfs/go-ipld-format"
 )
 
@@ -42,33 +43,36 @@ func FromHeaderAndTxs(header *wire.BlockHeader, txs []*btcutil.Tx) (*BtcHeader,
 }
 
 func mkMerkleTree(txs []*BtcTx) ([]*BtcTxTrie, error) {
-	layer := make([]node.Node, len(txs))
+	txCIDs := make([]cid.Cid, len(txs))
 	for i, tx := range txs {
-		layer[i] = tx
+		txCIDs[i] = tx.Cid()
 	}
+	return TxTrieFromCIDs(txCIDs), nil
+}
+
+// TxTrieFromCIDs builds the tx trie nodes linking together the txs with the given CIDs, in block order
+func TxTrieFromCIDs(txCIDs []cid.Cid) []*BtcTxTrie {
+	layer := make([]cid.Cid, len(txCIDs))
+	copy(layer, txCIDs)
 	var out []*BtcTxTrie
-	var next []node.Node
+	var next []cid.Cid
 	for len(layer) > 1 {
 		if len(layer)%2 != 0 {
 			layer = append(layer, layer[len(layer)-1])
 		}
 		for i := 0; i < len(layer)/2; i++ {
-			var left, right node.Node
-			left = layer[i*2]
-			right = layer[(i*2)+1]
-
 			t := &BtcTxTrie{
-				Left:  &node.Link{Cid: left.Cid()},
-				Right: &node.Link{Cid: right.Cid()},
+				Left:  &node.Link{Cid: layer[i*2]},
+				Right: &node.Link{Cid: layer[(i*2)+1]},
 			}
 
 			out = append(out, t)
-			next = append(next, t)
+			next = append(next, t.Cid())
 		}
 
 		layer = next
 		next = nil
 	}
 
-	return out, nil
+	return out
 }
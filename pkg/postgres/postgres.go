@@ -21,8 +21,10 @@ import (
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq" //postgres driver
+	log "github.com/sirupsen/logrus"
 
 	"github.com/vulcanize/ipld-btc-indexer/pkg/node"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/prom"
 )
 
 type DB struct {
@@ -47,6 +49,9 @@ func NewDB(databaseConfig Config, node node.Node) (*DB, error) {
 		lifetime := time.Duration(databaseConfig.MaxLifetime) * time.Second
 		db.SetConnMaxLifetime(lifetime)
 	}
+	if err := prom.RegisterDBCollector(databaseConfig.Name, db); err != nil {
+		log.Warnf("unable to register db stats collector: %v", err)
+	}
 	pg := DB{DB: db, Node: node}
 	nodeErr := pg.CreateNode(&node)
 	if nodeErr != nil {
@@ -0,0 +1,55 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package prom
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// Env variables
+const (
+	METRICS_ENABLED   = "METRICS_ENABLED"
+	METRICS_HTTP_ADDR = "METRICS_HTTP_ADDR"
+	METRICS_HTTP_PORT = "METRICS_HTTP_PORT"
+)
+
+// Config holds the settings for collecting and serving metrics
+type Config struct {
+	Enabled  bool
+	HTTPAddr string
+	HTTPPort int
+}
+
+// NewConfig is used to initialize a metrics config from a .toml file
+func NewConfig() Config {
+	viper.BindEnv("metrics.enabled", METRICS_ENABLED)
+	viper.BindEnv("metrics.httpAddr", METRICS_HTTP_ADDR)
+	viper.BindEnv("metrics.httpPort", METRICS_HTTP_PORT)
+
+	return Config{
+		Enabled:  viper.GetBool("metrics.enabled"),
+		HTTPAddr: viper.GetString("metrics.httpAddr"),
+		HTTPPort: viper.GetInt("metrics.httpPort"),
+	}
+}
+
+// Address returns the host:port the metrics are served on
+func (c Config) Address() string {
+	return fmt.Sprintf("%s:%d", c.HTTPAddr, c.HTTPPort)
+}
@@ -0,0 +1,96 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package prom
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const subsystemDB = "db"
+
+// DBStatsGetter is the interface satisfied by anything that reports connection pool stats, such as sql.DB and sqlx.DB
+type DBStatsGetter interface {
+	Stats() sql.DBStats
+}
+
+// DBStatsCollector is a prometheus.Collector reporting the connection pool stats of a database handle
+type DBStatsCollector struct {
+	sg DBStatsGetter
+
+	maxOpen           *prometheus.Desc
+	open              *prometheus.Desc
+	inUse             *prometheus.Desc
+	idle              *prometheus.Desc
+	waitedFor         *prometheus.Desc
+	blockedSeconds    *prometheus.Desc
+	closedMaxIdle     *prometheus.Desc
+	closedMaxLifetime *prometheus.Desc
+}
+
+// NewDBStatsCollector creates a DBStatsCollector for the database handle, labelled with the database name
+func NewDBStatsCollector(dbName string, sg DBStatsGetter) *DBStatsCollector {
+	labels := prometheus.Labels{"db_name": dbName}
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystemDB, name), help, nil, labels)
+	}
+	return &DBStatsCollector{
+		sg:                sg,
+		maxOpen:           desc("max_open", "Maximum number of open connections to the database"),
+		open:              desc("open", "Number of established connections, both in use and idle"),
+		inUse:             desc("in_use", "Number of connections currently in use"),
+		idle:              desc("idle", "Number of idle connections"),
+		waitedFor:         desc("waited_for_total", "Total number of connections waited for"),
+		blockedSeconds:    desc("blocked_seconds_total", "Total time blocked waiting for a new connection"),
+		closedMaxIdle:     desc("closed_max_idle_total", "Total number of connections closed due to SetMaxIdleConns"),
+		closedMaxLifetime: desc("closed_max_lifetime_total", "Total number of connections closed due to SetConnMaxLifetime"),
+	}
+}
+
+// Describe satisfies the prometheus.Collector interface
+func (c *DBStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxOpen
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitedFor
+	ch <- c.blockedSeconds
+	ch <- c.closedMaxIdle
+	ch <- c.closedMaxLifetime
+}
+
+// Collect satisfies the prometheus.Collector interface
+func (c *DBStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.sg.Stats()
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(c.waitedFor, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.blockedSeconds, prometheus.CounterValue, stats.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.closedMaxIdle, prometheus.CounterValue, float64(stats.MaxIdleClosed))
+	ch <- prometheus.MustNewConstMetric(c.closedMaxLifetime, prometheus.CounterValue, float64(stats.MaxLifetimeClosed))
+}
+
+// RegisterDBCollector registers a DBStatsCollector for the database handle, if metrics are enabled
+func RegisterDBCollector(dbName string, sg DBStatsGetter) error {
+	if !metrics {
+		return nil
+	}
+	return prometheus.Register(NewDBStatsCollector(dbName, sg))
+}
@@ -0,0 +1,215 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package prom
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	namespace = "ipld_btc_indexer"
+
+	subsystemSync    = "sync"
+	subsystemPublish = "publish"
+	subsystemRPC     = "rpc"
+	subsystemGaps    = "gaps"
+)
+
+var (
+	metrics bool
+
+	blocksStreamed  prometheus.Counter
+	blocksConverted prometheus.Counter
+	blocksPublished prometheus.Counter
+
+	publishLatency *prometheus.HistogramVec
+
+	rpcLatency *prometheus.HistogramVec
+	rpcErrors  *prometheus.CounterVec
+
+	gapCount prometheus.Gauge
+
+	queueDepth atomic.Value
+
+	chainHead  int64
+	syncedHead int64
+)
+
+// Init registers the collectors with the default prometheus registry and turns on metric collection
+// until it is called every function in this package is a no-op
+func Init() {
+	metrics = true
+
+	blocksStreamed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemSync,
+		Name:      "blocks_streamed_total",
+		Help:      "Number of blocks received from the streamer",
+	})
+	blocksConverted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemSync,
+		Name:      "blocks_converted_total",
+		Help:      "Number of blocks converted into IPLD payloads",
+	})
+	blocksPublished = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemSync,
+		Name:      "blocks_published_total",
+		Help:      "Number of blocks published and indexed in Postgres",
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystemSync,
+		Name:      "queue_depth",
+		Help:      "Number of converted blocks waiting for a publish worker",
+	}, func() float64 {
+		if depth, ok := queueDepth.Load().(func() int); ok {
+			return float64(depth())
+		}
+		return 0
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystemSync,
+		Name:      "chain_head",
+		Help:      "Height of the chain tip last reported by the node",
+	}, func() float64 { return float64(atomic.LoadInt64(&chainHead)) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystemSync,
+		Name:      "synced_head",
+		Help:      "Height of the last block published at the head of the chain",
+	}, func() float64 { return float64(atomic.LoadInt64(&syncedHead)) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystemSync,
+		Name:      "head_lag",
+		Help:      "Number of blocks between the node's chain tip and the last block published",
+	}, headLag)
+
+	publishLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: subsystemPublish,
+		Name:      "duration_seconds",
+		Help:      "Time spent writing to each table when publishing",
+	}, []string{"table"})
+
+	rpcLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: subsystemRPC,
+		Name:      "duration_seconds",
+		Help:      "Latency of calls to the node",
+	}, []string{"method"})
+	rpcErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystemRPC,
+		Name:      "errors_total",
+		Help:      "Number of calls to the node that returned an error",
+	}, []string{"method"})
+
+	gapCount = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystemGaps,
+		Name:      "count",
+		Help:      "Number of gaps found in the indexed data by the last search",
+	})
+}
+
+// Enabled returns whether metric collection has been turned on
+func Enabled() bool {
+	return metrics
+}
+
+// BlockStreamed increments the streamed blocks counter
+func BlockStreamed() {
+	if metrics {
+		blocksStreamed.Inc()
+	}
+}
+
+// BlocksConverted adds n to the converted blocks counter
+func BlocksConverted(n int) {
+	if metrics {
+		blocksConverted.Add(float64(n))
+	}
+}
+
+// BlocksPublished adds n to the published blocks counter
+func BlocksPublished(n int) {
+	if metrics {
+		blocksPublished.Add(float64(n))
+	}
+}
+
+// SetQueueDepthFunc sets the function the queue depth is read from when the metrics are collected
+func SetQueueDepthFunc(depth func() int) {
+	queueDepth.Store(depth)
+}
+
+// SetChainHead records the height of the node's chain tip
+func SetChainHead(height int64) {
+	atomic.StoreInt64(&chainHead, height)
+}
+
+// SetSyncedHead records the height of a block published at the head of the chain, if it is the highest yet
+// blocks are published concurrently, so a lower one can finish after a higher one and must not move the head back
+func SetSyncedHead(height int64) {
+	for {
+		current := atomic.LoadInt64(&syncedHead)
+		if height <= current || atomic.CompareAndSwapInt64(&syncedHead, current, height) {
+			return
+		}
+	}
+}
+
+// headLag is the distance between the chain tip and the synced head, or 0 until both are known
+func headLag() float64 {
+	tip, synced := atomic.LoadInt64(&chainHead), atomic.LoadInt64(&syncedHead)
+	if tip == 0 || synced == 0 || synced > tip {
+		return 0
+	}
+	return float64(tip - synced)
+}
+
+// PublishDuration records the time spent writing to the table since start
+func PublishDuration(table string, start time.Time) {
+	if metrics {
+		publishLatency.WithLabelValues(table).Observe(time.Since(start).Seconds())
+	}
+}
+
+// RPCCall records the latency of a call to the node made at start, and whether it failed
+func RPCCall(method string, start time.Time, err error) {
+	if metrics {
+		rpcLatency.WithLabelValues(method).Observe(time.Since(start).Seconds())
+		if err != nil {
+			rpcErrors.WithLabelValues(method).Inc()
+		}
+	}
+}
+
+// SetGapCount sets the number of gaps found in the indexed data
+func SetGapCount(gaps int) {
+	if metrics {
+		gapCount.Set(float64(gaps))
+	}
+}
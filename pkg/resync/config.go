@@ -23,6 +23,7 @@ import (
 	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/spf13/viper"
 
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/node"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
@@ -33,11 +34,19 @@ import (
 const (
 	RESYNC_START            = "RESYNC_START"
 	RESYNC_STOP             = "RESYNC_STOP"
+	RESYNC_RANGES           = "RESYNC_RANGES"
+	RESYNC_RANGES_FILE      = "RESYNC_RANGES_FILE"
 	RESYNC_BATCH_SIZE       = "RESYNC_BATCH_SIZE"
 	RESYNC_WORKERS          = "RESYNC_WORKERS"
 	RESYNC_CLEAR_OLD_CACHE  = "RESYNC_CLEAR_OLD_CACHE"
 	RESYNC_TYPE             = "RESYNC_TYPE"
 	RESYNC_RESET_VALIDATION = "RESYNC_RESET_VALIDATION"
+	RESYNC_RETRY_FAILED     = "RESYNC_RETRY_FAILED"
+	RESYNC_BULK_LOAD        = "RESYNC_BULK_LOAD"
+	RESYNC_COMMIT_SIZE      = "RESYNC_COMMIT_SIZE"
+	RESYNC_MAX_ATTEMPTS     = "RESYNC_MAX_ATTEMPTS"
+	RESYNC_LEASE            = "RESYNC_LEASE"
+	RESYNC_DRAIN_TIMEOUT    = "RESYNC_DRAIN_TIMEOUT"
 
 	RESYNC_MAX_IDLE_CONNECTIONS = "RESYNC_MAX_IDLE_CONNECTIONS"
 	RESYNC_MAX_OPEN_CONNECTIONS = "RESYNC_MAX_OPEN_CONNECTIONS"
@@ -49,17 +58,26 @@ type Config struct {
 	ResyncType      shared.DataType // The type of data to resync
 	ClearOldCache   bool            // Resync will first clear all the data within the range
 	ResetValidation bool            // If true, resync will reset the validation level to 0 for the given range
+	RetryFailed     bool            // If true, the batches a resumed job failed get a fresh set of attempts
+	BulkLoad        bool            // Publish each batch with COPY instead of row-by-row inserts
 
 	// DB info
 	DB       *postgres.DB
 	DBConfig postgres.Config
 
-	HTTPConfig *rpcclient.ConnConfig // Bitcoin rpc client config
-	NodeInfo   node.Node             // Info for the associated node
-	Ranges     [][2]uint64           // The block height ranges to resync
-	BatchSize  uint64                // BatchSize for the resync http calls (client has to support batch sizing)
-	Timeout    time.Duration         // HTTP connection timeout in seconds
+	Source     btc.SourceConfig // Bitcoin data source config
+	NodeInfo   node.Node        // Info for the associated node
+	Ranges     [][2]uint64      // The block height ranges to resync, resolved from resync.ranges or resync.start/stop
+	BatchSize  uint64           // BatchSize for the resync http calls (client has to support batch sizing)
+	CommitSize uint64           // Number of blocks published in each Postgres tx; defaults to BatchSize
+	Timeout    time.Duration    // HTTP connection timeout in seconds
 	Workers    uint64
+	// Number of times a batch is attempted before it is reported as failed
+	MaxAttempts int
+	// How long a worker's claim on a batch lasts without a heartbeat
+	Lease time.Duration
+	// Time given to the batches in progress to finish once the resync is interrupted
+	DrainTimeout time.Duration
 }
 
 // NewConfig fills and returns a resync config from toml parameters
@@ -70,11 +88,19 @@ func NewConfig() (*Config, error) {
 	viper.BindEnv("bitcoin.httpPath", shared.BTC_HTTP_PATH)
 	viper.BindEnv("resync.start", RESYNC_START)
 	viper.BindEnv("resync.stop", RESYNC_STOP)
+	viper.BindEnv("resync.ranges", RESYNC_RANGES)
+	viper.BindEnv("resync.rangesFile", RESYNC_RANGES_FILE)
 	viper.BindEnv("resync.clearOldCache", RESYNC_CLEAR_OLD_CACHE)
 	viper.BindEnv("resync.type", RESYNC_TYPE)
 	viper.BindEnv("resync.batchSize", RESYNC_BATCH_SIZE)
 	viper.BindEnv("resync.workers", RESYNC_WORKERS)
 	viper.BindEnv("resync.resetValidation", RESYNC_RESET_VALIDATION)
+	viper.BindEnv("resync.retryFailed", RESYNC_RETRY_FAILED)
+	viper.BindEnv("resync.bulkLoad", RESYNC_BULK_LOAD)
+	viper.BindEnv("resync.commitSize", RESYNC_COMMIT_SIZE)
+	viper.BindEnv("resync.maxAttempts", RESYNC_MAX_ATTEMPTS)
+	viper.BindEnv("resync.lease", RESYNC_LEASE)
+	viper.BindEnv("resync.drainTimeout", RESYNC_DRAIN_TIMEOUT)
 	viper.BindEnv("resync.timeout", shared.HTTP_TIMEOUT)
 
 	timeout := viper.GetInt("resync.timeout")
@@ -83,13 +109,24 @@ func NewConfig() (*Config, error) {
 	}
 	c.Timeout = time.Second * time.Duration(timeout)
 
-	start := uint64(viper.GetInt64("resync.start"))
-	stop := uint64(viper.GetInt64("resync.stop"))
-	c.Ranges = [][2]uint64{{start, stop}}
+	specs, err := RangeSpecs(viper.Get("resync.ranges"), viper.GetString("resync.rangesFile"))
+	if err != nil {
+		return nil, err
+	}
 	c.ClearOldCache = viper.GetBool("resync.clearOldCache")
 	c.ResetValidation = viper.GetBool("resync.resetValidation")
+	c.RetryFailed = viper.GetBool("resync.retryFailed")
+	c.BulkLoad = viper.GetBool("resync.bulkLoad")
 	c.BatchSize = uint64(viper.GetInt64("resync.batchSize"))
+	c.CommitSize = uint64(viper.GetInt64("resync.commitSize"))
 	c.Workers = uint64(viper.GetInt64("resync.workers"))
+	c.MaxAttempts = viper.GetInt("resync.maxAttempts")
+	c.Lease = time.Second * time.Duration(viper.GetInt("resync.lease"))
+	drainTimeout := viper.GetInt("resync.drainTimeout")
+	if drainTimeout < 1 {
+		drainTimeout = 30
+	}
+	c.DrainTimeout = time.Second * time.Duration(drainTimeout)
 
 	resyncType := viper.GetString("resync.type")
 	c.ResyncType, err = shared.GenerateDataTypeFromString(resyncType)
@@ -104,12 +141,29 @@ func NewConfig() (*Config, error) {
 	}
 
 	btcHTTP := viper.GetString("bitcoin.httpPath")
-	c.NodeInfo, c.HTTPConfig = shared.GetBtcNodeAndClient(btcHTTP)
+	var clientConfig *rpcclient.ConnConfig
+	c.NodeInfo, clientConfig = shared.GetBtcNodeAndClient(btcHTTP)
+	c.Source.RPCConfigs = shared.GetBtcClientConfigs(btcHTTP, clientConfig)
+	c.Source.Type, c.Source.EsploraPaths, err = shared.GetBtcSource()
+	if err != nil {
+		return nil, err
+	}
+	c.Source.Timeout = c.Timeout
+	c.Source.CrossValidate = shared.GetBtcCrossValidate()
 
 	c.DBConfig.Init()
 	overrideDBConnConfig(&c.DBConfig)
 	db := utils.LoadPostgres(c.DBConfig, c.NodeInfo)
 	c.DB = &db
+
+	// ranges are only resolved once the db is loaded, as expressions are resolved against it
+	if len(specs) == 0 {
+		start := uint64(viper.GetInt64("resync.start"))
+		stop := uint64(viper.GetInt64("resync.stop"))
+		c.Ranges = [][2]uint64{{start, stop}}
+	} else if c.Ranges, err = ResolveRanges(specs, btc.NewGapRetriever(c.DB)); err != nil {
+		return nil, err
+	}
 	return c, nil
 }
 
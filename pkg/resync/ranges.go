@@ -0,0 +1,156 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package resync
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/utils"
+)
+
+var (
+	lastBlocksExpr     = regexp.MustCompile(`(?i)^last\s+(\d+)(\s+blocks?)?$`)
+	timesValidatedExpr = regexp.MustCompile(`(?i)^times_validated\s*<\s*(\d+)$`)
+)
+
+// ResolveRanges resolves range specs into the block ranges to resync, merging any that overlap
+// A spec is either a literal range ("0-1000", or a single height "1000") or an expression resolved against the db:
+// "last N" (or "last N blocks") is the N highest indexed block heights, and "times_validated < N" is every indexed height
+// whose header has been validated fewer than N times
+func ResolveRanges(specs []string, retriever btc.Retriever) ([][2]uint64, error) {
+	var rngs [][2]uint64
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		resolved, err := resolveRange(spec, retriever)
+		if err != nil {
+			return nil, err
+		}
+		if len(resolved) == 0 {
+			logrus.Warnf("bitcoin resync range %q matches no blocks", spec)
+		}
+		rngs = append(rngs, resolved...)
+	}
+	return utils.MergeBlockRanges(rngs), nil
+}
+
+func resolveRange(spec string, retriever btc.Retriever) ([][2]uint64, error) {
+	if match := lastBlocksExpr.FindStringSubmatch(spec); match != nil {
+		n, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid resync range %q: %v", spec, err)
+		}
+		if n == 0 {
+			return nil, nil
+		}
+		last, err := retriever.RetrieveLastBlockNumber()
+		if err != nil {
+			return nil, fmt.Errorf("resolving resync range %q: %v", spec, err)
+		}
+		start := uint64(0)
+		if uint64(last)+1 > n {
+			start = uint64(last) + 1 - n
+		}
+		return [][2]uint64{{start, uint64(last)}}, nil
+	}
+	if match := timesValidatedExpr.FindStringSubmatch(spec); match != nil {
+		level, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid resync range %q: %v", spec, err)
+		}
+		heights, err := retriever.RetrieveUnvalidatedHeights(level)
+		if err != nil {
+			return nil, fmt.Errorf("resolving resync range %q: %v", spec, err)
+		}
+		gaps := btc.MissingHeightsToGaps(heights)
+		rngs := make([][2]uint64, len(gaps))
+		for i, gap := range gaps {
+			rngs[i] = [2]uint64{gap.Start, gap.Stop}
+		}
+		return rngs, nil
+	}
+	rng, err := utils.ParseBlockRange(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resync range %q: expected start-stop, \"last N\" or \"times_validated < N\"", spec)
+	}
+	return [][2]uint64{rng}, nil
+}
+
+// RangeSpecs returns the range specs of the resync.ranges config value followed by those of the ranges file, if there is one
+func RangeSpecs(ranges interface{}, rangesFile string) ([]string, error) {
+	specs := rangeSpecs(ranges)
+	if rangesFile == "" {
+		return specs, nil
+	}
+	fileSpecs, err := readRangesFile(rangesFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read resync ranges file: %v", err)
+	}
+	return append(specs, fileSpecs...), nil
+}
+
+// readRangesFile reads range specs from a file, one per line or separated by commas; blank lines and lines starting
+// with # are ignored
+func readRangesFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var specs []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		specs = append(specs, strings.Split(line, ",")...)
+	}
+	return specs, scanner.Err()
+}
+
+// rangeSpecs returns the range specs of a config value, which is a list in .toml and from the cli,
+// and a comma separated string in the environment
+func rangeSpecs(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return strings.Split(v, ",")
+	case []string:
+		return v
+	case []interface{}:
+		specs := make([]string, len(v))
+		for i, spec := range v {
+			specs[i] = fmt.Sprint(spec)
+		}
+		return specs
+	default:
+		return nil
+	}
+}
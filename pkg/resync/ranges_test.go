@@ -0,0 +1,150 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package resync_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/resync"
+)
+
+var _ = Describe("Ranges", func() {
+	var retriever *mocks.CIDRetriever
+	BeforeEach(func() {
+		retriever = &mocks.CIDRetriever{
+			LastBlockNumberToReturn: 1000,
+			HeightsToValidate:       []uint64{5, 6, 7, 20, 40, 41},
+		}
+	})
+
+	Describe("ResolveRanges", func() {
+		table.DescribeTable("Resolves range specs into merged block ranges",
+			func(specs []string, expected [][2]uint64) {
+				rngs, err := resync.ResolveRanges(specs, retriever)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(rngs).To(Equal(expected))
+			},
+			table.Entry("a start-stop range", []string{"10-20"}, [][2]uint64{{10, 20}}),
+			table.Entry("a single height", []string{" 15 "}, [][2]uint64{{15, 15}}),
+			table.Entry("the last N blocks", []string{"last 10"}, [][2]uint64{{991, 1000}}),
+			table.Entry("the last N blocks, spelled out", []string{"LAST 1 block"}, [][2]uint64{{1000, 1000}}),
+			table.Entry("more last blocks than are indexed", []string{"last 5000 blocks"}, [][2]uint64{{0, 1000}}),
+			table.Entry("no last blocks", []string{"last 0"}, [][2]uint64(nil)),
+			table.Entry("heights validated fewer than N times", []string{"times_validated < 2"}, [][2]uint64{{5, 7}, {20, 20}, {40, 41}}),
+			table.Entry("overlapping and adjacent ranges", []string{"30-50", "10-20", "15-25", "26-29"}, [][2]uint64{{10, 50}}),
+			table.Entry("expressions and literal ranges", []string{"0-6", "times_validated<2", "last 2", "42-45"}, [][2]uint64{{0, 7}, {20, 20}, {40, 45}, {999, 1000}}),
+			table.Entry("blank specs", []string{"", "  ", "3-4"}, [][2]uint64{{3, 4}}),
+		)
+
+		It("Passes the validation level through to the retriever", func() {
+			_, err := resync.ResolveRanges([]string{"times_validated < 3"}, retriever)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(retriever.ValidationLevels).To(Equal([]int{3}))
+		})
+
+		It("Resolves an expression matching no blocks to nothing", func() {
+			retriever.HeightsToValidate = nil
+			rngs, err := resync.ResolveRanges([]string{"times_validated < 1"}, retriever)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rngs).To(BeEmpty())
+		})
+
+		table.DescribeTable("Rejects invalid range specs",
+			func(spec string) {
+				_, err := resync.ResolveRanges([]string{"1-2", spec}, retriever)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring(spec))
+			},
+			table.Entry("a reversed range", "20-10"),
+			table.Entry("a word", "latest"),
+			table.Entry("a negative count", "last -1"),
+			table.Entry("a comparison other than <", "times_validated > 1"),
+		)
+
+		It("Returns the retriever's errors", func() {
+			retriever.RetrieveLastBlockNumberErr = errors.New("mock last block error")
+			_, err := resync.ResolveRanges([]string{"last 10"}, retriever)
+			Expect(err).To(MatchError(ContainSubstring("mock last block error")))
+			retriever.HeightsToValidateErr = errors.New("mock unvalidated heights error")
+			_, err = resync.ResolveRanges([]string{"times_validated < 1"}, retriever)
+			Expect(err).To(MatchError(ContainSubstring("mock unvalidated heights error")))
+		})
+	})
+
+	Describe("RangeSpecs", func() {
+		var dir string
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "resync-ranges")
+			Expect(err).ToNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			os.RemoveAll(dir)
+			os.Unsetenv(resync.RESYNC_RANGES)
+			viper.Reset()
+		})
+
+		It("Reads the ranges as a list, as they are in .toml and from the cli", func() {
+			specs, err := resync.RangeSpecs([]interface{}{"0-10", "last 5"}, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(specs).To(Equal([]string{"0-10", "last 5"}))
+		})
+
+		It("Reads the ranges as comma separated values from the environment", func() {
+			os.Setenv(resync.RESYNC_RANGES, "0-10,last 5, times_validated < 2")
+			viper.BindEnv("resync.ranges", resync.RESYNC_RANGES)
+			specs, err := resync.RangeSpecs(viper.Get("resync.ranges"), "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(specs).To(Equal([]string{"0-10", "last 5", " times_validated < 2"}))
+			rngs, err := resync.ResolveRanges(specs, retriever)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rngs).To(Equal([][2]uint64{{0, 10}, {20, 20}, {40, 41}, {996, 1000}}))
+		})
+
+		It("Appends the specs of a ranges file, skipping blank lines and comments", func() {
+			path := filepath.Join(dir, "ranges.txt")
+			contents := "# blocks to resync\n100-200\n\n  last 3  \n300,400-410\n"
+			Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+			specs, err := resync.RangeSpecs("0-10", path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(specs).To(Equal([]string{"0-10", "100-200", "last 3", "300", "400-410"}))
+			rngs, err := resync.ResolveRanges(specs, retriever)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rngs).To(Equal([][2]uint64{{0, 10}, {100, 200}, {300, 300}, {400, 410}, {998, 1000}}))
+		})
+
+		It("Fails on a missing ranges file", func() {
+			_, err := resync.RangeSpecs(nil, filepath.Join(dir, "missing.txt"))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Returns no specs when no ranges are configured", func() {
+			specs, err := resync.RangeSpecs(nil, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(specs).To(BeEmpty())
+		})
+	})
+})
@@ -17,19 +17,24 @@
 package resync
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/vulcanize/ipld-btc-indexer/pkg/prom"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
 	"github.com/vulcanize/ipld-btc-indexer/utils"
 )
 
 type Resync interface {
-	Sync() error
+	Sync(ctx context.Context) error
 }
 
 type Service struct {
@@ -43,12 +48,14 @@ type Service struct {
 	Fetcher btc.Fetcher
 	// Interface for cleaning out data before resyncing (if clearOldCache is on)
 	Cleaner btc.Cleaner
+	// Queue of the batches of resync jobs, shared with any other processes resyncing the same ranges
+	Queue *btc.BatchQueue
 	// Size of batch fetches
 	BatchSize uint64
+	// Number of blocks published in each Postgres tx
+	CommitSize uint64
 	// Number of worker goroutines
 	Workers int64
-	// Channel for receiving quit signal
-	quitChan chan bool
 	// Chain config for btc
 	ChainConfig *chaincfg.Params
 	// Resync data type
@@ -59,6 +66,10 @@ type Service struct {
 	clearOldCache bool
 	// Flag to turn on or off validation level reset
 	resetValidation bool
+	// Flag to give the batches that failed in an earlier run of a job a fresh set of attempts
+	retryFailed bool
+	// Time given to the batches in progress to finish once the context passed to Sync is cancelled
+	drainTimeout time.Duration
 }
 
 // NewResyncService creates and returns a resync service from the provided settings
@@ -67,92 +78,172 @@ func NewResyncService(settings *Config) (Resync, error) {
 	var err error
 	rs.ChainConfig = &chaincfg.MainNetParams /// TODO make this configurable
 	rs.Converter = btc.NewPayloadConverter(rs.ChainConfig)
-	rs.Publisher = btc.NewIPLDPublisher(settings.DB)
+	if settings.BulkLoad {
+		rs.Publisher = btc.NewBulkPublisher(settings.DB)
+	} else {
+		rs.Publisher = btc.NewIPLDPublisher(settings.DB)
+	}
 	rs.Retriever = btc.NewGapRetriever(settings.DB)
-	rs.Fetcher, err = btc.NewPayloadFetcher(settings.HTTPConfig)
+	rs.Fetcher, err = btc.NewFetcher(settings.Source)
 	if err != nil {
 		return nil, err
 	}
 	rs.Cleaner = btc.NewDBCleaner(settings.DB)
+	rs.Queue = btc.NewBatchQueue(btc.NewDBJobTracker(settings.DB), settings.Lease, settings.MaxAttempts)
 	rs.BatchSize = settings.BatchSize
 	if rs.BatchSize == 0 {
 		rs.BatchSize = shared.DefaultMaxBatchSize
 	}
+	rs.CommitSize = settings.CommitSize
+	if rs.CommitSize == 0 || rs.CommitSize > rs.BatchSize {
+		rs.CommitSize = rs.BatchSize
+	}
 	rs.Workers = int64(settings.Workers)
 	if rs.Workers == 0 {
 		rs.Workers = shared.DefaultMaxBatchNumber
 	}
 	rs.resetValidation = settings.ResetValidation
+	rs.retryFailed = settings.RetryFailed
 	rs.clearOldCache = settings.ClearOldCache
 	rs.data = settings.ResyncType
 	rs.ranges = settings.Ranges
-	rs.quitChan = make(chan bool)
+	rs.drainTimeout = settings.DrainTimeout
 	return rs, nil
 }
 
-func (rs *Service) Sync() error {
-	if rs.resetValidation {
-		logrus.Infof("resetting validation level")
-		if err := rs.Cleaner.ResetValidation(rs.ranges); err != nil {
-			return fmt.Errorf("validation reset failed: %v", err)
-		}
-	}
-	if rs.clearOldCache {
-		logrus.Infof("cleaning out old data from Postgres")
-		if err := rs.Cleaner.Clean(rs.ranges, rs.data); err != nil {
-			return fmt.Errorf("bitcoin %s data resync cleaning error: %v", rs.data.String(), err)
-		}
-	}
-	// spin up worker goroutines
-	heightsChan := make(chan []uint64)
-	for i := 1; i <= int(rs.Workers); i++ {
-		go rs.resync(i, heightsChan)
-	}
+// Sync resyncs the configured ranges
+// Each range is recorded as a job in btc.resync_jobs, with a row per batch in btc.resync_batches, and the batches are
+// claimed from there by the workers; a restarted resync picks up the batches left unfinished by an earlier run of the
+// same job, and any number of resync processes can be run against the same ranges to cooperatively work through them
+// Failed batches are retried up to MaxAttempts times, and the heights that still could not be synced are returned in the error
+// Once ctx is cancelled no more batches are claimed, and the batches in progress are given the drain timeout to finish
+// before their transactions are rolled back and they are released for a later run
+func (rs *Service) Sync(ctx context.Context) error {
+	jobs := make(map[int64][2]uint64, len(rs.ranges))
+	jobIDs := make([]int64, 0, len(rs.ranges))
 	for _, rng := range rs.ranges {
 		if rng[1] < rng[0] {
 			logrus.Error("bitcoin resync range ending block number needs to be greater than the starting block number")
 			continue
 		}
-		logrus.Infof("resyncing bitcoin data from %d to %d", rng[0], rng[1])
 		// break the range up into bins of smaller ranges
 		blockRangeBins, err := utils.GetBlockHeightBins(rng[0], rng[1], rs.BatchSize)
 		if err != nil {
 			return err
 		}
-		for _, heights := range blockRangeBins {
-			heightsChan <- heights
+		batches := make([][2]uint64, len(blockRangeBins))
+		for i, heights := range blockRangeBins {
+			batches[i] = [2]uint64{heights[0], heights[len(heights)-1]}
+		}
+		jobID, err := rs.Queue.Jobs.Job(btc.ResyncJob, rs.data, rng, batches, rs.retryFailed)
+		if err != nil {
+			return fmt.Errorf("bitcoin resync job error: %v", err)
+		}
+		pending, err := rs.Queue.Jobs.Pending(jobID, rs.Queue.MaxAttempts)
+		if err != nil {
+			return fmt.Errorf("bitcoin resync job error: %v", err)
 		}
+		logrus.Infof("resyncing bitcoin data from %d to %d in job %d: %d of %d batches left", rng[0], rng[1], jobID, len(pending), len(batches))
+		if _, ok := jobs[jobID]; !ok {
+			jobIDs = append(jobIDs, jobID)
+		}
+		jobs[jobID] = rng
+	}
+	// batches are reset and cleaned as they are claimed, so that a resumed job keeps the batches it already synced
+	// and no process cleans out a batch that another one is publishing
+	var cleaned uint64
+	workCtx, cancel := utils.DrainContext(ctx, rs.drainTimeout)
+	defer cancel()
+	err := rs.Queue.Drain(ctx, workCtx, jobIDs, int(rs.Workers), func(batchCtx context.Context, batch btc.ResyncBatch) error {
+		rng := [][2]uint64{{batch.Start, batch.Stop}}
+		if rs.resetValidation {
+			if err := rs.Cleaner.ResetValidation(rng); err != nil {
+				return fmt.Errorf("validation reset failed: %v", err)
+			}
+		}
+		if rs.clearOldCache {
+			if err := rs.Cleaner.Prune(rng, rs.data); err != nil {
+				return fmt.Errorf("bitcoin %s data resync cleaning error: %v", rs.data.String(), err)
+			}
+			atomic.AddUint64(&cleaned, 1)
+		}
+		return rs.syncBatch(batchCtx, batch)
+	})
+	if err != nil {
+		return fmt.Errorf("bitcoin resync queue error: %v", err)
 	}
-	// send a quit signal to each worker
-	// this blocks until each worker has finished its current task and can receive from the quit channel
-	for i := 1; i <= int(rs.Workers); i++ {
-		rs.quitChan <- true
+	if ctx.Err() != nil {
+		return fmt.Errorf("bitcoin resync interrupted; rerun resync with the same range to resume it")
 	}
-	return nil
+	if cleaned > 0 {
+		if err := rs.Cleaner.Vacuum(rs.data); err != nil {
+			return fmt.Errorf("bitcoin %s data resync vacuum error: %v", rs.data.String(), err)
+		}
+	}
+	return rs.summarize(jobIDs, jobs)
 }
 
-func (rs *Service) resync(id int, heightChan chan []uint64) {
-	for {
-		select {
-		case heights := <-heightChan:
-			logrus.Debugf("bitcoin resync worker %d processing section from %d to %d", id, heights[0], heights[len(heights)-1])
-			payloads, err := rs.Fetcher.FetchAt(heights)
-			if err != nil {
-				logrus.Errorf("bitcoin resync worker %d fetcher error: %s", id, err.Error())
-			}
-			for _, payload := range payloads {
-				ipldPayload, err := rs.Converter.Convert(payload)
-				if err != nil {
-					logrus.Errorf("bitcoin resync worker %d converter error: %s", id, err.Error())
-				}
-				if err := rs.Publisher.Publish(*ipldPayload); err != nil {
-					logrus.Errorf("bitcoin resync worker %d publisher error: %s", id, err.Error())
-				}
+// summarize marks the jobs whose batches are all done as finished and reports the heights that could not be synced
+func (rs *Service) summarize(jobIDs []int64, jobs map[int64][2]uint64) error {
+	var failed []string
+	for _, jobID := range jobIDs {
+		rng := jobs[jobID]
+		unfinished, err := rs.Queue.Jobs.Finish(jobID)
+		if err != nil {
+			return fmt.Errorf("bitcoin resync job error: %v", err)
+		}
+		if len(unfinished) == 0 {
+			logrus.Infof("bitcoin resync job %d finished resyncing %s data from %d to %d", jobID, rs.data.String(), rng[0], rng[1])
+			continue
+		}
+		for _, batch := range unfinished {
+			lastErr := ""
+			if batch.LastError != nil {
+				lastErr = *batch.LastError
 			}
-			logrus.Infof("bitcoin resync worker %d finished section from %d to %d", id, heights[0], heights[len(heights)-1])
-		case <-rs.quitChan:
-			logrus.Infof("bitcoin resync worker %d goroutine shutting down", id)
-			return
+			logrus.Errorf("bitcoin resync job %d could not sync heights %d to %d after %d attempts: %s", jobID, batch.Start, batch.Stop, batch.Attempts, lastErr)
+			failed = append(failed, fmt.Sprintf("%d-%d", batch.Start, batch.Stop))
 		}
 	}
+	if len(failed) > 0 {
+		return fmt.Errorf("bitcoin resync could not sync heights %s; rerun resync with the same range and --resync-retry-failed to retry them", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// syncBatch fetches, converts and publishes the blocks in the batch
+func (rs *Service) syncBatch(ctx context.Context, batch btc.ResyncBatch) error {
+	heights := make([]uint64, 0, batch.Stop-batch.Start+1)
+	for height := batch.Start; height <= batch.Stop; height++ {
+		heights = append(heights, height)
+	}
+	payloads, err := rs.Fetcher.FetchAt(ctx, heights)
+	if err != nil {
+		return fmt.Errorf("fetcher error: %v", err)
+	}
+	ipldPayloads := make([]btc.ConvertedPayload, 0, len(payloads))
+	for _, payload := range payloads {
+		ipldPayload, err := rs.Converter.Convert(payload)
+		if err != nil {
+			return fmt.Errorf("converter error at height %d: %v", payload.BlockHeight, err)
+		}
+		ipldPayloads = append(ipldPayloads, *ipldPayload)
+	}
+	prom.BlocksConverted(len(ipldPayloads))
+	return rs.publish(ctx, ipldPayloads)
+}
+
+// publish publishes the payloads in atomic batches of CommitSize blocks
+func (rs *Service) publish(ctx context.Context, payloads []btc.ConvertedPayload) error {
+	for start := 0; start < len(payloads); start += int(rs.CommitSize) {
+		end := start + int(rs.CommitSize)
+		if end > len(payloads) {
+			end = len(payloads)
+		}
+		if err := rs.Publisher.PublishBatch(ctx, payloads[start:end]); err != nil {
+			return fmt.Errorf("publisher error for heights %d to %d: %v", payloads[start].Height(), payloads[end-1].Height(), err)
+		}
+		prom.BlocksPublished(end - start)
+	}
+	return nil
 }
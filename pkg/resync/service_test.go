@@ -0,0 +1,55 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package resync_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/resync"
+)
+
+var _ = Describe("Service", func() {
+	It("Publishes nothing from a batch its sources disagree on", func() {
+		height := uint64(mocks.MockBlockHeight)
+		forkHeader := mocks.MockBlock.Header
+		forkHeader.Nonce++
+		good := &mocks.PayloadFetcher{PayloadsToReturn: map[uint64]btc.BlockPayload{height: mocks.MockBlockPayload}}
+		fork := &mocks.PayloadFetcher{PayloadsToReturn: map[uint64]btc.BlockPayload{
+			height: {BlockHeight: mocks.MockBlockHeight, Header: &forkHeader, Txs: mocks.MockTransactions},
+		}}
+		fetcher, err := btc.NewMultiFetcher([]btc.FetcherSource{{Name: "good", Fetcher: good}, {Name: "fork", Fetcher: fork}}, time.Second, true)
+		Expect(err).ToNot(HaveOccurred())
+		publisher := new(mocks.IterativeIPLDPublisher)
+		service := &resync.Service{
+			Fetcher:    fetcher,
+			Converter:  new(mocks.PassThroughConverter),
+			Publisher:  publisher,
+			CommitSize: 10,
+		}
+
+		err = service.SyncBatch(context.Background(), btc.ResyncBatch{Start: height, Stop: height})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring(btc.ErrSourcesDisagree.Error()))
+		Expect(publisher.PassedIPLDPayload).To(BeEmpty())
+	})
+})
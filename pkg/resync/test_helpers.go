@@ -0,0 +1,28 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package resync
+
+import (
+	"context"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+)
+
+// SyncBatch fetches, converts and publishes the blocks in the batch; used in tests
+func (rs *Service) SyncBatch(ctx context.Context, batch btc.ResyncBatch) error {
+	return rs.syncBatch(ctx, batch)
+}
@@ -17,6 +17,9 @@
 package shared
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/spf13/viper"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/node"
@@ -28,6 +31,10 @@ const (
 
 	BTC_WS_PATH       = "BTC_WS_PATH"
 	BTC_HTTP_PATH     = "BTC_HTTP_PATH"
+	BTC_HTTP_PATHS    = "BTC_HTTP_PATHS"
+	BTC_SOURCE        = "BTC_SOURCE"
+	BTC_ESPLORA_PATH  = "BTC_ESPLORA_PATH"
+	BTC_ESPLORA_PATHS = "BTC_ESPLORA_PATHS"
 	BTC_NODE_PASSWORD = "BTC_NODE_PASSWORD"
 	BTC_NODE_USER     = "BTC_NODE_USER"
 	BTC_NODE_ID       = "BTC_NODE_ID"
@@ -35,6 +42,8 @@ const (
 	BTC_GENESIS_BLOCK = "BTC_GENESIS_BLOCK"
 	BTC_NETWORK_ID    = "BTC_NETWORK_ID"
 	BTC_CHAIN_ID      = "BTC_CHAIN_ID"
+
+	BTC_CROSS_VALIDATE = "BTC_CROSS_VALIDATE"
 )
 
 // GetBtcNodeAndClient returns btc node info from path url
@@ -62,3 +71,57 @@ func GetBtcNodeAndClient(path string) (node.Node, *rpcclient.ConnConfig) {
 			User:         viper.GetString("bitcoin.user"),
 		}
 }
+
+// GetBtcSource returns the configured data source type and the esplora api urls (if any)
+func GetBtcSource() (SourceType, []string, error) {
+	viper.BindEnv("bitcoin.source", BTC_SOURCE)
+	viper.BindEnv("bitcoin.esploraPath", BTC_ESPLORA_PATH)
+	viper.BindEnv("bitcoin.esploraPaths", BTC_ESPLORA_PATHS)
+
+	sourceType, err := NewSourceType(viper.GetString("bitcoin.source"))
+	if err != nil {
+		return UnknownSource, nil, err
+	}
+	esploraPaths := mergePaths(viper.GetString("bitcoin.esploraPath"), viper.GetStringSlice("bitcoin.esploraPaths"))
+	if sourceType == Esplora && len(esploraPaths) == 0 {
+		return UnknownSource, nil, fmt.Errorf("bitcoin.esploraPath is required for the %s source", sourceType.String())
+	}
+	return sourceType, esploraPaths, nil
+}
+
+// GetBtcClientConfigs returns rpc client configs for the primary http path and any additional bitcoin.httpPaths
+func GetBtcClientConfigs(primary string, clientConfig *rpcclient.ConnConfig) []*rpcclient.ConnConfig {
+	viper.BindEnv("bitcoin.httpPaths", BTC_HTTP_PATHS)
+
+	paths := mergePaths(primary, viper.GetStringSlice("bitcoin.httpPaths"))
+	configs := make([]*rpcclient.ConnConfig, 0, len(paths))
+	for _, path := range paths {
+		c := *clientConfig
+		c.Host = path
+		configs = append(configs, &c)
+	}
+	return configs
+}
+
+// GetBtcCrossValidate returns whether blocks should be cross-validated against a second source
+func GetBtcCrossValidate() bool {
+	viper.BindEnv("bitcoin.crossValidate", BTC_CROSS_VALIDATE)
+	return viper.GetBool("bitcoin.crossValidate")
+}
+
+// mergePaths returns the non-empty, de-duplicated set of paths with the primary path first
+func mergePaths(primary string, others []string) []string {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, path := range append([]string{primary}, others...) {
+		for _, p := range strings.Split(path, ",") {
+			p = strings.TrimSpace(p)
+			if p == "" || seen[p] {
+				continue
+			}
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
@@ -17,24 +17,33 @@
 package shared
 
 import (
+	"database/sql"
+	"strings"
+	"time"
+
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-ipfs-blockstore"
 	"github.com/ipfs/go-ipfs-ds-help"
 	node "github.com/ipfs/go-ipld-format"
 	"github.com/jmoiron/sqlx"
+	"github.com/multiformats/go-multihash"
 	"github.com/sirupsen/logrus"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/ipfs/ipld"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/prom"
 )
 
 // Rollback sql transaction and log any error
+// a tx whose context was cancelled has already been rolled back, so that is not an error
 func Rollback(tx *sqlx.Tx) {
-	if err := tx.Rollback(); err != nil {
+	if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
 		logrus.Error(err)
 	}
 }
 
 // PublishIPLD is used to insert an ipld into Postgres blockstore with the provided tx
 func PublishIPLD(tx *sqlx.Tx, i node.Node) error {
+	defer prom.PublishDuration("public.blocks", time.Now())
 	dbKey := dshelp.MultihashToDsKey(i.Cid().Hash())
 	prefixedKey := blockstore.BlockPrefix.String() + dbKey.String()
 	raw := i.RawData()
@@ -76,6 +85,11 @@ func MultihashKeyFromCIDString(c string) (string, error) {
 	return blockstore.BlockPrefix.String() + dbKey.String(), nil
 }
 
+// MultihashFromKey converts a blockstore-prefixed multihash db key string back into the multihash
+func MultihashFromKey(key string) (multihash.Multihash, error) {
+	return dshelp.DsKeyToMultihash(datastore.NewKey(strings.TrimPrefix(key, blockstore.BlockPrefix.String())))
+}
+
 // PublishRaw derives a cid from raw bytes and provided codec and multihash type, and writes it to the db tx
 func PublishRaw(tx *sqlx.Tx, codec, mh uint64, raw []byte) (string, error) {
 	c, err := ipld.RawdataToCid(codec, raw, mh)
@@ -0,0 +1,85 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package shared
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+	log "github.com/sirupsen/logrus"
+)
+
+// ShutdownTimeout bounds how long an HTTPService's Stop waits for in-flight requests to finish
+const ShutdownTimeout = time.Second * 5
+
+// HTTPService serves an http.Handler as a node.Service
+type HTTPService struct {
+	name    string
+	addr    string
+	handler http.Handler
+	srv     *http.Server
+}
+
+// NewHTTPService creates an HTTPService serving the handler on the given address; the name is used in its logs
+func NewHTTPService(name, addr string, handler http.Handler) *HTTPService {
+	return &HTTPService{
+		name:    name,
+		addr:    addr,
+		handler: handler,
+	}
+}
+
+// Protocols exports the services p2p protocols, this service has none
+func (hs *HTTPService) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{}
+}
+
+// APIs returns the RPC descriptors the service offers over the node's own rpc, it has none
+func (hs *HTTPService) APIs() []rpc.API {
+	return []rpc.API{}
+}
+
+// Start binds the address, so that a port conflict fails the start, and then serves the handler in the background
+func (hs *HTTPService) Start(*p2p.Server) error {
+	listener, err := net.Listen("tcp", hs.addr)
+	if err != nil {
+		return err
+	}
+	hs.srv = &http.Server{Handler: hs.handler}
+	go func() {
+		log.Infof("serving %s at http://%s", hs.name, hs.addr)
+		if err := hs.srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("%s server error: %v", hs.name, err)
+		}
+	}()
+	return nil
+}
+
+// Stop shuts the server down
+func (hs *HTTPService) Stop() error {
+	if hs.srv == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	return hs.srv.Shutdown(ctx)
+}
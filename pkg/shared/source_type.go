@@ -0,0 +1,54 @@
+// VulcanizeDB
+// Copyright © 2020 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package shared
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SourceType enum for specifying the kind of backend chain data is sourced from
+type SourceType int
+
+const (
+	UnknownSource SourceType = iota
+	RPC
+	Esplora
+)
+
+func (s SourceType) String() string {
+	switch s {
+	case RPC:
+		return "rpc"
+	case Esplora:
+		return "esplora"
+	default:
+		return "unknown"
+	}
+}
+
+// NewSourceType returns the SourceType for the provided name; an empty name defaults to RPC
+func NewSourceType(name string) (SourceType, error) {
+	switch strings.ToLower(name) {
+	case "", "rpc", "node", "bitcoind", "btcd":
+		return RPC, nil
+	case "esplora", "electrs":
+		return Esplora, nil
+	default:
+		return UnknownSource, fmt.Errorf("unrecognized source type: %s", name)
+	}
+}
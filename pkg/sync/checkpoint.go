@@ -0,0 +1,109 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sync
+
+import (
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+)
+
+// trackedBlock is a streamed block that has been published
+type trackedBlock struct {
+	height int64
+	hash   string
+}
+
+// checkpointTracker advances the checkpoint over the contiguous prefix of the stream that has been published
+// Blocks are tracked in the order they were streamed; one that is still being published holds the checkpoint below it,
+// and one that failed or was abandoned holds it there until the stream is rewound, or for the rest of the run, so that
+// it is resynced on restart
+type checkpointTracker struct {
+	mu           sync.Mutex
+	checkpointer btc.Checkpointer
+	next         uint64 // sequence of the next block to be tracked
+	lowest       uint64 // sequence of the lowest block whose publish has not finished
+	finished     map[uint64]trackedBlock
+	held         bool // set once a block failed or was abandoned
+	heldAt       uint64
+}
+
+func newCheckpointTracker(checkpointer btc.Checkpointer) *checkpointTracker {
+	return &checkpointTracker{
+		checkpointer: checkpointer,
+		finished:     make(map[uint64]trackedBlock),
+	}
+}
+
+// track returns the sequence of the next streamed block
+func (ct *checkpointTracker) track() uint64 {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	seq := ct.next
+	ct.next++
+	return seq
+}
+
+// rewind starts tracking afresh from the next block streamed, which follows the saved checkpoint; the outcomes of the
+// blocks streamed before it are ignored
+func (ct *checkpointTracker) rewind() {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	ct.lowest = ct.next
+	ct.finished = make(map[uint64]trackedBlock)
+	ct.held = false
+}
+
+// finish records the outcome of publishing the block with the sequence, and saves the highest block of the published
+// prefix as the checkpoint if it moved
+func (ct *checkpointTracker) finish(seq uint64, height int64, hash string, published bool) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	if seq < ct.lowest {
+		return
+	}
+	if !published {
+		if !ct.held || seq < ct.heldAt {
+			ct.held = true
+			ct.heldAt = seq
+			log.Warnf("bitcoin sync checkpoint held below block %d until it is resynced", height)
+		}
+		return
+	}
+	if ct.held && seq > ct.heldAt {
+		return
+	}
+	ct.finished[seq] = trackedBlock{height: height, hash: hash}
+	var last *trackedBlock
+	for {
+		block, ok := ct.finished[ct.lowest]
+		if !ok {
+			break
+		}
+		delete(ct.finished, ct.lowest)
+		ct.lowest++
+		last = &block
+	}
+	if last == nil {
+		return
+	}
+	if err := ct.checkpointer.Save(last.height, last.hash); err != nil {
+		log.Errorf("bitcoin sync checkpoint error: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sync_test
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/sync"
+)
+
+var _ = Describe("Checkpoint", func() {
+	var (
+		publisher    *mocks.HeightPublisher
+		checkpointer *mocks.Checkpointer
+		failed       *mocks.FailedBlockRecorder
+		service      *sync.Service
+	)
+	BeforeEach(func() {
+		publisher = &mocks.HeightPublisher{Errs: map[int64][]error{}, Hold: map[int64]chan struct{}{}}
+		checkpointer = new(mocks.Checkpointer)
+		failed = new(mocks.FailedBlockRecorder)
+	})
+	start := func(drainTimeout time.Duration) {
+		streamer := &mocks.PayloadStreamer{ReturnSub: mocks.NewSubscription(), StreamPayloads: payloadsAt(1, 2, 3, 4, 5)}
+		service = sync.NewService(streamer, new(mocks.PassThroughConverter), publisher, checkpointer, failed, 3, false, drainTimeout)
+		service.MaxPublishAttempts = 1
+		Expect(service.Start(nil)).To(Succeed())
+	}
+
+	It("Advances over the blocks published without a gap", func() {
+		start(time.Second)
+		defer service.Stop()
+		Eventually(checkpointer.Height).Should(Equal(int64(5)))
+	})
+
+	It("Is held below a block that is still being published", func() {
+		hold := make(chan struct{})
+		publisher.Hold[2] = hold
+		start(time.Second)
+		defer service.Stop()
+		Eventually(publisher.Published).Should(ConsistOf(int64(1), int64(3), int64(4), int64(5)))
+		Consistently(checkpointer.Height, 100*time.Millisecond).Should(Equal(int64(1)))
+		close(hold)
+		Eventually(checkpointer.Height).Should(Equal(int64(5)))
+	})
+
+	It("Is held below a block that failed to publish", func() {
+		publisher.Errs[3] = []error{errors.New("mock publish error")}
+		start(time.Second)
+		defer service.Stop()
+		Eventually(failed.Heights).Should(Equal([]int64{3}))
+		Eventually(publisher.Published).Should(ConsistOf(int64(1), int64(2), int64(4), int64(5)))
+		Consistently(checkpointer.Height, 100*time.Millisecond).Should(Equal(int64(2)))
+	})
+
+	It("Is held below a block abandoned at the drain deadline", func() {
+		publisher.Hold[2] = make(chan struct{})
+		start(50 * time.Millisecond)
+		Eventually(publisher.Published).Should(ConsistOf(int64(1), int64(3), int64(4), int64(5)))
+		Expect(service.Stop()).To(Succeed())
+		Expect(service.Metrics.Snapshot().Abandoned).To(Equal(int64(1)))
+		Expect(checkpointer.Height()).To(Equal(int64(1)))
+	})
+})
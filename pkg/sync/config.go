@@ -17,9 +17,12 @@
 package sync
 
 import (
+	"time"
+
 	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/spf13/viper"
 
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/node"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
@@ -28,7 +31,9 @@ import (
 
 // Env variables
 const (
-	SUPERNODE_WORKERS = "SYNC_WORKERS"
+	SUPERNODE_WORKERS  = "SYNC_WORKERS"
+	SYNC_ORDERED       = "SYNC_ORDERED"
+	SYNC_DRAIN_TIMEOUT = "SYNC_DRAIN_TIMEOUT"
 
 	SYNC_MAX_IDLE_CONNECTIONS = "SYNC_MAX_IDLE_CONNECTIONS"
 	SYNC_MAX_OPEN_CONNECTIONS = "SYNC_MAX_OPEN_CONNECTIONS"
@@ -40,15 +45,32 @@ type Config struct {
 	DB           *postgres.DB
 	DBConfig     postgres.Config
 	Workers      int64
-	ClientConfig *rpcclient.ConnConfig
+	Ordered      bool
+	DrainTimeout time.Duration // time given to publish the blocks already streamed on shutdown
+	Source       btc.SourceConfig
 	NodeInfo     node.Node
 }
 
 // NewConfig is used to initialize a sync config from a .toml file
-func NewConfig() *Config {
+func NewConfig() (*Config, error) {
 	c := new(Config)
+	if err := c.Init(); err != nil {
+		return nil, err
+	}
+	c.DBConfig.Init()
+	overrideDBConnConfig(&c.DBConfig)
+	syncDB := utils.LoadPostgres(c.DBConfig, c.NodeInfo)
+	c.DB = &syncDB
+	return c, nil
+}
+
+// Init loads every setting but the database connection from the .toml file, so that the caller can provide the DB
+func (c *Config) Init() error {
+	var err error
 
 	viper.BindEnv("sync.workers", SUPERNODE_WORKERS)
+	viper.BindEnv("sync.ordered", SYNC_ORDERED)
+	viper.BindEnv("sync.drainTimeout", SYNC_DRAIN_TIMEOUT)
 	viper.BindEnv("bitcoin.wsPath", shared.BTC_WS_PATH)
 
 	workers := viper.GetInt64("sync.workers")
@@ -56,15 +78,19 @@ func NewConfig() *Config {
 		workers = 1
 	}
 	c.Workers = workers
+	c.Ordered = viper.GetBool("sync.ordered")
+	drainTimeout := viper.GetInt("sync.drainTimeout")
+	if drainTimeout < 1 {
+		drainTimeout = int(DrainTimeout.Seconds())
+	}
+	c.DrainTimeout = time.Second * time.Duration(drainTimeout)
 
 	btcWS := viper.GetString("bitcoin.wsPath")
-	c.NodeInfo, c.ClientConfig = shared.GetBtcNodeAndClient(btcWS)
-
-	c.DBConfig.Init()
-	overrideDBConnConfig(&c.DBConfig)
-	syncDB := utils.LoadPostgres(c.DBConfig, c.NodeInfo)
-	c.DB = &syncDB
-	return c
+	var clientConfig *rpcclient.ConnConfig
+	c.NodeInfo, clientConfig = shared.GetBtcNodeAndClient(btcWS)
+	c.Source.RPCConfigs = []*rpcclient.ConnConfig{clientConfig}
+	c.Source.Type, c.Source.EsploraPaths, err = shared.GetBtcSource()
+	return err
 }
 
 func overrideDBConnConfig(con *postgres.Config) {
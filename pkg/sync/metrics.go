@@ -0,0 +1,87 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sync
+
+import (
+	"sync/atomic"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/prom"
+)
+
+// Metrics holds counters describing the flow of blocks through the sync pipeline
+type Metrics struct {
+	streamed  int64
+	published int64
+	retried   int64
+	failed    int64
+	dropped   int64
+	abandoned int64
+	skipped   int64
+	queued    func() int
+}
+
+// MetricsSnapshot is a point-in-time copy of the sync Metrics
+type MetricsSnapshot struct {
+	Streamed   int64 // blocks received from the streamer
+	Published  int64 // blocks published successfully
+	Retried    int64 // publish attempts that failed and were retried
+	Failed     int64 // blocks that could not be published, even after retrying
+	Dropped    int64 // blocks discarded before publishing because they could not be converted
+	Abandoned  int64 // blocks left unpublished when the drain deadline passed on shutdown
+	Skipped    int64 // blocks streamed after one that failed in ordered mode, discarded to be restreamed from the checkpoint
+	QueueDepth int   // blocks waiting for a publish worker
+}
+
+// Snapshot returns the current values of the metrics
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	s := MetricsSnapshot{
+		Streamed:  atomic.LoadInt64(&m.streamed),
+		Published: atomic.LoadInt64(&m.published),
+		Retried:   atomic.LoadInt64(&m.retried),
+		Failed:    atomic.LoadInt64(&m.failed),
+		Dropped:   atomic.LoadInt64(&m.dropped),
+		Abandoned: atomic.LoadInt64(&m.abandoned),
+		Skipped:   atomic.LoadInt64(&m.skipped),
+	}
+	s.QueueDepth = m.queueDepth()
+	return s
+}
+
+// queueDepth returns the number of blocks waiting for a publish worker
+func (m *Metrics) queueDepth() int {
+	if m.queued == nil {
+		return 0
+	}
+	return m.queued()
+}
+
+// the streamed and published counts are mirrored in the prometheus metrics
+func (m *Metrics) incStreamed() {
+	atomic.AddInt64(&m.streamed, 1)
+	prom.BlockStreamed()
+}
+
+func (m *Metrics) incPublished() {
+	atomic.AddInt64(&m.published, 1)
+	prom.BlocksPublished(1)
+}
+
+func (m *Metrics) incRetried()   { atomic.AddInt64(&m.retried, 1) }
+func (m *Metrics) incFailed()    { atomic.AddInt64(&m.failed, 1) }
+func (m *Metrics) incDropped()   { atomic.AddInt64(&m.dropped, 1) }
+func (m *Metrics) incAbandoned() { atomic.AddInt64(&m.abandoned, 1) }
+func (m *Metrics) incSkipped()   { atomic.AddInt64(&m.skipped, 1) }
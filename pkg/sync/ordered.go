@@ -0,0 +1,139 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sync
+
+import (
+	"context"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/prom"
+)
+
+// sequencedPayload tracks a streamed payload, and the result of converting or preparing it, by its position in the stream
+type sequencedPayload struct {
+	seq uint64
+	// the stream the payload came from, and whether it may be committed without its parent indexed (ordered mode only)
+	stream         uint64
+	parentOptional bool
+	payload        btc.BlockPayload
+	converted      *btc.ConvertedPayload
+	prepared       *btc.PreparedPayload
+	err            error
+}
+
+// startOrderedPublishing spins up the prepare workers and the committer and returns the functions used to forward payloads
+// to them and to close their intake; payloads are converted and their IPLDs generated in parallel by the workers, but the
+// committer writes them to Postgres one at a time in the order they were streamed, which is height order apart from the
+// rewinds that follow a reorg
+// the committer exits once the intake is closed and everything forwarded is committed or abandoned
+// once a block fails, the blocks streamed after it can't be committed without it, so they are skipped and the stream is
+// restarted from the checkpoint (see restartStream)
+func (sap *Service) startOrderedPublishing(wg *sync.WaitGroup) (func(payload btc.BlockPayload), func()) {
+	prepareChan := make(chan sequencedPayload, PayloadChanBufferSize)
+	commitChan := make(chan sequencedPayload, sap.Workers)
+	sap.Metrics.queued = func() int { return len(prepareChan) + len(commitChan) }
+	preparers := new(sync.WaitGroup)
+	for i := 1; i <= int(sap.Workers); i++ {
+		preparers.Add(1)
+		go sap.prepare(preparers, i, prepareChan, commitChan)
+		log.Debugf("bitcoin sync worker %d successfully spun up", i)
+	}
+	go func() {
+		preparers.Wait()
+		close(commitChan)
+	}()
+	wg.Add(1)
+	go sap.commit(wg, commitChan)
+	forward := func(payload btc.BlockPayload) {
+		sp := sequencedPayload{seq: sap.checkpoints.track(), stream: sap.stream, parentOptional: sap.parentOptional, payload: payload}
+		sap.parentOptional = false
+		prepareChan <- sp
+	}
+	return forward, func() { close(prepareChan) }
+}
+
+// prepare converts payloads and generates their IPLDs, passing the results on to the committer
+// once the drain deadline has passed payloads are passed on unprepared, for the committer to abandon
+func (sap *Service) prepare(wg *sync.WaitGroup, id int, prepareChan <-chan sequencedPayload, commitChan chan<- sequencedPayload) {
+	defer wg.Done()
+	for sp := range prepareChan {
+		if sap.pubCtx.Err() == nil {
+			log.Debugf("bitcoin sync worker %d preparing data streamed at head height %d", id, sp.payload.BlockHeight)
+			converted, err := sap.Converter.Convert(sp.payload)
+			if err == nil {
+				prom.BlocksConverted(1)
+				sp.prepared, err = sap.orderedPublisher.Prepare(*converted)
+			}
+			sp.err = err
+		}
+		commitChan <- sp
+	}
+	log.Infof("bitcoin sync worker %d shutting down", id)
+}
+
+// commit receives prepared payloads from the workers, in any order, and commits them in sequence
+func (sap *Service) commit(wg *sync.WaitGroup, commitChan <-chan sequencedPayload) {
+	defer wg.Done()
+	pending := make(map[uint64]sequencedPayload)
+	var next uint64
+	// the blocks of streams up to failedStream that follow the failed block are skipped
+	failed, failedStream := false, uint64(0)
+	for sp := range commitChan {
+		pending[sp.seq] = sp
+		for {
+			sp, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if failed && sp.stream <= failedStream {
+				sap.Metrics.incSkipped()
+				sap.finish(sp.seq, sp.payload, false)
+				continue
+			}
+			published := false
+			if sp.err != nil {
+				log.Errorf("bitcoin data conversion error: %v", sp.err)
+				sap.Metrics.incDropped()
+				sap.recordFailure(sp.payload.BlockHeight, sp.payload.Header.BlockHash().String(), 1, sp.err)
+			} else {
+				log.Debugf("bitcoin sync committer publishing and indexing data streamed at head height %d", sp.payload.BlockHeight)
+				if sp.prepared != nil {
+					// only the first block streamed without a checkpoint may be committed without its parent indexed
+					sp.prepared.ParentOptional = sp.parentOptional
+				}
+				published = sap.publishWithRetry("committer", sp.payload, func(ctx context.Context) error {
+					return sap.orderedPublisher.Commit(ctx, sp.prepared)
+				})
+			}
+			sap.finish(sp.seq, sp.payload, published)
+			if !published && sap.pubCtx.Err() == nil {
+				log.Warnf("bitcoin sync committer skipping the blocks streamed after block %d, restarting the stream from the checkpoint", sp.payload.BlockHeight)
+				failed, failedStream = true, sp.stream
+				select {
+				case sap.rewind <- sp.stream:
+				default:
+				}
+			}
+		}
+	}
+	log.Info("bitcoin sync committer shutting down")
+}
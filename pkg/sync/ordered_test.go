@@ -0,0 +1,118 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sync_test
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/sync"
+)
+
+var _ = Describe("Ordered", func() {
+	var (
+		streamer     *mocks.PayloadStreamer
+		publisher    *mocks.HeightPublisher
+		checkpointer *mocks.Checkpointer
+		failed       *mocks.FailedBlockRecorder
+		service      *sync.Service
+	)
+	BeforeEach(func() {
+		streamer = &mocks.PayloadStreamer{ReturnSub: mocks.NewSubscription(), StreamPayloads: payloadsAt(1, 2, 3, 4, 5)}
+		publisher = &mocks.HeightPublisher{Errs: map[int64][]error{}, PrepareDelay: map[int64]time.Duration{}}
+		checkpointer = new(mocks.Checkpointer)
+		failed = new(mocks.FailedBlockRecorder)
+	})
+	start := func() {
+		service = sync.NewService(streamer, new(mocks.PassThroughConverter), publisher, checkpointer, failed, 3, true, time.Second)
+		service.PublishBackoff = time.Millisecond
+		Expect(service.Start(nil)).To(Succeed())
+	}
+	AfterEach(func() {
+		Expect(service.Stop()).To(Succeed())
+	})
+
+	It("Commits blocks in the order they were streamed, however their preparation finishes", func() {
+		publisher.PrepareDelay[1] = 60 * time.Millisecond
+		publisher.PrepareDelay[2] = 40 * time.Millisecond
+		publisher.PrepareDelay[3] = 20 * time.Millisecond
+		start()
+		Eventually(publisher.Published).Should(Equal([]int64{1, 2, 3, 4, 5}))
+		Eventually(checkpointer.Height).Should(Equal(int64(5)))
+	})
+
+	It("Commits the blocks resent after a reorg in the order they were streamed", func() {
+		streamer.StreamPayloads = append(payloadsAt(1, 2, 3), payloadsAt(2, 3, 4)...)
+		publisher.PrepareDelay[1] = 40 * time.Millisecond
+		publisher.PrepareDelay[3] = 20 * time.Millisecond
+		start()
+		Eventually(publisher.Published).Should(Equal([]int64{1, 2, 3, 2, 3, 4}))
+		Eventually(checkpointer.Height).Should(Equal(int64(4)))
+		Expect(checkpointer.Checkpoint.BlockHash).To(Equal(streamer.StreamPayloads[5].Header.BlockHash().String()))
+	})
+
+	It("Records a block whose parent does not match without retrying it, and restreams the rest from the checkpoint", func() {
+		publisher.Errs[3] = []error{fmt.Errorf("mock: %w", btc.ErrParentMismatch)}
+		streamer.ResumePayloads = streamer.StreamPayloads[2:]
+		start()
+		Eventually(publisher.Published).Should(Equal([]int64{1, 2, 3, 4, 5}))
+		Expect(failed.Heights()).To(Equal([]int64{3}))
+		Expect(failed.Failed[0].Attempts).To(Equal(1))
+		Expect(publisher.Attempts(3)).To(Equal(2))
+		Eventually(checkpointer.Height).Should(Equal(int64(5)))
+	})
+
+	It("Skips the blocks streamed after one whose commit failed, and restreams them from the checkpoint", func() {
+		for i := 0; i < sync.MaxPublishAttempts; i++ {
+			publisher.Errs[3] = append(publisher.Errs[3], errors.New("mock commit error"))
+		}
+		streamer.ResumePayloads = streamer.StreamPayloads[2:]
+		start()
+		Eventually(failed.Heights).Should(Equal([]int64{3}))
+		Eventually(publisher.Published).Should(Equal([]int64{1, 2, 3, 4, 5}))
+		Eventually(checkpointer.Height).Should(Equal(int64(5)))
+		Expect(failed.Heights()).To(Equal([]int64{3}))
+		Expect(publisher.Attempts(4)).To(Equal(1))
+		Expect(publisher.Attempts(5)).To(Equal(1))
+		Expect(streamer.Restarts()).To(HaveLen(1))
+		Expect(streamer.Restarts()[0].BlockNumber).To(Equal(int64(2)))
+		Expect(service.Metrics.Snapshot().Skipped).To(Equal(int64(2)))
+		Expect(publisher.ParentOptional()).To(Equal([]int64{1}))
+	})
+
+	It("Only lets the first block streamed be committed without its parent", func() {
+		start()
+		Eventually(publisher.Published).Should(Equal([]int64{1, 2, 3, 4, 5}))
+		Expect(publisher.ParentOptional()).To(Equal([]int64{1}))
+	})
+
+	It("Records a block whose parent is missing without retrying it", func() {
+		publisher.Errs[2] = []error{fmt.Errorf("mock: %w", btc.ErrMissingParent)}
+		start()
+		Eventually(failed.Heights).Should(Equal([]int64{2}))
+		Expect(failed.Failed[0].Attempts).To(Equal(1))
+		Expect(publisher.Attempts(2)).To(Equal(1))
+		Expect(publisher.Attempts(3)).To(Equal(0))
+		Consistently(checkpointer.Height, 100*time.Millisecond).Should(Equal(int64(1)))
+	})
+})
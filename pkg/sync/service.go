@@ -17,7 +17,11 @@
 package sync
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
@@ -28,11 +32,17 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/prom"
 	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+	"github.com/vulcanize/ipld-btc-indexer/utils"
 )
 
 const (
 	PayloadChanBufferSize = 2000
+	MaxPublishAttempts    = 5
+	PublishBackoff        = time.Second
+	MetricsLogInterval    = time.Minute
+	DrainTimeout          = time.Second * 30
 )
 
 // Indexer is the top level interface for streaming, converting to IPLDs, publishing, and indexing all chain data at head
@@ -48,8 +58,6 @@ type Indexer interface {
 
 // Service is the underlying struct for the watcher
 type Service struct {
-	// Used to sync access to the Subscriptions
-	sync.Mutex
 	// Interface for streaming payloads over an rpc subscription
 	Streamer btc.Streamer
 	// Interface for converting raw payloads into IPLD object payloads
@@ -58,34 +66,90 @@ type Service struct {
 	Publisher btc.Publisher
 	// Interface for searching and retrieving CIDs from Postgres index
 	Retriever btc.Retriever
+	// Interface for loading and saving the last published block
+	Checkpointer btc.Checkpointer
+	// Advances the checkpoint over the blocks published without a gap
+	checkpoints *checkpointTracker
+	// Interface for recording blocks that could not be published
+	FailedBlocks btc.FailedBlockRecorder
+	// Counters describing the flow of blocks through the pipeline
+	Metrics *Metrics
 	// Chan the processor uses to subscribe to payloads from the Streamer
 	PayloadChan chan btc.BlockPayload
-	// Used to signal shutdown of the service
-	QuitChan chan bool
 	// Number of worker goroutines
 	Workers int64
+	// Commit blocks to Postgres strictly in the order they were streamed, verifying each against its parent
+	Ordered bool
+	// Publisher used when Ordered is set
+	orderedPublisher btc.OrderedPublisher
+	// The stream the payloads come from, counted up on each restart, and whether the next payload streamed may be
+	// committed without its parent indexed; both are owned by the goroutine receiving from the stream
+	stream         uint64
+	parentOptional bool
+	// Restart requests, from the ordered committer, for the stream in which a block failed
+	rewind chan uint64
+	// Number of times a block is published before it is recorded as failed
+	MaxPublishAttempts int
+	// Wait before the first publish retry; doubled on each subsequent retry
+	PublishBackoff time.Duration
 	// chain type for this service
 	ChainConfig *chaincfg.Params
 	// Underlying db
 	db *postgres.DB
+	// Context cancelled to stop streaming
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Context blocks are published with; once streaming stops, the blocks already streamed are drained until it is
+	// cancelled, DrainTimeout later
+	pubCtx    context.Context
+	pubCancel context.CancelFunc
 	// wg for syncing serve processes
 	serveWg *sync.WaitGroup
 }
 
 // NewIndexerService creates a new Indexer using an underlying Service struct
 func NewIndexerService(settings *Config) (Indexer, error) {
-	sn := new(Service)
-	sn.Streamer = btc.NewHTTPPayloadStreamer(settings.ClientConfig)
-	sn.ChainConfig = &chaincfg.Params{} /// TODO make this configurable
-	sn.Converter = btc.NewPayloadConverter(sn.ChainConfig)
-	sn.Publisher = btc.NewIPLDPublisher(settings.DB)
+	streamer, err := btc.NewStreamer(settings.Source)
+	if err != nil {
+		return nil, err
+	}
+	chainConfig := &chaincfg.Params{} /// TODO make this configurable
+	sn := NewService(streamer, btc.NewPayloadConverter(chainConfig), btc.NewIPLDPublisher(settings.DB),
+		btc.NewDBCheckpointer(settings.DB), btc.NewDBFailedBlockRecorder(settings.DB), settings.Workers, settings.Ordered, settings.DrainTimeout)
+	sn.ChainConfig = chainConfig
 	sn.Retriever = btc.NewGapRetriever(settings.DB)
-
-	sn.QuitChan = make(chan bool)
-	sn.Workers = settings.Workers
 	return sn, nil
 }
 
+// NewService returns a Service built from the provided pipeline stages
+// If the publisher is an OrderedPublisher it can be run in ordered mode; a drainTimeout of zero means DrainTimeout
+func NewService(streamer btc.Streamer, converter btc.Converter, publisher btc.Publisher, checkpointer btc.Checkpointer,
+	failedBlocks btc.FailedBlockRecorder, workers int64, ordered bool, drainTimeout time.Duration) *Service {
+	sn := &Service{
+		Streamer:           streamer,
+		Converter:          converter,
+		Publisher:          publisher,
+		Checkpointer:       checkpointer,
+		FailedBlocks:       failedBlocks,
+		Metrics:            new(Metrics),
+		PayloadChan:        make(chan btc.BlockPayload, PayloadChanBufferSize),
+		Workers:            workers,
+		Ordered:            ordered,
+		MaxPublishAttempts: MaxPublishAttempts,
+		PublishBackoff:     PublishBackoff,
+		rewind:             make(chan uint64, 1),
+	}
+	if orderedPublisher, ok := publisher.(btc.OrderedPublisher); ok {
+		sn.orderedPublisher = orderedPublisher
+	}
+	if drainTimeout <= 0 {
+		drainTimeout = DrainTimeout
+	}
+	sn.ctx, sn.cancel = context.WithCancel(context.Background())
+	sn.pubCtx, sn.pubCancel = utils.DrainContext(sn.ctx, drainTimeout)
+	return sn
+}
+
 // Protocols exports the services p2p protocols, this service has none
 func (sap *Service) Protocols() []p2p.Protocol {
 	return []p2p.Protocol{}
@@ -99,41 +163,57 @@ func (sap *Service) APIs() []rpc.API {
 // Sync streams incoming raw chain data and converts it for further processing
 // It forwards the converted data to the publish process(es) it spins up
 // This continues on no matter if or how many subscribers there are
+// If a checkpoint from a previous run exists, every block between it and the head is streamed first
+// Once the service is stopped, the blocks already streamed are drained to the publish process(es) before wg is done
 func (sap *Service) Sync(wg *sync.WaitGroup) error {
-	sub, err := sap.Streamer.Stream(sap.PayloadChan)
+	checkpoint, err := sap.Checkpointer.Load()
 	if err != nil {
 		return err
 	}
-	// spin up publish worker goroutines
-	publishPayload := make(chan btc.ConvertedPayload, PayloadChanBufferSize)
-	for i := 1; i <= int(sap.Workers); i++ {
-		go sap.publish(wg, i, publishPayload)
-		log.Debugf("bitcoin sync worker %d successfully spun up", i)
+	if sap.Ordered && sap.orderedPublisher == nil {
+		return errors.New("bitcoin sync publisher does not support ordered publishing")
+	}
+	sub, err := sap.Streamer.Stream(sap.ctx, sap.PayloadChan, checkpoint)
+	if err != nil {
+		return err
 	}
+	sap.checkpoints = newCheckpointTracker(sap.Checkpointer)
+	// the first block streamed may be committed without its parent indexed, since without a checkpoint nothing is
+	sap.parentOptional = true
+	workers := new(sync.WaitGroup)
+	var forward func(payload btc.BlockPayload)
+	var closeIntake func()
+	if sap.Ordered {
+		forward, closeIntake = sap.startOrderedPublishing(workers)
+	} else {
+		forward, closeIntake = sap.startPublishing(workers)
+	}
+	prom.SetQueueDepthFunc(sap.Metrics.queueDepth)
+	wg.Add(1)
 	go func() {
-		wg.Add(1)
 		defer wg.Done()
+		ticker := time.NewTicker(MetricsLogInterval)
+		defer ticker.Stop()
 		for {
 			select {
 			case payload := <-sap.PayloadChan:
-				ipldPayload, err := sap.Converter.Convert(payload)
-				if err != nil {
-					log.Errorf("bitcoin data conversion error: %v", err)
+				sap.receive(payload, forward)
+			case err := <-sub.Err():
+				log.Errorf("bitcoin subscription error for chain: %v", err)
+			case stream := <-sap.rewind:
+				if stream != sap.stream {
 					continue
 				}
-				log.Infof("bitcoin data streamed at head height %d", ipldPayload.Height())
-				// Forward the payload to the publish workers
-				// this channel acts as a ring buffer
-				select {
-				case publishPayload <- *ipldPayload:
-				default:
-					<-publishPayload
-					publishPayload <- *ipldPayload
+				if sub = sap.restartStream(sub); sub == nil {
+					sap.drain(nil, forward, closeIntake, workers)
+					return
 				}
-			case err := <-sub.Err():
-				log.Errorf("bitcoin subscription error for chain: %v", err)
-			case <-sap.QuitChan:
-				log.Info("quiting bitcoin sync process")
+			case <-ticker.C:
+				m := sap.Metrics.Snapshot()
+				log.Infof("bitcoin sync metrics: streamed %d, published %d, retried %d, failed %d, dropped %d, abandoned %d, skipped %d, queue depth %d",
+					m.Streamed, m.Published, m.Retried, m.Failed, m.Dropped, m.Abandoned, m.Skipped, m.QueueDepth)
+			case <-sap.ctx.Done():
+				sap.drain(sub, forward, closeIntake, workers)
 				return
 			}
 		}
@@ -142,41 +222,195 @@ func (sap *Service) Sync(wg *sync.WaitGroup) error {
 	return nil
 }
 
+// restartStream unsubscribes from the stream, discarding the payloads it buffered, and subscribes again from the
+// checkpoint, retrying every PublishBackoff until it succeeds; it returns nil if the service is stopped first
+func (sap *Service) restartStream(sub btc.Subscription) btc.Subscription {
+	sub.Unsubscribe()
+	sap.stream++
+	sap.PayloadChan = make(chan btc.BlockPayload, PayloadChanBufferSize)
+	for {
+		select {
+		case <-time.After(sap.PublishBackoff):
+		case <-sap.ctx.Done():
+			return nil
+		}
+		checkpoint, err := sap.Checkpointer.Load()
+		if err != nil {
+			log.Errorf("bitcoin sync checkpoint error: %v", err)
+			continue
+		}
+		sap.checkpoints.rewind()
+		sap.parentOptional = checkpoint == nil
+		sub, err := sap.Streamer.Stream(sap.ctx, sap.PayloadChan, checkpoint)
+		if err != nil {
+			log.Errorf("bitcoin sync stream restart error: %v", err)
+			continue
+		}
+		if checkpoint != nil {
+			log.Infof("bitcoin sync stream restarted after block %d (%s)", checkpoint.BlockNumber, checkpoint.BlockHash)
+		}
+		return sub
+	}
+}
+
+// receive forwards a streamed payload to the publish process(es)
+// when the publishers fall behind this blocks, which in turn throttles the streamer
+func (sap *Service) receive(payload btc.BlockPayload, forward func(payload btc.BlockPayload)) {
+	sap.Metrics.incStreamed()
+	log.Infof("bitcoin data streamed at head height %d", payload.BlockHeight)
+	forward(payload)
+}
+
+// drain stops the streamer, forwards the payloads still buffered in the PayloadChan, and waits for the publish
+// process(es) to publish everything forwarded to them, or to abandon what is left once the drain deadline passes
+func (sap *Service) drain(sub btc.Subscription, forward func(payload btc.BlockPayload), closeIntake func(), workers *sync.WaitGroup) {
+	log.Info("bitcoin sync process stopped streaming, draining the blocks already streamed")
+	if sub != nil {
+		sub.Unsubscribe()
+	}
+buffered:
+	for {
+		select {
+		case payload := <-sap.PayloadChan:
+			sap.receive(payload, forward)
+		default:
+			break buffered
+		}
+	}
+	closeIntake()
+	workers.Wait()
+	sap.pubCancel()
+	m := sap.Metrics.Snapshot()
+	if m.Abandoned > 0 {
+		log.Warnf("bitcoin sync abandoned %d blocks at the drain deadline; the checkpoint is held below them, so they are resynced on restart", m.Abandoned)
+	}
+	checkpoint, err := sap.Checkpointer.Load()
+	if err != nil {
+		log.Errorf("bitcoin sync checkpoint error: %v", err)
+	} else if checkpoint != nil {
+		log.Infof("bitcoin sync process shut down with the last committed block at height %d (%s)", checkpoint.BlockNumber, checkpoint.BlockHash)
+	}
+}
+
+// startPublishing spins up the publish workers and returns the functions used to forward payloads to them and to
+// close their intake; payloads are converted as they are forwarded, and published in whatever order the workers get to them
+// the workers exit once their intake is closed and everything forwarded to them is published or abandoned
+func (sap *Service) startPublishing(workers *sync.WaitGroup) (func(payload btc.BlockPayload), func()) {
+	publishPayload := make(chan sequencedPayload, PayloadChanBufferSize)
+	sap.Metrics.queued = func() int { return len(publishPayload) }
+	for i := 1; i <= int(sap.Workers); i++ {
+		workers.Add(1)
+		go sap.publish(workers, i, publishPayload)
+		log.Debugf("bitcoin sync worker %d successfully spun up", i)
+	}
+	forward := func(payload btc.BlockPayload) {
+		seq := sap.checkpoints.track()
+		if sap.pubCtx.Err() != nil {
+			sap.Metrics.incAbandoned()
+			sap.finish(seq, payload, false)
+			return
+		}
+		ipldPayload, err := sap.Converter.Convert(payload)
+		if err != nil {
+			log.Errorf("bitcoin data conversion error: %v", err)
+			sap.Metrics.incDropped()
+			sap.recordFailure(payload.BlockHeight, payload.Header.BlockHash().String(), 1, err)
+			sap.finish(seq, payload, false)
+			return
+		}
+		prom.BlocksConverted(1)
+		publishPayload <- sequencedPayload{seq: seq, payload: payload, converted: ipldPayload}
+	}
+	return forward, func() { close(publishPayload) }
+}
+
 // publish is spun up by SyncAndConvert and receives converted chain data from that process
 // it publishes this data to IPFS and indexes their CIDs with useful metadata in Postgres
-func (sap *Service) publish(wg *sync.WaitGroup, id int, publishPayload <-chan btc.ConvertedPayload) {
-	wg.Add(1)
+func (sap *Service) publish(wg *sync.WaitGroup, id int, publishPayload <-chan sequencedPayload) {
 	defer wg.Done()
+	for sp := range publishPayload {
+		sp := sp
+		log.Debugf("bitcoin sync worker %d publishing and indexing data streamed at head height %d", id, sp.payload.BlockHeight)
+		published := sap.publishWithRetry(fmt.Sprintf("worker %d", id), sp.payload, func(ctx context.Context) error {
+			return sap.Publisher.Publish(ctx, *sp.converted)
+		})
+		sap.finish(sp.seq, sp.payload, published)
+	}
+	log.Infof("bitcoin sync worker %d shutting down", id)
+}
+
+// publishWithRetry calls publish for the payload, retrying with exponential backoff on error, and reports whether it was published
+// a payload that still can't be published after MaxPublishAttempts, or whose parent doesn't match or isn't indexed, is
+// recorded in btc.failed_blocks
+// once the drain deadline has passed the payload is abandoned, and an interrupted publish is rolled back
+func (sap *Service) publishWithRetry(name string, payload btc.BlockPayload, publish func(ctx context.Context) error) bool {
+	backoff := sap.PublishBackoff
+	hash := payload.Header.BlockHash().String()
+	attempts := 0
 	for {
+		if sap.pubCtx.Err() != nil {
+			sap.Metrics.incAbandoned()
+			return false
+		}
+		attempts++
+		err := publish(sap.pubCtx)
+		if err == nil {
+			sap.Metrics.incPublished()
+			prom.SetSyncedHead(payload.BlockHeight)
+			return true
+		}
+		if sap.pubCtx.Err() != nil {
+			log.Warnf("bitcoin sync %s publish of block %d rolled back at the drain deadline", name, payload.BlockHeight)
+			sap.Metrics.incAbandoned()
+			return false
+		}
+		if attempts >= sap.MaxPublishAttempts || errors.Is(err, btc.ErrParentMismatch) || errors.Is(err, btc.ErrMissingParent) {
+			log.Errorf("bitcoin sync %s failed to publish block %d after %d attempts: %v", name, payload.BlockHeight, attempts, err)
+			sap.Metrics.incFailed()
+			sap.recordFailure(payload.BlockHeight, hash, attempts, err)
+			return false
+		}
+		sap.Metrics.incRetried()
+		log.Warnf("bitcoin sync %s publishing error at height %d, retrying in %s: %v", name, payload.BlockHeight, backoff.String(), err)
 		select {
-		case payload := <-publishPayload:
-			log.Debugf("bitcoin sync worker %d publishing and indexing data streamed at head height %d", id, payload.Height())
-			if err := sap.Publisher.Publish(payload); err != nil {
-				log.Errorf("bitcoin sync worker %d publishing error: %v", id, err)
-				continue
-			}
-		case <-sap.QuitChan:
-			log.Infof("bitcoin sync worker %d shutting down", id)
-			return
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-sap.pubCtx.Done():
+			// shut down before the block ran out of attempts: it is left for the next run, not recorded as failed
+			log.Warnf("bitcoin sync %s abandoned block %d at the drain deadline, waiting to retry it: %v", name, payload.BlockHeight, err)
+			sap.Metrics.incAbandoned()
+			return false
 		}
 	}
 }
 
-// Start is used to begin the service
-// This is mostly just to satisfy the node.Service interface
+// finish hands the outcome of publishing the payload to the checkpoint tracker
+func (sap *Service) finish(seq uint64, payload btc.BlockPayload, published bool) {
+	sap.checkpoints.finish(seq, payload.BlockHeight, payload.Header.BlockHash().String(), published)
+}
+
+// recordFailure records a block that could not be published in btc.failed_blocks
+func (sap *Service) recordFailure(height int64, hash string, attempts int, cause error) {
+	if err := sap.FailedBlocks.RecordFailure(height, hash, attempts, cause); err != nil {
+		log.Errorf("bitcoin sync unable to record failed block %d: %v", height, err)
+	}
+}
+
+// Start is used to begin the service when it is registered with a node.Node
 func (sap *Service) Start(*p2p.Server) error {
 	log.Info("starting bitcoin sync service")
-	wg := new(sync.WaitGroup)
-	return sap.Sync(wg)
+	sap.serveWg = new(sync.WaitGroup)
+	return sap.Sync(sap.serveWg)
 }
 
 // Stop is used to close down the service
-// This is mostly just to satisfy the node.Service interface
+// If the service was begun with Start, it waits for the blocks already streamed to be drained
 func (sap *Service) Stop() error {
 	log.Info("stopping bitcoin sync service")
-	sap.Lock()
-	close(sap.QuitChan)
-	sap.Unlock()
+	sap.cancel()
+	if sap.serveWg != nil {
+		sap.serveWg.Wait()
+	}
 	return nil
 }
 
@@ -0,0 +1,204 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sync_test
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc/mocks"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/sync"
+)
+
+var _ = Describe("Service", func() {
+	var (
+		streamer     *mocks.PayloadStreamer
+		converter    *mocks.PassThroughConverter
+		publisher    *mocks.HeightPublisher
+		checkpointer *mocks.Checkpointer
+		failed       *mocks.FailedBlockRecorder
+		service      *sync.Service
+	)
+	BeforeEach(func() {
+		streamer = &mocks.PayloadStreamer{ReturnSub: mocks.NewSubscription(), StreamPayloads: payloadsAt(1, 2, 3, 4, 5)}
+		converter = &mocks.PassThroughConverter{Errs: map[int64]error{}}
+		publisher = &mocks.HeightPublisher{Errs: map[int64][]error{}, Hold: map[int64]chan struct{}{}}
+		checkpointer = new(mocks.Checkpointer)
+		failed = new(mocks.FailedBlockRecorder)
+	})
+	newService := func(workers int64, ordered bool, drainTimeout time.Duration) {
+		service = sync.NewService(streamer, converter, publisher, checkpointer, failed, workers, ordered, drainTimeout)
+		service.PublishBackoff = time.Millisecond
+	}
+	heights := func(from, to int64) []int64 {
+		hs := make([]int64, 0, to-from+1)
+		for h := from; h <= to; h++ {
+			hs = append(hs, h)
+		}
+		return hs
+	}
+
+	It("Streams from the stored checkpoint", func() {
+		checkpointer.Checkpoint = &btc.Checkpoint{BlockNumber: 0, BlockHash: "mock hash"}
+		newService(1, false, time.Second)
+		Expect(service.Start(nil)).To(Succeed())
+		defer service.Stop()
+		Expect(streamer.PassedCheckpoint).To(Equal(checkpointer.Checkpoint))
+		Expect(streamer.PassedPayloadChan).To(Equal(service.PayloadChan))
+	})
+
+	It("Throttles the streamer once the publishers fall behind", func() {
+		total := 2*sync.PayloadChanBufferSize + 10
+		streamer.StreamPayloads = payloadsAt(heights(1, int64(total))...)
+		hold := make(chan struct{})
+		publisher.Hold[1] = hold
+		newService(1, false, time.Second)
+		Expect(service.Start(nil)).To(Succeed())
+		defer service.Stop()
+		// one block with the worker, a full publish queue, and one waiting to be queued
+		Eventually(func() int64 { return service.Metrics.Snapshot().Streamed }).Should(Equal(int64(sync.PayloadChanBufferSize + 2)))
+		Eventually(func() int { return len(service.PayloadChan) }).Should(Equal(sync.PayloadChanBufferSize))
+		Consistently(func() int64 { return service.Metrics.Snapshot().Streamed }, 100*time.Millisecond).Should(Equal(int64(sync.PayloadChanBufferSize + 2)))
+		Expect(service.Metrics.Snapshot().QueueDepth).To(Equal(sync.PayloadChanBufferSize))
+		close(hold)
+		Eventually(func() int64 { return service.Metrics.Snapshot().Published }).Should(Equal(int64(total)))
+		Expect(service.Metrics.Snapshot().QueueDepth).To(Equal(0))
+	})
+
+	It("Retries a failed publish with exponential backoff", func() {
+		publisher.Errs[1] = []error{errors.New("one"), errors.New("two"), errors.New("three")}
+		streamer.StreamPayloads = payloadsAt(1)
+		newService(1, false, time.Second)
+		service.PublishBackoff = 20 * time.Millisecond
+		start := time.Now()
+		Expect(service.Start(nil)).To(Succeed())
+		defer service.Stop()
+		Eventually(publisher.Published).Should(Equal([]int64{1}))
+		Expect(time.Since(start)).To(BeNumerically(">=", (20+40+80)*time.Millisecond))
+		Expect(publisher.Attempts(1)).To(Equal(4))
+		Expect(service.Metrics.Snapshot().Retried).To(Equal(int64(3)))
+		Expect(failed.Heights()).To(BeEmpty())
+	})
+
+	It("Records a block that still fails after the maximum attempts in failed blocks", func() {
+		for i := 0; i < sync.MaxPublishAttempts; i++ {
+			publisher.Errs[2] = append(publisher.Errs[2], fmt.Errorf("mock error %d", i))
+		}
+		newService(2, false, time.Second)
+		Expect(service.Start(nil)).To(Succeed())
+		defer service.Stop()
+		Eventually(failed.Heights).Should(Equal([]int64{2}))
+		Eventually(publisher.Published).Should(ConsistOf(int64(1), int64(3), int64(4), int64(5)))
+		Expect(failed.Failed[0].Attempts).To(Equal(sync.MaxPublishAttempts))
+		Expect(failed.Failed[0].Hash).To(Equal(streamer.StreamPayloads[1].Header.BlockHash().String()))
+		Expect(failed.Failed[0].Cause.Error()).To(Equal(fmt.Sprintf("mock error %d", sync.MaxPublishAttempts-1)))
+		m := service.Metrics.Snapshot()
+		Expect(m.Failed).To(Equal(int64(1)))
+		Expect(m.Retried).To(Equal(int64(sync.MaxPublishAttempts - 1)))
+		Expect(m.Published).To(Equal(int64(4)))
+	})
+
+	It("Records a block whose parent does not match without retrying it", func() {
+		publisher.Errs[3] = []error{fmt.Errorf("mock: %w", btc.ErrParentMismatch)}
+		newService(1, false, time.Second)
+		Expect(service.Start(nil)).To(Succeed())
+		defer service.Stop()
+		Eventually(failed.Heights).Should(Equal([]int64{3}))
+		Expect(failed.Failed[0].Attempts).To(Equal(1))
+		Expect(publisher.Attempts(3)).To(Equal(1))
+	})
+
+	It("Records a block that cannot be converted and drops it", func() {
+		converter.Errs[4] = errors.New("mock conversion error")
+		newService(1, false, time.Second)
+		Expect(service.Start(nil)).To(Succeed())
+		defer service.Stop()
+		Eventually(publisher.Published).Should(Equal([]int64{1, 2, 3, 5}))
+		Expect(failed.Heights()).To(Equal([]int64{4}))
+		Expect(failed.Failed[0].Attempts).To(Equal(1))
+		Expect(service.Metrics.Snapshot().Dropped).To(Equal(int64(1)))
+	})
+
+	It("Commits in stream order in ordered mode", func() {
+		newService(3, true, time.Second)
+		Expect(service.Start(nil)).To(Succeed())
+		defer service.Stop()
+		Eventually(publisher.Published).Should(Equal([]int64{1, 2, 3, 4, 5}))
+		Eventually(checkpointer.Height).Should(Equal(int64(5)))
+	})
+
+	It("Refuses ordered mode with a publisher that cannot commit in order", func() {
+		service = sync.NewService(streamer, converter, new(mocks.IPLDPublisher), checkpointer, failed, 1, true, time.Second)
+		Expect(service.Sync(nil)).To(MatchError(ContainSubstring("does not support ordered publishing")))
+	})
+
+	Describe("Stop", func() {
+		for _, ordered := range []bool{false, true} {
+			ordered := ordered
+			Context(fmt.Sprintf("with ordered %t", ordered), func() {
+				It("Publishes the blocks already streamed before returning", func() {
+					hold := make(chan struct{})
+					publisher.Hold[1] = hold
+					newService(1, ordered, time.Second)
+					Expect(service.Start(nil)).To(Succeed())
+					Eventually(func() int64 { return service.Metrics.Snapshot().Streamed }).Should(Equal(int64(5)))
+					time.AfterFunc(50*time.Millisecond, func() { close(hold) })
+					Expect(service.Stop()).To(Succeed())
+					Expect(publisher.Published()).To(ConsistOf(int64(1), int64(2), int64(3), int64(4), int64(5)))
+					Expect(service.Metrics.Snapshot().Abandoned).To(Equal(int64(0)))
+					Expect(checkpointer.Height()).To(Equal(int64(5)))
+				})
+
+				It("Abandons the blocks still unpublished at the drain deadline", func() {
+					publisher.Hold[1] = make(chan struct{})
+					newService(1, ordered, 50*time.Millisecond)
+					Expect(service.Start(nil)).To(Succeed())
+					Eventually(func() int64 { return service.Metrics.Snapshot().Streamed }).Should(Equal(int64(5)))
+					start := time.Now()
+					Expect(service.Stop()).To(Succeed())
+					Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+					Expect(publisher.Published()).To(BeEmpty())
+					m := service.Metrics.Snapshot()
+					Expect(m.Abandoned).To(Equal(int64(5)))
+					Expect(m.Published).To(Equal(int64(0)))
+					Expect(failed.Heights()).To(BeEmpty())
+					Expect(checkpointer.Height()).To(Equal(int64(-1)))
+				})
+
+				It("Abandons a block still waiting to retry at the drain deadline instead of failing it", func() {
+					publisher.Errs[1] = []error{errors.New("mock error")}
+					newService(1, ordered, 50*time.Millisecond)
+					service.PublishBackoff = time.Hour
+					Expect(service.Start(nil)).To(Succeed())
+					Eventually(func() int { return publisher.Attempts(1) }).Should(Equal(1))
+					start := time.Now()
+					Expect(service.Stop()).To(Succeed())
+					Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+					m := service.Metrics.Snapshot()
+					Expect(m.Failed).To(Equal(int64(0)))
+					Expect(m.Abandoned).To(BeNumerically(">=", 1))
+					Expect(failed.Heights()).To(BeEmpty())
+				})
+			})
+		}
+	})
+})
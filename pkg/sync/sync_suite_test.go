@@ -0,0 +1,50 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sync_test
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+)
+
+func TestSync(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "BTC IPFS Sync Suite Test")
+}
+
+var _ = BeforeSuite(func() {
+	logrus.SetOutput(ioutil.Discard)
+})
+
+// payloadsAt returns a chain of empty block payloads at the heights
+func payloadsAt(heights ...int64) []btc.BlockPayload {
+	payloads := make([]btc.BlockPayload, len(heights))
+	var prev wire.BlockHeader
+	for i, height := range heights {
+		header := wire.BlockHeader{PrevBlock: prev.BlockHash(), Nonce: uint32(height)}
+		payloads[i] = btc.BlockPayload{BlockHeight: height, Header: &header}
+		prev = header
+	}
+	return payloads
+}
@@ -0,0 +1,97 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package verify
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/spf13/viper"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/node"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/postgres"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+	"github.com/vulcanize/ipld-btc-indexer/utils"
+)
+
+// Env variables
+const (
+	VERIFY_START      = "VERIFY_START"
+	VERIFY_STOP       = "VERIFY_STOP"
+	VERIFY_BATCH_SIZE = "VERIFY_BATCH_SIZE"
+	VERIFY_REPAIR     = "VERIFY_REPAIR"
+)
+
+// Config holds the parameters needed to verify, and optionally repair, the data indexed in a block range
+type Config struct {
+	DB       *postgres.DB
+	DBConfig postgres.Config
+	NodeInfo node.Node
+
+	Start     uint64
+	Stop      uint64
+	BatchSize uint64 // Number of block heights verified at a time
+	Repair    bool   // Resync the heights that fail verification
+
+	// Used to resync the heights that fail verification
+	Source  btc.SourceConfig
+	Timeout time.Duration
+}
+
+// NewConfig fills and returns a verify config from toml parameters
+func NewConfig() (*Config, error) {
+	c := new(Config)
+	var err error
+
+	viper.BindEnv("bitcoin.httpPath", shared.BTC_HTTP_PATH)
+	viper.BindEnv("verify.start", VERIFY_START)
+	viper.BindEnv("verify.stop", VERIFY_STOP)
+	viper.BindEnv("verify.batchSize", VERIFY_BATCH_SIZE)
+	viper.BindEnv("verify.repair", VERIFY_REPAIR)
+	viper.BindEnv("verify.timeout", shared.HTTP_TIMEOUT)
+
+	c.Start = uint64(viper.GetInt64("verify.start"))
+	c.Stop = uint64(viper.GetInt64("verify.stop"))
+	c.BatchSize = uint64(viper.GetInt64("verify.batchSize"))
+	if c.BatchSize == 0 {
+		c.BatchSize = shared.DefaultMaxBatchSize
+	}
+	c.Repair = viper.GetBool("verify.repair")
+
+	timeout := viper.GetInt("verify.timeout")
+	if timeout < 5 {
+		timeout = 5
+	}
+	c.Timeout = time.Second * time.Duration(timeout)
+
+	btcHTTP := viper.GetString("bitcoin.httpPath")
+	var clientConfig *rpcclient.ConnConfig
+	c.NodeInfo, clientConfig = shared.GetBtcNodeAndClient(btcHTTP)
+	c.Source.RPCConfigs = shared.GetBtcClientConfigs(btcHTTP, clientConfig)
+	c.Source.Type, c.Source.EsploraPaths, err = shared.GetBtcSource()
+	if err != nil {
+		return nil, err
+	}
+	c.Source.Timeout = c.Timeout
+	c.Source.CrossValidate = shared.GetBtcCrossValidate()
+
+	c.DBConfig.Init()
+	db := utils.LoadPostgres(c.DBConfig, c.NodeInfo)
+	c.DB = &db
+	return c, nil
+}
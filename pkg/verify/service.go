@@ -0,0 +1,106 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package verify
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/vulcanize/ipld-btc-indexer/pkg/btc"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/resync"
+	"github.com/vulcanize/ipld-btc-indexer/pkg/shared"
+	"github.com/vulcanize/ipld-btc-indexer/utils"
+)
+
+// Service verifies the data indexed in a block range, and can repair it by resyncing the heights that fail
+type Service struct {
+	// Interface for verifying the indexed data
+	Verifier btc.Verifier
+	// Settings used to build the resync service that repairs failed heights
+	settings *Config
+}
+
+// NewVerifyService creates and returns a verify service from the provided settings
+func NewVerifyService(settings *Config) *Service {
+	return &Service{
+		Verifier: btc.NewDBVerifier(settings.DB),
+		settings: settings,
+	}
+}
+
+// Verify verifies the configured range a batch at a time and logs every failure
+// If repair is on, the data at every height that failed is cleared and resynced
+func (s *Service) Verify() ([]btc.VerificationFailure, error) {
+	bins, err := utils.GetBlockHeightBins(s.settings.Start, s.settings.Stop, s.settings.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	var failures []btc.VerificationFailure
+	for _, heights := range bins {
+		logrus.Debugf("verifying bitcoin data from %d to %d", heights[0], heights[len(heights)-1])
+		batchFailures, err := s.Verifier.Verify(heights[0], heights[len(heights)-1])
+		if err != nil {
+			return failures, err
+		}
+		for _, failure := range batchFailures {
+			logrus.Warnf("bitcoin block %s at height %d failed verification: %s", failure.BlockHash, failure.BlockNumber, failure.Reason)
+		}
+		failures = append(failures, batchFailures...)
+	}
+	if !s.settings.Repair || len(failures) == 0 {
+		return failures, nil
+	}
+	return failures, s.repair(failures)
+}
+
+// repair clears and resyncs the heights that failed verification
+func (s *Service) repair(failures []btc.VerificationFailure) error {
+	ranges := failedRanges(failures)
+	logrus.Infof("repairing %d bitcoin block ranges that failed verification", len(ranges))
+	rs, err := resync.NewResyncService(&resync.Config{
+		ResyncType:    shared.Full,
+		ClearOldCache: true,
+		DB:            s.settings.DB,
+		DBConfig:      s.settings.DBConfig,
+		Source:        s.settings.Source,
+		NodeInfo:      s.settings.NodeInfo,
+		Ranges:        ranges,
+		BatchSize:     s.settings.BatchSize,
+		Timeout:       s.settings.Timeout,
+	})
+	if err != nil {
+		return err
+	}
+	return rs.Sync(context.Background())
+}
+
+// failedRanges merges the heights of the failures, which are in ascending order, into contiguous ranges
+func failedRanges(failures []btc.VerificationFailure) [][2]uint64 {
+	var ranges [][2]uint64
+	for _, failure := range failures {
+		height := uint64(failure.BlockNumber)
+		if len(ranges) > 0 && height <= ranges[len(ranges)-1][1]+1 {
+			if height > ranges[len(ranges)-1][1] {
+				ranges[len(ranges)-1][1] = height
+			}
+			continue
+		}
+		ranges = append(ranges, [2]uint64{height, height})
+	}
+	return ranges
+}
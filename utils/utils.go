@@ -17,7 +17,13 @@
 package utils
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
@@ -58,3 +64,119 @@ func GetBlockHeightBins(startingBlock, endingBlock, batchSize uint64) ([][]uint6
 	}
 	return blockRangeBins, nil
 }
+
+// SplitRangeByPartition splits the inclusive block range [start, stop] into the partitions of the given size that it
+// fully covers, and the leftover ranges at its edges that only cover part of a partition
+func SplitRangeByPartition(start, stop, size uint64) (aligned [][2]uint64, edges [][2]uint64) {
+	if stop < start {
+		return nil, nil
+	}
+	if size == 0 {
+		return nil, [][2]uint64{{start, stop}}
+	}
+	for from := start - start%size; from <= stop; from += size {
+		to := from + size - 1
+		switch {
+		case from >= start && to <= stop:
+			aligned = append(aligned, [2]uint64{from, to})
+		case from < start && to > stop:
+			edges = append(edges, [2]uint64{start, stop})
+		case from < start:
+			edges = append(edges, [2]uint64{start, to})
+		default:
+			edges = append(edges, [2]uint64{from, stop})
+		}
+		if to >= stop {
+			break
+		}
+	}
+	return aligned, edges
+}
+
+// ParseBlockRange parses an inclusive block range written as "start-stop", or a single block height
+func ParseBlockRange(s string) ([2]uint64, error) {
+	bounds := strings.SplitN(strings.TrimSpace(s), "-", 2)
+	start, err := strconv.ParseUint(strings.TrimSpace(bounds[0]), 10, 64)
+	if err != nil {
+		return [2]uint64{}, fmt.Errorf("invalid block range %q: %v", s, err)
+	}
+	stop := start
+	if len(bounds) == 2 {
+		if stop, err = strconv.ParseUint(strings.TrimSpace(bounds[1]), 10, 64); err != nil {
+			return [2]uint64{}, fmt.Errorf("invalid block range %q: %v", s, err)
+		}
+	}
+	if stop < start {
+		return [2]uint64{}, fmt.Errorf("invalid block range %q: ending block number needs to be greater than starting block number", s)
+	}
+	return [2]uint64{start, stop}, nil
+}
+
+// MergeBlockRanges merges overlapping and adjacent inclusive block ranges, returning them in ascending order
+func MergeBlockRanges(rngs [][2]uint64) [][2]uint64 {
+	if len(rngs) == 0 {
+		return nil
+	}
+	sorted := make([][2]uint64, len(rngs))
+	copy(sorted, rngs)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i][0] < sorted[j][0] })
+	merged := [][2]uint64{sorted[0]}
+	for _, rng := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if rng[0] <= last[1]+1 {
+			if rng[1] > last[1] {
+				last[1] = rng[1]
+			}
+			continue
+		}
+		merged = append(merged, rng)
+	}
+	return merged
+}
+
+// SubtractBlockRanges returns the parts of the inclusive block ranges that are not covered by any of the ranges to subtract
+func SubtractBlockRanges(rngs, subtract [][2]uint64) [][2]uint64 {
+	subtract = MergeBlockRanges(subtract)
+	var remaining [][2]uint64
+	for _, rng := range rngs {
+		start := rng[0]
+		for _, sub := range subtract {
+			if sub[1] < start || sub[0] > rng[1] {
+				continue
+			}
+			if sub[0] > start {
+				remaining = append(remaining, [2]uint64{start, sub[0] - 1})
+			}
+			if sub[1] >= rng[1] {
+				start = rng[1] + 1
+				break
+			}
+			start = sub[1] + 1
+		}
+		if start <= rng[1] && start >= rng[0] {
+			remaining = append(remaining, [2]uint64{start, rng[1]})
+		}
+	}
+	return remaining
+}
+
+// DrainContext returns a context that is cancelled the timeout after the intake context is done, or when the returned
+// cancel function is called; work already taken in before shutdown uses it to finish up within a deadline
+func DrainContext(intake context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-intake.Done():
+		case <-ctx.Done():
+			return
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
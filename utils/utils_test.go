@@ -17,6 +17,9 @@
 package utils_test
 
 import (
+	"context"
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -72,3 +75,85 @@ var _ = Describe("GetBlockHeightBins", func() {
 		Expect(err.Error()).To(ContainSubstring("batchsize needs to be greater than zero"))
 	})
 })
+
+var _ = Describe("SplitRangeByPartition", func() {
+	It("separates whole partitions from the partial ranges at the edges", func() {
+		aligned, edges := utils.SplitRangeByPartition(150, 420, 100)
+		Expect(aligned).To(Equal([][2]uint64{{200, 299}, {300, 399}}))
+		Expect(edges).To(Equal([][2]uint64{{150, 199}, {400, 420}}))
+	})
+
+	It("returns only whole partitions when the range is aligned", func() {
+		aligned, edges := utils.SplitRangeByPartition(0, 199, 100)
+		Expect(aligned).To(Equal([][2]uint64{{0, 99}, {100, 199}}))
+		Expect(edges).To(BeEmpty())
+	})
+
+	It("returns a single edge when the range falls inside one partition", func() {
+		aligned, edges := utils.SplitRangeByPartition(110, 120, 100)
+		Expect(aligned).To(BeEmpty())
+		Expect(edges).To(Equal([][2]uint64{{110, 120}}))
+	})
+
+	It("returns the whole range as an edge when there are no partitions", func() {
+		aligned, edges := utils.SplitRangeByPartition(110, 120, 0)
+		Expect(aligned).To(BeEmpty())
+		Expect(edges).To(Equal([][2]uint64{{110, 120}}))
+	})
+})
+
+var _ = Describe("ParseBlockRange", func() {
+	It("parses a range and a single height", func() {
+		rng, err := utils.ParseBlockRange("5000-6000")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rng).To(Equal([2]uint64{5000, 6000}))
+		rng, err = utils.ParseBlockRange(" 42 ")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rng).To(Equal([2]uint64{42, 42}))
+	})
+
+	It("rejects malformed and backwards ranges", func() {
+		_, err := utils.ParseBlockRange("10-x")
+		Expect(err).To(HaveOccurred())
+		_, err = utils.ParseBlockRange("10-5")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("ending block number needs to be greater than starting block number"))
+	})
+})
+
+var _ = Describe("MergeBlockRanges", func() {
+	It("merges overlapping and adjacent ranges", func() {
+		merged := utils.MergeBlockRanges([][2]uint64{{50, 60}, {0, 10}, {11, 20}, {55, 70}, {100, 100}})
+		Expect(merged).To(Equal([][2]uint64{{0, 20}, {50, 70}, {100, 100}}))
+	})
+})
+
+var _ = Describe("SubtractBlockRanges", func() {
+	It("removes the covered parts of each range", func() {
+		remaining := utils.SubtractBlockRanges([][2]uint64{{0, 100}, {200, 210}, {300, 310}}, [][2]uint64{{10, 19}, {20, 29}, {90, 120}, {200, 210}})
+		Expect(remaining).To(Equal([][2]uint64{{0, 9}, {30, 89}, {300, 310}}))
+	})
+
+	It("leaves ranges alone when nothing is subtracted", func() {
+		remaining := utils.SubtractBlockRanges([][2]uint64{{5, 10}}, nil)
+		Expect(remaining).To(Equal([][2]uint64{{5, 10}}))
+	})
+})
+
+var _ = Describe("DrainContext", func() {
+	It("is cancelled the timeout after the intake context is done", func() {
+		intake, stop := context.WithCancel(context.Background())
+		drain, cancel := utils.DrainContext(intake, 50*time.Millisecond)
+		defer cancel()
+		Consistently(drain.Done(), 100*time.Millisecond).ShouldNot(BeClosed())
+		stop()
+		Consistently(drain.Done(), 25*time.Millisecond).ShouldNot(BeClosed())
+		Eventually(drain.Done()).Should(BeClosed())
+	})
+
+	It("is cancelled by its cancel function", func() {
+		drain, cancel := utils.DrainContext(context.Background(), time.Hour)
+		cancel()
+		Expect(drain.Done()).To(BeClosed())
+	})
+})